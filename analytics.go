@@ -0,0 +1,252 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The analytics panel on the Events view. Everything here is computed from the ssrEvent models the
+// view already holds: no extra API calls, and nothing Gatekeeper did not write into its annotations.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How many entries each "top" list keeps. The panel is a summary; the table below it has the rest.
+const eventTopN = 8
+
+// The histogram aims for at most this many bars, and picks the narrowest bucket that fits.
+const eventHistogramBars = 24
+
+// The bucket widths the histogram can choose from, narrowest first. Round numbers only, so a bar
+// reads as "the 14:00 hour" rather than "a 37 minute slice starting at 13:52".
+var eventBucketWidths = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// One bar of the histogram, split by mode like the Resources view's severity bar.
+type ssrEventBar struct {
+	Index              int
+	Label              string // the bucket's start, for the tooltip and the filter chip
+	Deny, DryRun, Warn int
+	Height             int // percent of the tallest bar
+}
+
+func (b ssrEventBar) Total() int { return b.Deny + b.DryRun + b.Warn }
+
+// Segments is the bar's stack, in the same fixed order the Resources view uses.
+func (b ssrEventBar) Segments() []ssrModeCount {
+	return []ssrModeCount{
+		{"deny", "deny", b.Deny},
+		{"dryrun", "dry-run", b.DryRun},
+		{"warn", "warn", b.Warn},
+	}
+}
+
+// One entry of a "top" list. Value is what the table rows carry in the matching data attribute, so
+// a click can filter on it; Label is what a reader sees.
+type ssrEventTop struct {
+	Value          string
+	Label          string
+	Detail         string // the constraint's kind, or the service account's namespace
+	ServiceAccount bool
+	Count          int
+	Width          int // percent of the list's largest count, for the bar behind the label
+}
+
+// One "top" list, with what the panel needs to render it: the heading, the data attribute a click
+// filters on, and what to say when the events never recorded the field.
+type ssrEventTopList struct {
+	Title   string
+	Dim     string
+	Empty   string
+	Entries []ssrEventTop
+}
+
+// ssrEventAnalytics is the whole panel. Only events Gatekeeper tagged with a constraint action are
+// counted: those are the admission and audit verdicts, everything else is noise for this purpose.
+type ssrEventAnalytics struct {
+	Total       int
+	Modes       []ssrModeCount
+	Bars        []ssrEventBar
+	BucketWidth string // "1h", for the histogram caption
+	From, To    string // the first bar's start and the last bar's, for the axis
+	Users       ssrEventTopList
+	Constraints ssrEventTopList
+	Namespaces  ssrEventTopList
+}
+
+// serviceAccountPrefix is how the API server names a service account in request.userInfo.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// eventAnalytics builds the panel and, as a side effect the template depends on, stamps each event
+// with the histogram bar it fell in, so the rows can be filtered by a click on that bar. Events
+// without a usable timestamp are counted everywhere except the histogram.
+func eventAnalytics(events []ssrEvent) ssrEventAnalytics {
+	a := ssrEventAnalytics{}
+	var deny, dryrun, warn int
+	users := map[string]int{}
+	constraints := map[string]int{}
+	constraintKind := map[string]string{}
+	constraintName := map[string]string{}
+	namespaces := map[string]int{}
+
+	var first, last time.Time
+	for i := range events {
+		events[i].Bucket = -1
+		e := &events[i]
+		if e.Action == "" {
+			continue
+		}
+		a.Total++
+		switch e.Mode {
+		case "warn":
+			warn++
+		case "dryrun":
+			dryrun++
+		default:
+			deny++
+		}
+		if e.RequestUsername != "" {
+			users[e.RequestUsername]++
+		}
+		if e.ConstraintName != "" {
+			ref := constraintAnchor(e.ConstraintKind, e.ConstraintName)
+			constraints[ref]++
+			constraintKind[ref] = e.ConstraintKind
+			constraintName[ref] = e.ConstraintName
+		}
+		if ns := e.Namespace(); ns != "" {
+			namespaces[ns]++
+		}
+		if e.Time.IsZero() {
+			continue
+		}
+		if first.IsZero() || e.Time.Before(first) {
+			first = e.Time
+		}
+		if last.IsZero() || e.Time.After(last) {
+			last = e.Time
+		}
+	}
+
+	a.Modes = []ssrModeCount{
+		{"deny", "deny", deny},
+		{"dryrun", "dry-run", dryrun},
+		{"warn", "warn", warn},
+	}
+
+	a.Users = ssrEventTopList{Title: "Top users", Dim: "user", Empty: "No request user recorded."}
+	a.Users.Entries = topEntries(users, func(v string) ssrEventTop {
+		t := ssrEventTop{Value: v, Label: v}
+		if rest, ok := strings.CutPrefix(v, serviceAccountPrefix); ok {
+			ns, name, _ := strings.Cut(rest, ":")
+			t.ServiceAccount = true
+			t.Label = name
+			t.Detail = ns
+		}
+		return t
+	})
+	a.Constraints = ssrEventTopList{Title: "Top constraints", Dim: "cref", Empty: "No constraint recorded."}
+	a.Constraints.Entries = topEntries(constraints, func(v string) ssrEventTop {
+		return ssrEventTop{Value: v, Label: constraintName[v], Detail: constraintKind[v]}
+	})
+	a.Namespaces = ssrEventTopList{Title: "Top namespaces", Dim: "namespace", Empty: "No namespace recorded."}
+	a.Namespaces.Entries = topEntries(namespaces, func(v string) ssrEventTop {
+		return ssrEventTop{Value: v, Label: v}
+	})
+
+	if first.IsZero() {
+		return a
+	}
+	width := eventBucketWidths[len(eventBucketWidths)-1]
+	for _, w := range eventBucketWidths {
+		if last.Sub(first.Truncate(w)) < time.Duration(eventHistogramBars)*w {
+			width = w
+			break
+		}
+	}
+	a.BucketWidth = formatBucketWidth(width)
+
+	start := first.Truncate(width)
+	count := int(last.Sub(start)/width) + 1
+	a.Bars = make([]ssrEventBar, count)
+	for i := range a.Bars {
+		a.Bars[i] = ssrEventBar{Index: i, Label: start.Add(time.Duration(i) * width).UTC().Format("2006-01-02 15:04 UTC")}
+	}
+	a.From, a.To = a.Bars[0].Label, a.Bars[count-1].Label
+	for i := range events {
+		e := &events[i]
+		if e.Action == "" || e.Time.IsZero() {
+			continue
+		}
+		b := int(e.Time.Sub(start) / width)
+		e.Bucket = b
+		switch e.Mode {
+		case "warn":
+			a.Bars[b].Warn++
+		case "dryrun":
+			a.Bars[b].DryRun++
+		default:
+			a.Bars[b].Deny++
+		}
+	}
+	tallest := 0
+	for _, b := range a.Bars {
+		tallest = max(tallest, b.Total())
+	}
+	for i := range a.Bars {
+		if tallest > 0 {
+			a.Bars[i].Height = a.Bars[i].Total() * 100 / tallest
+		}
+	}
+	return a
+}
+
+// topEntries is the largest counts, most first, ties broken on the value so the panel does not
+// reshuffle between two loads of the same data.
+func topEntries(counts map[string]int, entry func(string) ssrEventTop) []ssrEventTop {
+	values := make([]string, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	if len(values) > eventTopN {
+		values = values[:eventTopN]
+	}
+
+	out := make([]ssrEventTop, 0, len(values))
+	for _, v := range values {
+		t := entry(v)
+		t.Count = counts[v]
+		t.Width = t.Count * 100 / counts[values[0]]
+		out = append(out, t)
+	}
+	return out
+}
+
+// formatBucketWidth spells a bucket width the way an operator would: 15m, 6h, 1d, 7d.
+func formatBucketWidth(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// analyticsEvent builds one event as ssrEventModel would have parsed it.
+func analyticsEvent(action, user, kind, name, ns string, at time.Time) ssrEvent {
+	e := ssrEvent{
+		Action: action, RequestUsername: user, ConstraintKind: kind, ConstraintName: name,
+		ResourceNamespace: ns, Time: at,
+	}
+	if action != "" {
+		e.Mode = enforcementMode(action)
+	}
+	return e
+}
+
+func TestEventAnalyticsCountsVerdictsOnly(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	events := []ssrEvent{
+		analyticsEvent("deny", "alice", "K8sRequiredLabels", "owner", "apps", at),
+		analyticsEvent("deny", "alice", "K8sRequiredLabels", "owner", "apps", at.Add(time.Minute)),
+		analyticsEvent("warn", "system:serviceaccount:ci:deployer", "K8sAllowedRepos", "repos", "ci", at),
+		analyticsEvent("dryrun", "", "K8sAllowedRepos", "repos", "apps", at),
+		// No constraint_action: not a verdict, so it stays out of every tally.
+		analyticsEvent("", "bob", "", "", "apps", at),
+	}
+
+	a := eventAnalytics(events)
+
+	if a.Total != 4 {
+		t.Errorf("Total = %d, want 4", a.Total)
+	}
+	if got := [3]int{a.Modes[0].Count, a.Modes[1].Count, a.Modes[2].Count}; got != [3]int{2, 1, 1} {
+		t.Errorf("deny/dryrun/warn = %v, want [2 1 1]", got)
+	}
+
+	users := a.Users.Entries
+	if len(users) != 2 || users[0].Value != "alice" || users[0].Count != 2 {
+		t.Fatalf("users = %+v, want alice first with 2", users)
+	}
+	if sa := users[1]; !sa.ServiceAccount || sa.Label != "deployer" || sa.Detail != "ci" {
+		t.Errorf("service account = %+v, want deployer in ci flagged as a service account", sa)
+	}
+
+	constraints := a.Constraints.Entries
+	if len(constraints) != 2 {
+		t.Fatalf("constraints = %+v, want two", constraints)
+	}
+	// The value is the card anchor, so two same-named Constraints of different Kinds stay apart and
+	// the row attribute it filters on is the one the table already links with.
+	if constraints[0].Value != "K8sAllowedRepos--repos" && constraints[0].Value != "K8sRequiredLabels--owner" {
+		t.Errorf("constraint value = %q, want a Kind-prefixed anchor", constraints[0].Value)
+	}
+
+	if ns := a.Namespaces.Entries; len(ns) != 2 || ns[0].Value != "apps" || ns[0].Count != 3 {
+		t.Errorf("namespaces = %+v, want apps first with 3", ns)
+	}
+	if events[4].Bucket != -1 {
+		t.Errorf("a non-verdict event landed in bar %d, want -1", events[4].Bucket)
+	}
+}
+
+// The histogram picks the narrowest round bucket that keeps the bars to a readable count, and
+// every verdict is stamped with the bar it fell in so a click on the bar can find its rows.
+func TestEventAnalyticsHistogramBuckets(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 7, 0, 0, time.UTC)
+	events := []ssrEvent{
+		analyticsEvent("deny", "", "", "", "", at),
+		analyticsEvent("deny", "", "", "", "", at.Add(30*time.Minute)),
+		analyticsEvent("warn", "", "", "", "", at.Add(3*time.Hour)),
+	}
+
+	a := eventAnalytics(events)
+
+	if a.BucketWidth != "15m" {
+		t.Fatalf("BucketWidth = %q, want 15m for a three-hour span", a.BucketWidth)
+	}
+	if a.From != "2026-03-01 10:00 UTC" {
+		t.Errorf("From = %q, want the first bar aligned to the quarter hour", a.From)
+	}
+	if len(a.Bars) > eventHistogramBars {
+		t.Errorf("%d bars, want at most %d", len(a.Bars), eventHistogramBars)
+	}
+	if got := []int{events[0].Bucket, events[1].Bucket, events[2].Bucket}; got[0] != 0 || got[1] != 2 || got[2] != 12 {
+		t.Errorf("buckets = %v, want [0 2 12]", got)
+	}
+	if b := a.Bars[12]; b.Warn != 1 || b.Height != 100 {
+		t.Errorf("bar 12 = %+v, want one warn at full height", b)
+	}
+}
+
+func TestEventAnalyticsWithoutTimestampsHasNoHistogram(t *testing.T) {
+	a := eventAnalytics([]ssrEvent{analyticsEvent("deny", "alice", "", "", "", time.Time{})})
+	if a.Total != 1 || a.Bars != nil {
+		t.Errorf("Total = %d, bars = %v; want the verdict counted and no histogram", a.Total, a.Bars)
+	}
+}
+
+// Every entry of the panel is a button that names the row attribute it filters on, and every row
+// carries those attributes, or a click would hide everything.
+func TestEventsViewRendersTheAnalyticsPanel(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	events := []ssrEvent{
+		analyticsEvent("deny", "system:serviceaccount:ci:deployer", "K8sRequiredLabels", "owner", "apps", at),
+	}
+	analytics := eventAnalytics(events)
+
+	out := renderSSR(t, "events", map[string]any{
+		"Layout": minimalLayout(), "Events": events, "Analytics": analytics,
+	})
+	for _, want := range []string{
+		`x-data="eventsFilter()"`, "events-filter.js", "Verdicts over time",
+		`data-dim="user" data-value="system:serviceaccount:ci:deployer"`,
+		`data-dim="cref" data-value="K8sRequiredLabels--owner"`,
+		`data-dim="namespace" data-value="apps"`,
+		`data-dim="bucket" data-value="0"`,
+		`data-user="system:serviceaccount:ci:deployer"`, `data-cref="K8sRequiredLabels--owner"`,
+		`data-mode="deny"`, `data-bucket="0"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("events output missing %q", want)
+		}
+	}
+}
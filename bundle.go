@@ -0,0 +1,394 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The desired-state bundle. A policy repository keeps the templates and Constraints a cluster should
+// have as YAML; GPM reads them from a directory mounted at GPM_BUNDLE_DIR, or from a tarball uploaded
+// on the Bundle view, and compares every context with them the way the Drift view compares clusters
+// with each other. Per context it lists the policies the cluster is missing, the ones it has that the
+// bundle does not, and the ones whose spec or enforcement differs, each with a side-by-side diff. The
+// comparison downloads as a standalone HTML report.
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// The most a bundle may hold, uploaded or unpacked. A policy repository is a few hundred kilobytes of
+// YAML. Decoded, the YAML takes several times its size in memory, and an upload is read whole, so
+// the limit is kept to what a repository needs.
+const bundleMaxBytes = 2 << 20
+
+// policyBundle is the policies a bundle declares.
+type policyBundle struct {
+	Source      string // the directory, or the uploaded file's name
+	templates   []map[string]any
+	constraints []map[string]any
+	Skipped     int               // documents of other kinds, which the comparison leaves out
+	Broken      map[string]string // the files that do not parse, and why, by path
+}
+
+// Summary counts what the bundle declares, for the view and the report.
+func (b *policyBundle) Summary() string {
+	s := plural(len(b.templates), "Constraint Template", "Constraint Templates") + " and " +
+		plural(len(b.constraints), "Constraint", "Constraints")
+	if b.Skipped > 0 {
+		s += ", and " + plural(b.Skipped, "document", "documents") + " of other kinds that the comparison leaves out"
+	}
+	return s
+}
+
+// bundleDir is the directory GPM_BUNDLE_DIR names, empty when there is none.
+func bundleDir() string {
+	return viper.GetString("bundle_dir")
+}
+
+// isBundleFile says whether a file of the bundle is one GPM reads.
+func isBundleFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// addFile reads the documents of one file into the bundle. A List's items count one by one, as
+// kubectl exports them that way.
+func (b *policyBundle) addFile(name string, raw []byte) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(raw)))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			b.Broken[name] = err.Error()
+			return
+		}
+		var obj map[string]any
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			b.Broken[name] = err.Error()
+			return
+		}
+		if obj == nil {
+			continue
+		}
+		objects := []map[string]any{obj}
+		if items, ok := obj["items"].([]any); ok && strings.HasSuffix((&unstructured.Unstructured{Object: obj}).GetKind(), "List") {
+			objects = objects[:0]
+			for _, item := range items {
+				if m, ok := item.(map[string]any); ok {
+					objects = append(objects, m)
+				}
+			}
+		}
+		for _, o := range objects {
+			u := &unstructured.Unstructured{Object: o}
+			switch group := u.GroupVersionKind().Group; {
+			case group == "templates.gatekeeper.sh" && u.GetKind() == "ConstraintTemplate":
+				b.templates = append(b.templates, o)
+			case group == "constraints.gatekeeper.sh":
+				b.constraints = append(b.constraints, o)
+			default:
+				b.Skipped++
+			}
+		}
+	}
+}
+
+// readBundleDir reads every YAML or JSON file under dir, its subdirectories too.
+func readBundleDir(dir string) (*policyBundle, error) {
+	b := &policyBundle{Source: dir, Broken: map[string]string{}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// A mounted ConfigMap keeps its files behind ..data and dot-named links; read each once.
+		if d.IsDir() && path != dir && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+		if d.IsDir() || !isBundleFile(path) {
+			return nil
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		b.addFile(rel, raw)
+		return nil
+	})
+	return b, err
+}
+
+// readBundleArchive reads the YAML and JSON files of a tarball, gzipped or not.
+func readBundleArchive(name string, r io.Reader) (*policyBundle, error) {
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("reading the gzip stream: %w", err)
+		}
+		defer gz.Close()
+		src = gz
+	}
+	// Count what is unpacked, so a small archive of a huge file is refused too.
+	limited := &io.LimitedReader{R: src, N: bundleMaxBytes + 1}
+	tr := tar.NewReader(limited)
+
+	b := &policyBundle{Source: name, Broken: map[string]string{}}
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading the tarball: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg || !isBundleFile(h.Name) {
+			continue
+		}
+		raw, err := io.ReadAll(tr)
+		if limited.N <= 0 {
+			return nil, fmt.Errorf("the bundle is larger than %d MiB", bundleMaxBytes>>20)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", h.Name, err)
+		}
+		b.addFile(h.Name, raw)
+	}
+	return b, nil
+}
+
+// bundleDifference is one policy where a cluster and the bundle disagree.
+type bundleDifference struct {
+	Kind     string
+	Name     string
+	Template bool
+	State    string   // missing: the bundle has it and the cluster does not | extra: the reverse | differs
+	Fields   []string // the spec fields that differ
+	Diff     []driftLine
+	URL      string // the policy on the cluster's view, when the cluster has it
+	Anchor   string
+}
+
+// Enforcement says a Constraint differs in its enforcement action and nothing else.
+func (d bundleDifference) Enforcement() bool {
+	return len(d.Fields) > 0 && !slices.ContainsFunc(d.Fields, func(f string) bool {
+		return f != "enforcementAction" && f != "scopedEnforcementActions"
+	})
+}
+
+// Label says what the difference is, in the view's and the report's words.
+func (d bundleDifference) Label() string {
+	switch {
+	case d.State == "missing":
+		return "missing from the cluster"
+	case d.State == "extra":
+		return "not in the bundle"
+	case d.Enforcement():
+		return "enforcement differs"
+	}
+	return "spec differs"
+}
+
+// bundleComparison is one context against the bundle.
+type bundleComparison struct {
+	Cluster     string // the context's display name
+	Unreachable bool
+	Differences []bundleDifference
+	Missing     int
+	Extra       int
+	Differs     int
+	Same        int
+	Anchor      string
+	// The differing policies left without a diff, once the request drew driftMaxDiffs.
+	Undrawn int
+}
+
+// Clean says the cluster has exactly the bundle's policies.
+func (c bundleComparison) Clean() bool {
+	return !c.Unreachable && len(c.Differences) == 0
+}
+
+// UndrawnSummary counts the differing policies left without a diff, for the view and the report.
+func (c bundleComparison) UndrawnSummary() string {
+	if c.Undrawn == 1 {
+		return "1 policy that differs is listed"
+	}
+	return fmt.Sprintf("%d policies that differ are listed", c.Undrawn)
+}
+
+// compareBundle compares one cluster with the bundle, which the diffs read as the base. diffs is how
+// many more diffs the request may draw, and each one drawn here is taken from it.
+func compareBundle(b *policyBundle, cluster clusterPolicies, diffs *int) bundleComparison {
+	out := bundleComparison{Cluster: clusterLabel(cluster.context), Unreachable: cluster.err != nil}
+	out.Anchor = "bundle-" + clusterAnchor(cluster.context)
+	if out.Unreachable {
+		return out
+	}
+	want := policyIndex(b.templates, b.constraints)
+	have := policyIndex(cluster.templates, cluster.constraints)
+	keys := slices.SortedFunc(maps.Keys(union(want, have)), comparePolicyKeys)
+	for _, k := range keys {
+		d := bundleDifference{Kind: k.kind, Name: k.name, Template: k.template, Anchor: out.Anchor + "--" + constraintAnchor(k.kind, k.name)}
+		w, inBundle := want[k]
+		h, inCluster := have[k]
+		if inCluster {
+			d.URL = policyURL(cluster.context, h, k.template)
+		}
+		switch {
+		case !inCluster:
+			d.State = "missing"
+			out.Missing++
+		case !inBundle:
+			d.State = "extra"
+			out.Extra++
+		default:
+			base, spec := policySpec(w, k.template), policySpec(h, k.template)
+			if d.Fields = specFields(base, spec, k.template); len(d.Fields) == 0 {
+				out.Same++
+				continue
+			}
+			d.State = "differs"
+			out.Differs++
+			if *diffs > 0 {
+				d.Diff = sideBySide(diffText(toYAML(base), toYAML(spec)))
+				*diffs--
+			} else {
+				out.Undrawn++
+			}
+		}
+		out.Differences = append(out.Differences, d)
+	}
+	return out
+}
+
+// compareFleet compares every context with the bundle, in parallel as the Drift view reads them, in
+// the contexts' order.
+func (s *server) compareFleet(ctx context.Context, b *policyBundle) []bundleComparison {
+	names, _ := s.fleetContexts()
+	clusters := make([]clusterPolicies, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clusters[i] = s.fetchClusterPolicies(ctx, name)
+		}()
+	}
+	wg.Wait()
+
+	out := make([]bundleComparison, len(clusters))
+	diffs := driftMaxDiffs
+	for i, cl := range clusters {
+		out[i] = compareBundle(b, cl, &diffs)
+	}
+	return out
+}
+
+// getBundle renders the Bundle view against the mounted bundle, or the upload form alone when there
+// is none. ?report=html downloads the comparison instead.
+func (s *server) getBundle(c echo.Context) error {
+	data := map[string]any{}
+	if bundleDir() == "" {
+		return s.renderBundle(c, nil, data)
+	}
+	b, err := readBundleDir(bundleDir())
+	if err != nil {
+		slog.Error("SSR bundle: reading the bundle directory failed", "dir", bundleDir(), "error", err)
+		setViewError(data, "GPM could not read the directory that GPM_BUNDLE_DIR names. Make sure it is mounted in the GPM container and readable.", err)
+		return s.renderBundle(c, nil, data)
+	}
+	if c.QueryParam("report") != "" {
+		return s.renderBundleReport(c, b)
+	}
+	data["ReportURL"] = contextPath(c, "/bundle") + "?report=html"
+	return s.renderBundle(c, b, data)
+}
+
+// postBundle compares the fleet with an uploaded bundle. Nothing of it is kept, so the report button
+// sends the file again. Uploads are off unless GPM_BUNDLE_UPLOAD is set: every upload is read,
+// unpacked and compared with every context.
+func (s *server) postBundle(c echo.Context) error {
+	if !viper.GetBool("bundle_upload") {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	data := map[string]any{"Uploaded": true}
+	file, err := c.FormFile("bundle")
+	if err != nil {
+		setViewError(data, "Choose a tarball of the bundle's YAML files to upload.", err)
+		return s.renderBundle(c, nil, data)
+	}
+	f, err := file.Open()
+	if err != nil {
+		slog.Error("SSR bundle: opening the upload failed", "error", err)
+		setViewError(data, "GPM could not read the uploaded file.", err)
+		return s.renderBundle(c, nil, data)
+	}
+	defer f.Close()
+	b, err := readBundleArchive(file.Filename, f)
+	if err != nil {
+		setViewError(data, "GPM could not read the uploaded bundle. Upload a .tar or .tar.gz of YAML or JSON files.", err)
+		return s.renderBundle(c, nil, data)
+	}
+	if c.FormValue("report") != "" {
+		return s.renderBundleReport(c, b)
+	}
+	return s.renderBundle(c, b, data)
+}
+
+// renderBundle renders the view: the bundle's summary, every context's counts and differences, and
+// the upload form. A nil bundle renders the form alone. The view is fleet-wide, like the dashboard,
+// so it drops the context switcher.
+func (s *server) renderBundle(c echo.Context, b *policyBundle, data map[string]any) error {
+	layout := s.ssrLayoutData(c, "bundle", "/bundle", "Bundle")
+	layout.Contexts = nil
+	layout.HasContexts = false
+	data["Layout"] = layout
+	data["Dir"] = bundleDir()
+	data["CSRF"], _ = c.Get(csrfContextKey).(string)
+	data["Action"] = contextPath(c, "/bundle")
+	data["MaxMiB"] = bundleMaxBytes >> 20
+	data["UploadEnabled"] = viper.GetBool("bundle_upload")
+	data["MaxDiffs"] = driftMaxDiffs
+	if b != nil {
+		data["Bundle"] = b
+		data["Comparisons"] = s.compareFleet(c.Request().Context(), b)
+	}
+	return s.ssr.render(c, "bundle", data)
+}
+
+// renderBundleReport downloads the comparison as a standalone HTML page.
+func (s *server) renderBundleReport(c echo.Context, b *policyBundle) error {
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="gpm-bundle-report.html"`)
+	return c.Render(http.StatusOK, "bundlereport", map[string]any{
+		"bundle":      b,
+		"comparisons": s.compareFleet(c.Request().Context(), b),
+		"timestamp":   time.Now().Format(time.ANSIC),
+	})
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+)
+
+const bundleTemplate = `apiVersion: templates.gatekeeper.sh/v1
+kind: ConstraintTemplate
+metadata:
+  name: k8srequiredlabels
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sRequiredLabels
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: package k8srequiredlabels
+`
+
+// bundleConstraints wants the label the cluster's must-have-owner already asks for, and a
+// Constraint the cluster does not have.
+const bundleConstraints = `apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: must-have-owner
+spec:
+  parameters:
+    labels: ["team"]
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: must-have-cost-center
+spec:
+  enforcementAction: warn
+`
+
+// bundleTarball packs files into a gzipped tarball.
+func bundleTarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBundleAddFile(t *testing.T) {
+	b := &policyBundle{Broken: map[string]string{}}
+	b.addFile("policies.yaml", []byte(bundleTemplate+"---\n"+bundleConstraints+`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+---
+`))
+	b.addFile("export.json", []byte(`{"apiVersion":"v1","kind":"List","items":[`+
+		`{"apiVersion":"constraints.gatekeeper.sh/v1beta1","kind":"K8sAllowedRepos","metadata":{"name":"repos"}}]}`))
+	b.addFile("broken.yaml", []byte("kind: [unclosed"))
+
+	if len(b.templates) != 1 || len(b.constraints) != 3 || b.Skipped != 1 {
+		t.Errorf("read %d templates, %d Constraints and skipped %d; want 1, 3 and 1", len(b.templates), len(b.constraints), b.Skipped)
+	}
+	if _, ok := b.Broken["broken.yaml"]; !ok || len(b.Broken) != 1 {
+		t.Errorf("broken = %v, want only broken.yaml", b.Broken)
+	}
+	if got := b.Summary(); got != "1 Constraint Template and 3 Constraints, and 1 document of other kinds that the comparison leaves out" {
+		t.Errorf("summary = %q", got)
+	}
+}
+
+func TestReadBundleDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"templates/labels.yaml": bundleTemplate,
+		"constraints.yml":       bundleConstraints,
+		"README.md":             "not a policy",
+		// A mounted ConfigMap's files show twice, once behind ..data.
+		"..data/constraints.yml": bundleConstraints,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := readBundleDir(dir)
+	if err != nil {
+		t.Fatalf("reading the directory failed: %v", err)
+	}
+	if len(b.templates) != 1 || len(b.constraints) != 2 {
+		t.Errorf("read %d templates and %d Constraints, want 1 and 2", len(b.templates), len(b.constraints))
+	}
+}
+
+func TestReadBundleArchive(t *testing.T) {
+	b, err := readBundleArchive("policies.tar.gz", bytes.NewReader(bundleTarball(t, map[string]string{
+		"policies/template.yaml":    bundleTemplate,
+		"policies/constraints.yaml": bundleConstraints,
+		"policies/Makefile":         "all:",
+	})))
+	if err != nil {
+		t.Fatalf("reading the archive failed: %v", err)
+	}
+	if b.Source != "policies.tar.gz" || len(b.templates) != 1 || len(b.constraints) != 2 {
+		t.Errorf("read %s with %d templates and %d Constraints", b.Source, len(b.templates), len(b.constraints))
+	}
+
+	// A small archive that unpacks past the limit is refused.
+	huge := bundleTarball(t, map[string]string{"huge.yaml": strings.Repeat(" ", bundleMaxBytes+1)})
+	if _, err := readBundleArchive("huge.tar.gz", bytes.NewReader(huge)); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("an oversized bundle was read: %v", err)
+	}
+	if _, err := readBundleArchive("notes.txt", strings.NewReader("not a tarball at all, just some text")); err == nil {
+		t.Error("a file that is not a tarball should be an error")
+	}
+}
+
+func TestCompareBundle(t *testing.T) {
+	constraint := func(name string, spec map[string]any) map[string]any {
+		return map[string]any{"kind": "K8sRequiredLabels", "metadata": map[string]any{"name": name}, "spec": spec}
+	}
+	b := &policyBundle{constraints: []map[string]any{
+		constraint("same", map[string]any{"parameters": map[string]any{"labels": []any{"owner"}}}),
+		constraint("enforcement", map[string]any{"enforcementAction": "deny"}),
+		constraint("parameters", map[string]any{"parameters": map[string]any{"labels": []any{"owner"}}}),
+		constraint("missing", map[string]any{}),
+	}}
+	cluster := clusterPolicies{context: "prod", constraints: []map[string]any{
+		constraint("same", map[string]any{"enforcementAction": "deny", "parameters": map[string]any{"labels": []any{"owner"}}}),
+		constraint("enforcement", map[string]any{"enforcementAction": "dryrun"}),
+		constraint("parameters", map[string]any{"parameters": map[string]any{"labels": []any{"team"}}}),
+		constraint("extra", map[string]any{}),
+	}}
+
+	diffs := driftMaxDiffs
+	got := compareBundle(b, cluster, &diffs)
+	if got.Missing != 1 || got.Extra != 1 || got.Differs != 2 || got.Same != 1 {
+		t.Errorf("counts = missing %d, extra %d, differs %d, same %d", got.Missing, got.Extra, got.Differs, got.Same)
+	}
+	labels := map[string]string{}
+	for _, d := range got.Differences {
+		labels[d.Name] = d.Label()
+	}
+	for name, want := range map[string]string{
+		"enforcement": "enforcement differs",
+		"parameters":  "spec differs",
+		"missing":     "missing from the cluster",
+		"extra":       "not in the bundle",
+	} {
+		if labels[name] != want {
+			t.Errorf("%s: %q, want %q", name, labels[name], want)
+		}
+	}
+
+	if down := compareBundle(b, clusterPolicies{context: "dev", err: os.ErrDeadlineExceeded}, &diffs); !down.Unreachable || down.Clean() {
+		t.Errorf("an unreachable cluster = %+v", down)
+	}
+
+	// Past the request's diffs, a policy that differs is listed without one.
+	diffs = 1
+	got = compareBundle(b, cluster, &diffs)
+	if got.Differs != 2 || got.Undrawn != 1 || diffs != 0 || got.UndrawnSummary() != "1 policy that differs is listed" {
+		t.Errorf("with one diff to draw: differs %d, undrawn %d, %d left", got.Differs, got.Undrawn, diffs)
+	}
+	for _, d := range got.Differences {
+		if d.Name == "parameters" && (d.Diff != nil || len(d.Fields) == 0) {
+			t.Errorf("the second difference = %+v, want its fields without a diff", d)
+		}
+	}
+}
+
+// postBundleUpload uploads a bundle to the view, asking for the report when report is set.
+func postBundleUpload(t *testing.T, s *server, tarball []byte, report bool) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("bundle", "policies.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write(tarball)
+	if report {
+		_ = form.WriteField("report", "html")
+	}
+	_ = form.Close()
+
+	e := echo.New()
+	e.Renderer = newRenderer()
+	req := httptest.NewRequest(http.MethodPost, "/bundle", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	if err := s.postBundle(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	return rec
+}
+
+func TestBundleViewComparesAnUpload(t *testing.T) {
+	s, _ := discoveryTestClients(t, driftTestAPI(t, "owner"))
+	viper.Set("bundle_upload", true)
+	tarball := bundleTarball(t, map[string]string{"template.yaml": bundleTemplate, "constraints.yaml": bundleConstraints})
+
+	out := postBundleUpload(t, s, tarball, false).Body.String()
+	for _, want := range []string{
+		"declares 1 Constraint Template and 2 Constraints.",
+		`<a href="#bundle-fake">fake</a>`,
+		`<span class="badge badge-danger">missing from the cluster</span>`,
+		`<a href="/constraints/fake#K8sRequiredLabels--must-have-owner">must-have-owner</a>`,
+		`<tr class="drift-changed"><td>  - team</td><td>  - owner</td></tr>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("bundle view missing %q", want)
+		}
+	}
+
+	rec := postBundleUpload(t, s, tarball, true)
+	if got := rec.Header().Get(echo.HeaderContentDisposition); !strings.Contains(got, "attachment") {
+		t.Errorf("the report should download, got Content-Disposition %q", got)
+	}
+	if report := rec.Body.String(); !strings.Contains(report, "GPM - Policy Bundle Report") || !strings.Contains(report, "must-have-cost-center") {
+		t.Error("the report should list the Constraint the cluster is missing")
+	}
+}
+
+func TestBundleViewWithoutADirectory(t *testing.T) {
+	s, _ := discoveryTestClients(t, driftTestAPI(t, "owner"))
+	viper.Set("bundle_dir", "")
+
+	getBundle := func() string {
+		rec := httptest.NewRecorder()
+		if err := s.getBundle(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/bundle", nil), rec)); err != nil {
+			t.Fatalf("the handler returned an error: %v", err)
+		}
+		return rec.Body.String()
+	}
+	if out := getBundle(); strings.Contains(out, `enctype="multipart/form-data"`) || !strings.Contains(out, "GPM_BUNDLE_UPLOAD=true") {
+		t.Error("with uploads off the view should say how to give it a bundle, and offer no form")
+	}
+	viper.Set("bundle_upload", true)
+	if out := getBundle(); !strings.Contains(out, `enctype="multipart/form-data"`) || strings.Contains(out, "declares") {
+		t.Error("without a directory the view should offer the upload form alone")
+	}
+}
+
+// Uploads are off unless GPM_BUNDLE_UPLOAD turns them on: the handler answers 404 and reads nothing.
+func TestBundleUploadIsOffByDefault(t *testing.T) {
+	s, _ := discoveryTestClients(t, driftTestAPI(t, "owner"))
+	req := httptest.NewRequest(http.MethodPost, "/bundle", strings.NewReader(""))
+	err := s.postBundle(echo.New().NewContext(req, httptest.NewRecorder()))
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusNotFound {
+		t.Errorf("an upload with uploads off = %v, want a 404", err)
+	}
+}
@@ -72,3 +72,16 @@ Create the name of the cluster role to use.
 {{- end -}}
 {{- end -}}
 
+
+{{/*
+The namespaces GPM reads events from, comma-separated. config.eventsNamespace takes one name, a
+comma-separated string or a list.
+*/}}
+{{- define "gatekeeper-policy-manager.eventsNamespaces" -}}
+{{- $namespaces := .Values.config.eventsNamespace -}}
+{{- if kindIs "slice" $namespaces -}}
+    {{ join "," $namespaces }}
+{{- else if $namespaces -}}
+    {{ $namespaces | replace " " "" }}
+{{- end -}}
+{{- end -}}
@@ -12,6 +12,7 @@ import (
 	"github.com/spf13/viper"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
@@ -33,6 +34,7 @@ const (
 type kubeClients struct {
 	dynamic   *dynamic.DynamicClient
 	discovery *discovery.DiscoveryClient
+	metadata  metadata.Interface
 	rest      *rest.Config
 }
 
@@ -154,5 +156,12 @@ func buildKubeClients(kubeContext string) (*kubeClients, *api.Config, error) {
 		return nil, nil, fmt.Errorf("creating constraints discovery Kubernetes client failed: %w", err)
 	}
 
-	return &kubeClients{dynamic: dynamicClient, discovery: discoveryClient, rest: restConfig}, kubeconfig, nil
+	// Used to list objects when only their names, namespaces and labels are needed, as the
+	// compliance score does for every kind the Constraints select.
+	metadataClient, err := metadata.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating metadata Kubernetes client failed: %w", err)
+	}
+
+	return &kubeClients{dynamic: dynamicClient, discovery: discoveryClient, metadata: metadataClient, rest: restConfig}, kubeconfig, nil
 }
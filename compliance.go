@@ -0,0 +1,352 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The compliance score. A violation total grows with the cluster, so a big cluster looks worse than
+// a small one that is just as careless. The score is a share instead: of the objects the Constraints
+// select, how many break no deny or warn policy. It needs the objects that are in scope as well as
+// the ones that fail, so GPM lists the kinds each Constraint names and runs its match over them.
+// That is a read of every such object, and RBAC to list them, so it is off unless
+// GPM_COMPLIANCE_SCORE turns it on.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// What a warn violation costs an object. A deny costs all of it; a dry-run one costs nothing, since
+// it is a policy still on trial.
+const complianceWarnWeight = 0.5
+
+// complianceEnabled says whether GPM_COMPLIANCE_SCORE is on.
+func complianceEnabled() bool {
+	return viper.GetBool("compliance_score")
+}
+
+// complianceTally counts the objects in scope of the scored Constraints, and the failing ones.
+type complianceTally struct {
+	InScope int // objects at least one scored Constraint selects
+	Deny    int // of those, the objects with a deny violation
+	Warn    int // the objects with a warn violation and no deny one
+}
+
+func (t *complianceTally) add(o complianceTally) {
+	t.InScope += o.InScope
+	t.Deny += o.Deny
+	t.Warn += o.Warn
+}
+
+// Score is the weighted share of compliant objects, from 0 to 100. It rounds down, so a single
+// failing object out of a thousand does not read as 100.
+func (t complianceTally) Score() int {
+	if t.InScope == 0 {
+		return 100
+	}
+	lost := float64(t.Deny) + complianceWarnWeight*float64(t.Warn)
+	return int(math.Floor(100 * (1 - lost/float64(t.InScope))))
+}
+
+// Label is the score as the views show it.
+func (t complianceTally) Label() string {
+	if t.InScope == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%d%%", t.Score())
+}
+
+// Summary spells the counts behind the score out for a tooltip.
+func (t complianceTally) Summary() string {
+	if t.InScope == 0 {
+		return "no object is in scope of a scored Constraint"
+	}
+	return fmt.Sprintf("%s in scope: %d with a deny violation, %d with a warn one and no deny",
+		plural(t.InScope, "object", "objects"), t.Deny, t.Warn)
+}
+
+// compliance is the score of a cluster, and of each of its namespaces.
+type compliance struct {
+	Cluster    complianceTally
+	Namespaces map[string]complianceTally // by namespace; "" holds the cluster-scoped objects
+	// The Constraints left out of the score: their match names no kinds, or a wildcard, or a kind
+	// GPM could not list.
+	Unscored []ssrConstraintRef
+	// The audit cut a scored Constraint's violations short, so the score is an upper bound.
+	Limited bool
+}
+
+// forNamespaces adds the tallies of some namespaces up, a team's for example.
+func (c compliance) forNamespaces(names []string) complianceTally {
+	var t complianceTally
+	for _, name := range names {
+		t.add(c.Namespaces[name])
+	}
+	return t
+}
+
+// scoredKinds is the kinds a Constraint's match names, or false when it does not name them one by
+// one: a match with no kinds, or with a wildcard, selects kinds GPM has no cheap way to list.
+func scoredKinds(constraint map[string]any) ([]schema.GroupKind, bool) {
+	m, err := parseMatch(constraint, "spec", "match")
+	if err != nil || len(m.Kinds) == 0 {
+		return nil, false
+	}
+	var out []schema.GroupKind
+	for _, k := range m.Kinds {
+		if len(k.APIGroups) == 0 || len(k.Kinds) == 0 || slices.Contains(k.APIGroups, "*") || slices.Contains(k.Kinds, "*") {
+			return nil, false
+		}
+		for _, g := range k.APIGroups {
+			for _, kind := range k.Kinds {
+				out = append(out, schema.GroupKind{Group: g, Kind: kind})
+			}
+		}
+	}
+	return out, true
+}
+
+// How many objects a metadata list call, for the compliance score or the owners, returns at a time.
+const metadataListChunk = 500
+
+// listKinds lists the objects of each kind, in parallel. Only their metadata is read, a chunk at a
+// time, since the score needs no more than the names, namespaces and labels a match reads. A kind
+// the cluster does not serve has no objects; one GPM cannot list comes back in failed.
+func listKinds(ctx context.Context, clients *kubeClients, kinds []schema.GroupKind) (objects map[schema.GroupKind][]unstructured.Unstructured, failed map[schema.GroupKind]error) {
+	objects = make(map[schema.GroupKind][]unstructured.Unstructured, len(kinds))
+	failed = map[schema.GroupKind]error{}
+	resources := map[schema.GroupKind]schema.GroupVersionResource{}
+	for _, gk := range kinds {
+		gvr, _, err := objectResource(clients.discovery, gk.Group, gk.Kind)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			failed[gk] = fmt.Errorf("resolving %s: %w", gk, err)
+			continue
+		}
+		resources[gk] = gvr
+	}
+
+	var mu sync.Mutex
+	sem := make(chan struct{}, listConstraintsConcurrency)
+	var wg sync.WaitGroup
+	for gk, gvr := range resources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			items, err := listMetadata(ctx, clients, gvr, gk.Kind)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[gk] = fmt.Errorf("listing %s: %w", gk, err)
+				return
+			}
+			objects[gk] = items
+		}()
+	}
+	wg.Wait()
+	return objects, failed
+}
+
+// listMetadata lists the metadata of a resource's objects, following the continue token, as
+// objects a match can read: the items of a metadata list carry no kind of their own.
+func listMetadata(ctx context.Context, clients *kubeClients, gvr schema.GroupVersionResource, kind string) ([]unstructured.Unstructured, error) {
+	apiVersion := gvr.GroupVersion().String()
+	opts := metav1.ListOptions{Limit: metadataListChunk}
+	var out []unstructured.Unstructured
+	for {
+		list, err := clients.metadata.Resource(gvr).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			u := unstructured.Unstructured{Object: map[string]any{}}
+			u.SetAPIVersion(apiVersion)
+			u.SetKind(kind)
+			u.SetName(item.Name)
+			u.SetNamespace(item.Namespace)
+			u.SetLabels(item.Labels)
+			out = append(out, u)
+		}
+		if list.Continue == "" {
+			return out, nil
+		}
+		opts.Continue = list.Continue
+	}
+}
+
+// complianceCache holds each context's last score for dashboardCacheTTL. A score lists every kind
+// the Constraints name, and the dashboard, the Resources and Teams views and the report each ask for
+// one, so a cluster is listed once however many of them load.
+type complianceCache struct {
+	mu      sync.Mutex
+	entries map[string]*complianceEntry
+}
+
+// complianceEntry is one context's score, with the error it came with.
+type complianceEntry struct {
+	mu       sync.Mutex
+	score    *compliance
+	err      error
+	computed time.Time // zero until the context has been scored once
+}
+
+// complianceFor returns a context's score, from the cache while it is fresh. The entry's lock is
+// held across the scoring, so concurrent loads of one context coalesce onto one listing while the
+// other contexts go on. As with the dashboard, the scoring is detached from the caller's
+// cancellation, since every viewer shares the result, and bounded by its own timeout.
+func (s *server) complianceFor(ctx context.Context, kubeContext string, clients *kubeClients, constraints []map[string]any) (*compliance, error) {
+	if kubeContext == "" {
+		_, kubeContext = s.k8s.contexts()
+	}
+	s.compliance.mu.Lock()
+	if s.compliance.entries == nil {
+		s.compliance.entries = map[string]*complianceEntry{}
+	}
+	e := s.compliance.entries[kubeContext]
+	if e == nil {
+		e = &complianceEntry{}
+		s.compliance.entries[kubeContext] = e
+	}
+	s.compliance.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.computed.IsZero() && time.Since(e.computed) < dashboardCacheTTL {
+		return e.score, e.err
+	}
+	cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), clusterFetchTimeout)
+	defer cancel()
+	e.score, e.err = scoreCompliance(cctx, clients, constraints)
+	e.computed = time.Now()
+	return e.score, e.err
+}
+
+// auditExempt reads the namespace patterns the Configs keep the audit out of, as the Exemptions view
+// reads them. The audit reports nothing there, so their objects stay out of the score.
+func auditExempt(ctx context.Context, clients *kubeClients) []string {
+	configs, err := getCustomResources(ctx, *clients.dynamic, "config.gatekeeper.sh", "v1alpha1", "configs")
+	if err != nil {
+		slog.Warn("reading the Gatekeeper Config failed, the score counts every namespace", "error", err)
+		return nil
+	}
+	raw := make([]map[string]any, 0, len(configs.Items))
+	for _, c := range configs.Items {
+		raw = append(raw, c.Object)
+	}
+	var out []string
+	for _, ce := range configExemptionsOf(raw) {
+		if slices.Contains(ce.processes, "audit") {
+			out = append(out, ce.namespaces...)
+		}
+	}
+	return out
+}
+
+// scoreCompliance scores a cluster. An object is in scope when a scored Constraint selects it, or
+// when one reports it as a violation, and outside a namespace the Config exempts from the audit.
+// An in-scope object counts as failing by the worst mode of its violations. The error joins the
+// kinds GPM could not list; their Constraints are left out, and the rest is still scored. Without
+// the namespaces nothing can be scored, and the score is nil.
+func scoreCompliance(ctx context.Context, clients *kubeClients, constraints []map[string]any) (*compliance, error) {
+	namespaces, err := listNamespaces(ctx, clients)
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+	out := &compliance{Namespaces: map[string]complianceTally{}}
+	byName := make(map[string]*clusterNamespace, len(namespaces))
+	for i := range namespaces {
+		byName[namespaces[i].Name] = &namespaces[i]
+	}
+	excluded := auditExempt(ctx, clients)
+	exempt := func(ns string) bool {
+		return ns != "" && slices.ContainsFunc(excluded, func(p string) bool { return globMatch(p, ns) })
+	}
+
+	kindsOf := make([][]schema.GroupKind, len(constraints))
+	var all []schema.GroupKind
+	for i, o := range constraints {
+		kinds, ok := scoredKinds(o)
+		if !ok {
+			continue
+		}
+		kindsOf[i] = kinds
+		for _, gk := range kinds {
+			if !slices.Contains(all, gk) {
+				all = append(all, gk)
+			}
+		}
+	}
+	objects, failed := listKinds(ctx, clients, all)
+
+	inScope := map[objectRef]bool{}
+	worst := map[objectRef]string{}
+	for i, o := range constraints {
+		m := ssrConstraintModel(o)
+		kinds := kindsOf[i]
+		if kinds == nil || slices.ContainsFunc(kinds, func(gk schema.GroupKind) bool { return failed[gk] != nil }) {
+			out.Unscored = append(out.Unscored, ssrConstraintRef{Kind: m.Kind, Name: m.Name})
+			continue
+		}
+		out.Limited = out.Limited || m.AuditLimited
+		for _, gk := range kinds {
+			for _, obj := range objects[gk] {
+				ref := objectRef{Group: gk.Group, Kind: gk.Kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+				if inScope[ref] || exempt(ref.Namespace) || !constraintMatches(o, obj.Object, byName[ref.Namespace]) {
+					continue
+				}
+				inScope[ref] = true
+			}
+		}
+		for _, v := range m.Violations {
+			ref := objectRef{Group: v.Group, Kind: v.Kind, Namespace: v.Namespace, Name: v.Name}
+			if exempt(ref.Namespace) {
+				// Reported before the Config exempted the namespace.
+				continue
+			}
+			inScope[ref] = true
+			switch mode := enforcementMode(v.EnforcementAction); {
+			case mode == "deny":
+				worst[ref] = mode
+			case mode == "warn" && worst[ref] != "deny":
+				worst[ref] = mode
+			}
+		}
+	}
+
+	for ref := range inScope {
+		t := out.Namespaces[ref.Namespace]
+		t.InScope++
+		switch worst[ref] {
+		case "deny":
+			t.Deny++
+		case "warn":
+			t.Warn++
+		}
+		out.Namespaces[ref.Namespace] = t
+	}
+	for _, t := range out.Namespaces {
+		out.Cluster.add(t)
+	}
+
+	var errs []error
+	for _, err := range failed {
+		errs = append(errs, err)
+	}
+	return out, errors.Join(errs...)
+}
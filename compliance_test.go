@@ -0,0 +1,240 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+func TestComplianceScore(t *testing.T) {
+	for _, tc := range []struct {
+		tally complianceTally
+		want  string
+	}{
+		{complianceTally{InScope: 10, Deny: 1, Warn: 2}, "80%"},
+		{complianceTally{InScope: 1000, Deny: 1}, "99%"},
+		{complianceTally{InScope: 4}, "100%"},
+		{complianceTally{}, "—"},
+	} {
+		if got := tc.tally.Label(); got != tc.want {
+			t.Errorf("%+v: score %q, want %q", tc.tally, got, tc.want)
+		}
+	}
+}
+
+func TestScoredKinds(t *testing.T) {
+	constraint := func(kinds ...any) map[string]any {
+		return map[string]any{"spec": map[string]any{"match": map[string]any{"kinds": kinds}}}
+	}
+	kinds, ok := scoredKinds(constraint(map[string]any{"apiGroups": []any{"", "apps"}, "kinds": []any{"Pod", "Deployment"}}))
+	if !ok || len(kinds) != 4 {
+		t.Errorf("named kinds = %v, %v; want every group and kind paired", kinds, ok)
+	}
+	for name, c := range map[string]map[string]any{
+		"no match":       {"spec": map[string]any{}},
+		"no kinds":       constraint(),
+		"any group":      constraint(map[string]any{"apiGroups": []any{"*"}, "kinds": []any{"Pod"}}),
+		"any kind":       constraint(map[string]any{"apiGroups": []any{""}, "kinds": []any{"*"}}),
+		"no kinds named": constraint(map[string]any{"apiGroups": []any{""}}),
+	} {
+		if _, ok := scoredKinds(c); ok {
+			t.Errorf("%s: scored, want it left out", name)
+		}
+	}
+}
+
+// complianceTestAPI is a cluster with ConfigMaps in a production namespace, a development one and
+// a sandbox the Config keeps the audit out of. The sandbox still has a violation, from an audit run
+// before the Config exempted it.
+func complianceTestAPI(t *testing.T) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.serveGroup("apps", "v1", servedResource{Name: "deployments", Kind: "Deployment", Namespaced: true})
+	configMap := func(ns, name string) string {
+		return `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"` + name + `","namespace":"` + ns + `"}}`
+	}
+	api.serveGroup("", "v1",
+		servedResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Items: []string{
+			configMap("payments", "settings"), configMap("payments", "flags"), configMap("dev", "scratch"), configMap("sandbox", "anything"),
+		}},
+		servedResource{Name: "namespaces", Kind: "Namespace", Items: []string{
+			`{"metadata":{"name":"payments","labels":{"env":"prod","team":"payments"}}}`,
+			`{"metadata":{"name":"dev","labels":{"env":"dev"}}}`,
+			`{"metadata":{"name":"sandbox"}}`,
+		}})
+	api.respondAt("/apis/config.gatekeeper.sh/v1alpha1/configs", `{"apiVersion":"config.gatekeeper.sh/v1alpha1","kind":"ConfigList",`+
+		`"metadata":{},"items":[{"kind":"Config","metadata":{"name":"config","namespace":"gatekeeper-system"},`+
+		`"spec":{"match":[{"excludedNamespaces":["sand*"],"processes":["audit"]}]}}]}`)
+	configMaps := `"match":{"kinds":[{"apiGroups":[""],"kinds":["ConfigMap"]}]`
+	api.serveConstraints("K8sRequiredLabels",
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"prod-owner"},"spec":{`+configMaps+`,`+
+			`"namespaceSelector":{"matchLabels":{"env":"prod"}}}},`+
+			`"status":{"totalViolations":1,"violations":[`+
+			`{"enforcementAction":"deny","kind":"ConfigMap","namespace":"payments","name":"settings","message":"no owner"}]}}`,
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"docs"},"spec":{"enforcementAction":"warn",`+configMaps+`}},`+
+			`"status":{"totalViolations":3,"violations":[`+
+			`{"enforcementAction":"warn","kind":"ConfigMap","namespace":"payments","name":"settings","message":"no docs"},`+
+			`{"enforcementAction":"warn","kind":"ConfigMap","namespace":"dev","name":"scratch","message":"no docs"},`+
+			`{"enforcementAction":"warn","kind":"ConfigMap","namespace":"sandbox","name":"anything","message":"no docs"}]}}`,
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"everything"},"spec":{}}`)
+	return api
+}
+
+func TestComplianceFor(t *testing.T) {
+	_, clients := discoveryTestClients(t, complianceTestAPI(t))
+	raw, err := listConstraints(context.Background(), clients)
+	if err != nil {
+		t.Fatalf("listing constraints failed: %v", err)
+	}
+
+	score, err := scoreCompliance(context.Background(), clients, raw)
+	if err != nil {
+		t.Fatalf("scoring failed: %v", err)
+	}
+	// settings fails both and counts as a denial, not as a warning too.
+	if got := score.Namespaces["payments"]; got != (complianceTally{InScope: 2, Deny: 1}) {
+		t.Errorf("payments = %+v", got)
+	}
+	if got := score.Namespaces["dev"]; got != (complianceTally{InScope: 1, Warn: 1}) {
+		t.Errorf("dev = %+v", got)
+	}
+	if got := score.Namespaces["sandbox"]; got.InScope != 0 {
+		t.Errorf("the audit skips the sandbox, so it has nothing in scope: %+v", got)
+	}
+	if score.Cluster != (complianceTally{InScope: 3, Deny: 1, Warn: 1}) || score.Cluster.Label() != "50%" {
+		t.Errorf("cluster = %+v, %s", score.Cluster, score.Cluster.Label())
+	}
+	if len(score.Unscored) != 1 || score.Unscored[0].Name != "everything" {
+		t.Errorf("unscored = %v, want the Constraint that names no kinds", score.Unscored)
+	}
+	if got := score.forNamespaces([]string{"payments"}); got.Label() != "50%" {
+		t.Errorf("the payments team scores %s", got.Label())
+	}
+}
+
+// Each view that shows the score used to list every scored kind again. A context's score is kept
+// for dashboardCacheTTL, and the default context shares the entry of the context it names.
+func TestComplianceForCachesPerContext(t *testing.T) {
+	api := complianceTestAPI(t)
+	s, clients := discoveryTestClients(t, api)
+	raw, err := listConstraints(context.Background(), clients)
+	if err != nil {
+		t.Fatalf("listing constraints failed: %v", err)
+	}
+	_, current := s.k8s.contexts()
+
+	first, err := s.complianceFor(context.Background(), "", clients, raw)
+	if err != nil {
+		t.Fatalf("scoring failed: %v", err)
+	}
+	second, _ := s.complianceFor(context.Background(), current, clients, raw)
+	if second != first {
+		t.Error("the second load should be served from the cache")
+	}
+	lists := 0
+	for _, p := range api.requested() {
+		if p == "/api/v1/configmaps" {
+			lists++
+		}
+	}
+	if lists != 1 {
+		t.Errorf("the ConfigMaps were listed %d times, want once", lists)
+	}
+
+	s.compliance.entries[current].computed = time.Now().Add(-dashboardCacheTTL)
+	if third, _ := s.complianceFor(context.Background(), current, clients, raw); third == first {
+		t.Error("a stale score should be rebuilt")
+	}
+}
+
+// The score lists metadata only, a chunk at a time, and the items come back as objects a match
+// can read.
+func TestListMetadataPages(t *testing.T) {
+	var accepts, limits []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepts = append(accepts, r.Header.Get("Accept"))
+		limits = append(limits, r.URL.Query().Get("limit"))
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("continue") == "" {
+			_, _ = fmt.Fprint(w, `{"kind":"PartialObjectMetadataList","apiVersion":"meta.k8s.io/v1","metadata":{"continue":"next"},`+
+				`"items":[{"metadata":{"name":"web","namespace":"apps","labels":{"app":"web"}}}]}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"kind":"PartialObjectMetadataList","apiVersion":"meta.k8s.io/v1","metadata":{},`+
+			`"items":[{"metadata":{"name":"api","namespace":"apps"}}]}`)
+	}))
+	t.Cleanup(srv.Close)
+	client, err := metadata.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	items, err := listMetadata(context.Background(), &kubeClients{metadata: client}, gvr, "Deployment")
+	if err != nil {
+		t.Fatalf("listing failed: %v", err)
+	}
+	if len(items) != 2 || items[0].GetName() != "web" || items[1].GetName() != "api" {
+		t.Fatalf("items = %+v, want web and api", items)
+	}
+	if u := items[0]; u.GetAPIVersion() != "apps/v1" || u.GetKind() != "Deployment" || u.GetLabels()["app"] != "web" {
+		t.Errorf("item = %+v, want a Deployment with its labels", u.Object)
+	}
+	if len(limits) != 2 || limits[0] != "500" || !strings.Contains(accepts[0], "as=PartialObjectMetadataList") {
+		t.Errorf("requests asked limit %q, accept %q", limits, accepts)
+	}
+}
+
+func TestResourcesViewShowsTheScore(t *testing.T) {
+	s, _ := discoveryTestClients(t, complianceTestAPI(t))
+	viper.Set("compliance_score", true)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/resources?view=objects", nil)
+	if err := s.getResources(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`title="3 objects in scope: 1 with a deny violation, 1 with a warn one and no deny">Score 50%</span>`,
+		`title="1 object in scope: 0 with a deny violation, 1 with a warn one and no deny">Score 50%</span>`,
+		"It leaves out one Constraint",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("resources view missing %q", want)
+		}
+	}
+}
+
+func TestDashboardScoresEachCluster(t *testing.T) {
+	d := aggregateDashboard([]clusterConstraints{
+		{context: "alpha", reachable: true, compliance: &complianceTally{InScope: 100, Deny: 10}},
+		{context: "beta", reachable: true, compliance: &complianceTally{InScope: 900}},
+		{context: "gamma", reachable: true},
+	})
+	if got := d.Clusters[0].Score; got == nil || *got != 90 {
+		t.Errorf("alpha scores %v, want 90", got)
+	}
+	if d.Clusters[2].Score != nil {
+		t.Error("a cluster that was not scored should have no score")
+	}
+	// The fleet's score weighs the clusters by their size: 10 failing objects in 1000.
+	if d.Compliance == nil || d.Compliance.Label() != "99%" {
+		t.Errorf("fleet score = %+v", d.Compliance)
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The optional deny-log collector. Gatekeeper started with --log-denies writes every verdict to its
+// controller logs, one JSON line each, with the same fields it puts in the Event annotations. Events
+// are rate limited, aggregated and dropped by the API server after an hour; the log lines are not.
+// The collector reads them through pods/log on every context and the Events view merges them in.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// How many log verdicts the collector keeps per context. The oldest go first.
+	denyLogBufferSize = 5000
+	// How far back the first read of a pod's log goes. Kubernetes keeps Events for an hour by
+	// default, so this covers the same span the view already shows.
+	denyLogLookback = time.Hour
+	// The most one read of one container's log returns. A controller that logs this much in one
+	// interval is better served by a log pipeline than by GPM.
+	denyLogLimitBytes = 8 << 20
+	// How far a log line's time may sit outside an Event's first and last sighting and still be
+	// the same verdict. The webhook logs and records at slightly different moments, and the Event
+	// only keeps whole seconds.
+	denyLogMatchSlack = 5 * time.Second
+	// The default GPM_DENY_LOGS_INTERVAL, in seconds.
+	defaultDenyLogInterval = 30
+)
+
+// denyLogCollector polls the Gatekeeper pods' logs on every context and keeps the verdicts it
+// finds. A nil collector is a disabled one, and every method accepts that.
+type denyLogCollector struct {
+	k8s       *clientRegistry
+	namespace string
+	selector  string
+	interval  time.Duration
+	limit     int // the per-context buffer size
+
+	mu   sync.RWMutex
+	logs map[string]*denyLogRing // by context name
+}
+
+// denyLogRing is one context's verdicts, oldest first. keys runs parallel to events and holds the
+// hash of the line each came from, so overlapping reads do not add a line twice.
+type denyLogRing struct {
+	events []ssrEvent
+	keys   []uint64
+	seen   map[uint64]struct{}
+	// When each pod's container was last read, by "pod/container". The next read starts there.
+	since map[string]time.Time
+}
+
+// newDenyLogCollector reads the GPM_DENY_LOGS settings and returns nil when the collector is off.
+func newDenyLogCollector(registry *clientRegistry) *denyLogCollector {
+	if !viper.GetBool("deny_logs") {
+		return nil
+	}
+	interval := viper.GetInt("deny_logs_interval")
+	if interval <= 0 {
+		slog.Warn("GPM_DENY_LOGS_INTERVAL is not a positive number of seconds, falling back to the default",
+			"configured", viper.GetString("deny_logs_interval"), "using", defaultDenyLogInterval)
+		interval = defaultDenyLogInterval
+	}
+	return &denyLogCollector{
+		k8s:       registry,
+		namespace: viper.GetString("deny_logs_namespace"),
+		selector:  viper.GetString("deny_logs_selector"),
+		interval:  time.Duration(interval) * time.Second,
+		limit:     denyLogBufferSize,
+		logs:      map[string]*denyLogRing{},
+	}
+}
+
+// run polls until the context ends. The first poll is immediate, so the view has data shortly
+// after startup rather than one interval later.
+func (d *denyLogCollector) run(ctx context.Context) {
+	slog.Info("collecting Gatekeeper deny logs", "namespace", d.namespace, "selector", d.selector, "interval", d.interval)
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.collect(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// contextNames is every context in the kubeconfig, or the unnamed in-cluster one when there is no
+// kubeconfig.
+func (d *denyLogCollector) contextNames() []string {
+	contexts, _ := d.k8s.contexts()
+	if len(contexts) == 0 {
+		return []string{defaultKubeContext}
+	}
+	names := make([]string, 0, len(contexts))
+	for name := range contexts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// key is the buffer a request's context reads: the unnamed default is the kubeconfig's current
+// context, which the collector polls under its name.
+func (d *denyLogCollector) key(name string) string {
+	if name == defaultKubeContext {
+		_, current := d.k8s.contexts()
+		return current
+	}
+	return name
+}
+
+// collect polls every context once. One unreachable cluster does not hold up the others past its
+// own timeout.
+func (d *denyLogCollector) collect(ctx context.Context) {
+	for _, name := range d.contextNames() {
+		clients, err := d.k8s.forContext(name)
+		if err != nil {
+			slog.Warn("deny logs: resolving context failed", "context", name, "error", err)
+			continue
+		}
+		pollCtx, cancel := context.WithTimeout(ctx, d.interval)
+		if err := d.collectContext(pollCtx, name, clients); err != nil {
+			slog.Warn("deny logs: reading the Gatekeeper logs failed", "context", name, "namespace", d.namespace, "error", err)
+		}
+		cancel()
+	}
+}
+
+// collectContext reads the log of every container of every Gatekeeper pod in one context, from
+// where the previous read of that container stopped.
+func (d *denyLogCollector) collectContext(ctx context.Context, name string, clients *kubeClients) error {
+	pods, err := clients.dynamic.Resource(schema.GroupVersionResource{Version: "v1", Resource: "pods"}).
+		Namespace(d.namespace).List(ctx, metav1.ListOptions{LabelSelector: d.selector})
+	if err != nil {
+		return fmt.Errorf("listing the Gatekeeper pods: %w", err)
+	}
+
+	d.mu.Lock()
+	ring, ok := d.logs[name]
+	if !ok {
+		ring = &denyLogRing{seen: map[uint64]struct{}{}, since: map[string]time.Time{}}
+		d.logs[name] = ring
+	}
+	since := make(map[string]time.Time, len(ring.since))
+	for k, v := range ring.since {
+		since[k] = v
+	}
+	d.mu.Unlock()
+
+	read := map[string]time.Time{}
+	for _, pod := range pods.Items {
+		containers, _, _ := unstructured.NestedSlice(pod.Object, "spec", "containers")
+		for _, c := range containers {
+			cm, ok := c.(map[string]any)
+			if !ok {
+				continue
+			}
+			container, _, _ := unstructured.NestedString(cm, "name")
+			key := pod.GetName() + "/" + container
+			start := time.Now()
+			body, err := fetchPodLog(ctx, clients, d.namespace, pod.GetName(), container, since[key])
+			if err != nil {
+				slog.Warn("deny logs: reading a pod log failed", "context", name, "pod", pod.GetName(), "container", container, "error", err)
+				read[key] = since[key]
+				continue
+			}
+			d.add(ring, body, pod.GetName())
+			read[key] = start
+		}
+	}
+
+	// Pods that are gone drop out here, so a rolling restart does not grow the map forever.
+	d.mu.Lock()
+	ring.since = read
+	d.mu.Unlock()
+	return nil
+}
+
+// fetchPodLog reads one container's log, from since or the lookback when the container was never
+// read. The API's sinceTime has whole seconds only, so consecutive reads overlap by up to a
+// second; add drops the lines it has seen already.
+func fetchPodLog(ctx context.Context, clients *kubeClients, namespace, pod, container string, since time.Time) ([]byte, error) {
+	req := clients.discovery.RESTClient().Get().
+		AbsPath("/api/v1/namespaces", namespace, "pods", pod, "log").
+		Param("container", container).
+		Param("limitBytes", strconv.Itoa(denyLogLimitBytes))
+	if since.IsZero() {
+		req = req.Param("sinceSeconds", strconv.Itoa(int(denyLogLookback/time.Second)))
+	} else {
+		req = req.Param("sinceTime", since.UTC().Format(time.RFC3339))
+	}
+	return req.DoRaw(ctx)
+}
+
+// add parses a log body into the ring, skipping lines it holds already and trimming the oldest
+// entries past the limit.
+func (d *denyLogCollector) add(ring *denyLogRing, body []byte, pod string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		e, ok := parseDenyLogLine(line, pod)
+		if !ok {
+			continue
+		}
+		h := fnv.New64a()
+		_, _ = h.Write(line)
+		key := h.Sum64()
+		if _, dup := ring.seen[key]; dup {
+			continue
+		}
+		ring.seen[key] = struct{}{}
+		ring.events = append(ring.events, e)
+		ring.keys = append(ring.keys, key)
+	}
+
+	if over := len(ring.events) - d.limit; over > 0 {
+		for _, key := range ring.keys[:over] {
+			delete(ring.seen, key)
+		}
+		ring.events = slices.Delete(ring.events, 0, over)
+		ring.keys = slices.Delete(ring.keys, 0, over)
+	}
+}
+
+// events returns up to limit of a context's log verdicts that match the query, after the first skip
+// of them, newest first unless the query sorts oldest first. more says there are others after them.
+func (d *denyLogCollector) events(name string, q eventQuery, skip, limit int) (out []ssrEvent, more bool) {
+	if d == nil {
+		return nil, false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	ring, ok := d.logs[d.key(name)]
+	if !ok {
+		return nil, false
+	}
+	for n := range ring.events {
+		i := len(ring.events) - 1 - n
+		if q.Sort == "oldest" {
+			i = n
+		}
+		if !q.matches(ring.events[i]) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if len(out) == limit {
+			return out, true
+		}
+		out = append(out, ring.events[i])
+	}
+	return out, false
+}
+
+// The Event reason Gatekeeper records for each kind of verdict, so a log line reads the same as
+// the Event it would have been.
+func denyLogReason(process, mode string) string {
+	switch {
+	case process == "audit":
+		return "AuditViolation"
+	case mode == "warn":
+		return "WarningAdmission"
+	case mode == "dryrun":
+		return "DryrunViolation"
+	default:
+		return "FailedAdmission"
+	}
+}
+
+// parseDenyLogLine reads one controller log line. Only the verdict lines count: the ones with an
+// event_type of violation (admission) or violation_audited (audit) and a constraint name. The
+// timestamp is zap's, a float of epoch seconds by default or an ISO 8601 string when the encoder
+// was configured for it.
+func parseDenyLogLine(line []byte, pod string) (ssrEvent, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return ssrEvent{}, false
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return ssrEvent{}, false
+	}
+	str := func(k string) string {
+		v, _ := entry[k].(string)
+		return v
+	}
+	if !strings.HasPrefix(str("event_type"), "violation") || str("constraint_name") == "" {
+		return ssrEvent{}, false
+	}
+
+	m := ssrEvent{Origin: "log", Name: pod, SourceHost: pod}
+	m.Action = str("constraint_action")
+	if m.Action != "" {
+		m.Mode = enforcementMode(m.Action)
+	}
+	m.ConstraintKind = str("constraint_kind")
+	m.ConstraintName = str("constraint_name")
+	m.EventType = str("event_type")
+	m.Process = str("process")
+	m.RequestUsername = str("request_username")
+	m.ResourceAPIVersion = str("resource_api_version")
+	m.ResourceGroup = str("resource_group")
+	m.ResourceKind = str("resource_kind")
+	m.ResourceName = str("resource_name")
+	m.ResourceNamespace = str("resource_namespace")
+	m.ObjKind, m.ObjName, m.ObjNamespace = m.ResourceKind, m.ResourceName, m.ResourceNamespace
+	m.Message = strings.TrimPrefix(str("msg"), "denied admission: ")
+
+	m.SourceComponent = "gatekeeper-webhook"
+	if m.Process == "audit" {
+		m.SourceComponent = "gatekeeper-audit"
+	}
+	m.Reason = denyLogReason(m.Process, m.Mode)
+
+	switch ts := entry["ts"].(type) {
+	case float64:
+		sec := int64(ts)
+		m.Time = time.Unix(sec, int64((ts-float64(sec))*1e9)).UTC()
+	case string:
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			m.Time = t.UTC()
+		}
+	}
+	if !m.Time.IsZero() {
+		m.First = m.Time
+		m.FirstTimestamp = m.Time.Format("2006-01-02 15:04:05 UTC")
+		m.LastTimestamp = m.FirstTimestamp
+	}
+	return m, true
+}
+
+// verdictKey is what makes an Event and a log line the same verdict: the same policy judging the
+// same resource the same way, from the same component.
+func (e ssrEvent) verdictKey() string {
+	return strings.Join([]string{
+		e.SourceComponent, e.ConstraintKind, e.ConstraintName, e.Mode,
+		e.ResourceKind, e.ResourceNamespace, e.ResourceName,
+	}, "\x00")
+}
+
+// mergeDenyLogs adds a page's share of the log verdicts to its Events, leaving out each one an Event
+// on the page already records. An Event aggregates repeats, so a log line counts as recorded when
+// its time falls in the Event's first-to-last span.
+func mergeDenyLogs(events, logs []ssrEvent) []ssrEvent {
+	spans := map[string][]ssrEvent{}
+	for _, e := range events {
+		if e.Origin == "" {
+			spans[e.verdictKey()] = append(spans[e.verdictKey()], e)
+		}
+	}
+	for _, l := range logs {
+		recorded := slices.ContainsFunc(spans[l.verdictKey()], func(e ssrEvent) bool {
+			if e.Time.IsZero() || l.Time.IsZero() {
+				return true
+			}
+			first := e.First
+			if first.IsZero() {
+				first = e.Time
+			}
+			return !l.Time.Before(first.Add(-denyLogMatchSlack)) && !l.Time.After(e.Time.Add(denyLogMatchSlack))
+		})
+		if !recorded {
+			events = append(events, l)
+		}
+	}
+	return events
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+)
+
+// The line Gatekeeper's webhook writes for a denial with --log-denies, as zap encodes it by default.
+const webhookDenyLine = `{"level":"info","ts":1772359200.25,"logger":"webhook","msg":"denied admission: you must provide labels: {\"owner\"}",` +
+	`"process":"admission","event_type":"violation","constraint_name":"owner","constraint_group":"constraints.gatekeeper.sh",` +
+	`"constraint_api_version":"v1beta1","constraint_kind":"K8sRequiredLabels","constraint_action":"deny",` +
+	`"resource_group":"apps","resource_api_version":"v1","resource_kind":"Deployment","resource_namespace":"apps",` +
+	`"resource_name":"web","request_username":"alice"}`
+
+// The line the audit writes for a violation, with an ISO 8601 timestamp.
+const auditDenyLine = `{"level":"info","ts":"2026-03-01T10:05:00Z","logger":"controller","msg":"image not allowed",` +
+	`"process":"audit","event_type":"violation_audited","constraint_name":"repos","constraint_kind":"K8sAllowedRepos",` +
+	`"constraint_action":"warn","resource_kind":"Pod","resource_namespace":"ci","resource_name":"runner"}`
+
+func TestParseDenyLogLine(t *testing.T) {
+	admission, ok := parseDenyLogLine([]byte(webhookDenyLine), "gatekeeper-controller-manager-0")
+	if !ok {
+		t.Fatal("the webhook deny line was not read")
+	}
+	want := ssrEvent{
+		Origin: "log", Name: "gatekeeper-controller-manager-0", SourceHost: "gatekeeper-controller-manager-0",
+		SourceComponent: "gatekeeper-webhook", Reason: "FailedAdmission", Message: `you must provide labels: {"owner"}`,
+		Action: "deny", Mode: "deny", ConstraintKind: "K8sRequiredLabels", ConstraintName: "owner",
+		EventType: "violation", Process: "admission", RequestUsername: "alice",
+		ResourceAPIVersion: "v1", ResourceGroup: "apps", ResourceKind: "Deployment", ResourceName: "web", ResourceNamespace: "apps",
+		ObjKind: "Deployment", ObjName: "web", ObjNamespace: "apps",
+		FirstTimestamp: "2026-03-01 10:00:00 UTC", LastTimestamp: "2026-03-01 10:00:00 UTC",
+	}
+	at := time.Date(2026, 3, 1, 10, 0, 0, 250_000_000, time.UTC)
+	if !admission.Time.Equal(at) || !admission.First.Equal(at) {
+		t.Errorf("Time = %v, First = %v, want %v", admission.Time, admission.First, at)
+	}
+	admission.Time, admission.First = time.Time{}, time.Time{}
+	if admission != want {
+		t.Errorf("model = %+v\nwant    %+v", admission, want)
+	}
+
+	audit, ok := parseDenyLogLine([]byte(auditDenyLine), "gatekeeper-audit-0")
+	if !ok {
+		t.Fatal("the audit line was not read")
+	}
+	if audit.SourceComponent != "gatekeeper-audit" || audit.Reason != "AuditViolation" || audit.Mode != "warn" ||
+		!audit.Time.Equal(time.Date(2026, 3, 1, 10, 5, 0, 0, time.UTC)) {
+		t.Errorf("audit model = %+v, want an audit warn at 10:05", audit)
+	}
+
+	for _, line := range []string{
+		"",
+		"I0301 10:00:00.000000 1 main.go:1] starting",
+		`{"level":"info","msg":"starting manager"}`,
+		`{"level":"info","event_type":"violation","msg":"no constraint named"}`,
+		`{"level":"info","event_type":"mutation_applied","constraint_name":"x"}`,
+		`{"broken":`,
+	} {
+		if _, ok := parseDenyLogLine([]byte(line), "pod"); ok {
+			t.Errorf("%q was read as a verdict", line)
+		}
+	}
+}
+
+// A line read twice, as consecutive reads overlap, is kept once; past the limit the oldest lines go
+// and can then come back, since nothing remembers them any more.
+func TestDenyLogCollectorKeepsEachLineOnceWithinItsLimit(t *testing.T) {
+	d := &denyLogCollector{limit: 2, logs: map[string]*denyLogRing{}}
+	ring := &denyLogRing{seen: map[uint64]struct{}{}, since: map[string]time.Time{}}
+
+	line := func(name string) string {
+		return strings.Replace(webhookDenyLine, `"resource_name":"web"`, `"resource_name":"`+name+`"`, 1)
+	}
+	d.add(ring, []byte(line("a")+"\n"+line("b")+"\n"), "pod")
+	d.add(ring, []byte(line("b")+"\nnot json\n"), "pod")
+	if len(ring.events) != 2 {
+		t.Fatalf("kept %d lines, want 2", len(ring.events))
+	}
+
+	d.add(ring, []byte(line("c")+"\n"), "pod")
+	names := []string{ring.events[0].ResourceName, ring.events[1].ResourceName}
+	if names[0] != "b" || names[1] != "c" || len(ring.seen) != 2 {
+		t.Errorf("kept %v with %d keys, want [b c] with 2", names, len(ring.seen))
+	}
+}
+
+func TestMergeDenyLogsSkipsVerdictsAnEventRecords(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	log, _ := parseDenyLogLine([]byte(webhookDenyLine), "pod")
+
+	event := log
+	event.Origin = ""
+	event.First, event.Time = at.Add(-time.Minute), at
+
+	other := log
+	other.Mode, other.Action = "warn", "warn"
+
+	late := log
+	late.Time = at.Add(time.Hour)
+
+	got := mergeDenyLogs([]ssrEvent{event}, []ssrEvent{log, other, late})
+	if len(got) != 3 {
+		t.Fatalf("merged %d entries, want the Event, the warn and the later denial", len(got))
+	}
+	if got[1].Mode != "warn" || !got[2].Time.Equal(at.Add(time.Hour)) {
+		t.Errorf("merged %+v", got[1:])
+	}
+}
+
+// A stand-in API with one Gatekeeper pod whose log holds the given lines. It records the query of
+// every log read, since where a read starts is the behavior under test.
+type podLogAPI struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	log     string
+	queries []url.Values
+	listed  []url.Values
+}
+
+func newPodLogAPI(t *testing.T) *podLogAPI {
+	t.Helper()
+
+	api := &podLogAPI{}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v1/namespaces/gatekeeper-system/pods":
+			api.listed = append(api.listed, r.URL.Query())
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, `{"apiVersion":"v1","kind":"PodList","items":[{"metadata":{"name":"gk-0","namespace":"gatekeeper-system"},`+
+				`"spec":{"containers":[{"name":"manager"}]}}]}`)
+		case "/api/v1/namespaces/gatekeeper-system/pods/gk-0/log":
+			api.queries = append(api.queries, r.URL.Query())
+			_, _ = fmt.Fprint(w, api.log)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func TestDenyLogCollectorReadsEachPodFromWhereItStopped(t *testing.T) {
+	api := newPodLogAPI(t)
+	api.log = webhookDenyLine + "\n" + `{"level":"info","msg":"unrelated"}` + "\n"
+	useTestSettings(t)
+	s := newEventsTestServer(t, &recordingAPI{server: api.server})
+	clients, err := s.k8s.forContext(defaultKubeContext)
+	if err != nil {
+		t.Fatalf("resolving clients failed: %v", err)
+	}
+
+	d := &denyLogCollector{
+		k8s: s.k8s, namespace: "gatekeeper-system", selector: "gatekeeper.sh/system=yes",
+		interval: time.Second, limit: denyLogBufferSize, logs: map[string]*denyLogRing{},
+	}
+	for range 2 {
+		if err := d.collectContext(context.Background(), "fake", clients); err != nil {
+			t.Fatalf("collecting failed: %v", err)
+		}
+	}
+
+	if got := api.listed[0].Get("labelSelector"); got != "gatekeeper.sh/system=yes" {
+		t.Errorf("listed the pods with labelSelector %q", got)
+	}
+	first, second := api.queries[0], api.queries[1]
+	if first.Get("container") != "manager" || first.Get("sinceSeconds") != "3600" || first.Get("sinceTime") != "" {
+		t.Errorf("first read = %v, want the manager container over the last hour", first)
+	}
+	if second.Get("sinceTime") == "" || second.Get("sinceSeconds") != "" {
+		t.Errorf("second read = %v, want it to start where the first stopped", second)
+	}
+
+	// newEventsTestServer's kubeconfig names its context "fake", and it is the current one.
+	got, more := d.events(defaultKubeContext, eventQuery{Sources: []string{"gatekeeper-webhook"}}, 0, 10)
+	if len(got) != 1 || got[0].ConstraintName != "owner" || more {
+		t.Errorf("collected %+v, want the one denial, once", got)
+	}
+	if got, _ := d.events("fake", eventQuery{Sources: []string{"gatekeeper-audit"}}, 0, 10); got != nil {
+		t.Error("the source filter did not apply to the log verdicts")
+	}
+}
+
+func TestEventsViewMergesTheDenyLogs(t *testing.T) {
+	api := newRecordingAPI(t)
+	useTestSettings(t)
+	s := newEventsTestServer(t, api)
+	s.denyLogs = &denyLogCollector{k8s: s.k8s, limit: denyLogBufferSize, logs: map[string]*denyLogRing{}}
+	ring := &denyLogRing{seen: map[uint64]struct{}{}, since: map[string]time.Time{}}
+	s.denyLogs.logs["fake"] = ring
+	s.denyLogs.add(ring, []byte(webhookDenyLine), "gk-0")
+
+	render := func(query string) string {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		if err := s.getEvents(e.NewContext(httptest.NewRequest(http.MethodGet, "/events"+query, nil), rec)); err != nil {
+			t.Fatalf("the handler returned an error: %v", err)
+		}
+		return rec.Body.String()
+	}
+
+	out := render("")
+	for _, want := range []string{`data-origin="log"`, `<span class="tag">log</span> FailedAdmission`, "Gatekeeper pod log", "--log-denies"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("events output missing %q", want)
+		}
+	}
+	if out := render("?user=bob"); strings.Contains(out, `data-origin="log"`) {
+		t.Error("a log verdict ignored the filter bar")
+	}
+
+	// The verdict is about a Deployment in apps: it shows where apps is read, and nowhere else.
+	if out := render("?namespace=apps"); !strings.Contains(out, `data-origin="log"`) {
+		t.Error("the requested namespace left out its own log verdict")
+	}
+	if out := render("?namespace=ci"); strings.Contains(out, `data-origin="log"`) {
+		t.Error("a log verdict ignored the requested namespace")
+	}
+	viper.Set("events_namespace", "gatekeeper-system")
+	if out := render(""); strings.Contains(out, `data-origin="log"`) {
+		t.Error("a log verdict showed a namespace the deployment is not configured for")
+	}
+}
+
+// A busy cluster fills every page with Events. The log verdicts still show, a page size of them per
+// page, and the pager goes on past the last Event while verdicts remain.
+func TestEventsViewPagesTheDenyLogsBesideAFullPage(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.respondWith(`{"apiVersion":"v1","kind":"EventList","metadata":{},"items":[` +
+		pagedEvent(1, "deny", "gatekeeper-webhook") + "," + pagedEvent(2, "deny", "gatekeeper-webhook") + `]}`)
+	useTestSettings(t)
+	s := newEventsTestServer(t, api)
+	s.denyLogs = &denyLogCollector{k8s: s.k8s, limit: denyLogBufferSize, logs: map[string]*denyLogRing{}}
+	ring := &denyLogRing{seen: map[uint64]struct{}{}, since: map[string]time.Time{}}
+	s.denyLogs.logs["fake"] = ring
+	for _, name := range []string{"first", "second"} {
+		s.denyLogs.add(ring, []byte(strings.Replace(webhookDenyLine, `"resource_name":"web"`, `"resource_name":"`+name+`"`, 1)), "gk-0")
+	}
+
+	render := func(query string) string {
+		rec := httptest.NewRecorder()
+		if err := s.getEvents(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/events"+query, nil), rec)); err != nil {
+			t.Fatalf("the handler returned an error: %v", err)
+		}
+		return rec.Body.String()
+	}
+
+	// The page of one is full with an Event, and still takes the newest verdict.
+	out := render("?limit=1")
+	if strings.Count(out, `data-origin="log"`) != 1 || !strings.Contains(out, "second") {
+		t.Error("a page full of Events left out the log verdicts")
+	}
+	if !strings.Contains(out, "logs=1") {
+		t.Error("the next page link does not carry the verdicts this page showed")
+	}
+
+	// Past the last Event, the pages go on with the verdicts alone.
+	out = render("?limit=1&logs=1&continue=" + doneEventCursor(nil))
+	if strings.Count(out, `data-origin="log"`) != 1 || !strings.Contains(out, "first") || strings.Contains(out, "Next page") {
+		t.Error("the last page should hold the older verdict and link nowhere further")
+	}
+	if out := render("?limit=1&action=warn"); strings.Contains(out, `data-origin="log"`) {
+		t.Error("a log verdict ignored the action filter")
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Drift between clusters. The dashboard joins the clusters' Constraints by kind and name to add up
+// their violations; the Drift view joins their ConstraintTemplates and Constraints to compare them.
+// Every policy gets a row and every context a column, and each cell says whether the cluster has the
+// policy, and whether its spec is the one the base cluster has. A cell that differs comes with a
+// side-by-side YAML diff of the two specs. The base is the context the view is opened under, so the
+// context switcher picks it.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The most side-by-side diffs one comparison of the fleet draws, on the Drift view or against a
+// bundle. Each is a diff of two specs, so a policy that differs everywhere would otherwise cost one
+// per context. The differences past it are still listed, with their fields.
+const driftMaxDiffs = 50
+
+// clusterPolicies is one cluster's templates and Constraints, as driftModel compares them.
+type clusterPolicies struct {
+	context     string
+	err         error // the cluster could not be read; its column is unreachable
+	templates   []map[string]any
+	constraints []map[string]any
+}
+
+// driftLine is one row of a side-by-side diff. A changed line has both sides; a removed one only
+// the base's, and an added one only the cluster's.
+type driftLine struct {
+	Base    string
+	Cluster string
+	Op      string // same | changed | removed | added
+}
+
+// driftCell is one policy on one cluster.
+type driftCell struct {
+	Cluster string
+	State   string      // base | same | differs | missing | unreachable
+	Fields  []string    // the spec fields that differ from the base's, "rego" for a template's code
+	Diff    []driftLine // nil past driftMaxDiffs
+	Anchor  string      // the id of the diff's card
+	URL     string      // the policy on the cluster's own view
+}
+
+// driftRow is one ConstraintTemplate, or one Constraint, across the clusters.
+type driftRow struct {
+	Kind     string // ConstraintTemplate, or the Constraint's kind
+	Name     string
+	Template bool
+	Anchor   string
+	Cells    []driftCell // in the order of the clusters
+	Drifted  bool        // some cluster lacks the policy or has a different spec
+	BaseName string      // the cluster the diffs compare against
+}
+
+// Summary counts the clusters that drift, for the row's tooltip.
+func (r driftRow) Summary() string {
+	var differs, missing int
+	for _, c := range r.Cells {
+		switch c.State {
+		case "differs":
+			differs++
+		case "missing":
+			missing++
+		}
+	}
+	return plural(differs, "cluster differs", "clusters differ") + ", " + plural(missing, "cluster lacks it", "clusters lack it")
+}
+
+// policySpec is what the view compares: the spec, with the defaults Gatekeeper applies filled in, so
+// a Constraint that says enforcementAction: deny and one that leaves it out are the same.
+func policySpec(o map[string]any, template bool) map[string]any {
+	spec, _, _ := unstructured.NestedMap(o, "spec")
+	if spec == nil {
+		spec = map[string]any{}
+	}
+	if !template {
+		if action, _ := spec["enforcementAction"].(string); action == "" {
+			spec["enforcementAction"] = "deny"
+		}
+	}
+	return spec
+}
+
+// specFields names the top-level spec fields that differ. A template's targets carry its Rego, or
+// its CEL, so they read as "rego"; its crd carries the parameters' schema.
+func specFields(base, other map[string]any, template bool) []string {
+	var out []string
+	for _, k := range slices.Sorted(maps.Keys(union(base, other))) {
+		if reflect.DeepEqual(base[k], other[k]) {
+			continue
+		}
+		switch {
+		case template && k == "targets":
+			k = "rego"
+		case template && k == "crd":
+			k = "schema"
+		}
+		out = append(out, k)
+	}
+	return out
+}
+
+// union is the keys of both maps, with b's values where both have one.
+func union[K comparable, V any](a, b map[K]V) map[K]V {
+	out := maps.Clone(a)
+	maps.Copy(out, b)
+	return out
+}
+
+// sideBySide lays diffText's unified diff out in two columns. A run of removed lines and the run of
+// added lines after it are paired up line by line, as changed; the longer run's remainder stands alone.
+func sideBySide(diff string) []driftLine {
+	var out []driftLine
+	var removed, added []string
+	flush := func() {
+		for i := range max(len(removed), len(added)) {
+			l := driftLine{Op: "changed"}
+			switch {
+			case i >= len(added):
+				l.Op = "removed"
+			case i >= len(removed):
+				l.Op = "added"
+			}
+			if i < len(removed) {
+				l.Base = removed[i]
+			}
+			if i < len(added) {
+				l.Cluster = added[i]
+			}
+			out = append(out, l)
+		}
+		removed, added = nil, nil
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		prefix, text := line[:min(2, len(line))], line[min(2, len(line)):]
+		switch prefix {
+		case "- ":
+			if len(added) > 0 {
+				flush()
+			}
+			removed = append(removed, text)
+		case "+ ":
+			added = append(added, text)
+		default:
+			flush()
+			out = append(out, driftLine{Base: text, Cluster: text, Op: "same"})
+		}
+	}
+	flush()
+	return out
+}
+
+// policyKey names a policy across clusters: a template by its name, a Constraint by kind and name.
+type policyKey struct {
+	template   bool
+	kind, name string
+}
+
+// policyIndex keys a cluster's policies.
+func policyIndex(templates, constraints []map[string]any) map[policyKey]map[string]any {
+	out := make(map[policyKey]map[string]any, len(templates)+len(constraints))
+	for _, o := range templates {
+		out[policyKey{true, "ConstraintTemplate", (&unstructured.Unstructured{Object: o}).GetName()}] = o
+	}
+	for _, o := range constraints {
+		u := &unstructured.Unstructured{Object: o}
+		out[policyKey{false, u.GetKind(), u.GetName()}] = o
+	}
+	return out
+}
+
+// comparePolicyKeys orders the templates first, then the Constraints by kind and name.
+func comparePolicyKeys(a, b policyKey) int {
+	if a.template != b.template {
+		if a.template {
+			return -1
+		}
+		return 1
+	}
+	if c := strings.Compare(a.kind, b.kind); c != 0 {
+		return c
+	}
+	return strings.Compare(a.name, b.name)
+}
+
+// comparePolicy compares a policy's spec with the reference's: the fields that differ and, when any
+// does and draw is set, the side-by-side diff of the two.
+func comparePolicy(ref, other map[string]any, template, draw bool) ([]string, []driftLine) {
+	refSpec, spec := policySpec(ref, template), policySpec(other, template)
+	fields := specFields(refSpec, spec, template)
+	if len(fields) == 0 || !draw {
+		return fields, nil
+	}
+	return fields, sideBySide(diffText(toYAML(refSpec), toYAML(spec)))
+}
+
+// driftModel compares the clusters' policies with the base cluster's. A policy the base lacks is
+// compared with the first cluster that has it, which the row names. The rows come templates first,
+// then the Constraints by kind and name. The first driftMaxDiffs cells that differ get a diff; the
+// rest are counted in undrawn.
+func driftModel(clusters []clusterPolicies, base string) (rows []driftRow, undrawn int) {
+	found := map[policyKey]bool{}
+	byCluster := make([]map[policyKey]map[string]any, len(clusters))
+	for i, c := range clusters {
+		byCluster[i] = policyIndex(c.templates, c.constraints)
+		for k := range byCluster[i] {
+			found[k] = true
+		}
+	}
+	keys := slices.SortedFunc(maps.Keys(found), comparePolicyKeys)
+
+	rows = make([]driftRow, 0, len(keys))
+	diffs := driftMaxDiffs
+	for _, k := range keys {
+		row := driftRow{Kind: k.kind, Name: k.name, Template: k.template, Anchor: "drift-" + constraintAnchor(k.kind, k.name)}
+		// The reference is the base's copy, or failing that the first cluster's that has one.
+		ref := slices.IndexFunc(clusters, func(c clusterPolicies) bool { return c.context == base && c.err == nil })
+		if _, ok := byCluster[max(ref, 0)][k]; ref < 0 || !ok {
+			ref = slices.IndexFunc(byCluster, func(m map[policyKey]map[string]any) bool { _, ok := m[k]; return ok })
+		}
+		row.BaseName = clusterLabel(clusters[ref].context)
+
+		for i, c := range clusters {
+			cell := driftCell{Cluster: clusterLabel(c.context), Anchor: row.Anchor + "--" + clusterAnchor(c.context)}
+			p, ok := byCluster[i][k]
+			switch {
+			case c.err != nil:
+				cell.State = "unreachable"
+			case !ok:
+				cell.State = "missing"
+				row.Drifted = true
+			case i == ref:
+				cell.State = "base"
+			default:
+				cell.Fields, cell.Diff = comparePolicy(byCluster[ref][k], p, k.template, diffs > 0)
+				cell.State = "same"
+				if len(cell.Fields) > 0 {
+					cell.State = "differs"
+					row.Drifted = true
+					if cell.Diff != nil {
+						diffs--
+					} else {
+						undrawn++
+					}
+				}
+			}
+			if ok {
+				cell.URL = policyURL(c.context, p, k.template)
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		rows = append(rows, row)
+	}
+	return rows, undrawn
+}
+
+// policyURL links to a template's card on the Constraint Templates view, which the card keys by the
+// kind the template defines, or to a Constraint's on the Constraints view.
+func policyURL(kubeContext string, o map[string]any, template bool) string {
+	if !template {
+		u := &unstructured.Unstructured{Object: o}
+		return constraintsURL(kubeContext, u.GetKind(), u.GetName())
+	}
+	path := "/constrainttemplates"
+	if kubeContext != "" {
+		path += "/" + url.PathEscape(kubeContext)
+	}
+	if kind, _, _ := unstructured.NestedString(o, "spec", "crd", "spec", "names", "kind"); kind != "" {
+		path += "#" + kind
+	}
+	return browserPath(path)
+}
+
+// fleetContexts is every kubeconfig context, sorted, and the current one. In-cluster, or with a
+// kubeconfig that names no contexts, the fleet is the single default cluster.
+func (s *server) fleetContexts() ([]string, string) {
+	contexts, current := s.k8s.contexts()
+	names := slices.Sorted(maps.Keys(contexts))
+	if len(names) == 0 {
+		names = []string{defaultKubeContext}
+	}
+	return names, current
+}
+
+// fetchClusterPolicies resolves one context and lists its templates and Constraints under the
+// dashboard's timeout.
+func (s *server) fetchClusterPolicies(ctx context.Context, name string) clusterPolicies {
+	res := clusterPolicies{context: name}
+
+	clients, err := s.k8s.forContext(name)
+	if err != nil {
+		slog.Warn("SSR drift: resolving cluster failed", "cluster", name, "error", err)
+		res.err = err
+		return res
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, clusterFetchTimeout)
+	defer cancel()
+
+	templates, err := getCustomResources(cctx, *clients.dynamic, "templates.gatekeeper.sh", "v1", "constrainttemplates")
+	if err != nil {
+		slog.Warn("SSR drift: reading cluster templates failed", "cluster", name, "error", err)
+		res.err = err
+		return res
+	}
+	for _, t := range templates.Items {
+		res.templates = append(res.templates, t.Object)
+	}
+	res.constraints, err = listConstraints(cctx, clients)
+	if err != nil {
+		slog.Warn("SSR drift: reading cluster constraints failed", "cluster", name, "error", err)
+		res.err = err
+	}
+	return res
+}
+
+// getDrift renders the Drift view: every ConstraintTemplate and Constraint against every context,
+// compared with the base cluster's. By default only the policies that drift are listed; ?show=all
+// lists the rest too.
+func (s *server) getDrift(c echo.Context) error {
+	layout := s.ssrLayoutData(c, "drift", "/drift", "Drift")
+	data := map[string]any{"Layout": layout}
+
+	names, current := s.fleetContexts()
+	base := c.Param("context")
+	if base == "" {
+		base = current
+	}
+	if !slices.Contains(names, base) {
+		base = names[0]
+	}
+	data["Base"] = clusterLabel(base)
+	data["Single"] = len(names) < 2
+	if len(names) < 2 {
+		return s.ssr.render(c, "drift", data)
+	}
+
+	// One goroutine per cluster, as the dashboard does.
+	clusters := make([]clusterPolicies, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clusters[i] = s.fetchClusterPolicies(c.Request().Context(), name)
+		}()
+	}
+	wg.Wait()
+
+	columns := make([]string, 0, len(clusters))
+	var unreachable []string
+	for _, cl := range clusters {
+		columns = append(columns, clusterLabel(cl.context))
+		if cl.err != nil {
+			unreachable = append(unreachable, clusterLabel(cl.context))
+		}
+	}
+	data["Clusters"] = columns
+	data["Unreachable"] = unreachable
+	if len(unreachable) == len(clusters) {
+		setViewError(data, "GPM could not read the Constraint Templates and Constraints of any cluster. Make sure the kubeconfig's contexts reach their clusters and that Gatekeeper is installed in them.", clusters[0].err)
+		return s.ssr.render(c, "drift", data)
+	}
+
+	rows, undrawn := driftModel(clusters, base)
+	data["Undrawn"] = undrawn
+	data["MaxDiffs"] = driftMaxDiffs
+	showAll := c.QueryParam("show") == "all"
+	shown := make([]driftRow, 0, len(rows))
+	drifted := 0
+	for _, r := range rows {
+		if r.Drifted {
+			drifted++
+		}
+		if r.Drifted || showAll {
+			shown = append(shown, r)
+		}
+	}
+	sort.SliceStable(shown, func(i, j int) bool { return shown[i].Drifted && !shown[j].Drifted })
+	data["Rows"] = shown
+	data["Total"] = len(rows)
+	data["Drifted"] = drifted
+	data["ShowAll"] = showAll
+	data["ToggleURL"] = contextPath(c, "/drift")
+	if !showAll {
+		data["ToggleURL"] = contextPath(c, "/drift") + "?show=all"
+	}
+
+	return s.ssr.render(c, "drift", data)
+}
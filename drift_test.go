@@ -0,0 +1,181 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSideBySide(t *testing.T) {
+	got := sideBySide(diffText("a\nb\nc\nd\n", "a\nB\nc\nd\ne\n"))
+	want := []driftLine{
+		{"a", "a", "same"},
+		{"b", "B", "changed"},
+		{"c", "c", "same"},
+		{"d", "d", "same"},
+		{"", "e", "added"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("sideBySide = %v, want %v", got, want)
+	}
+}
+
+func TestDriftModel(t *testing.T) {
+	template := func(rego string) map[string]any {
+		return map[string]any{"kind": "ConstraintTemplate", "metadata": map[string]any{"name": "k8srequiredlabels"},
+			"spec": map[string]any{"targets": []any{map[string]any{"target": "admission.k8s.gatekeeper.sh", "rego": rego}}}}
+	}
+	constraint := func(name string, spec map[string]any) map[string]any {
+		return map[string]any{"kind": "K8sRequiredLabels", "metadata": map[string]any{"name": name}, "spec": spec}
+	}
+	clusters := []clusterPolicies{
+		{context: "prod", templates: []map[string]any{template("package a")}, constraints: []map[string]any{
+			constraint("owner", map[string]any{"enforcementAction": "deny", "parameters": map[string]any{"labels": []any{"owner"}}}),
+			constraint("prod-only", map[string]any{}),
+		}},
+		{context: "staging", templates: []map[string]any{template("package b")}, constraints: []map[string]any{
+			// Leaving enforcementAction out is the same as deny.
+			constraint("owner", map[string]any{"parameters": map[string]any{"labels": []any{"team"}}}),
+			constraint("staging-only", map[string]any{}),
+		}},
+		{context: "dev", err: fmt.Errorf("unreachable")},
+	}
+
+	rows, undrawn := driftModel(clusters, "prod")
+	if undrawn != 0 {
+		t.Errorf("two diffs should all be drawn, %d were not", undrawn)
+	}
+	states := map[string][]string{}
+	for _, r := range rows {
+		for _, c := range r.Cells {
+			states[r.Name] = append(states[r.Name], c.State)
+		}
+	}
+	for name, want := range map[string][]string{
+		"k8srequiredlabels": {"base", "differs", "unreachable"},
+		"owner":             {"base", "differs", "unreachable"},
+		"prod-only":         {"base", "missing", "unreachable"},
+		"staging-only":      {"missing", "base", "unreachable"},
+	} {
+		if !slices.Equal(states[name], want) {
+			t.Errorf("%s: states %v, want %v", name, states[name], want)
+		}
+	}
+	if rows[0].Kind != "ConstraintTemplate" || !slices.Equal(rows[0].Cells[1].Fields, []string{"rego"}) {
+		t.Errorf("the template should come first and differ in its Rego: %+v", rows[0])
+	}
+	owner := rows[slices.IndexFunc(rows, func(r driftRow) bool { return r.Name == "owner" })]
+	if !slices.Equal(owner.Cells[1].Fields, []string{"parameters"}) {
+		t.Errorf("owner differs in %v, want only the parameters", owner.Cells[1].Fields)
+	}
+	if !slices.ContainsFunc(owner.Cells[1].Diff, func(l driftLine) bool {
+		return l.Op == "changed" && strings.Contains(l.Base, "owner") && strings.Contains(l.Cluster, "team")
+	}) {
+		t.Errorf("the diff should pair the two labels: %v", owner.Cells[1].Diff)
+	}
+	if staging := rows[len(rows)-1]; staging.Name != "staging-only" || staging.BaseName != "staging" {
+		t.Errorf("a Constraint the base lacks should be compared with the first cluster that has it: %+v", staging)
+	}
+}
+
+// Every differing cell used to get a diff, so a fleet that differs everywhere drew one per policy
+// per context. Past driftMaxDiffs the cells keep their fields and go without. A context's name, here
+// an EKS ARN, is slugged into the cell's anchor.
+func TestDriftModelCapsTheDiffs(t *testing.T) {
+	var base, other []map[string]any
+	for i := range driftMaxDiffs + 2 {
+		name := fmt.Sprintf("c%03d", i)
+		base = append(base, map[string]any{"kind": "K8sRequiredLabels", "metadata": map[string]any{"name": name}, "spec": map[string]any{"enforcementAction": "deny"}})
+		other = append(other, map[string]any{"kind": "K8sRequiredLabels", "metadata": map[string]any{"name": name}, "spec": map[string]any{"enforcementAction": "warn"}})
+	}
+	rows, undrawn := driftModel([]clusterPolicies{
+		{context: "prod", constraints: base},
+		{context: "arn:aws:eks:eu-west-1:123:cluster/staging one", constraints: other},
+	}, "prod")
+	if undrawn != 2 {
+		t.Errorf("undrawn = %d, want 2", undrawn)
+	}
+	first, last := rows[0].Cells[1], rows[len(rows)-1].Cells[1]
+	if first.Diff == nil || last.Diff != nil || !slices.Equal(last.Fields, []string{"enforcementAction"}) {
+		t.Errorf("the first cell should have its diff and the last only its fields: %+v, %+v", first, last)
+	}
+	if want := "drift-K8sRequiredLabels--c000--arn-aws-eks-eu-west-1-123-cluster-staging-one"; first.Anchor != want {
+		t.Errorf("anchor = %q, want %q", first.Anchor, want)
+	}
+}
+
+// driftTestAPI stands in for one cluster with a template and one Constraint whose parameters it is
+// given.
+func driftTestAPI(t *testing.T, label string) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.respondAt("/apis/templates.gatekeeper.sh/v1/constrainttemplates", `{"apiVersion":"templates.gatekeeper.sh/v1",`+
+		`"kind":"ConstraintTemplateList","metadata":{},"items":[{"apiVersion":"templates.gatekeeper.sh/v1",`+
+		`"kind":"ConstraintTemplate","metadata":{"name":"k8srequiredlabels"},"spec":{"crd":{"spec":{"names":`+
+		`{"kind":"K8sRequiredLabels"}}},"targets":[{"target":"admission.k8s.gatekeeper.sh","rego":"package k8srequiredlabels"}]}}]}`)
+	api.serveConstraints("K8sRequiredLabels", `{"apiVersion":"constraints.gatekeeper.sh/v1beta1",`+
+		`"kind":"K8sRequiredLabels","metadata":{"name":"must-have-owner"},"spec":{"parameters":{"labels":["`+label+`"]}}}`)
+	return api
+}
+
+func TestDriftView(t *testing.T) {
+	prod, staging := driftTestAPI(t, "owner"), driftTestAPI(t, "team")
+	useTestSettings(t)
+	useTestKubeconfig(t, fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+  - name: prod
+    cluster:
+      server: %s
+  - name: staging
+    cluster:
+      server: %s
+contexts:
+  - name: prod
+    context:
+      cluster: prod
+      user: fake-user
+  - name: staging
+    context:
+      cluster: staging
+      user: fake-user
+users:
+  - name: fake-user
+    user:
+      token: fake-token
+`, prod.server.URL, staging.server.URL))
+	registry, err := newClientRegistry()
+	if err != nil {
+		t.Fatalf("building the registry failed: %v", err)
+	}
+	s := &server{k8s: registry, ssr: newSSRRenderer()}
+
+	rec := httptest.NewRecorder()
+	if err := s.getDrift(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/drift", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"1 of 2 policies drift.",
+		`<a href="#drift-K8sRequiredLabels--must-have-owner" title="1 cluster differs, 0 clusters lack it">must-have-owner</a>`,
+		`<tr class="drift-changed"><td>  - owner</td><td>  - team</td></tr>`,
+		`<a href="/constraints/staging#K8sRequiredLabels--must-have-owner">staging</a> against prod:`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("drift view missing %q", want)
+		}
+	}
+	if strings.Contains(out, "drift-ConstraintTemplate--k8srequiredlabels") {
+		t.Error("the template is the same on both clusters and should be left out")
+	}
+}
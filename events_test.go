@@ -6,12 +6,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"html"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/viper"
@@ -23,9 +29,12 @@ import (
 type recordingAPI struct {
 	server *httptest.Server
 
-	mu    sync.Mutex
-	paths []string
-	body  string // response body; empty means an empty EventList
+	mu     sync.Mutex
+	paths  []string
+	body   string            // response body; empty means an empty EventList
+	bodies map[string]string // per-path bodies, which win over body
+	codes  map[string]int    // per-path status codes; unset means 200
+	groups map[string]string // API groups served by serveGroup, and their version
 }
 
 func newRecordingAPI(t *testing.T) *recordingAPI {
@@ -36,12 +45,22 @@ func newRecordingAPI(t *testing.T) *recordingAPI {
 		api.mu.Lock()
 		api.paths = append(api.paths, r.URL.Path)
 		body := api.body
+		if b, ok := api.bodies[r.URL.Path]; ok {
+			body = b
+		}
+		code, failed := api.codes[r.URL.Path]
 		api.mu.Unlock()
 
 		if body == "" {
 			body = `{"apiVersion":"v1","kind":"EventList","items":[]}`
 		}
 		w.Header().Set("Content-Type", "application/json")
+		if failed {
+			w.WriteHeader(code)
+			_, _ = fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":%q,"code":%d}`,
+				strings.ReplaceAll(http.StatusText(code), " ", ""), code)
+			return
+		}
 		_, _ = fmt.Fprint(w, body)
 	}))
 	t.Cleanup(api.server.Close)
@@ -56,10 +75,40 @@ func (a *recordingAPI) respondWith(body string) {
 	a.body = body
 }
 
+// respondAt sets the JSON body the stand-in API returns for one path.
+func (a *recordingAPI) respondAt(path, body string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.bodies == nil {
+		a.bodies = map[string]string{}
+	}
+	a.bodies[path] = body
+}
+
+// failAt makes the stand-in API answer one path with an error status, as a Kubernetes Status.
+func (a *recordingAPI) failAt(path string, code int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.codes == nil {
+		a.codes = map[string]int{}
+	}
+	a.codes[path] = code
+}
+
+// eventsV1DiscoveryPath is where the view asks whether the cluster serves events.k8s.io/v1.
+const eventsV1DiscoveryPath = "/apis/events.k8s.io/v1"
+
+// requested returns the list calls, leaving out the discovery probe every page load makes first.
 func (a *recordingAPI) requested() []string {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return append([]string(nil), a.paths...)
+	var out []string
+	for _, p := range a.paths {
+		if p != eventsV1DiscoveryPath {
+			out = append(out, p)
+		}
+	}
+	return out
 }
 
 // Builds a server whose Kubernetes clients talk to the stand-in API.
@@ -187,7 +236,7 @@ func TestGetKubernetesEventsHonorsContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // already cancelled before the call
 
-	if _, err := getKubernetesEvents(ctx, *clients.dynamic, "", []string{"gatekeeper-webhook"}); err == nil {
+	if _, err := getKubernetesEvents(ctx, *clients.dynamic, coreEventsResource, nil, eventQuery{Sources: []string{"gatekeeper-webhook"}}); err == nil {
 		t.Error("a cancelled context did not abort the events list; the context is not threaded")
 	}
 }
@@ -221,3 +270,442 @@ func TestEventsViewShowsWebhookAndAuditSources(t *testing.T) {
 		t.Error("a non-Gatekeeper event was not filtered out")
 	}
 }
+
+// A stand-in events API that serves its items in chunks and honors the continue token, the way the
+// real one does. The token is the index of the chunk's first item; the chunk is smaller than GPM's
+// Limit, which the real API is also allowed to do.
+type pagedEventsAPI struct {
+	server  *httptest.Server
+	items   []string
+	byPath  map[string][]string // per-path items, which win over items
+	chunk   int
+	mu      sync.Mutex
+	queries []url.Values
+	expired bool // answer every continued list with 410 Gone
+}
+
+func newPagedEventsAPI(t *testing.T, items []string, chunk int) *pagedEventsAPI {
+	t.Helper()
+
+	api := &pagedEventsAPI{items: items, chunk: chunk}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		api.queries = append(api.queries, r.URL.Query())
+		expired := api.expired
+		items, ok := api.byPath[r.URL.Path]
+		if !ok {
+			items = api.items
+		}
+		api.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		from, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+		if expired && from > 0 {
+			w.WriteHeader(http.StatusGone)
+			_, _ = fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Expired","code":410}`)
+			return
+		}
+		from = min(from, len(items))
+		to := min(from+api.chunk, len(items))
+		next := ""
+		if to < len(items) {
+			next = strconv.Itoa(to)
+		}
+		_, _ = fmt.Fprintf(w, `{"apiVersion":"v1","kind":"EventList","metadata":{"continue":%q},"items":[%s]}`,
+			next, strings.Join(items[from:to], ","))
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+// pagedEvent is one Gatekeeper admission event, numbered so a test can tell which ones it got.
+func pagedEvent(n int, action, component string) string {
+	return fmt.Sprintf(`{"metadata":{"name":"ev-%02d","annotations":{"constraint_action":%q,"constraint_name":"c"}},`+
+		`"lastTimestamp":"2026-03-01T10:%02d:00Z","source":{"component":%q}}`, n, action, n, component)
+}
+
+func pagedEventsClient(t *testing.T, api *pagedEventsAPI) *kubeClients {
+	t.Helper()
+	useTestSettings(t)
+	s := newEventsTestServer(t, &recordingAPI{server: api.server})
+	clients, err := s.k8s.forContext(defaultKubeContext)
+	if err != nil {
+		t.Fatalf("resolving clients failed: %v", err)
+	}
+	return clients
+}
+
+// Paging through every page must return each matching event exactly once, including when a page
+// fills half way through a chunk: the API only resumes at chunk boundaries, so the rest of that
+// chunk has to be carried in GPM's own token.
+func TestGetKubernetesEventsPagesThroughEveryMatchOnce(t *testing.T) {
+	var items []string
+	want := map[string]bool{}
+	for i := range 20 {
+		action := "deny"
+		if i%3 == 0 {
+			action = "warn"
+		}
+		items = append(items, pagedEvent(i, action, "gatekeeper-webhook"))
+		if action == "deny" {
+			want[fmt.Sprintf("ev-%02d", i)] = true
+		}
+	}
+	api := newPagedEventsAPI(t, items, 7)
+	clients := pagedEventsClient(t, api)
+
+	q := eventQuery{Sources: []string{"gatekeeper-webhook"}, Action: "deny", PageSize: 4}
+	got := map[string]bool{}
+	pages := 0
+	for {
+		page, err := getKubernetesEvents(context.Background(), *clients.dynamic, coreEventsResource, nil, q)
+		if err != nil {
+			t.Fatalf("page %d failed: %v", pages, err)
+		}
+		pages++
+		if len(page.Events) > q.PageSize {
+			t.Errorf("page %d has %d events, more than the page size %d", pages, len(page.Events), q.PageSize)
+		}
+		for _, e := range page.Events {
+			if got[e.Name] {
+				t.Errorf("%s was listed twice", e.Name)
+			}
+			got[e.Name] = true
+		}
+		if page.Continue == "" || pages > 10 {
+			break
+		}
+		q.Continue = page.Continue
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("listed %d events over %d pages, want %d", len(got), pages, len(want))
+	}
+	for name := range want {
+		if !got[name] {
+			t.Errorf("%s was never listed", name)
+		}
+	}
+	for _, query := range api.queries {
+		if query.Get("limit") != strconv.Itoa(eventListChunk) {
+			t.Errorf("listed with limit=%q, want %d", query.Get("limit"), eventListChunk)
+		}
+	}
+}
+
+// One source fits the API's field selector, so the API does the filtering; two do not.
+func TestGetKubernetesEventsSelectsASingleSourceOnTheServer(t *testing.T) {
+	api := newPagedEventsAPI(t, []string{pagedEvent(1, "deny", "gatekeeper-webhook")}, 5)
+	clients := pagedEventsClient(t, api)
+
+	for _, tc := range []struct {
+		sources []string
+		want    string
+	}{
+		{[]string{"gatekeeper-webhook"}, "source=gatekeeper-webhook"},
+		{[]string{"gatekeeper-webhook", "gatekeeper-audit"}, ""},
+	} {
+		api.queries = nil
+		if _, err := getKubernetesEvents(context.Background(), *clients.dynamic, coreEventsResource, nil, eventQuery{Sources: tc.sources}); err != nil {
+			t.Fatalf("listing failed: %v", err)
+		}
+		if got := api.queries[0].Get("fieldSelector"); got != tc.want {
+			t.Errorf("sources %v: fieldSelector = %q, want %q", tc.sources, got, tc.want)
+		}
+	}
+}
+
+// A continue token the API has forgotten, or one somebody edited, reads as an expired page rather
+// than as the cluster being unreachable.
+func TestGetKubernetesEventsReportsAnExpiredPage(t *testing.T) {
+	var items []string
+	for i := range 6 {
+		items = append(items, pagedEvent(i, "deny", "gatekeeper-webhook"))
+	}
+	api := newPagedEventsAPI(t, items, 3)
+	clients := pagedEventsClient(t, api)
+	q := eventQuery{Sources: []string{"gatekeeper-webhook"}, PageSize: 3}
+
+	page, err := getKubernetesEvents(context.Background(), *clients.dynamic, coreEventsResource, nil, q)
+	if err != nil || page.Continue == "" {
+		t.Fatalf("first page: continue %q, err %v; want a next page", page.Continue, err)
+	}
+	api.expired = true
+	q.Continue = page.Continue
+	if _, err := getKubernetesEvents(context.Background(), *clients.dynamic, coreEventsResource, nil, q); !errors.Is(err, errExpiredEventsPage) {
+		t.Errorf("a 410 from the API gave %v, want errExpiredEventsPage", err)
+	}
+
+	q.Continue = "not a token"
+	if _, err := getKubernetesEvents(context.Background(), *clients.dynamic, coreEventsResource, nil, q); !errors.Is(err, errExpiredEventsPage) {
+		t.Errorf("a mangled token gave %v, want errExpiredEventsPage", err)
+	}
+}
+
+func TestEventQueryMatches(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	e := ssrEvent{
+		SourceComponent: "gatekeeper-webhook", ConstraintName: "owner", Action: "deny", Mode: "deny",
+		RequestUsername: "alice", ResourceNamespace: "apps", ResourceKind: "Deployment",
+		Time: now.Add(-30 * time.Minute),
+	}
+	base := eventQuery{Sources: []string{"gatekeeper-webhook"}, Now: now}
+
+	for name, tc := range map[string]struct {
+		edit func(*eventQuery)
+		want bool
+	}{
+		"no filter":          {func(*eventQuery) {}, true},
+		"other source":       {func(q *eventQuery) { q.Sources = []string{"gatekeeper-audit"} }, false},
+		"constraint":         {func(q *eventQuery) { q.Constraint = "owner" }, true},
+		"other constraint":   {func(q *eventQuery) { q.Constraint = "repos" }, false},
+		"action":             {func(q *eventQuery) { q.Action = "deny" }, true},
+		"other action":       {func(q *eventQuery) { q.Action = "warn" }, false},
+		"user":               {func(q *eventQuery) { q.User = "alice" }, true},
+		"resource namespace": {func(q *eventQuery) { q.ResourceNamespace = "apps" }, true},
+		"other namespace":    {func(q *eventQuery) { q.ResourceNamespace = "kube-system" }, false},
+		"resource kind":      {func(q *eventQuery) { q.ResourceKind = "Deployment" }, true},
+		"inside the window":  {func(q *eventQuery) { q.Since = time.Hour }, true},
+		"outside the window": {func(q *eventQuery) { q.Since = 15 * time.Minute }, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			q := base
+			tc.edit(&q)
+			if got := q.matches(e); got != tc.want {
+				t.Errorf("matches = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if (eventQuery{Sources: base.Sources, Since: time.Hour, Now: now}).matches(ssrEvent{SourceComponent: "gatekeeper-webhook"}) {
+		t.Error("an event without a timestamp matched a time window")
+	}
+	if (eventQuery{Sources: base.Sources, Action: "deny", Now: now}).matches(ssrEvent{SourceComponent: "gatekeeper-webhook"}) {
+		t.Error("an event that recorded no action matched an action")
+	}
+}
+
+func TestParseEventWindow(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"15m": 15 * time.Minute, "24h": 24 * time.Hour, "7d": 7 * 24 * time.Hour,
+		"": 0, "soon": 0, "-1h": 0, "xd": 0,
+	} {
+		if got := parseEventWindow(in); got != want {
+			t.Errorf("parseEventWindow(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+// The filter bar round-trips its values, and the pager links forward with the token and back to the
+// first page without it.
+func TestEventsViewRendersTheFilterBarAndPager(t *testing.T) {
+	var items []string
+	for i := range 5 {
+		items = append(items, pagedEvent(i, "deny", "gatekeeper-webhook"))
+	}
+	api := newPagedEventsAPI(t, items, 5)
+	useTestSettings(t)
+	s := newEventsTestServer(t, &recordingAPI{server: api.server})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/events?action=deny&limit=2&user=alice+smith", nil)
+	rec := httptest.NewRecorder()
+	if err := s.getEvents(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`name="user" value="alice smith"`, `<option selected>deny</option>`,
+		"No matching events", // nobody is alice smith
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("events output missing %q", want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/events?action=deny&limit=2", nil)
+	rec = httptest.NewRecorder()
+	if err := s.getEvents(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out = html.UnescapeString(rec.Body.String())
+	if !strings.Contains(out, "Next page") || !strings.Contains(out, "continue=") {
+		t.Error("a full page did not link to the next one")
+	}
+	if strings.Contains(out, "First page") {
+		t.Error("the first page linked to itself")
+	}
+	if !strings.Contains(out, "this page's 2 verdicts") {
+		t.Error("the analytics panel should say it counts this page only")
+	}
+}
+
+// Each configured namespace is its own list call, so a Role in each of them is all the access GPM
+// needs; nothing cluster-wide is asked for.
+func TestEventsListEachConfiguredNamespace(t *testing.T) {
+	api := newRecordingAPI(t)
+	useTestSettings(t)
+	s := newEventsTestServer(t, api)
+	viper.Set("events_namespace", "gatekeeper-system, apps,")
+
+	callGetEvents(t, s, "")
+
+	got := api.requested()
+	slices.Sort(got)
+	want := []string{"/api/v1/namespaces/apps/events", "/api/v1/namespaces/gatekeeper-system/events"}
+	if !slices.Equal(got, want) {
+		t.Errorf("asked the API for %v, want %v", got, want)
+	}
+}
+
+// The query parameter can narrow the configured list to one of its namespaces.
+func TestEventsQueryParameterPicksOneConfiguredNamespace(t *testing.T) {
+	api := newRecordingAPI(t)
+	useTestSettings(t)
+	s := newEventsTestServer(t, api)
+	viper.Set("events_namespace", "gatekeeper-system,apps")
+
+	callGetEvents(t, s, "?namespace=apps")
+
+	want := "/api/v1/namespaces/apps/events"
+	if got := api.requested(); len(got) != 1 || got[0] != want {
+		t.Errorf("asked the API for %v, want %q", got, want)
+	}
+}
+
+// A deployment whose Role only grants the core group's events still gets its events: discovery says
+// events.k8s.io/v1 is served, the list of it is forbidden, and the view reads core v1 instead.
+func TestEventsFallBackToCoreEventsWhenEventsV1IsForbidden(t *testing.T) {
+	api := newRecordingAPI(t)
+	useTestSettings(t)
+	s := newEventsTestServer(t, api)
+	api.respondAt(eventsV1DiscoveryPath, `{"kind":"APIResourceList","apiVersion":"v1","groupVersion":"events.k8s.io/v1",`+
+		`"resources":[{"name":"events","namespaced":true,"kind":"Event","verbs":["get","list","watch"]}]}`)
+	api.failAt("/apis/events.k8s.io/v1/events", http.StatusForbidden)
+	api.respondAt("/api/v1/events", `{"apiVersion":"v1","kind":"EventList","items":[{
+		"metadata":{"name":"ev","annotations":{"constraint_action":"deny","constraint_name":"owner"}},
+		"reason":"FailedAdmission","message":"Admission webhook denied the request",
+		"involvedObject":{"kind":"Pod","name":"web","namespace":"apps"},"source":{"component":"gatekeeper-webhook"}}]}`)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := s.getEvents(e.NewContext(httptest.NewRequest(http.MethodGet, "/events", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+
+	want := []string{"/apis/events.k8s.io/v1/events", "/api/v1/events"}
+	if got := api.requested(); !slices.Equal(got, want) {
+		t.Errorf("asked the API for %v, want %v", got, want)
+	}
+	if out := rec.Body.String(); !strings.Contains(out, "Admission webhook denied the request") {
+		t.Error("the core event did not reach the table")
+	}
+}
+
+// Where the cluster serves events.k8s.io/v1 the view reads it, and shows its fields the same way it
+// shows the core ones.
+func TestEventsPreferTheEventsV1API(t *testing.T) {
+	api := newRecordingAPI(t)
+	useTestSettings(t)
+	s := newEventsTestServer(t, api)
+	api.respondAt(eventsV1DiscoveryPath, `{"kind":"APIResourceList","apiVersion":"v1","groupVersion":"events.k8s.io/v1",`+
+		`"resources":[{"name":"events","namespaced":true,"kind":"Event","verbs":["get","list","watch"]}]}`)
+	api.respondAt("/apis/events.k8s.io/v1/events", `{"apiVersion":"events.k8s.io/v1","kind":"EventList","items":[{
+		"metadata":{"name":"ev","annotations":{"constraint_action":"deny","constraint_name":"owner"}},
+		"reason":"FailedAdmission","note":"Admission webhook denied the request","eventTime":"2026-03-01T10:00:00.000000Z",
+		"regarding":{"kind":"Pod","name":"web","namespace":"apps"},"deprecatedSource":{"component":"gatekeeper-webhook"}}]}`)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := s.getEvents(e.NewContext(httptest.NewRequest(http.MethodGet, "/events", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+
+	want := "/apis/events.k8s.io/v1/events"
+	if got := api.requested(); len(got) != 1 || got[0] != want {
+		t.Errorf("asked the API for %v, want %q", got, want)
+	}
+	if out := rec.Body.String(); !strings.Contains(out, "Admission webhook denied the request") {
+		t.Error("the events.k8s.io/v1 note did not reach the table")
+	}
+}
+
+func TestSSREventModelReadsEventsV1(t *testing.T) {
+	series := ssrEventModel(map[string]any{
+		"apiVersion":       "events.k8s.io/v1",
+		"metadata":         map[string]any{"name": "ev"},
+		"note":             "denied",
+		"eventTime":        "2026-03-01T10:00:00.000000Z",
+		"series":           map[string]any{"count": int64(4), "lastObservedTime": "2026-03-01T10:30:00.000000Z"},
+		"regarding":        map[string]any{"kind": "Pod", "name": "web", "namespace": "apps"},
+		"deprecatedSource": map[string]any{"component": "gatekeeper-webhook", "host": "node-1"},
+	})
+	if series.Message != "denied" || series.Count != "4" || series.ObjKind != "Pod" || series.ObjName != "web" ||
+		series.ObjNamespace != "apps" || series.SourceComponent != "gatekeeper-webhook" || series.SourceHost != "node-1" {
+		t.Errorf("model = %+v, want the note, series count, regarding object and deprecated source", series)
+	}
+	if want := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC); !series.Time.Equal(want) {
+		t.Errorf("Time = %v, want the series' last sighting %v", series.Time, want)
+	}
+	if series.FirstTimestamp != "2026-03-01 10:00:00 UTC" {
+		t.Errorf("FirstTimestamp = %q, want the eventTime", series.FirstTimestamp)
+	}
+
+	// A writer that only fills the new fields: the reporting controller stands in for the source.
+	single := ssrEventModel(map[string]any{
+		"apiVersion":          "events.k8s.io/v1",
+		"eventTime":           "2026-03-01T11:00:00.000000Z",
+		"reportingController": "gatekeeper-audit",
+		"reportingInstance":   "audit-0",
+	})
+	if single.SourceComponent != "gatekeeper-audit" || single.SourceHost != "audit-0" || single.Count != "" {
+		t.Errorf("model = %+v, want the reporting controller and instance and no count", single)
+	}
+	if want := time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC); !single.Time.Equal(want) {
+		t.Errorf("Time = %v, want the eventTime %v", single.Time, want)
+	}
+}
+
+// With several namespaces every page takes from each of them, and paging through every page still
+// returns each event exactly once, however unevenly the namespaces are filled.
+func TestGetKubernetesEventsPagesThroughSeveralNamespaces(t *testing.T) {
+	byPath := map[string][]string{}
+	want := map[string]bool{}
+	for ns, n := range map[string]int{"apps": 7, "ci": 2, "quiet": 0} {
+		var items []string
+		for i := range n {
+			e := strings.Replace(pagedEvent(i, "deny", "gatekeeper-webhook"), `"name":"ev-`, `"name":"`+ns+`-`, 1)
+			items = append(items, e)
+			want[fmt.Sprintf("%s-%02d", ns, i)] = true
+		}
+		byPath["/api/v1/namespaces/"+ns+"/events"] = items
+	}
+	api := newPagedEventsAPI(t, nil, 3)
+	api.byPath = byPath
+	clients := pagedEventsClient(t, api)
+
+	q := eventQuery{Sources: []string{"gatekeeper-webhook"}, PageSize: 3}
+	got := map[string]bool{}
+	for pages := 1; ; pages++ {
+		page, err := getKubernetesEvents(context.Background(), *clients.dynamic, coreEventsResource, []string{"apps", "ci", "quiet"}, q)
+		if err != nil {
+			t.Fatalf("page %d failed: %v", pages, err)
+		}
+		if pages == 1 && !slices.ContainsFunc(page.Events, func(e ssrEvent) bool { return strings.HasPrefix(e.Name, "ci-") }) {
+			t.Error("the first page took nothing from the smaller namespace")
+		}
+		for _, e := range page.Events {
+			if got[e.Name] {
+				t.Errorf("%s was listed twice", e.Name)
+			}
+			got[e.Name] = true
+		}
+		if page.Continue == "" || pages > 10 {
+			break
+		}
+		q.Continue = page.Continue
+	}
+	if len(got) != len(want) {
+		t.Errorf("listed %d events, want %d", len(got), len(want))
+	}
+}
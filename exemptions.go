@@ -0,0 +1,200 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The Exemptions view. A namespace can be kept out of Gatekeeper in three places: the Config's
+// spec.match, per process; a Constraint's excludedNamespaces, for that Constraint; and the
+// admission.gatekeeper.sh/ignore label, which the webhooks' namespaceSelector honours. The view
+// merges them per live namespace, so "is kube-system exempt from audit?" has one place to look.
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The namespace label Gatekeeper's webhooks skip. Gatekeeper refuses it on a namespace its
+// --exempt-namespace flags do not list, so a namespace that carries it is exempt.
+const ignoreLabel = "admission.gatekeeper.sh/ignore"
+
+// The processes a Config match entry can name, in the order the view lists them. "*" is all of them.
+var exemptionProcesses = []string{"audit", "webhook", "mutation-webhook", "sync"}
+
+// One thing that exempts a namespace.
+type ssrExemption struct {
+	Source     string   // "Config gatekeeper-system/config", "label admission.gatekeeper.sh/ignore"
+	Pattern    string   // the excludedNamespaces entry that matched; empty for the label
+	Processes  []string // what the namespace is exempt from; empty for a Constraint, which is exempt from itself
+	Constraint *ssrConstraintRef
+}
+
+// ssrNamespaceExemptions is one namespace of the view, with everything that exempts it.
+type ssrNamespaceExemptions struct {
+	Name        string
+	Processes   []string // the processes some exemption covers, in exemptionProcesses order
+	Constraints int      // how many Constraints exclude it
+	Exemptions  []ssrExemption
+}
+
+// Exempt reports whether the namespace is exempt from a process, for the summary table.
+func (n ssrNamespaceExemptions) Exempt(process string) bool {
+	return slices.Contains(n.Processes, process)
+}
+
+// A Config excludedNamespaces entry that matches no live namespace, which is either a typo or a
+// namespace still to come.
+type ssrUnusedPattern struct {
+	Source  string
+	Pattern string
+}
+
+// configExemption is one Config match entry: the namespaces it keeps out of its processes.
+type configExemption struct {
+	source     string
+	namespaces []string
+	processes  []string
+}
+
+// configExemptionsOf reads the match entries of the Configs, with "*" spelled out as every process.
+func configExemptionsOf(configs []map[string]any) []configExemption {
+	var out []configExemption
+	for _, c := range configs {
+		entries, _, _ := unstructured.NestedSlice(c, "spec", "match")
+		for _, e := range entries {
+			entry, ok := e.(map[string]any)
+			if !ok {
+				continue
+			}
+			ce := configExemption{source: syncSource("Config", c)}
+			ce.namespaces, _, _ = unstructured.NestedStringSlice(entry, "excludedNamespaces")
+			processes, _, _ := unstructured.NestedStringSlice(entry, "processes")
+			for _, p := range exemptionProcesses {
+				if slices.Contains(processes, "*") || slices.Contains(processes, p) {
+					ce.processes = append(ce.processes, p)
+				}
+			}
+			out = append(out, ce)
+		}
+	}
+	return out
+}
+
+// exemptionMap merges the three sources per namespace. Only the namespaces something exempts come
+// back, sorted by name, with the Config patterns no namespace matched.
+func exemptionMap(namespaces []clusterNamespace, configs, constraints []map[string]any) ([]ssrNamespaceExemptions, []ssrUnusedPattern) {
+	configEntries := configExemptionsOf(configs)
+	used := map[ssrUnusedPattern]bool{}
+
+	var out []ssrNamespaceExemptions
+	for _, ns := range namespaces {
+		n := ssrNamespaceExemptions{Name: ns.Name}
+		add := func(e ssrExemption) {
+			n.Exemptions = append(n.Exemptions, e)
+			for _, p := range e.Processes {
+				if !slices.Contains(n.Processes, p) {
+					n.Processes = append(n.Processes, p)
+				}
+			}
+		}
+
+		for _, ce := range configEntries {
+			for _, pattern := range ce.namespaces {
+				if globMatch(pattern, ns.Name) {
+					used[ssrUnusedPattern{Source: ce.source, Pattern: pattern}] = true
+					add(ssrExemption{Source: ce.source, Pattern: pattern, Processes: ce.processes})
+					break
+				}
+			}
+		}
+		if _, ok := ns.Labels[ignoreLabel]; ok {
+			add(ssrExemption{Source: "label " + ignoreLabel, Processes: []string{"webhook", "mutation-webhook"}})
+		}
+		for _, con := range constraints {
+			match, err := parseMatch(con, "spec", "match")
+			if err != nil {
+				continue
+			}
+			i := slices.IndexFunc(match.ExcludedNamespaces, func(p string) bool { return globMatch(p, ns.Name) })
+			if i < 0 {
+				continue
+			}
+			u := unstructured.Unstructured{Object: con}
+			ref := ssrConstraintRef{Kind: u.GetKind(), Name: u.GetName()}
+			n.Exemptions = append(n.Exemptions, ssrExemption{Source: ref.Kind + " " + ref.Name, Pattern: match.ExcludedNamespaces[i], Constraint: &ref})
+			n.Constraints++
+		}
+
+		if len(n.Exemptions) == 0 {
+			continue
+		}
+		sort.Slice(n.Processes, func(i, j int) bool {
+			return slices.Index(exemptionProcesses, n.Processes[i]) < slices.Index(exemptionProcesses, n.Processes[j])
+		})
+		out = append(out, n)
+	}
+
+	var unused []ssrUnusedPattern
+	for _, ce := range configEntries {
+		for _, pattern := range ce.namespaces {
+			p := ssrUnusedPattern{Source: ce.source, Pattern: pattern}
+			if !used[p] && !slices.Contains(unused, p) {
+				unused = append(unused, p)
+			}
+		}
+	}
+	return out, unused
+}
+
+// getExemptions renders the Exemptions view.
+func (s *server) getExemptions(c echo.Context) error {
+	layout := s.ssrLayoutData(c, "exemptions", "/exemptions", "Exemptions")
+
+	data := map[string]any{"Layout": layout}
+
+	clients, err := s.clientsFor(c)
+	if err != nil {
+		slog.Error("SSR exemptions: resolving context failed", "error", err)
+		setViewError(data, "GPM could not switch to the requested Kubernetes context. Make sure the kubeconfig defines it correctly.", err)
+		return s.ssr.render(c, "exemptions", data)
+	}
+
+	ctx := c.Request().Context()
+	namespaces, err := listNamespaces(ctx, clients)
+	if err != nil {
+		slog.Error("SSR exemptions: getting namespaces failed", "error", err)
+		setViewError(data, "GPM could not get the namespaces from the Kubernetes API. Make sure the API is reachable and GPM can list namespaces.", err)
+		return s.ssr.render(c, "exemptions", data)
+	}
+	configList, err := getCustomResources(ctx, *clients.dynamic, "config.gatekeeper.sh", "v1alpha1", "configs")
+	if err != nil {
+		slog.Error("SSR exemptions: getting config resources failed", "error", err)
+		setViewError(data, "GPM could not get the configuration objects from the Kubernetes API. Make sure the API is reachable.", err)
+		return s.ssr.render(c, "exemptions", data)
+	}
+	configs := make([]map[string]any, 0, len(configList.Items))
+	for i := range configList.Items {
+		configs = append(configs, configList.Items[i].Object)
+	}
+
+	// Without the Constraints the map is still right about the Config and the label, so a failure
+	// costs their rows and a warning says so.
+	constraints, err := listConstraints(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR exemptions: reading constraints failed, their exclusions are left out", "error", err)
+		data["ConstraintsMissing"] = true
+	}
+	sortConstraints(constraints)
+
+	exempt, unused := exemptionMap(namespaces, configs, constraints)
+	data["Namespaces"] = exempt
+	data["NamespaceCount"] = len(namespaces)
+	data["Unused"] = unused
+	data["Processes"] = exemptionProcesses
+	data["ConstraintsURL"] = contextPath(c, "/constraints")
+	data["ConfigurationsURL"] = contextPath(c, "/configurations")
+	return s.ssr.render(c, "exemptions", data)
+}
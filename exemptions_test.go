@@ -0,0 +1,91 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+const exemptingConfig = `
+kind: Config
+metadata: {name: config, namespace: gatekeeper-system}
+spec:
+  match:
+  - excludedNamespaces: ["kube-*"]
+    processes: ["*"]
+  - excludedNamespaces: [monitoring, legacy]
+    processes: [audit]
+`
+
+func TestExemptionMap(t *testing.T) {
+	namespaces := []clusterNamespace{
+		{Name: "apps"},
+		{Name: "kube-system"},
+		{Name: "monitoring"},
+		{Name: "vendor", Labels: map[string]string{ignoreLabel: "no-self-managing"}},
+	}
+	constraint := yamlObject(t, `
+kind: K8sRequiredLabels
+metadata: {name: owner}
+spec:
+  match:
+    excludedNamespaces: [monitoring]
+`)
+
+	got, unused := exemptionMap(namespaces, []map[string]any{yamlObject(t, exemptingConfig)}, []map[string]any{constraint})
+	if len(got) != 3 {
+		t.Fatalf("exempt namespaces = %+v, want kube-system, monitoring and vendor", got)
+	}
+	system, monitoring, vendor := got[0], got[1], got[2]
+	if strings.Join(system.Processes, ",") != "audit,webhook,mutation-webhook,sync" || system.Exemptions[0].Pattern != "kube-*" {
+		t.Errorf("kube-system = %+v, want every process through the glob", system)
+	}
+	if strings.Join(monitoring.Processes, ",") != "audit" || monitoring.Constraints != 1 ||
+		monitoring.Exemptions[1].Constraint == nil || monitoring.Exemptions[1].Constraint.Anchor() != constraintAnchor("K8sRequiredLabels", "owner") {
+		t.Errorf("monitoring = %+v, want audit from the Config and the owner Constraint", monitoring)
+	}
+	if !vendor.Exempt("webhook") || vendor.Exempt("audit") || vendor.Exemptions[0].Source != "label "+ignoreLabel {
+		t.Errorf("vendor = %+v, want the webhooks through the label", vendor)
+	}
+	if len(unused) != 1 || unused[0].Pattern != "legacy" {
+		t.Errorf("unused patterns = %+v, want legacy", unused)
+	}
+}
+
+func TestExemptionsView(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.respondAt("/api/v1/namespaces", `{"apiVersion":"v1","kind":"NamespaceList","items":[`+
+		`{"metadata":{"name":"apps"}},{"metadata":{"name":"kube-system"}},{"metadata":{"name":"monitoring"}}]}`)
+	api.serveGroup("config.gatekeeper.sh", "v1alpha1", servedResource{Name: "configs", Kind: "Config", Namespaced: true, Items: []string{
+		`{"kind":"Config","metadata":{"name":"config","namespace":"gatekeeper-system"},` +
+			`"spec":{"match":[{"excludedNamespaces":["kube-*"],"processes":["*"]},{"excludedNamespaces":["monitoring"],"processes":["audit"]}]}}`,
+	}})
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getExemptions(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/exemptions", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	// The stand-in API serves no constraints group, so their exclusions are missing and the view says so.
+	for _, want := range []string{
+		`<a href="#kube-system">kube-system</a>`, `id="monitoring"`, "2 of 3",
+		"Exempt from Audit, Validation webhook, Mutation webhook, Sync", "matched by <code>kube-*</code>",
+		"GPM could not read the Constraints",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exemptions output missing %q", want)
+		}
+	}
+	if strings.Contains(out, `id="apps"`) {
+		t.Error("a namespace nothing exempts got a card")
+	}
+}
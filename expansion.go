@@ -0,0 +1,198 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The Expansion view. Gatekeeper's ExpansionTemplates turn a workload into the resource it would
+// create, a Deployment into the Pod of its template, and run the Constraints on that resource too.
+// Without them on screen nobody learns why a Pod Constraint judged their Deployment.
+package main
+
+import (
+	"log/slog"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The API group of the ExpansionTemplates. listGroup reads it at whatever version the cluster
+// prefers: v1alpha1 or v1beta1, depending on the Gatekeeper release.
+const expansionGroup = "expansion.gatekeeper.sh"
+
+// ssrExpansion is the flat shape an ExpansionTemplate card renders.
+type ssrExpansion struct {
+	Name              string
+	Description       string
+	Created           string
+	ApplyTo           []targetKind // the workloads expanded
+	TemplateSource    string       // where in a workload the generated resource's template lives
+	Generated         targetKind   // the kind of the resource the expansion generates
+	EnforcementAction string       // overrides the Constraints' action on the generated resource; empty when unset
+	Constraints       []ssrConstraintRef
+	Raw               map[string]any
+}
+
+// A link to a Constraint card.
+type ssrConstraintRef struct {
+	Kind string
+	Name string
+}
+
+// Anchor is the Constraint's card id on the Constraints view.
+func (r ssrConstraintRef) Anchor() string { return constraintAnchor(r.Kind, r.Name) }
+
+// A link to an ExpansionTemplate card, with the workloads it expands, for a Constraint card.
+type ssrExpansionRef struct {
+	Name    string
+	ApplyTo []targetKind
+}
+
+// ssrExpansionModel reads an ExpansionTemplate. The applyTo kinds are checked against discovery,
+// like a mutator's, since an expansion of a kind the cluster does not serve never runs.
+func ssrExpansionModel(o map[string]any, served *servedKinds) ssrExpansion {
+	m := ssrExpansion{Raw: o}
+	m.Name, _, _ = unstructured.NestedString(o, "metadata", "name")
+	m.Created, _, _ = unstructured.NestedString(o, "metadata", "creationTimestamp")
+	m.Description = annotation(o, "description")
+	m.TemplateSource, _, _ = unstructured.NestedString(o, "spec", "templateSource")
+	m.EnforcementAction, _, _ = unstructured.NestedString(o, "spec", "enforcementAction")
+
+	var spec struct {
+		ApplyTo []struct {
+			Groups   []string `json:"groups"`
+			Versions []string `json:"versions"`
+			Kinds    []string `json:"kinds"`
+		} `json:"applyTo"`
+		GeneratedGVK struct {
+			Group   string `json:"group"`
+			Version string `json:"version"`
+			Kind    string `json:"kind"`
+		} `json:"generatedGVK"`
+	}
+	if raw, ok, _ := unstructured.NestedMap(o, "spec"); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec); err != nil {
+			slog.Warn("SSR expansion: reading a template's spec failed", "name", m.Name, "error", err)
+		}
+	}
+	for _, a := range spec.ApplyTo {
+		for _, g := range a.Groups {
+			for _, v := range a.Versions {
+				for _, kind := range a.Kinds {
+					k := targetKind{APIVersion: schema.GroupVersion{Group: g, Version: v}.String(), Kind: kind}
+					if served != nil {
+						k.namespaced, k.Unserved = served.lookup(k.APIVersion, kind)
+					}
+					m.ApplyTo = append(m.ApplyTo, k)
+				}
+			}
+		}
+	}
+	gvk := spec.GeneratedGVK
+	m.Generated = targetKind{APIVersion: schema.GroupVersion{Group: gvk.Group, Version: gvk.Version}.String(), Kind: gvk.Kind}
+	return m
+}
+
+// generatedGroup is the API group of the generated kind, the part of it a Constraint matches on.
+func (e ssrExpansion) generatedGroup() string {
+	gv, _ := schema.ParseGroupVersion(e.Generated.APIVersion)
+	return gv.Group
+}
+
+// constraintJudges reports whether a Constraint's match selects the kind an expansion generates,
+// and so judges the resources it generates. A Constraint with no match.kinds judges every kind.
+func constraintJudges(constraint map[string]any, e ssrExpansion) bool {
+	if e.Generated.Kind == "" {
+		return false
+	}
+	match, err := parseMatch(constraint, "spec", "match")
+	if err != nil {
+		return false
+	}
+	return match.matchesKind(e.generatedGroup(), e.Generated.Kind)
+}
+
+// getExpansion renders the Expansion view: every ExpansionTemplate, what it expands into, and the
+// Constraints that judge the result.
+func (s *server) getExpansion(c echo.Context) error {
+	layout := s.ssrLayoutData(c, "expansion", "/expansion", "Expansion Templates")
+
+	data := map[string]any{"Layout": layout}
+
+	clients, err := s.clientsFor(c)
+	if err != nil {
+		slog.Error("SSR expansion: resolving context failed", "error", err)
+		setViewError(data, "GPM could not switch to the requested Kubernetes context. Make sure the kubeconfig defines it correctly.", err)
+		return s.ssr.render(c, "expansion", data)
+	}
+
+	ctx := c.Request().Context()
+	items, err := listGroup(ctx, clients, expansionGroup)
+	if err != nil && len(items) == 0 {
+		slog.Error("SSR expansion: getting expansion templates failed", "error", err)
+		setViewError(data, "GPM could not get the ExpansionTemplates from the Kubernetes API. Make sure the API is reachable and GPM can read the expansion.gatekeeper.sh group.", err)
+		return s.ssr.render(c, "expansion", data)
+	}
+	if err != nil {
+		slog.Error("SSR expansion: getting some expansion templates failed", "error", err)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return (&unstructured.Unstructured{Object: items[i]}).GetName() < (&unstructured.Unstructured{Object: items[j]}).GetName()
+	})
+
+	// The Constraints are only read for the cross-links, so failing to read them costs the links
+	// and nothing else.
+	constraints, err := listConstraints(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR expansion: reading constraints failed, the cards leave them out", "error", err)
+	}
+	sortConstraints(constraints)
+
+	served := servedKinds{client: clients.discovery, lists: map[string]*metav1.APIResourceList{}}
+	models := make([]ssrExpansion, 0, len(items))
+	for _, o := range items {
+		m := ssrExpansionModel(o, &served)
+		for _, con := range constraints {
+			if constraintJudges(con, m) {
+				u := unstructured.Unstructured{Object: con}
+				m.Constraints = append(m.Constraints, ssrConstraintRef{Kind: u.GetKind(), Name: u.GetName()})
+			}
+		}
+		models = append(models, m)
+	}
+	data["Expansions"] = models
+	data["ExpectedPods"] = maxPodCount(items)
+	data["ConstraintsURL"] = contextPath(c, "/constraints")
+	return s.ssr.render(c, "expansion", data)
+}
+
+// expansionRefs finds, for each Constraint, the ExpansionTemplates whose generated kind it judges,
+// for the cross-links on the Constraints view. Reading the templates failing costs the links only.
+func expansionRefs(c echo.Context, clients *kubeClients, constraints []map[string]any) map[string][]ssrExpansionRef {
+	items, err := listGroup(c.Request().Context(), clients, expansionGroup)
+	if err != nil {
+		slog.Warn("SSR constraints: reading expansion templates failed, the cards leave them out", "error", err)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	expansions := make([]ssrExpansion, 0, len(items))
+	for _, o := range items {
+		expansions = append(expansions, ssrExpansionModel(o, nil))
+	}
+	sort.Slice(expansions, func(i, j int) bool { return expansions[i].Name < expansions[j].Name })
+
+	out := map[string][]ssrExpansionRef{}
+	for _, con := range constraints {
+		u := unstructured.Unstructured{Object: con}
+		for _, e := range expansions {
+			if constraintJudges(con, e) {
+				anchor := constraintAnchor(u.GetKind(), u.GetName())
+				out[anchor] = append(out[anchor], ssrExpansionRef{Name: e.Name, ApplyTo: e.ApplyTo})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"sigs.k8s.io/yaml"
+)
+
+const expandDeployments = `
+apiVersion: expansion.gatekeeper.sh/v1beta1
+kind: ExpansionTemplate
+metadata:
+  name: expand-deployments
+spec:
+  applyTo:
+  - groups: ["apps"]
+    kinds: ["Deployment", "StatefulSet"]
+    versions: ["v1"]
+  templateSource: spec.template
+  generatedGVK:
+    group: ""
+    version: v1
+    kind: Pod
+`
+
+func yamlObject(t *testing.T, doc string) map[string]any {
+	t.Helper()
+	var obj map[string]any
+	if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+		t.Fatalf("the test object does not parse: %v", err)
+	}
+	return obj
+}
+
+func TestSSRExpansionModel(t *testing.T) {
+	m := ssrExpansionModel(yamlObject(t, expandDeployments), nil)
+	if len(m.ApplyTo) != 2 || m.ApplyTo[1].APIVersion != "apps/v1" || m.ApplyTo[1].Kind != "StatefulSet" {
+		t.Errorf("applyTo = %+v", m.ApplyTo)
+	}
+	if m.TemplateSource != "spec.template" || m.Generated.APIVersion != "v1" || m.Generated.Kind != "Pod" {
+		t.Errorf("model = %+v", m)
+	}
+}
+
+// A Constraint judges what an expansion generates when its match selects the generated kind, and
+// one that names no kinds selects them all.
+func TestConstraintJudges(t *testing.T) {
+	e := ssrExpansionModel(yamlObject(t, expandDeployments), nil)
+	constraint := func(match string) map[string]any {
+		return yamlObject(t, "kind: K8sRequiredLabels\nmetadata:\n  name: c\nspec:\n"+match)
+	}
+	for match, want := range map[string]bool{
+		"  match:\n    kinds:\n    - apiGroups: [\"\"]\n      kinds: [\"Pod\"]\n":            true,
+		"  match:\n    kinds:\n    - apiGroups: [\"apps\"]\n      kinds: [\"Deployment\"]\n": false,
+		"  match:\n    namespaces: [\"apps\"]\n":                                             true,
+		"  parameters: {}\n":                                                                 true,
+	} {
+		if got := constraintJudges(constraint(match), e); got != want {
+			t.Errorf("constraintJudges(%q) = %t, want %t", match, got, want)
+		}
+	}
+}
+
+// expansionAPI serves one ExpansionTemplate and two Constraints, one on Pods and one on Services.
+func expansionAPI(t *testing.T) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	template, _ := yaml.YAMLToJSON([]byte(expandDeployments))
+	api.serveGroup("expansion.gatekeeper.sh", "v1beta1",
+		servedResource{Name: "expansiontemplate", Kind: "ExpansionTemplate", Items: []string{string(template)}})
+	api.serveGroup("apps", "v1", servedResource{Name: "deployments", Kind: "Deployment", Namespaced: true})
+	api.serveConstraints("K8sRequiredLabels",
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"pod-owner"},"spec":{"match":{"kinds":[{"apiGroups":[""],"kinds":["Pod"]}]}}}`,
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"service-owner"},"spec":{"match":{"kinds":[{"apiGroups":[""],"kinds":["Service"]}]}}}`)
+	return api
+}
+
+func TestExpansionViewLinksTheConstraints(t *testing.T) {
+	s, _ := discoveryTestClients(t, expansionAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/expansion/fake", nil), rec)
+	c.SetParamNames("context")
+	c.SetParamValues("fake")
+	if err := s.getExpansion(c); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`id="expand-deployments"`, "<code>apps/v1 Deployment</code>", "<code>spec.template</code>", "<code>v1 Pod</code>",
+		`href="/constraints/fake#K8sRequiredLabels--pod-owner"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expansion output missing %q", want)
+		}
+	}
+	// apps/v1 serves Deployments only.
+	if !strings.Contains(out, "StatefulSet</code> <span class=\"badge badge-danger\"") {
+		t.Error("the StatefulSet the cluster does not serve was not flagged")
+	}
+	if strings.Contains(out, "service-owner") {
+		t.Error("a Constraint on Services was linked to a Pod expansion")
+	}
+}
+
+func TestConstraintCardsLinkTheExpansions(t *testing.T) {
+	s, _ := discoveryTestClients(t, expansionAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getConstraints(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/constraints", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	if got := strings.Count(out, `href="/expansion#expand-deployments"`); got != 1 {
+		t.Errorf("%d links to the expansion, want one from the Pod Constraint", got)
+	}
+	if !strings.Contains(out, "(Deployment, StatefulSet)") {
+		t.Error("the link does not name the workloads the expansion covers")
+	}
+}
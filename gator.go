@@ -0,0 +1,500 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Gator suites. A policy repository tests its templates with gator: a Suite lists tests, each a
+// template file, a Constraint file and cases, each an object and what the Constraint should say
+// about it. GPM reads the suites from a directory and runs every case against the template the
+// cluster has, not the file next to the suite, so a card says whether the live version passes the
+// tests written for it. The Rego runs as Gatekeeper runs it: input.review is a CREATE of the
+// object, input.parameters the Constraint's, and data.inventory is empty.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage/inmem"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// The API group of gator's Suite kind.
+const gatorSuiteGroup = "test.gatekeeper.sh"
+
+// gatorSuite is a Suite file: the paths in it are relative to the file.
+type gatorSuite struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Tests      []gatorTest `json:"tests"`
+}
+
+type gatorTest struct {
+	Name       string      `json:"name"`
+	Template   string      `json:"template"`
+	Constraint string      `json:"constraint"`
+	Cases      []gatorCase `json:"cases"`
+}
+
+type gatorCase struct {
+	Name       string           `json:"name"`
+	Object     string           `json:"object"`
+	Assertions []gatorAssertion `json:"assertions"`
+	// The older fixtures name an object that must pass, or one that must not, in place of object
+	// and assertions.
+	Allow string `json:"allow"`
+	Deny  string `json:"deny"`
+}
+
+// gatorAssertion is what a case expects: how many violations, "yes" for at least one, "no" for
+// none; and, when Message is set, of the violations whose message it matches. YAML reads an
+// unquoted yes or no as a boolean, so Violations takes either spelling.
+type gatorAssertion struct {
+	Violations any     `json:"violations"`
+	Message    *string `json:"message"`
+}
+
+// ssrTestCase is one case of a suite, as run against the cluster's template.
+type ssrTestCase struct {
+	Suite   string // the suite file, relative to the suites directory
+	Test    string
+	Case    string
+	Passed  bool
+	Problem string // why it failed
+}
+
+// ssrTemplateTests is what a template card shows of the suites that test it.
+type ssrTemplateTests struct {
+	Passed int
+	Failed int
+	Cases  []ssrTestCase
+}
+
+// gatorCache keeps what the suites directory held at its last read, so a page load only re-reads
+// the suites that changed, and only runs the cases whose suite or template changed since.
+type gatorCache struct {
+	mu     sync.Mutex
+	suites map[string]*gatorSuiteEntry // by the suite's path
+}
+
+// gatorSuiteEntry is one suite file as last read, with the results of its tests against the
+// templates they ran on. A result is good for as long as the suite file and the template's
+// generation stay the same: the files a suite names are not watched, so editing one alone does not
+// rerun its cases.
+type gatorSuiteEntry struct {
+	modTime time.Time
+	suite   gatorSuite
+	err     error // the Suite does not parse
+	// Per test, the kind of the template file, or why it could not be read.
+	kinds    []string
+	kindErrs []error
+	results  map[gatorResultKey][]ssrTestCase
+}
+
+// gatorResultKey names one test run against one version of a cluster's template.
+type gatorResultKey struct {
+	context    string
+	test       int
+	uid        string
+	generation int64
+}
+
+// readSuites brings the cache up to date with the Suites under dir, and returns their paths
+// relative to dir. A YAML file that is not a Suite is skipped; one that is and does not parse keeps
+// its error in its entry.
+func (g *gatorCache) readSuites(dir string) ([]string, error) {
+	seen := map[string]*gatorSuiteEntry{}
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".yml") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if e, ok := g.suites[path]; ok && e.modTime.Equal(info.ModTime()) {
+			seen[path] = e
+		} else {
+			e, err := readGatorSuite(path, info.ModTime())
+			if err != nil {
+				return err
+			}
+			if e == nil {
+				return nil
+			}
+			seen[path] = e
+		}
+		rel, _ := filepath.Rel(dir, path)
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.suites = seen
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// readGatorSuite reads one suite file and the kinds of the templates its tests name. It returns
+// nil when the file is not a Suite.
+func readGatorSuite(path string, modTime time.Time) (*gatorSuiteEntry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var head struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if yaml.Unmarshal(raw, &head) != nil || head.Kind != "Suite" || !strings.HasPrefix(head.APIVersion, gatorSuiteGroup+"/") {
+		return nil, nil
+	}
+	e := &gatorSuiteEntry{modTime: modTime, results: map[gatorResultKey][]ssrTestCase{}}
+	if e.err = yaml.Unmarshal(raw, &e.suite); e.err != nil {
+		return e, nil
+	}
+	for _, test := range e.suite.Tests {
+		fileTemplate, err := readGatorObject(path, test.Template)
+		kind, _, _ := unstructured.NestedString(fileTemplate, "spec", "crd", "spec", "names", "kind")
+		e.kinds = append(e.kinds, kind)
+		e.kindErrs = append(e.kindErrs, err)
+	}
+	return e, nil
+}
+
+// readGatorObject reads the object a suite names, the first document of the file.
+func readGatorObject(suitePath, file string) (map[string]any, error) {
+	raw, err := os.ReadFile(filepath.Join(filepath.Dir(suitePath), file))
+	if err != nil {
+		return nil, err
+	}
+	doc, _, _ := strings.Cut(strings.TrimPrefix(string(raw), "---\n"), "\n---")
+	var obj map[string]any
+	if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("%s is empty", file)
+	}
+	return obj, nil
+}
+
+// templateRego is the Rego code a template runs for admission, with its libs and version: the
+// first Rego code of the admission target, or of any target when it has none.
+func templateRego(t ssrConstraintTemplate) (ssrTemplateCode, bool) {
+	for _, admissionOnly := range []bool{true, false} {
+		for _, target := range t.Targets {
+			if admissionOnly && target.Target != "admission.k8s.gatekeeper.sh" {
+				continue
+			}
+			for _, code := range target.Code {
+				if code.Engine == "Rego" && code.Rego != "" {
+					return code, true
+				}
+			}
+		}
+	}
+	return ssrTemplateCode{}, false
+}
+
+// gatorCapabilities are the built-ins a template's Rego may call when GPM runs it: OPA's, less the
+// ones that reach out of the process. The Rego comes from the cluster, so a template must not make
+// GPM send requests, resolve names or read its environment.
+var gatorCapabilities = sync.OnceValue(func() *ast.Capabilities {
+	caps := ast.CapabilitiesForThisVersion()
+	caps.Builtins = slices.DeleteFunc(caps.Builtins, func(b *ast.Builtin) bool {
+		return b.Name == "http.send" || b.Name == "net.lookup_ip_addr" || b.Name == "opa.runtime"
+	})
+	return caps
+})
+
+// How long one case's Rego may run before it fails.
+const gatorEvalTimeout = 2 * time.Second
+
+// prepareTemplate compiles a template's Rego with its libs, in the version the code declares, ready
+// to run on any case.
+func prepareTemplate(ctx context.Context, code ssrTemplateCode) (rego.PreparedEvalQuery, error) {
+	module, err := parseRego("template.rego", code.Rego, code.RegoVersion())
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+	options := []func(*rego.Rego){
+		rego.Query(module.Package.Path.String() + ".violation"),
+		rego.SetRegoVersion(code.RegoVersion()),
+		rego.Capabilities(gatorCapabilities()),
+		rego.Module("template.rego", code.Rego),
+		rego.Store(inmem.NewFromObject(map[string]any{"inventory": map[string]any{}})),
+	}
+	for i, lib := range code.Libs {
+		options = append(options, rego.Module(fmt.Sprintf("lib%d.rego", i), lib))
+	}
+	return rego.New(options...).PrepareForEval(ctx)
+}
+
+// evalViolations runs a template's compiled Rego on an object under a Constraint, and returns the
+// messages of the violations.
+func evalViolations(ctx context.Context, query rego.PreparedEvalQuery, constraint, obj map[string]any) ([]string, error) {
+	u := unstructured.Unstructured{Object: obj}
+	gvk := u.GroupVersionKind()
+	params, _, _ := unstructured.NestedFieldNoCopy(constraint, "spec", "parameters")
+	input := map[string]any{
+		"review": map[string]any{
+			"kind":      map[string]any{"group": gvk.Group, "version": gvk.Version, "kind": gvk.Kind},
+			"name":      u.GetName(),
+			"namespace": u.GetNamespace(),
+			"operation": "CREATE",
+			"object":    obj,
+		},
+		"parameters": params,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gatorEvalTimeout)
+	defer cancel()
+	results, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, r := range results {
+		for _, e := range r.Expressions {
+			set, _ := e.Value.([]any)
+			for _, v := range set {
+				violation, _ := v.(map[string]any)
+				msg, _ := violation["msg"].(string)
+				messages = append(messages, msg)
+			}
+		}
+	}
+	sort.Strings(messages)
+	return messages, nil
+}
+
+// assertionProblem checks one assertion against the violations, and says how it fails.
+func assertionProblem(a gatorAssertion, messages []string) (string, error) {
+	matching := messages
+	about := ""
+	if a.Message != nil {
+		re, err := regexp.Compile(*a.Message)
+		if err != nil {
+			return "", fmt.Errorf("the message %q is not a regular expression: %w", *a.Message, err)
+		}
+		matching = nil
+		for _, m := range messages {
+			if re.MatchString(m) {
+				matching = append(matching, m)
+			}
+		}
+		about = fmt.Sprintf(" matching %q", *a.Message)
+	}
+	got := fmt.Sprintf("got %d", len(matching))
+	if len(messages) > 0 {
+		got += ": " + strings.Join(messages, "; ")
+	}
+
+	switch v := a.Violations; v {
+	case nil, true, "yes":
+		if len(matching) == 0 {
+			return "want a violation" + about + ", got none", nil
+		}
+	case false, "no":
+		if len(matching) > 0 {
+			return "want no violations" + about + ", " + got, nil
+		}
+	default:
+		var want int
+		switch n := v.(type) {
+		case float64:
+			want = int(n)
+		case string:
+			var err error
+			if want, err = strconv.Atoi(n); err != nil {
+				return "", fmt.Errorf("violations is %q, not yes, no or a number", n)
+			}
+		default:
+			return "", fmt.Errorf("violations is %v, not yes, no or a number", v)
+		}
+		if len(matching) != want {
+			return fmt.Sprintf("want %s%s, %s", plural(want, "violation", "violations"), about, got), nil
+		}
+	}
+	return "", nil
+}
+
+// runGatorCase runs one case and reports whether it passed, and why not.
+func runGatorCase(ctx context.Context, suitePath string, c gatorCase, query rego.PreparedEvalQuery, constraint map[string]any) (string, error) {
+	file, assertions := c.Object, c.Assertions
+	switch {
+	case c.Allow != "":
+		file, assertions = c.Allow, []gatorAssertion{{Violations: "no"}}
+	case c.Deny != "":
+		file, assertions = c.Deny, []gatorAssertion{{Violations: "yes"}}
+	}
+	if file == "" {
+		return "", errors.New("the case names no object")
+	}
+	obj, err := readGatorObject(suitePath, file)
+	if err != nil {
+		return "", err
+	}
+	var messages []string
+	if constraintMatches(constraint, obj, nil) {
+		if messages, err = evalViolations(ctx, query, constraint, obj); err != nil {
+			return "", err
+		}
+	}
+	for _, a := range assertions {
+		if problem, err := assertionProblem(a, messages); problem != "" || err != nil {
+			return problem, err
+		}
+	}
+	return "", nil
+}
+
+// runGatorTest runs the cases of one test against a template, compiling the template's Rego the
+// first time a test needs it.
+func runGatorTest(ctx context.Context, rel, suitePath string, test gatorTest, t ssrConstraintTemplate, prepare func() (rego.PreparedEvalQuery, error)) []ssrTestCase {
+	_, hasRego := templateRego(t)
+	constraint, constraintErr := readGatorObject(suitePath, test.Constraint)
+	cases := make([]ssrTestCase, 0, len(test.Cases))
+	for _, c := range test.Cases {
+		result := ssrTestCase{Suite: rel, Test: test.Name, Case: c.Name}
+		var problem string
+		var err error
+		switch {
+		case !hasRego:
+			err = errors.New("the cluster's template has no Rego to run")
+		case constraintErr != nil:
+			err = constraintErr
+		default:
+			var query rego.PreparedEvalQuery
+			if query, err = prepare(); err == nil {
+				problem, err = runGatorCase(ctx, suitePath, c, query, constraint)
+			}
+		}
+		if err != nil {
+			problem = err.Error()
+		}
+		result.Problem = problem
+		result.Passed = problem == ""
+		cases = append(cases, result)
+	}
+	return cases
+}
+
+// runSuites runs the suites under dir against a context's templates, and hangs the results on them.
+// It returns the problems that belong to no template: suites that do not parse, template files that
+// cannot be read, and tests of templates the cluster does not have. The lock is held only to read
+// the suites and to look up and store results, never across the Rego, so a slow suite holds up no
+// other load. A run keeps only the context's results it used, so the results of a template's older
+// generations, or of one since deleted, go.
+func (g *gatorCache) runSuites(ctx context.Context, kubeContext, dir string, templates []ssrConstraintTemplate) []string {
+	// The results are kept for later loads, so a viewer who leaves mid-run must not leave them all
+	// failed with a cancelled context. gatorEvalTimeout still bounds each case.
+	ctx = context.WithoutCancel(ctx)
+
+	g.mu.Lock()
+	paths, err := g.readSuites(dir)
+	entries := make([]*gatorSuiteEntry, len(paths))
+	for i, rel := range paths {
+		entries[i] = g.suites[filepath.Join(dir, rel)]
+	}
+	g.mu.Unlock()
+	if err != nil {
+		slog.Warn("SSR constraint templates: reading the gator suites failed", "dir", dir, "error", err)
+		return []string{fmt.Sprintf("GPM could not read the suites in %s: %v", dir, err)}
+	}
+
+	byKind := map[string]int{}
+	for i, t := range templates {
+		byKind[t.Kind] = i
+	}
+	// Each template's Rego is compiled once per run, and only when a case has to run on it.
+	type prepared struct {
+		query rego.PreparedEvalQuery
+		err   error
+	}
+	queries := map[int]*prepared{}
+	used := make([]map[gatorResultKey][]ssrTestCase, len(entries))
+
+	var problems []string
+	for pi, rel := range paths {
+		suitePath := filepath.Join(dir, rel)
+		e := entries[pi]
+		if e.err != nil {
+			problems = append(problems, fmt.Sprintf("%s does not parse: %v", rel, e.err))
+			continue
+		}
+		used[pi] = map[gatorResultKey][]ssrTestCase{}
+		for n, test := range e.suite.Tests {
+			if err := e.kindErrs[n]; err != nil {
+				problems = append(problems, fmt.Sprintf("%s, test %s: %v", rel, test.Name, err))
+				continue
+			}
+			i, ok := byKind[e.kinds[n]]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s, test %s: the cluster has no %s template", rel, test.Name, e.kinds[n]))
+				continue
+			}
+			t := &templates[i]
+			live := unstructured.Unstructured{Object: t.Raw}
+			key := gatorResultKey{context: kubeContext, test: n, uid: string(live.GetUID()), generation: live.GetGeneration()}
+			g.mu.Lock()
+			cases, ok := e.results[key]
+			g.mu.Unlock()
+			if !ok {
+				cases = runGatorTest(ctx, rel, suitePath, test, *t, func() (rego.PreparedEvalQuery, error) {
+					p, ok := queries[i]
+					if !ok {
+						code, _ := templateRego(*t)
+						p = &prepared{}
+						p.query, p.err = prepareTemplate(ctx, code)
+						queries[i] = p
+					}
+					return p.query, p.err
+				})
+			}
+			used[pi][key] = cases
+
+			if t.Tests == nil {
+				t.Tests = &ssrTemplateTests{}
+			}
+			for _, c := range cases {
+				if c.Passed {
+					t.Tests.Passed++
+				} else {
+					t.Tests.Failed++
+				}
+			}
+			t.Tests.Cases = append(t.Tests.Cases, cases...)
+		}
+	}
+
+	g.mu.Lock()
+	for pi, e := range entries {
+		maps.DeleteFunc(e.results, func(k gatorResultKey, _ []ssrTestCase) bool { return k.context == kubeContext })
+		maps.Copy(e.results, used[pi])
+	}
+	g.mu.Unlock()
+	sort.Strings(problems)
+	return problems
+}
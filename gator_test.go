@@ -0,0 +1,316 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+)
+
+// writeFiles lays out a suites directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// A policy repository's layout: the suite, the template file it was written for, a Constraint and
+// the fixtures. The template file is only read for its kind; the cluster's copy is what runs.
+var requiredLabelsSuite = map[string]string{
+	"requiredlabels/suite.yaml": `kind: Suite
+apiVersion: test.gatekeeper.sh/v1alpha1
+tests:
+- name: owner-label
+  template: template.yaml
+  constraint: samples/constraint.yaml
+  cases:
+  - name: labelled
+    object: samples/allowed.yaml
+    assertions:
+    - violations: no
+  - name: unlabelled
+    object: samples/disallowed.yaml
+    assertions:
+    - violations: yes
+    - violations: 1
+      message: owner
+  - name: other-kind
+    object: samples/configmap.yaml
+    assertions:
+    - violations: no
+  - name: legacy-deny
+    deny: samples/disallowed.yaml
+- name: other-template
+  template: ../other/template.yaml
+  constraint: samples/constraint.yaml
+  cases:
+  - name: any
+    object: samples/allowed.yaml
+`,
+	"requiredlabels/template.yaml": `kind: ConstraintTemplate
+apiVersion: templates.gatekeeper.sh/v1
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sRequiredLabels
+`,
+	"requiredlabels/samples/constraint.yaml": `kind: K8sRequiredLabels
+apiVersion: constraints.gatekeeper.sh/v1beta1
+metadata:
+  name: must-have-owner
+spec:
+  match:
+    kinds:
+    - apiGroups: [""]
+      kinds: [Namespace, Pod]
+  parameters:
+    labels:
+    - key: owner
+`,
+	"requiredlabels/samples/allowed.yaml": `kind: Pod
+apiVersion: v1
+metadata:
+  name: labelled
+  namespace: default
+  labels:
+    owner: me
+`,
+	"requiredlabels/samples/disallowed.yaml": `---
+kind: Pod
+apiVersion: v1
+metadata:
+  name: unlabelled
+  namespace: default
+`,
+	"requiredlabels/samples/configmap.yaml": `kind: ConfigMap
+apiVersion: v1
+metadata:
+  name: settings
+  namespace: default
+`,
+	"other/template.yaml": `kind: ConstraintTemplate
+apiVersion: templates.gatekeeper.sh/v1
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sAllowedRepos
+`,
+	"notes.yaml": "kind: ConfigMap\napiVersion: v1\n",
+}
+
+func TestRunGatorSuites(t *testing.T) {
+	dir := writeFiles(t, requiredLabelsSuite)
+	templates := []ssrConstraintTemplate{regoTemplate("K8sRequiredLabels", requiredLabelsRego)}
+
+	var cache gatorCache
+	problems := cache.runSuites(context.Background(), "", dir, templates)
+	if len(problems) != 1 || problems[0] != "requiredlabels/suite.yaml, test other-template: the cluster has no K8sAllowedRepos template" {
+		t.Errorf("problems = %q", problems)
+	}
+	tests := templates[0].Tests
+	if tests == nil || tests.Passed != 4 || tests.Failed != 0 {
+		t.Fatalf("tests = %+v, want the four cases passing", tests)
+	}
+	if c := tests.Cases[1]; c.Suite != "requiredlabels/suite.yaml" || c.Test != "owner-label" || c.Case != "unlabelled" {
+		t.Errorf("second case = %+v", c)
+	}
+}
+
+// The point of running the suites against the cluster: a live template that drifted from the one
+// the tests were written for fails them.
+func TestRunGatorSuitesAgainstTheLiveTemplate(t *testing.T) {
+	dir := writeFiles(t, requiredLabelsSuite)
+	drifted := "package k8srequiredlabels\n\nviolation[{\"msg\": \"always\"}] {\n  true\n}\n"
+	templates := []ssrConstraintTemplate{regoTemplate("K8sRequiredLabels", drifted)}
+
+	var cache gatorCache
+	cache.runSuites(context.Background(), "", dir, templates)
+	tests := templates[0].Tests
+	if tests.Passed != 2 || tests.Failed != 2 {
+		t.Fatalf("tests = %+v, want two cases failing", tests)
+	}
+	if got := tests.Cases[0].Problem; got != "want no violations, got 1: always" {
+		t.Errorf("labelled problem = %q", got)
+	}
+	if got := tests.Cases[1].Problem; got != `want 1 violation matching "owner", got 0: always` {
+		t.Errorf("unlabelled problem = %q", got)
+	}
+
+	// A template whose generation moved on runs again.
+	broken := []ssrConstraintTemplate{regoTemplate("K8sRequiredLabels", "package x\n\nviolation[{\"msg\": msg}] {\n")}
+	broken[0].Raw = map[string]any{"metadata": map[string]any{"generation": int64(2)}}
+	cache.runSuites(context.Background(), "", dir, broken)
+	if c := broken[0].Tests.Cases[0]; c.Passed || !strings.Contains(c.Problem, "template.rego") {
+		t.Errorf("a template that does not parse: %+v", c)
+	}
+}
+
+// The results last until the suite file or the template changes: a page load reruns neither the
+// Rego nor the cases of a suite it has already run.
+func TestRunGatorSuitesCachesResults(t *testing.T) {
+	dir := writeFiles(t, requiredLabelsSuite)
+	live := func() []ssrConstraintTemplate {
+		t := regoTemplate("K8sRequiredLabels", requiredLabelsRego)
+		t.Raw = map[string]any{"metadata": map[string]any{"uid": "1234", "generation": int64(1)}}
+		return []ssrConstraintTemplate{t}
+	}
+	var cache gatorCache
+	cache.runSuites(context.Background(), "", dir, live())
+
+	// A fixture changed on its own is not read again...
+	allowed := filepath.Join(dir, "requiredlabels/samples/allowed.yaml")
+	if err := os.WriteFile(allowed, []byte(requiredLabelsSuite["requiredlabels/samples/disallowed.yaml"]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	templates := live()
+	cache.runSuites(context.Background(), "", dir, templates)
+	if tests := templates[0].Tests; tests.Passed != 4 || tests.Failed != 0 {
+		t.Errorf("tests = %+v, want the cached results", tests)
+	}
+
+	// ...until the suite changes.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "requiredlabels/suite.yaml"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	templates = live()
+	cache.runSuites(context.Background(), "", dir, templates)
+	if tests := templates[0].Tests; tests.Passed != 3 || tests.Failed != 1 {
+		t.Errorf("tests = %+v, want the labelled case failing", tests)
+	}
+}
+
+// A run keeps only the results it used: those of a template's older generation go, and another
+// context's stay.
+func TestRunGatorSuitesPrunesStaleResults(t *testing.T) {
+	dir := writeFiles(t, requiredLabelsSuite)
+	at := func(generation int64) []ssrConstraintTemplate {
+		t := regoTemplate("K8sRequiredLabels", requiredLabelsRego)
+		t.Raw = map[string]any{"metadata": map[string]any{"uid": "1234", "generation": generation}}
+		return []ssrConstraintTemplate{t}
+	}
+	var cache gatorCache
+	cache.runSuites(context.Background(), "staging", dir, at(1))
+	cache.runSuites(context.Background(), "prod", dir, at(1))
+	cache.runSuites(context.Background(), "prod", dir, at(2))
+
+	results := cache.suites[filepath.Join(dir, "requiredlabels/suite.yaml")].results
+	generations := map[string][]int64{}
+	for k := range results {
+		generations[k.context] = append(generations[k.context], k.generation)
+	}
+	if !slices.Equal(generations["prod"], []int64{2}) || !slices.Equal(generations["staging"], []int64{1}) {
+		t.Errorf("generations kept = %v, want prod's latest and staging's", generations)
+	}
+}
+
+// A template whose code declares Rego v1 is parsed and run as v1, as Gatekeeper runs it.
+func TestGatorRunsRegoV1(t *testing.T) {
+	code := templateCode(map[string]any{"code": []any{map[string]any{"engine": "Rego", "source": map[string]any{
+		"version": "v1",
+		"rego":    "package k8srequiredlabels\n\nviolation contains {\"msg\": \"no owner\"} if {\n  not input.review.object.metadata.labels.owner\n}\n",
+	}}}})
+	if len(code) != 1 || code[0].RegoVersion() != ast.RegoV1 {
+		t.Fatalf("code = %+v, want one entry in v1", code)
+	}
+	query, err := prepareTemplate(context.Background(), code[0])
+	if err != nil {
+		t.Fatalf("compiling v1 failed: %v", err)
+	}
+	got, err := evalViolations(context.Background(), query, map[string]any{}, map[string]any{"kind": "ConfigMap", "metadata": map[string]any{"name": "x"}})
+	if err != nil || !slices.Equal(got, []string{"no owner"}) {
+		t.Errorf("violations = %q, %v", got, err)
+	}
+
+	tmpl := ssrConstraintTemplate{Kind: "K8sRequiredLabels", Targets: []ssrTemplateTarget{{Code: code}}}
+	lintTemplate(&tmpl)
+	for _, f := range tmpl.Targets[0].Code[0].Modules[0].Findings {
+		if f.Severity == "error" {
+			t.Errorf("the lint read v1 as v0: %+v", f)
+		}
+	}
+}
+
+// The Rego comes from the cluster, so it may not reach out of GPM.
+func TestGatorRegoCannotCallOut(t *testing.T) {
+	for _, call := range []string{
+		`http.send({"method": "get", "url": "http://169.254.169.254/"})`,
+		`net.lookup_ip_addr("example.com")`,
+		`opa.runtime()`,
+	} {
+		src := "package k8srequiredlabels\n\nviolation[{\"msg\": sprintf(\"%v\", [x])}] {\n  x := " + call + "\n}\n"
+		if _, err := prepareTemplate(context.Background(), ssrTemplateCode{Engine: "Rego", Rego: src}); err == nil || !strings.Contains(err.Error(), "undefined function") {
+			t.Errorf("%s compiled: %v", call, err)
+		}
+	}
+}
+
+func TestAssertionProblem(t *testing.T) {
+	msg := "msg"
+	for _, c := range []struct {
+		assertion gatorAssertion
+		messages  []string
+		want      string
+	}{
+		{gatorAssertion{}, nil, "want a violation, got none"},
+		{gatorAssertion{Violations: true}, []string{"a"}, ""},
+		{gatorAssertion{Violations: "no"}, nil, ""},
+		{gatorAssertion{Violations: false}, []string{"a"}, "want no violations, got 1: a"},
+		{gatorAssertion{Violations: float64(2)}, []string{"a", "b"}, ""},
+		{gatorAssertion{Violations: "2"}, []string{"a"}, "want 2 violations, got 1: a"},
+		{gatorAssertion{Violations: "no", Message: &msg}, []string{"other"}, ""},
+	} {
+		got, err := assertionProblem(c.assertion, c.messages)
+		if err != nil || got != c.want {
+			t.Errorf("%+v on %q = %q, %v; want %q", c.assertion, c.messages, got, err, c.want)
+		}
+	}
+	if _, err := assertionProblem(gatorAssertion{Violations: "some"}, nil); err == nil {
+		t.Error("violations: some should be an error")
+	}
+}
+
+func TestConstraintTemplatesRenderTests(t *testing.T) {
+	dir := writeFiles(t, requiredLabelsSuite)
+	templates := []ssrConstraintTemplate{regoTemplate("K8sRequiredLabels", "package k8srequiredlabels\n\nviolation[{\"msg\": \"always\"}] {\n  true\n}\n")}
+	var cache gatorCache
+	problems := cache.runSuites(context.Background(), "", dir, templates)
+	data := map[string]any{"Layout": minimalLayout(), "Templates": templates, "SuiteProblems": problems}
+
+	var buf bytes.Buffer
+	if err := newSSRRenderer().pages["constrainttemplates"].ExecuteTemplate(&buf, "layout", data); err != nil {
+		t.Fatalf("constrainttemplates render failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<span class="badge badge-danger">2 failing</span> <span class="badge badge-success">2 passing</span>`,
+		`<span class="badge badge-danger">fail</span> want no violations, got 1: always`,
+		"Some gator suites did not run:",
+		"the cluster has no K8sAllowedRepos template",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("constrainttemplates output missing %q", want)
+		}
+	}
+}
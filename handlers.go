@@ -8,15 +8,23 @@ package main
 
 import (
 	"context"
-	"log/slog"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"slices"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 )
 
@@ -37,6 +45,94 @@ func getCustomResources(ctx context.Context, clientset dynamic.DynamicClient, gr
 	return clientset.Resource(r).List(ctx, metav1.ListOptions{})
 }
 
+// groupResources discovers the version of an API group the cluster prefers, and the resources it
+// serves there that can be listed. Subresources such as <kind>/status are left out. A group the
+// cluster does not serve at all is not an error: it comes back with an empty version, so a view can
+// tell "not installed" from "installed but empty".
+func groupResources(client discovery.DiscoveryInterface, group string) (string, []metav1.APIResource, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return "", nil, fmt.Errorf("listing API groups: %w", err)
+	}
+	i := slices.IndexFunc(groups.Groups, func(g metav1.APIGroup) bool { return g.Name == group })
+	if i < 0 {
+		return "", nil, nil
+	}
+	version := groups.Groups[i].PreferredVersion.Version
+
+	served, err := client.ServerResourcesForGroupVersion(groups.Groups[i].PreferredVersion.GroupVersion)
+	if err != nil {
+		return "", nil, fmt.Errorf("listing %s resources: %w", group, err)
+	}
+	var resources []metav1.APIResource
+	for _, r := range served.APIResources {
+		if strings.Contains(r.Name, "/") || !slices.Contains(r.Verbs, "list") {
+			continue
+		}
+		resources = append(resources, r)
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Name < resources[j].Name })
+	return version, resources, nil
+}
+
+// resourceFor finds the resource that serves a kind at an API version, and whether it is
+// namespaced, the way kubectl maps a manifest's kind to the URL it writes to.
+func resourceFor(client discovery.DiscoveryInterface, apiVersion, kind string) (schema.GroupVersionResource, bool, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	served, err := client.ServerResourcesForGroupVersion(gv.String())
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("listing %s resources: %w", gv, err)
+	}
+	for _, r := range served.APIResources {
+		if r.Kind == kind && !strings.Contains(r.Name, "/") {
+			return gv.WithResource(r.Name), r.Namespaced, nil
+		}
+	}
+	return schema.GroupVersionResource{}, false, apierrors.NewNotFound(gv.WithResource(strings.ToLower(kind)).GroupResource(), "")
+}
+
+// listGroup lists every object of every resource a group serves, at its preferred version, the way
+// listConstraints does for the Constraint Kinds. A resource that fails to list does not fail the
+// others: its error joins the returned one and the objects that were read still come back.
+func listGroup(ctx context.Context, clients *kubeClients, group string) ([]map[string]any, error) {
+	version, resources, err := groupResources(clients.discovery, group)
+	if err != nil || version == "" {
+		return nil, err
+	}
+
+	perResource := make([][]map[string]any, len(resources))
+	errs := make([]error, len(resources))
+	sem := make(chan struct{}, listConstraintsConcurrency)
+	var wg sync.WaitGroup
+	for i, r := range resources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			list, err := getCustomResources(ctx, *clients.dynamic, group, version, r.Name)
+			if err != nil {
+				errs[i] = fmt.Errorf("listing %s: %w", r.Name, err)
+				return
+			}
+			for j := range list.Items {
+				perResource[i] = append(perResource[i], list.Items[j].Object)
+			}
+		}()
+	}
+	wg.Wait()
+
+	items := make([]map[string]any, 0)
+	for _, objects := range perResource {
+		items = append(items, objects...)
+	}
+	return items, errors.Join(errs...)
+}
+
 // Health probe. Always returns OK.
 func getHealth(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
@@ -60,30 +156,298 @@ func sortConstraints(response []map[string]interface{}) {
 	})
 }
 
-// Returns the events whose source.component is one of the given sources (Gatekeeper tags admission
-// events with gatekeeper-webhook and audit events with gatekeeper-audit). An empty namespace lists
-// events from every namespace.
-func getKubernetesEvents(ctx context.Context, clientset dynamic.DynamicClient, namespace string, sources []string) (*[]unstructured.Unstructured, error) {
-	// FieldSeletor is very limited in the supported fields, we can't filter like this:
-	//   listOptions := metav1.ListOptions{
-	// 	  FieldSelector: "involvedObject.metadata.source.component=gatekeeper-webhook", //Filter events related to Pods
-	//   }
-	// so we need to filter the events manually with the for loop below
+// How many events the API returns per list call. The view's page size is independent: a filtered
+// page can take several chunks to fill, and an unfiltered one stops part way through a chunk.
+const eventListChunk = 500
+
+// The page sizes the Events view offers, and the one it uses when the request names none.
+var eventPageSizes = []int{50, 100, 250, 500}
+
+const defaultEventPageSize = 100
+
+// eventQuery narrows an events listing. Every field but Sources is optional, and an empty one does
+// not filter. The fields are compared against the parsed ssrEvent, so they mean what the table
+// shows: Action is compared as a mode, so "deny" also finds an event whose action the table shows as
+// a denial, an unknown one included. An event that recorded no action has no mode and matches none.
+type eventQuery struct {
+	Sources            []string // source.component values to keep
+	Constraint         string   // constraint_name annotation
+	Action             string   // deny | warn | dryrun
+	User               string   // request_username annotation
+	ResourceNamespace  string
+	ResourceKind       string
+	ResourceName       string
+	ResourceNamespaces []string      // a team's namespaces, say; nil is any namespace and empty is none
+	Since              time.Duration // only events seen this recently; 0 means any age
+	Now                time.Time     // what Since counts back from
+
+	PageSize int
+	Continue string // the opaque token of the page to resume at, from a previous eventPage
+	Sort     string // newest (the default) | oldest
+}
+
+// matches reports whether an event belongs in the listing.
+func (q eventQuery) matches(e ssrEvent) bool {
+	switch {
+	case !slices.Contains(q.Sources, e.SourceComponent):
+		return false
+	case q.Constraint != "" && e.ConstraintName != q.Constraint:
+		return false
+	case q.Action != "" && e.Mode != enforcementMode(q.Action):
+		return false
+	case q.User != "" && e.RequestUsername != q.User:
+		return false
+	case q.ResourceNamespace != "" && e.Namespace() != q.ResourceNamespace:
+		return false
+	case q.ResourceKind != "" && e.ResourceKind != q.ResourceKind && e.ObjKind != q.ResourceKind:
+		return false
+	case q.ResourceName != "" && e.ResourceName != q.ResourceName && e.ObjName != q.ResourceName:
+		return false
+	case q.ResourceNamespaces != nil && !slices.Contains(q.ResourceNamespaces, e.Namespace()):
+		return false
+	}
+	// An event without a timestamp cannot be placed in a window, so a window leaves it out.
+	return q.Since == 0 || (!e.Time.IsZero() && !e.Time.Before(q.Now.Add(-q.Since)))
+}
+
+// One page of events, and the token for the next one.
+type eventPage struct {
+	Events   []ssrEvent
+	Continue string // empty on the last page
+}
+
+// eventCursor is where one namespace's stream stopped: the API continue token of the chunk it
+// stopped in, and how many items of that chunk it had already read. The API only resumes at chunk
+// boundaries, and a page that filled half way through a chunk must not drop the other half. Done
+// marks a stream read to the end, so the next page does not start it over.
+type eventCursor struct {
+	Token string `json:"c,omitempty"`
+	Skip  int    `json:"s,omitempty"`
+	Done  bool   `json:"d,omitempty"`
+}
+
+// eventPageCursor is a page's position in every namespace it lists, keyed by namespace name. The
+// cluster-wide list is the "" key. A namespace that is missing starts from the beginning.
+type eventPageCursor map[string]eventCursor
+
+// errExpiredEventsPage is what a stale or mangled page link reads as. The API forgets a continue
+// token after a few minutes (it answers 410 Gone), and the token travels in a URL anyone can edit.
+var errExpiredEventsPage = errors.New("the events page link has expired or is not valid")
+
+// doneEventCursor is the position past the end of every namespace's stream: a page that resumes at
+// it reads no Events. The Events view pages on through it while deny-log verdicts remain.
+func doneEventCursor(namespaces []string) string {
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	c := eventPageCursor{}
+	for _, ns := range namespaces {
+		c[ns] = eventCursor{Done: true}
+	}
+	return c.encode()
+}
 
-	r := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "events"}
-	events, err := clientset.Resource(r).Namespace(namespace).List(ctx, metav1.ListOptions{})
+func (c eventPageCursor) encode() string {
+	out, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+func decodeEventCursor(s string) (eventPageCursor, error) {
+	c := eventPageCursor{}
+	if s == "" {
+		return c, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		return nil, err
+		return c, errExpiredEventsPage
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return eventPageCursor{}, errExpiredEventsPage
+	}
+	for _, ns := range c {
+		if ns.Skip < 0 {
+			return eventPageCursor{}, errExpiredEventsPage
+		}
+	}
+	return c, nil
+}
+
+// The two shapes of the Event API. events.k8s.io/v1 is the current one and carries the series and
+// reporting fields; core v1 is the one every cluster still serves. Both read the same stored events.
+var (
+	coreEventsResource = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "events"}
+	eventsV1Resource   = schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}
+)
+
+// eventsResource picks events.k8s.io/v1 when the cluster serves it, and core v1 otherwise. A
+// discovery error is not worth failing the view over: core v1 is always there. Serving it is not
+// the same as granting it, so getKubernetesEvents falls back to core v1 on a 403 too.
+func eventsResource(client discovery.DiscoveryInterface) schema.GroupVersionResource {
+	resources, err := client.ServerResourcesForGroupVersion(eventsV1Resource.GroupVersion().String())
+	if err != nil {
+		return coreEventsResource
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == eventsV1Resource.Resource && slices.Contains(r.Verbs, "list") {
+			return eventsV1Resource
+		}
+	}
+	return coreEventsResource
+}
+
+// One event read off a namespace's stream, with the cursor that resumes the stream right after it.
+type streamedEvent struct {
+	event ssrEvent
+	after eventCursor
+}
+
+// Returns one page of the events that match the query (Gatekeeper tags admission events with
+// gatekeeper-webhook and audit events with gatekeeper-audit), from the given namespaces. No
+// namespaces means one list across the whole cluster.
+//
+// Each namespace is its own list, read in parallel, so a deployment can get by with a Role in each
+// of them. The lists are read in chunks with Limit/Continue and filtered as they stream, so a big
+// cluster never sits in memory whole and a stream stops as soon as it could fill the page alone.
+// The API returns events in storage order, not by time, so the sort applies within the page.
+func getKubernetesEvents(ctx context.Context, clientset dynamic.DynamicClient, r schema.GroupVersionResource, namespaces []string, q eventQuery) (eventPage, error) {
+	cursor, err := decodeEventCursor(q.Continue)
+	if err != nil {
+		return eventPage{}, err
+	}
+	pageSize := q.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultEventPageSize
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	// The field selector on core events is very limited: it can match one source component, but
+	// not a list of them and not any annotation. Use it when it narrows the stream, and filter the
+	// rest as it arrives. events.k8s.io/v1 has no selector for the deprecated source Gatekeeper's
+	// recorder still writes, so there everything is filtered here.
+	opts := metav1.ListOptions{Limit: eventListChunk}
+	if len(q.Sources) == 1 && r == coreEventsResource {
+		opts.FieldSelector = "source=" + q.Sources[0]
 	}
 
-	var filteredList []unstructured.Unstructured
-	for i := range events.Items {
-		source, found, err := unstructured.NestedString(events.Items[i].Object, "source", "component")
-		if found && err == nil && slices.Contains(sources, source) {
-			filteredList = append(filteredList, events.Items[i])
-		} else if err != nil {
-			slog.Debug("error getting event source", "event", events.Items[i].GetName(), "error", err)
+	streams := make([][]streamedEvent, len(namespaces))
+	ends := make([]eventCursor, len(namespaces))
+	errs := make([]error, len(namespaces))
+	var wg sync.WaitGroup
+	for i, ns := range namespaces {
+		from := cursor[ns]
+		ends[i] = from
+		if from.Done {
+			continue
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			streams[i], ends[i], errs[i] = readEventStream(ctx, clientset.Resource(r).Namespace(ns), opts, from, q, pageSize)
+			if errs[i] != nil && ns != "" && !errors.Is(errs[i], errExpiredEventsPage) {
+				errs[i] = fmt.Errorf("namespace %s: %w", ns, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	err = errors.Join(errs...)
+	// Discovery does not look at RBAC: a cluster serves events.k8s.io/v1 to a deployment whose Role
+	// only grants the core group's events. Read those instead of failing.
+	if r == eventsV1Resource && apierrors.IsForbidden(err) {
+		return getKubernetesEvents(ctx, clientset, coreEventsResource, namespaces, q)
 	}
-	return &filteredList, nil
+	if err != nil {
+		return eventPage{}, err
+	}
+
+	// Take from the streams in turn. Each namespace then gives up a prefix of what it read, so its
+	// cursor can resume right after the last event it gave, and nothing it read but did not give is
+	// lost.
+	page := eventPage{}
+	taken := make([]int, len(namespaces))
+	for len(page.Events) < pageSize {
+		took := false
+		for i := range streams {
+			if taken[i] < len(streams[i]) && len(page.Events) < pageSize {
+				page.Events = append(page.Events, streams[i][taken[i]].event)
+				taken[i]++
+				took = true
+			}
+		}
+		if !took {
+			break
+		}
+	}
+
+	next := eventPageCursor{}
+	more := false
+	for i, ns := range namespaces {
+		c := ends[i]
+		if taken[i] > 0 && taken[i] < len(streams[i]) {
+			c = streams[i][taken[i]-1].after
+		} else if taken[i] == 0 && len(streams[i]) > 0 {
+			c = cursor[ns]
+		}
+		next[ns] = c
+		more = more || !c.Done
+	}
+	if more {
+		page.Continue = next.encode()
+	}
+	sortEvents(page.Events, q.Sort)
+	return page, nil
+}
+
+// readEventStream reads one namespace's list from a cursor until it has limit matches or the list
+// ends, and returns the matches with the cursor after the last one.
+func readEventStream(ctx context.Context, list dynamic.ResourceInterface, opts metav1.ListOptions, from eventCursor, q eventQuery, limit int) ([]streamedEvent, eventCursor, error) {
+	var out []streamedEvent
+	token, skip := from.Token, from.Skip
+	for {
+		opts.Continue = token
+		events, err := list.List(ctx, opts)
+		if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+			return nil, from, errExpiredEventsPage
+		}
+		if err != nil {
+			return nil, from, err
+		}
+
+		next := events.GetContinue()
+		for i := skip; i < len(events.Items); i++ {
+			m := ssrEventModel(events.Items[i].Object)
+			if !q.matches(m) {
+				continue
+			}
+			after := eventCursor{Token: token, Skip: i + 1}
+			if i+1 == len(events.Items) {
+				after = eventCursor{Token: next, Done: next == ""}
+			}
+			out = append(out, streamedEvent{event: m, after: after})
+			if len(out) == limit {
+				return out, after, nil
+			}
+		}
+
+		if next == "" {
+			return out, eventCursor{Done: true}, nil
+		}
+		token, skip = next, 0
+	}
+}
+
+// sortEvents orders a page by when the events were last seen, newest first unless asked otherwise.
+// Events without a timestamp go last either way, and ties keep the API's order.
+func sortEvents(events []ssrEvent, order string) {
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i].Time, events[j].Time
+		if a.IsZero() || b.IsZero() {
+			return !a.IsZero() && b.IsZero()
+		}
+		if order == "oldest" {
+			return a.Before(b)
+		}
+		return a.After(b)
+	})
 }
@@ -5,8 +5,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/labstack/echo/v4"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -37,3 +44,145 @@ func TestConstraintOrder(t *testing.T) {
 		}
 	}
 }
+
+// One resource the stand-in API serves in a group: its discovery entry and the objects a list of it
+// returns, as JSON.
+type servedResource struct {
+	Name, Kind string
+	Namespaced bool
+	Categories []string
+	Items      []string
+}
+
+// serveGroup makes the stand-in API serve an API group at one version, with discovery answers for
+// /api, /apis and the group version, and a list answer for each resource. Every resource also gets
+// a status subresource, which discovery lists and nothing should try to list. The core group, "",
+// is served under /api/v1 and left out of /apis, as the API server does. Calls accumulate, so a
+// test can serve several groups.
+func (a *recordingAPI) serveGroup(group, version string, resources ...servedResource) {
+	a.mu.Lock()
+	if a.groups == nil {
+		a.groups = map[string]string{}
+	}
+	a.groups[group] = version
+	var groups []string
+	for g, v := range a.groups {
+		if g == "" {
+			continue
+		}
+		groups = append(groups, fmt.Sprintf(`{"name":%q,"versions":[{"groupVersion":"%s/%s","version":%q}],`+
+			`"preferredVersion":{"groupVersion":"%s/%s","version":%q}}`, g, g, v, v, g, v, v))
+	}
+	a.mu.Unlock()
+
+	a.respondAt("/api", `{"kind":"APIVersions","versions":["v1"]}`)
+	a.respondAt("/apis", `{"kind":"APIGroupList","apiVersion":"v1","groups":[`+strings.Join(groups, ",")+`]}`)
+
+	gv, base := group+"/"+version, "/apis/"+group+"/"+version
+	if group == "" {
+		gv, base = version, "/api/"+version
+	}
+	var entries []string
+	for _, r := range resources {
+		categories := ""
+		if len(r.Categories) > 0 {
+			quoted, _ := json.Marshal(r.Categories)
+			categories = `,"categories":` + string(quoted)
+		}
+		entries = append(entries,
+			fmt.Sprintf(`{"name":%q,"singularName":%q,"namespaced":%t,"kind":%q,"verbs":["get","list","watch"]%s}`,
+				r.Name, strings.ToLower(r.Kind), r.Namespaced, r.Kind, categories),
+			fmt.Sprintf(`{"name":"%s/status","singularName":"","namespaced":%t,"kind":%q,"verbs":["get","patch","update"]}`,
+				r.Name, r.Namespaced, r.Kind))
+		a.respondAt(base+"/"+r.Name, fmt.Sprintf(`{"apiVersion":%q,"kind":"%sList","metadata":{},"items":[%s]}`,
+			gv, r.Kind, strings.Join(r.Items, ",")))
+	}
+	a.respondAt(base, fmt.Sprintf(`{"kind":"APIResourceList","apiVersion":"v1","groupVersion":%q,"resources":[%s]}`,
+		gv, strings.Join(entries, ",")))
+}
+
+// serveConstraints makes the stand-in API serve Constraints of one kind, as Gatekeeper serves them:
+// a resource in the constraints group, named after the kind and in the constraint category.
+func (a *recordingAPI) serveConstraints(kind string, items ...string) {
+	a.serveGroup("constraints.gatekeeper.sh", "v1beta1",
+		servedResource{Name: strings.ToLower(kind), Kind: kind, Categories: []string{"constraint"}, Items: items})
+}
+
+// discoveryTestClients builds the clients of a server that talks to the stand-in API.
+func discoveryTestClients(t *testing.T, api *recordingAPI) (*server, *kubeClients) {
+	t.Helper()
+	useTestSettings(t)
+	s := newEventsTestServer(t, api)
+	clients, err := s.k8s.forContext(defaultKubeContext)
+	if err != nil {
+		t.Fatalf("resolving clients failed: %v", err)
+	}
+	return s, clients
+}
+
+// The mutator kinds are whatever the group serves, at whatever version it prefers: a kind GPM has
+// never heard of shows up, and a cluster that only serves v1beta1 is read at v1beta1.
+func TestListGroupDiscoversKindsAndVersion(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.serveGroup("mutations.gatekeeper.sh", "v1beta1",
+		servedResource{Name: "assign", Kind: "Assign", Items: []string{`{"kind":"Assign","metadata":{"name":"a"}}`}},
+		servedResource{Name: "assignfuture", Kind: "AssignFuture", Items: []string{`{"kind":"AssignFuture","metadata":{"name":"f"}}`}},
+	)
+	_, clients := discoveryTestClients(t, api)
+
+	version, resources, err := groupResources(clients.discovery, "mutations.gatekeeper.sh")
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if version != "v1beta1" || len(resources) != 2 || resources[0].Name != "assign" || resources[1].Name != "assignfuture" {
+		t.Errorf("discovered %q %v, want v1beta1 with assign and assignfuture and no subresource", version, resources)
+	}
+
+	items, err := listGroup(context.Background(), clients, "mutations.gatekeeper.sh")
+	if err != nil {
+		t.Fatalf("listing failed: %v", err)
+	}
+	if len(items) != 2 {
+		t.Errorf("listed %d objects, want 2", len(items))
+	}
+	for _, p := range api.requested() {
+		if strings.HasPrefix(p, "/apis/mutations.gatekeeper.sh/v1/") {
+			t.Errorf("asked for %s, a version the cluster does not serve", p)
+		}
+	}
+}
+
+// A group the cluster does not serve is empty, not broken.
+func TestListGroupWithoutTheGroup(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.serveGroup("templates.gatekeeper.sh", "v1")
+	_, clients := discoveryTestClients(t, api)
+
+	version, _, err := groupResources(clients.discovery, "mutations.gatekeeper.sh")
+	if err != nil || version != "" {
+		t.Errorf("got version %q, err %v; want no version and no error", version, err)
+	}
+	items, err := listGroup(context.Background(), clients, "mutations.gatekeeper.sh")
+	if err != nil || len(items) != 0 {
+		t.Errorf("listed %d objects, err %v; want none and no error", len(items), err)
+	}
+}
+
+func TestMutationsViewListsDiscoveredKinds(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.serveGroup("mutations.gatekeeper.sh", "v1",
+		servedResource{Name: "assignimage", Kind: "AssignImage", Items: []string{
+			`{"apiVersion":"mutations.gatekeeper.sh/v1","kind":"AssignImage","metadata":{"name":"registry-mirror"},"spec":{"location":"spec.containers[name:*].image"}}`,
+		}},
+	)
+	s, _ := discoveryTestClients(t, api)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := s.getMutations(e.NewContext(httptest.NewRequest(http.MethodGet, "/mutations", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	if out := rec.Body.String(); !strings.Contains(out, `id="registry-mirror"`) {
+		t.Error("the discovered AssignImage was not rendered")
+	}
+}
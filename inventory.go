@@ -0,0 +1,324 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Referential data. A template that reads data.inventory sees only the kinds Gatekeeper replicates
+// into its cache, the ones the Config's spec.sync.syncOnly or a SyncSet lists. Reading a kind that
+// nothing syncs is not an error: the lookup is empty, and the template quietly allows everything.
+// This file finds the kinds the Rego reads and the kinds the cluster syncs, and compares the two.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The API group of the SyncSets, read at whatever version the cluster prefers.
+const syncSetGroup = "syncset.gatekeeper.sh"
+
+// inventoryNeed is a kind a template reads from the inventory. An empty APIVersion stands for a
+// lookup that does not name one, data.inventory.namespace[ns][_]["Ingress"], which any synced
+// version of the kind satisfies.
+type inventoryNeed struct {
+	APIVersion string
+	Kind       string
+}
+
+func (n inventoryNeed) String() string {
+	if n.APIVersion == "" {
+		return n.Kind + " (any version)"
+	}
+	return n.APIVersion + " " + n.Kind
+}
+
+// inventorySegment is one step of a Rego reference: a .field, or an [index] that is a string
+// literal or anything else.
+type inventorySegment struct {
+	Value   string
+	Literal bool
+}
+
+// nextSegment reads the reference step starting at s[i], and where it ends. ok is false when the
+// reference ends there.
+func nextSegment(s string, i int) (seg inventorySegment, end int, ok bool) {
+	if i >= len(s) {
+		return seg, i, false
+	}
+	switch s[i] {
+	case '.':
+		j := i + 1
+		for j < len(s) && (s[j] == '_' || 'a' <= s[j] && s[j] <= 'z' || 'A' <= s[j] && s[j] <= 'Z' || '0' <= s[j] && s[j] <= '9') {
+			j++
+		}
+		if j == i+1 {
+			return seg, i, false
+		}
+		return inventorySegment{Value: s[i+1 : j], Literal: true}, j, true
+	case '[':
+		depth := 0
+		for j := i; j < len(s); j++ {
+			switch s[j] {
+			case '[':
+				depth++
+			case ']':
+				depth--
+				if depth == 0 {
+					index := strings.TrimSpace(s[i+1 : j])
+					if v, err := strconv.Unquote(index); err == nil && strings.HasPrefix(index, `"`) {
+						return inventorySegment{Value: v, Literal: true}, j + 1, true
+					}
+					return inventorySegment{Value: index}, j + 1, true
+				}
+			}
+		}
+	}
+	return seg, i, false
+}
+
+// regoInventory finds the kinds a Rego source reads from the inventory. A reference whose kind is
+// not a string literal cannot be resolved by reading the source, and is reported as dynamic instead;
+// so is data.inventory handed whole to a variable.
+func regoInventory(rego string) (needs []inventoryNeed, dynamic bool) {
+	for line := range strings.Lines(rego) {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for rest := line; ; {
+			i := strings.Index(rest, "data.inventory")
+			if i < 0 {
+				break
+			}
+			rest = rest[i+len("data.inventory"):]
+
+			var segs []inventorySegment
+			for j := 0; ; {
+				seg, end, ok := nextSegment(rest, j)
+				if !ok {
+					break
+				}
+				segs = append(segs, seg)
+				j = end
+			}
+			// cluster[<apiVersion>][<kind>] or namespace[<namespace>][<apiVersion>][<kind>].
+			if len(segs) > 0 && segs[0].Literal && segs[0].Value == "namespace" {
+				segs = slices.Delete(segs, 0, min(2, len(segs)))
+			} else if len(segs) > 0 && segs[0].Literal && segs[0].Value == "cluster" {
+				segs = segs[1:]
+			} else {
+				dynamic = true
+				continue
+			}
+			if len(segs) < 2 || !segs[1].Literal {
+				dynamic = true
+				continue
+			}
+			n := inventoryNeed{Kind: segs[1].Value}
+			if segs[0].Literal {
+				n.APIVersion = segs[0].Value
+			}
+			if !slices.Contains(needs, n) {
+				needs = append(needs, n)
+			}
+		}
+	}
+	return needs, dynamic
+}
+
+// templateInventory is every kind a template's Rego and libs read from the inventory.
+func templateInventory(t ssrConstraintTemplate) (needs []inventoryNeed, dynamic bool) {
+	for _, source := range t.RegoSources() {
+		found, d := regoInventory(source)
+		dynamic = dynamic || d
+		for _, n := range found {
+			if !slices.Contains(needs, n) {
+				needs = append(needs, n)
+			}
+		}
+	}
+	sort.Slice(needs, func(i, j int) bool { return needs[i].String() < needs[j].String() })
+	return needs, dynamic
+}
+
+// syncEntry is a kind Gatekeeper replicates, and the object that asks it to.
+type syncEntry struct {
+	APIVersion string
+	Kind       string
+	Source     string // "Config gatekeeper-system/config", "SyncSet pods"
+}
+
+// covers reports whether the entry replicates what the need reads.
+func (e syncEntry) covers(n inventoryNeed) bool {
+	return e.Kind == n.Kind && (n.APIVersion == "" || n.APIVersion == e.APIVersion)
+}
+
+// syncGVKs reads the group, version, kind entries at fields of obj, the shape both the Config's
+// syncOnly and a SyncSet's gvks have.
+func syncGVKs(obj map[string]any, source string, fields ...string) []syncEntry {
+	entries, _, _ := unstructured.NestedSlice(obj, fields...)
+	out := make([]syncEntry, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(entry, "group")
+		version, _, _ := unstructured.NestedString(entry, "version")
+		kind, _, _ := unstructured.NestedString(entry, "kind")
+		out = append(out, syncEntry{APIVersion: schema.GroupVersion{Group: group, Version: version}.String(), Kind: kind, Source: source})
+	}
+	return out
+}
+
+// syncSource names the object a sync entry comes from: "Config gatekeeper-system/config".
+func syncSource(kind string, o map[string]any) string {
+	u := unstructured.Unstructured{Object: o}
+	if ns := u.GetNamespace(); ns != "" {
+		return kind + " " + ns + "/" + u.GetName()
+	}
+	return kind + " " + u.GetName()
+}
+
+// syncEntriesOf is what the Configs' syncOnly and the SyncSets' gvks replicate.
+func syncEntriesOf(configs, syncSets []map[string]any) []syncEntry {
+	var out []syncEntry
+	for _, c := range configs {
+		out = append(out, syncGVKs(c, syncSource("Config", c), "spec", "sync", "syncOnly")...)
+	}
+	for _, s := range syncSets {
+		out = append(out, syncGVKs(s, syncSource("SyncSet", s), "spec", "gvks")...)
+	}
+	return out
+}
+
+// listSyncSources reads the Configs and the SyncSets. A cluster whose Gatekeeper predates SyncSets
+// has none, and that is an answer; failing to read either is an error, and the objects read so far
+// come back with it.
+func listSyncSources(ctx context.Context, clients *kubeClients) (configs, syncSets []map[string]any, err error) {
+	var errs []error
+	list, err := getCustomResources(ctx, *clients.dynamic, "config.gatekeeper.sh", "v1alpha1", "configs")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("listing configs: %w", err))
+	} else {
+		for i := range list.Items {
+			configs = append(configs, list.Items[i].Object)
+		}
+	}
+	syncSets, err = listGroup(ctx, clients, syncSetGroup)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("listing syncsets: %w", err))
+	}
+	return configs, syncSets, errors.Join(errs...)
+}
+
+// listSyncEntries reads what the cluster replicates, with listSyncSources' error.
+func listSyncEntries(ctx context.Context, clients *kubeClients) ([]syncEntry, error) {
+	configs, syncSets, err := listSyncSources(ctx, clients)
+	return syncEntriesOf(configs, syncSets), err
+}
+
+// A kind a template reads from the inventory, and whether nothing syncs it.
+type ssrInventoryRef struct {
+	Need    string
+	Missing bool
+}
+
+// inventoryRefs marks the needs no entry covers. known is false when GPM could not read what the
+// cluster syncs, so nothing is marked.
+func inventoryRefs(needs []inventoryNeed, entries []syncEntry, known bool) []ssrInventoryRef {
+	out := make([]ssrInventoryRef, 0, len(needs))
+	for _, n := range needs {
+		covered := slices.ContainsFunc(entries, func(e syncEntry) bool { return e.covers(n) })
+		out = append(out, ssrInventoryRef{Need: n.String(), Missing: known && !covered})
+	}
+	return out
+}
+
+// ssrInventoryNeed is one row of the Configurations view's referential data table: a kind the
+// templates read, which of them read it, and what syncs it.
+type ssrInventoryNeed struct {
+	Need      string
+	Templates []string
+	SyncedBy  []string // empty when nothing syncs the kind
+}
+
+// inventoryAnalysis is what the Configurations view shows about referential data.
+type inventoryAnalysis struct {
+	Needs   []ssrInventoryNeed
+	Missing int
+	Dynamic []string // the templates with a lookup GPM cannot resolve
+	// The syncOnly entries that would cover the missing needs that name their version, ready to
+	// paste into the Config.
+	Snippet string
+}
+
+// analyseInventory compares what the templates read with what the cluster syncs.
+func analyseInventory(templates []ssrConstraintTemplate, entries []syncEntry) inventoryAnalysis {
+	var a inventoryAnalysis
+	byNeed := map[inventoryNeed][]string{}
+	for _, t := range templates {
+		needs, dynamic := templateInventory(t)
+		if dynamic {
+			a.Dynamic = append(a.Dynamic, t.Kind)
+		}
+		for _, n := range needs {
+			byNeed[n] = append(byNeed[n], t.Kind)
+		}
+	}
+
+	needs := make([]inventoryNeed, 0, len(byNeed))
+	for n := range byNeed {
+		needs = append(needs, n)
+	}
+	sort.Slice(needs, func(i, j int) bool { return needs[i].String() < needs[j].String() })
+
+	var snippet []map[string]any
+	for _, n := range needs {
+		row := ssrInventoryNeed{Need: n.String(), Templates: byNeed[n]}
+		for _, e := range entries {
+			if e.covers(n) && !slices.Contains(row.SyncedBy, e.Source) {
+				row.SyncedBy = append(row.SyncedBy, e.Source)
+			}
+		}
+		sort.Strings(row.Templates)
+		sort.Strings(row.SyncedBy)
+		if len(row.SyncedBy) == 0 {
+			a.Missing++
+			if n.APIVersion != "" {
+				gv, _ := schema.ParseGroupVersion(n.APIVersion)
+				snippet = append(snippet, map[string]any{"group": gv.Group, "version": gv.Version, "kind": n.Kind})
+			}
+		}
+		a.Needs = append(a.Needs, row)
+	}
+	sort.Strings(a.Dynamic)
+	if len(snippet) > 0 {
+		a.Snippet = toYAML(map[string]any{"spec": map[string]any{"sync": map[string]any{"syncOnly": snippet}}})
+	}
+	return a
+}
+
+// templateInventoryAnalysis reads the templates for the Configurations view and compares them with
+// the sync entries it already has. It returns nil when the templates cannot be read, since there is
+// nothing to compare then.
+func templateInventoryAnalysis(ctx context.Context, clients *kubeClients, entries []syncEntry) *inventoryAnalysis {
+	cts, err := getCustomResources(ctx, *clients.dynamic, "templates.gatekeeper.sh", "v1", "constrainttemplates")
+	if err != nil {
+		slog.Warn("SSR configurations: reading constraint templates failed, referential data is left out", "error", err)
+		return nil
+	}
+	templates := make([]ssrConstraintTemplate, 0, len(cts.Items))
+	for i := range cts.Items {
+		templates = append(templates, ssrConstraintTemplateModel(cts.Items[i].Object, nil))
+	}
+	a := analyseInventory(templates, entries)
+	return &a
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRegoInventory(t *testing.T) {
+	for _, c := range []struct {
+		rego    string
+		needs   string
+		dynamic bool
+	}{
+		{`other := data.inventory.namespace[ns][otherapiversion]["Ingress"][name]`, "Ingress (any version)", false},
+		{`svc := data.inventory.namespace[namespace]["v1"]["Service"][name]`, "v1 Service", false},
+		{`ns := data.inventory.cluster["v1"].Namespace[input.review.object.metadata.namespace]`, "v1 Namespace", false},
+		{`x := data.inventory.namespace[ns]["networking.k8s.io/v1"][kind][name]`, "", true},
+		{`inv := data.inventory`, "", true},
+		{`# data.inventory.cluster["v1"]["Namespace"] used to be read here`, "", false},
+		{
+			`a := data.inventory.cluster["v1"]["Namespace"]` + "\n" + `b := data.inventory.namespace[n]["apps/v1"]["Deployment"][_]`,
+			"v1 Namespace,apps/v1 Deployment", false,
+		},
+	} {
+		needs, dynamic := regoInventory(c.rego)
+		var got []string
+		for _, n := range needs {
+			got = append(got, n.String())
+		}
+		if strings.Join(got, ",") != c.needs || dynamic != c.dynamic {
+			t.Errorf("regoInventory(%q) = %v, %t; want %q, %t", c.rego, got, dynamic, c.needs, c.dynamic)
+		}
+	}
+}
+
+// regoTemplate is a template with one Rego module and its libs.
+func regoTemplate(kind, rego string, libs ...string) ssrConstraintTemplate {
+	return ssrConstraintTemplate{Kind: kind, Targets: []ssrTemplateTarget{{Code: []ssrTemplateCode{{Engine: "Rego", Rego: rego, Libs: libs}}}}}
+}
+
+// A lookup that names no version is satisfied by any synced version of the kind; one that names a
+// version only by that version.
+func TestAnalyseInventory(t *testing.T) {
+	templates := []ssrConstraintTemplate{
+		regoTemplate("K8sUniqueIngressHost", `data.inventory.namespace[ns][_]["Ingress"][name]`),
+		regoTemplate("K8sUniqueServiceSelector", `data.inventory.namespace[ns]["v1"]["Service"][name]`),
+		regoTemplate("K8sRequiredNamespaceOwner", "package owner", `data.inventory.cluster["v1"]["Namespace"][n]`),
+	}
+	entries := []syncEntry{
+		{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Source: "SyncSet ingresses"},
+		{APIVersion: "v1", Kind: "Service", Source: "Config gatekeeper-system/config"},
+		{APIVersion: "v1beta1", Kind: "Namespace", Source: "Config gatekeeper-system/config"},
+	}
+
+	a := analyseInventory(templates, entries)
+	if a.Missing != 1 || len(a.Needs) != 3 {
+		t.Fatalf("analysis = %+v, want three kinds and the Namespace missing", a)
+	}
+	if got := a.Needs[0]; got.Need != "Ingress (any version)" || strings.Join(got.SyncedBy, ",") != "SyncSet ingresses" {
+		t.Errorf("Ingress row = %+v", got)
+	}
+	if got := a.Needs[1]; got.Need != "v1 Namespace" || got.SyncedBy != nil || got.Templates[0] != "K8sRequiredNamespaceOwner" {
+		t.Errorf("Namespace row = %+v, want it unsynced", got)
+	}
+	if !strings.Contains(a.Snippet, "kind: Namespace") || !strings.Contains(a.Snippet, `group: ""`) {
+		t.Errorf("snippet =\n%s", a.Snippet)
+	}
+}
+
+// inventoryAPI serves a template that reads Services and Namespaces, a Config that syncs Services
+// and no SyncSets.
+func inventoryAPI(t *testing.T) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.respondAt("/apis/templates.gatekeeper.sh/v1/constrainttemplates", `{"apiVersion":"templates.gatekeeper.sh/v1",`+
+		`"kind":"ConstraintTemplateList","metadata":{},"items":[`+
+		constraintTemplateJSON(t, "K8sUniqueServiceSelector",
+			`s := data.inventory.namespace[ns]["v1"]["Service"][name]`+"\n"+`n := data.inventory.cluster["v1"]["Namespace"][ns]`)+`]}`)
+	api.serveGroup("config.gatekeeper.sh", "v1alpha1", servedResource{Name: "configs", Kind: "Config", Namespaced: true, Items: []string{
+		`{"kind":"Config","metadata":{"name":"config","namespace":"gatekeeper-system"},` +
+			`"spec":{"sync":{"syncOnly":[{"group":"","version":"v1","kind":"Service"}]}}}`,
+	}})
+	return api
+}
+
+func TestConfigurationsListUnsyncedInventory(t *testing.T) {
+	s, _ := discoveryTestClients(t, inventoryAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getConfigurations(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/configurations", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`id="referential-data"`, `<span class="badge badge-danger">1 not synced</span>`,
+		"<code>v1 Service</code>", "Config gatekeeper-system/config",
+		`<a href="/constrainttemplates#K8sUniqueServiceSelector">K8sUniqueServiceSelector</a>`,
+		"Sync entries for the missing kinds",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("configurations output missing %q", want)
+		}
+	}
+}
+
+func TestConstraintTemplatesMarkUnsyncedInventory(t *testing.T) {
+	s, _ := discoveryTestClients(t, inventoryAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getConstraintTemplates(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/constrainttemplates", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	if !strings.Contains(out, `<a href="/configurations#referential-data">v1 Namespace <span class="badge badge-danger"`) {
+		t.Error("the unsynced Namespace is not marked")
+	}
+	if !strings.Contains(out, `<a href="/configurations#referential-data">v1 Service</a>`) {
+		t.Error("the synced Service is not listed, or is marked")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 	"log/slog"
 
@@ -26,9 +27,12 @@ import (
 // Holds everything the handlers need. They are methods on it rather than package-level functions
 // so that nothing reaches for shared mutable state, and so tests can build one with fakes.
 type server struct {
-	k8s       *clientRegistry
-	ssr       *ssrRenderer
-	dashCache dashboardCache
+	k8s        *clientRegistry
+	ssr        *ssrRenderer
+	dashCache  dashboardCache
+	denyLogs   *denyLogCollector // nil unless GPM_DENY_LOGS is on
+	gator      gatorCache
+	compliance complianceCache
 }
 
 // The single source of truth for the version string shown in logs and the UI.
@@ -88,10 +92,48 @@ func bindSettings() {
 	// with gatekeeper-webhook and audit events with gatekeeper-audit; show both by default.
 	_ = viper.BindEnv("events_source")
 	viper.SetDefault("events_source", "gatekeeper-webhook,gatekeeper-audit")
-	// Which namespace to read events from. Empty means every namespace, which needs a cluster-wide
-	// read on events; naming one lets the deployment get by with a Role in that namespace.
+	// Comma-separated namespaces to read events from. Empty means every namespace, which needs a
+	// cluster-wide read on events; naming them lets the deployment get by with a Role in each.
 	_ = viper.BindEnv("events_namespace")
 	viper.SetDefault("events_namespace", "")
+	// The optional deny-log collector: off unless asked for, since it needs a read on pods/log in
+	// the Gatekeeper namespace. The selector matches the labels Gatekeeper's own manifests put on
+	// both the webhook and the audit pods; the interval is in seconds.
+	_ = viper.BindEnv("deny_logs")
+	viper.SetDefault("deny_logs", false)
+	_ = viper.BindEnv("deny_logs_namespace")
+	viper.SetDefault("deny_logs_namespace", "gatekeeper-system")
+	_ = viper.BindEnv("deny_logs_selector")
+	viper.SetDefault("deny_logs_selector", "gatekeeper.sh/system=yes")
+	_ = viper.BindEnv("deny_logs_interval")
+	viper.SetDefault("deny_logs_interval", defaultDenyLogInterval)
+	// The mutation preview dry-runs objects through the API server, which needs create and update
+	// on them, so it is off unless asked for.
+	_ = viper.BindEnv("mutation_preview")
+	viper.SetDefault("mutation_preview", false)
+	// Comma-separated label keys that name a namespace's owner or contact, for the namespace page.
+	// A key missing from the labels is looked up in the annotations.
+	_ = viper.BindEnv("namespace_owner_keys")
+	viper.SetDefault("namespace_owner_keys", "owner,team,contact")
+	// The namespace label that names the team owning a namespace, for the Teams view and the
+	// ?team= filter of the Constraints, Resources and Events views.
+	_ = viper.BindEnv("team_label")
+	viper.SetDefault("team_label", "team")
+	// Score the compliance of each cluster, namespace and team. It lists every object of the kinds
+	// the Constraints name, so it is off by default.
+	_ = viper.BindEnv("compliance_score")
+	viper.SetDefault("compliance_score", false)
+	// A directory of gator suites to run against the templates of each context. Empty runs none.
+	_ = viper.BindEnv("gator_suites")
+	viper.SetDefault("gator_suites", "")
+	// A directory of ConstraintTemplates and Constraints, the desired state to compare each context
+	// with. Empty leaves the Bundle view to uploads.
+	_ = viper.BindEnv("bundle_dir")
+	viper.SetDefault("bundle_dir", "")
+	// Let the Bundle view compare an uploaded bundle. Any viewer could then make GPM unpack a file
+	// and compare it with every context, so it is off unless asked for.
+	_ = viper.BindEnv("bundle_upload")
+	viper.SetDefault("bundle_upload", false)
 	_ = viper.BindEnv("skip_tls_verify")
 	viper.SetDefault("skip_tls_verify", false)
 	// The subpath GPM is served from. The image sets this from the PUBLIC_URL the frontend was
@@ -125,6 +167,35 @@ func bindSettings() {
 	}
 }
 
+// settingList reads a comma-separated setting, dropping blanks and the spaces around each entry.
+func settingList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(viper.GetString(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// The echo context key of the CSRF token, which the forms that POST render as their _csrf field.
+const csrfContextKey = "csrf"
+
+// csrfProtection guards the routes that take a POST. The token travels in a cookie scoped like the
+// session cookie and in the form, and a POST without both matching is refused. The views that only
+// read do not carry it.
+func csrfProtection() echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		TokenLookup:    "form:_csrf",
+		ContextKey:     csrfContextKey,
+		CookieName:     "_gpm_csrf",
+		CookiePath:     cookiePath(),
+		CookieHTTPOnly: true,
+		CookieSameSite: http.SameSiteStrictMode,
+		CookieSecure:   strings.EqualFold(viper.GetString("preferred_url_scheme"), "https"),
+	})
+}
+
 func main() {
 	bindSettings()
 
@@ -207,7 +278,8 @@ func main() {
 	slog.Info("starting Gatekeeper Policy Manager", "version", appVersion)
 	setLogLevel(programLevel, viper.GetString("log_level"))
 
-	// Renders the HTML violations report at /constraints?report=html.
+	// Renders the HTML reports: the violations report at /constraints?report=html, and the policy
+	// bundle report at /bundle?report=html.
 	e.Renderer = newRenderer()
 
 	// Authentication. When it is off, no session or auth middleware is installed at all, so the
@@ -241,7 +313,10 @@ func main() {
 		slog.Error("Kubernetes client initialization failed", "error", err)
 		os.Exit(1)
 	}
-	s := &server{k8s: registry, ssr: newSSRRenderer()}
+	s := &server{k8s: registry, ssr: newSSRRenderer(), denyLogs: newDenyLogCollector(registry)}
+	if s.denyLogs != nil {
+		go s.denyLogs.run(context.Background())
+	}
 
 	// The server-rendered UI: every view at its real path, plus the embedded static assets. See ssr.go.
 	registerViews(e, s)
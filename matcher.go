@@ -0,0 +1,192 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Gatekeeper's match block, read the way Gatekeeper reads it. Constraints, mutators and the Config
+// all select objects with the same fields, so the views that ask "what does this apply to" share
+// one reading of them.
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// matchCriteria is a spec.match. Every field narrows the match, and an unset field does not.
+type matchCriteria struct {
+	Scope              string                `json:"scope,omitempty"` // *, Cluster or Namespaced
+	Kinds              []matchKinds          `json:"kinds,omitempty"`
+	Namespaces         []string              `json:"namespaces,omitempty"`
+	ExcludedNamespaces []string              `json:"excludedNamespaces,omitempty"`
+	NamespaceSelector  *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	LabelSelector      *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	Name               string                `json:"name,omitempty"`
+}
+
+// One entry of match.kinds: every kind listed, in every group listed.
+type matchKinds struct {
+	APIGroups []string `json:"apiGroups,omitempty"`
+	Kinds     []string `json:"kinds,omitempty"`
+}
+
+// A namespace of the cluster, with the labels a namespaceSelector is evaluated against.
+type clusterNamespace struct {
+	Name   string
+	Labels map[string]string
+}
+
+// parseMatch reads the match block at fields of obj. A missing block matches everything; one that
+// does not have Gatekeeper's shape is an error.
+func parseMatch(obj map[string]any, fields ...string) (matchCriteria, error) {
+	var m matchCriteria
+	node := any(obj)
+	for _, f := range fields {
+		next, ok := node.(map[string]any)
+		if !ok {
+			return m, nil
+		}
+		node = next[f]
+	}
+	raw, ok := node.(map[string]any)
+	if !ok {
+		return m, nil
+	}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &m)
+	return m, err
+}
+
+// matchesKind reports whether the criteria select a kind of a group: no kinds listed selects them
+// all, and "*" stands for any group or any kind.
+func (m matchCriteria) matchesKind(group, kind string) bool {
+	if len(m.Kinds) == 0 {
+		return true
+	}
+	for _, k := range m.Kinds {
+		groups := k.APIGroups
+		if len(groups) == 0 {
+			groups = []string{"*"}
+		}
+		kinds := k.Kinds
+		if len(kinds) == 0 {
+			kinds = []string{"*"}
+		}
+		if (slices.Contains(groups, "*") || slices.Contains(groups, group)) &&
+			(slices.Contains(kinds, "*") || slices.Contains(kinds, kind)) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyKind reports whether the criteria select every kind of every group.
+func (m matchCriteria) anyKind() bool {
+	if len(m.Kinds) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(m.Kinds, func(k matchKinds) bool {
+		return (len(k.APIGroups) == 0 || slices.Contains(k.APIGroups, "*")) &&
+			(len(k.Kinds) == 0 || slices.Contains(k.Kinds, "*"))
+	})
+}
+
+// matchesNamespace reports whether the criteria select objects in a namespace. A namespaceSelector
+// that does not parse selects nothing, as Gatekeeper would refuse the object carrying it.
+func (m matchCriteria) matchesNamespace(ns clusterNamespace) bool {
+	if m.Scope == "Cluster" {
+		return false
+	}
+	if len(m.Namespaces) > 0 && !slices.ContainsFunc(m.Namespaces, func(p string) bool { return globMatch(p, ns.Name) }) {
+		return false
+	}
+	if slices.ContainsFunc(m.ExcludedNamespaces, func(p string) bool { return globMatch(p, ns.Name) }) {
+		return false
+	}
+	if m.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(m.NamespaceSelector)
+		if err != nil || !selector.Matches(labels.Set(ns.Labels)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesClusterScoped reports whether the criteria can select an object that has no namespace.
+// The namespace fields do not apply to those.
+func (m matchCriteria) matchesClusterScoped() bool {
+	return m.Scope != "Namespaced"
+}
+
+// constraintMatches reports whether a Constraint's match selects an object. A namespaceSelector
+// needs the labels of the object's namespace: ns carries them, and without it (a gator case has
+// only the object) the selector is left out. A Namespace is matched by the namespace fields as the
+// namespace it is, the way Gatekeeper matches it.
+func constraintMatches(constraint, obj map[string]any, ns *clusterNamespace) bool {
+	m, err := parseMatch(constraint, "spec", "match")
+	if err != nil {
+		return false
+	}
+	u := unstructured.Unstructured{Object: obj}
+	group := u.GroupVersionKind().Group
+	if !m.matchesKind(group, u.GetKind()) {
+		return false
+	}
+	if m.Name != "" && !globMatch(m.Name, u.GetName()) {
+		return false
+	}
+	if m.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(m.LabelSelector)
+		if err != nil || !selector.Matches(labels.Set(u.GetLabels())) {
+			return false
+		}
+	}
+	switch {
+	case group == "" && u.GetKind() == "Namespace":
+		if !m.matchesClusterScoped() {
+			return false
+		}
+		// The scope already held; matchesNamespace would read Cluster as "no namespaces".
+		m.Scope = ""
+		return m.matchesNamespace(clusterNamespace{Name: u.GetName(), Labels: u.GetLabels()})
+	case u.GetNamespace() != "":
+		if ns == nil {
+			m.NamespaceSelector = nil
+			ns = &clusterNamespace{Name: u.GetNamespace()}
+		}
+		return m.matchesNamespace(*ns)
+	}
+	return m.matchesClusterScoped()
+}
+
+// globMatch is Gatekeeper's namespace glob: a name, a prefix ending in * or a suffix starting with *.
+func globMatch(pattern, value string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, "*"):
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	case strings.HasPrefix(pattern, "*"):
+		return strings.HasSuffix(value, strings.TrimPrefix(pattern, "*"))
+	default:
+		return pattern == value
+	}
+}
+
+// listNamespaces reads the namespaces of the cluster with their labels.
+func listNamespaces(ctx context.Context, clients *kubeClients) ([]clusterNamespace, error) {
+	list, err := getCustomResources(ctx, *clients.dynamic, "", "v1", "namespaces")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]clusterNamespace, 0, len(list.Items))
+	for _, item := range list.Items {
+		out = append(out, clusterNamespace{Name: item.GetName(), Labels: item.GetLabels()})
+	}
+	slices.SortFunc(out, func(a, b clusterNamespace) int { return strings.Compare(a.Name, b.Name) })
+	return out, nil
+}
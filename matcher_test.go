@@ -0,0 +1,114 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func labelSelector(key, value string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: map[string]string{key: value}}
+}
+
+func TestParseMatch(t *testing.T) {
+	obj := map[string]any{"spec": map[string]any{"match": map[string]any{
+		"scope":              "Namespaced",
+		"kinds":              []any{map[string]any{"apiGroups": []any{"apps"}, "kinds": []any{"Deployment"}}},
+		"excludedNamespaces": []any{"kube-*"},
+		"namespaceSelector":  map[string]any{"matchLabels": map[string]any{"team": "a"}},
+	}}}
+	m, err := parseMatch(obj, "spec", "match")
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	if m.Scope != "Namespaced" || len(m.Kinds) != 1 || m.NamespaceSelector == nil {
+		t.Errorf("match = %+v", m)
+	}
+
+	if m, err := parseMatch(map[string]any{}, "spec", "match"); err != nil || !m.anyKind() {
+		t.Errorf("a missing match = %+v, %v; want one that matches everything", m, err)
+	}
+	if _, err := parseMatch(map[string]any{"match": map[string]any{"kinds": "Pod"}}, "match"); err == nil {
+		t.Error("a match with a string for kinds parsed")
+	}
+}
+
+func TestMatchesKind(t *testing.T) {
+	m := matchCriteria{Kinds: []matchKinds{
+		{APIGroups: []string{"apps"}, Kinds: []string{"Deployment"}},
+		{APIGroups: []string{"*"}, Kinds: []string{"Pod"}},
+	}}
+	for _, c := range []struct {
+		group, kind string
+		want        bool
+	}{
+		{"apps", "Deployment", true},
+		{"apps", "StatefulSet", false},
+		{"", "Pod", true},
+		{"batch", "Job", false},
+	} {
+		if got := m.matchesKind(c.group, c.kind); got != c.want {
+			t.Errorf("matchesKind(%q, %q) = %t, want %t", c.group, c.kind, got, c.want)
+		}
+	}
+	if m.anyKind() {
+		t.Error("a match with listed kinds selects every kind")
+	}
+}
+
+func TestMatchesNamespace(t *testing.T) {
+	team := clusterNamespace{Name: "team-a", Labels: map[string]string{"team": "a"}}
+	kube := clusterNamespace{Name: "kube-system"}
+	for name, c := range map[string]struct {
+		m    matchCriteria
+		ns   clusterNamespace
+		want bool
+	}{
+		"no criteria":       {matchCriteria{}, kube, true},
+		"cluster scope":     {matchCriteria{Scope: "Cluster"}, team, false},
+		"prefix glob":       {matchCriteria{Namespaces: []string{"team-*"}}, team, true},
+		"suffix glob":       {matchCriteria{Namespaces: []string{"*-system"}}, team, false},
+		"excluded":          {matchCriteria{ExcludedNamespaces: []string{"kube-*"}}, kube, false},
+		"selector matches":  {matchCriteria{NamespaceSelector: labelSelector("team", "a")}, team, true},
+		"selector does not": {matchCriteria{NamespaceSelector: labelSelector("team", "a")}, kube, false},
+	} {
+		if got := c.m.matchesNamespace(c.ns); got != c.want {
+			t.Errorf("%s: matchesNamespace(%s) = %t, want %t", name, c.ns.Name, got, c.want)
+		}
+	}
+}
+
+func TestConstraintMatches(t *testing.T) {
+	constraint := func(match map[string]any) map[string]any {
+		return map[string]any{"spec": map[string]any{"match": match}}
+	}
+	envProd := map[string]any{"namespaceSelector": map[string]any{"matchLabels": map[string]any{"env": "prod"}}}
+	pod := map[string]any{"apiVersion": "v1", "kind": "Pod", "metadata": map[string]any{"name": "web", "namespace": "shop"}}
+	prod := &clusterNamespace{Name: "shop", Labels: map[string]string{"env": "prod"}}
+	dev := &clusterNamespace{Name: "shop", Labels: map[string]string{"env": "dev"}}
+	namespace := map[string]any{"apiVersion": "v1", "kind": "Namespace",
+		"metadata": map[string]any{"name": "shop", "labels": map[string]any{"env": "prod"}}}
+
+	for name, c := range map[string]struct {
+		match map[string]any
+		obj   map[string]any
+		ns    *clusterNamespace
+		want  bool
+	}{
+		"selector on the namespace's labels": {envProd, pod, prod, true},
+		"selector not matching":              {envProd, pod, dev, false},
+		"selector without the namespace":     {envProd, pod, nil, true},
+		"a Namespace matches itself":         {envProd, namespace, nil, true},
+		"a Namespace under cluster scope":    {map[string]any{"scope": "Cluster", "namespaces": []any{"shop"}}, namespace, nil, true},
+		"a Namespace excluded":               {map[string]any{"excludedNamespaces": []any{"sh*"}}, namespace, nil, false},
+		"a Namespace under namespaced scope": {map[string]any{"scope": "Namespaced"}, namespace, nil, false},
+	} {
+		if got := constraintMatches(constraint(c.match), c.obj, c.ns); got != c.want {
+			t.Errorf("%s: constraintMatches = %t, want %t", name, got, c.want)
+		}
+	}
+}
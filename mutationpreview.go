@@ -0,0 +1,650 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The mutation preview. An object goes to the API server as a server-side dry run, the mutating
+// webhooks run on it as they would for real, and nothing is stored. The answer is diffed against
+// what went in, and each changed path is traced back to the mutator whose location covers it.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// The most lines either side of the diff may have. A diff's memory grows with the lines times the
+// edits, and an object this long is not one anybody reads as a diff: past it, the diff is the whole
+// of one side removed and the other added.
+const previewDiffMaxLines = 400
+
+// The annotation Gatekeeper writes, with --mutation-annotations, listing the mutators that changed
+// an object: "Assign//always-pull:1, AssignMetadata//owner:2", that is kind/namespace/name:generation.
+const mutationsAnnotation = "gatekeeper.sh/mutations"
+
+// The annotations Gatekeeper adds to record its own work. They show in the diff like any change,
+// and are attributed to Gatekeeper itself rather than to a mutator.
+var gatekeeperMutationAnnotations = []string{mutationsAnnotation, "gatekeeper.sh/mutation-id"}
+
+// The preview form, as the user filled it in, so a render after a POST keeps their input.
+type mutationPreviewForm struct {
+	Source     string // manifest | live
+	Manifest   string
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	CSRF       string
+}
+
+// One changed path of the previewed object.
+type mutationChange struct {
+	Path     string // in Gatekeeper's location syntax, so it reads like the mutators' spec.location
+	Change   string // added | changed | removed
+	Before   string
+	After    string
+	Mutators []mutatorRef
+	System   bool // one of Gatekeeper's own bookkeeping annotations
+}
+
+// A mutator a change is attributed to, with the card it links to on the Mutations view.
+type mutatorRef struct {
+	Kind string
+	Name string
+}
+
+// The outcome of one preview.
+type mutationPreviewResult struct {
+	Operation string // create | update: which dry run the API server ran
+	Diff      string // a unified line diff of the YAML, for the diff highlighter
+	Changes   []mutationChange
+	// Annotated is true when the result carries Gatekeeper's mutations annotation, so the
+	// attribution is to the mutators that ran. Otherwise it is to every mutator whose location
+	// covers the path, which may include one that did not match the object.
+	Annotated bool
+	Applied   []mutatorRef // the mutators the annotation names
+}
+
+// getMutationPreview renders the preview form. The query can fill in a live object, so other views
+// can link straight to a preview of it.
+func (s *server) getMutationPreview(c echo.Context) error {
+	form := mutationPreviewForm{
+		Source:     "manifest",
+		APIVersion: c.QueryParam("apiVersion"),
+		Kind:       c.QueryParam("kind"),
+		Namespace:  c.QueryParam("namespace"),
+		Name:       c.QueryParam("name"),
+	}
+	if form.Kind != "" && form.Name != "" {
+		form.Source = "live"
+	}
+	return s.renderMutationPreview(c, form, nil)
+}
+
+// postMutationPreview runs a preview and renders the form again with its outcome.
+func (s *server) postMutationPreview(c echo.Context) error {
+	if !viper.GetBool("mutation_preview") {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	form := mutationPreviewForm{
+		Source:     c.FormValue("source"),
+		Manifest:   c.FormValue("manifest"),
+		APIVersion: strings.TrimSpace(c.FormValue("apiVersion")),
+		Kind:       strings.TrimSpace(c.FormValue("kind")),
+		Namespace:  strings.TrimSpace(c.FormValue("namespace")),
+		Name:       strings.TrimSpace(c.FormValue("name")),
+	}
+	data := map[string]any{}
+
+	clients, err := s.clientsFor(c)
+	if err != nil {
+		slog.Error("SSR mutation preview: resolving context failed", "error", err)
+		setViewError(data, "GPM could not switch to the requested Kubernetes context. Make sure the kubeconfig defines it correctly.", err)
+		return s.renderMutationPreview(c, form, data)
+	}
+
+	result, err := previewMutation(c.Request().Context(), clients, form)
+	if err != nil {
+		setViewError(data, previewErrorMessage(err), err)
+		return s.renderMutationPreview(c, form, data)
+	}
+	data["Result"] = result
+	return s.renderMutationPreview(c, form, data)
+}
+
+// previewErrorMessage says what went wrong in the terms of the form. A denial is the most likely
+// answer of all: a dry run goes through the validating webhooks too, Gatekeeper's among them.
+func previewErrorMessage(err error) string {
+	switch {
+	case errPreviewInput(err):
+		return "GPM could not read the object to preview. Check the manifest or the object fields."
+	case apierrors.IsForbidden(err):
+		return "The Kubernetes API refused the dry run. Either a policy denied the object, or GPM's service account may not create or update it."
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return "The Kubernetes API rejected the object as invalid."
+	case apierrors.IsNotFound(err):
+		return "The object or its namespace does not exist in this cluster."
+	default:
+		return "GPM could not run the dry run against the Kubernetes API. Make sure the API is reachable."
+	}
+}
+
+// previewInputError marks a problem with what the user sent, rather than with the cluster.
+type previewInputError struct{ msg string }
+
+func (e previewInputError) Error() string { return e.msg }
+
+func errPreviewInput(err error) bool {
+	var input previewInputError
+	return errors.As(err, &input)
+}
+
+func (s *server) renderMutationPreview(c echo.Context, form mutationPreviewForm, data map[string]any) error {
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["Layout"] = s.ssrLayoutData(c, "mutations", "/mutation-preview", "Mutation preview")
+	data["Enabled"] = viper.GetBool("mutation_preview")
+	form.CSRF, _ = c.Get(csrfContextKey).(string)
+	data["Form"] = form
+	data["MutationsURL"] = contextPath(c, "/mutations")
+	return s.ssr.render(c, "mutationpreview", data)
+}
+
+// previewMutation reads the object the form names, dry-runs it, and works out what changed.
+//
+// A manifest is dry-run created, and dry-run updated instead when an object of that name already
+// exists. A live object is dry-run updated as it is, which shows what the mutators would do on its
+// next update: Gatekeeper mutates on both operations.
+func previewMutation(ctx context.Context, clients *kubeClients, form mutationPreviewForm) (mutationPreviewResult, error) {
+	obj, err := previewObject(form)
+	if err != nil {
+		return mutationPreviewResult{}, err
+	}
+	gvr, namespaced, err := resourceFor(clients.discovery, obj.GetAPIVersion(), obj.GetKind())
+	if err != nil {
+		return mutationPreviewResult{}, err
+	}
+	// The page shows the object's YAML to anyone who can open it, and the object page never reads a
+	// Secret either.
+	if gvr.Group == "" && obj.GetKind() == "Secret" {
+		return mutationPreviewResult{}, previewInputError{"GPM does not preview Secrets"}
+	}
+	if !namespaced {
+		obj.SetNamespace("")
+	} else if obj.GetNamespace() == "" {
+		obj.SetNamespace("default")
+	}
+	var client dynamic.ResourceInterface = clients.dynamic.Resource(gvr)
+	if namespaced {
+		client = clients.dynamic.Resource(gvr).Namespace(obj.GetNamespace())
+	}
+
+	dryRun := []string{metav1.DryRunAll}
+	result := mutationPreviewResult{}
+	var out *unstructured.Unstructured
+	if form.Source == "live" {
+		obj, err = client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return result, err
+		}
+		result.Operation = "update"
+		out, err = client.Update(ctx, obj.DeepCopy(), metav1.UpdateOptions{DryRun: dryRun})
+	} else {
+		result.Operation = "create"
+		out, err = client.Create(ctx, obj.DeepCopy(), metav1.CreateOptions{DryRun: dryRun})
+		if apierrors.IsAlreadyExists(err) {
+			var current *unstructured.Unstructured
+			current, err = client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return result, err
+			}
+			obj.SetResourceVersion(current.GetResourceVersion())
+			result.Operation = "update"
+			out, err = client.Update(ctx, obj.DeepCopy(), metav1.UpdateOptions{DryRun: dryRun})
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// Hidden as on the object page: the values under data and stringData are none of the preview's
+	// business, whatever the kind.
+	before, after := previewComparable(redactObject(obj.Object)), previewComparable(redactObject(out.Object))
+	result.Diff = diffText(toYAML(before), toYAML(after))
+	changes := changedPaths(before, after)
+
+	if value, ok := out.GetAnnotations()[mutationsAnnotation]; ok {
+		result.Annotated = true
+		result.Applied = parseMutationsAnnotation(value)
+	}
+	mutators, err := listGroup(ctx, clients, "mutations.gatekeeper.sh")
+	if err != nil {
+		slog.Warn("SSR mutation preview: listing the mutators failed, attribution may be incomplete", "error", err)
+	}
+	result.Changes = attributeChanges(changes, mutators, result.Applied, result.Annotated, after)
+	return result, nil
+}
+
+// previewObject reads the object out of the form: the manifest, or the live object's coordinates.
+func previewObject(form mutationPreviewForm) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{Object: map[string]any{}}
+	if form.Source == "live" {
+		if form.APIVersion == "" || form.Kind == "" || form.Name == "" {
+			return nil, previewInputError{"a live object needs an API version, a kind and a name"}
+		}
+		obj.SetAPIVersion(form.APIVersion)
+		obj.SetKind(form.Kind)
+		obj.SetNamespace(form.Namespace)
+		obj.SetName(form.Name)
+		return obj, nil
+	}
+
+	if strings.TrimSpace(form.Manifest) == "" {
+		return nil, previewInputError{"the manifest is empty"}
+	}
+	if err := yaml.Unmarshal([]byte(form.Manifest), &obj.Object); err != nil {
+		return nil, previewInputError{"the manifest is not valid YAML or JSON: " + err.Error()}
+	}
+	if obj.Object == nil || obj.GetAPIVersion() == "" || obj.GetKind() == "" {
+		return nil, previewInputError{"the manifest needs an apiVersion and a kind; only one object at a time can be previewed"}
+	}
+	if obj.GetName() == "" && obj.GetGenerateName() == "" {
+		return nil, previewInputError{"the manifest needs a metadata.name"}
+	}
+	return obj, nil
+}
+
+// previewComparable is the object without the fields the API server fills in on every write, which
+// would otherwise show as changes no mutator made.
+func previewComparable(obj map[string]any) map[string]any {
+	out := (&unstructured.Unstructured{Object: obj}).DeepCopy().Object
+	for _, field := range []string{"uid", "resourceVersion", "creationTimestamp", "generation", "managedFields", "selfLink"} {
+		unstructured.RemoveNestedField(out, "metadata", field)
+	}
+	delete(out, "status")
+	return out
+}
+
+// parseMutationsAnnotation reads Gatekeeper's list of the mutators that ran.
+func parseMutationsAnnotation(value string) []mutatorRef {
+	var refs []mutatorRef
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			entry = entry[:i]
+		}
+		parts := strings.Split(entry, "/")
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			continue
+		}
+		refs = append(refs, mutatorRef{Kind: parts[0], Name: parts[2]})
+	}
+	return refs
+}
+
+// --- Changed paths --------------------------------------------------------------------------
+
+// One step of a path through an object: a map key, a list element picked by its name (the way
+// Gatekeeper's locations pick containers), or a list element picked by position.
+type pathSegment struct {
+	Key   string
+	Named string // the element's name, for a list whose elements all have one
+	Index int    // -1 unless the element is picked by position
+}
+
+type pathChange struct {
+	Path          []pathSegment
+	Before, After any
+	Change        string
+}
+
+// changedPaths walks two versions of an object and returns the paths whose values differ, as deep
+// as the two still have the same shape. A list whose elements all carry a name is compared by name,
+// so a container inserted at the front does not read as every container changing.
+func changedPaths(before, after any) []pathChange {
+	var out []pathChange
+	walkChanges(nil, before, after, &out)
+	return out
+}
+
+func walkChanges(path []pathSegment, before, after any, out *[]pathChange) {
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	switch {
+	case before == nil:
+		*out = append(*out, pathChange{Path: path, After: after, Change: "added"})
+		return
+	case after == nil:
+		*out = append(*out, pathChange{Path: path, Before: before, Change: "removed"})
+		return
+	}
+
+	bm, bok := before.(map[string]any)
+	am, aok := after.(map[string]any)
+	if bok && aok {
+		keys := make([]string, 0, len(bm)+len(am))
+		for k := range bm {
+			keys = append(keys, k)
+		}
+		for k := range am {
+			if _, ok := bm[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkChanges(appendSegment(path, pathSegment{Key: k, Index: -1}), bm[k], am[k], out)
+		}
+		return
+	}
+
+	bl, bok := before.([]any)
+	al, aok := after.([]any)
+	if bok && aok {
+		if bn, an := elementNames(bl), elementNames(al); bn != nil && an != nil {
+			names := slices.Clone(bn)
+			for _, n := range an {
+				if !slices.Contains(bn, n) {
+					names = append(names, n)
+				}
+			}
+			for _, n := range names {
+				var b, a any
+				if i := slices.Index(bn, n); i >= 0 {
+					b = bl[i]
+				}
+				if i := slices.Index(an, n); i >= 0 {
+					a = al[i]
+				}
+				walkChanges(appendSegment(path, pathSegment{Named: n, Index: -1}), b, a, out)
+			}
+			return
+		}
+		for i := range max(len(bl), len(al)) {
+			var b, a any
+			if i < len(bl) {
+				b = bl[i]
+			}
+			if i < len(al) {
+				a = al[i]
+			}
+			walkChanges(appendSegment(path, pathSegment{Index: i}), b, a, out)
+		}
+		return
+	}
+
+	*out = append(*out, pathChange{Path: path, Before: before, After: after, Change: "changed"})
+}
+
+func appendSegment(path []pathSegment, s pathSegment) []pathSegment {
+	return append(slices.Clip(path), s)
+}
+
+// elementNames is the name of every element of a list, or nil when any element has none or two
+// share one.
+func elementNames(list []any) []string {
+	names := make([]string, 0, len(list))
+	for _, e := range list {
+		m, ok := e.(map[string]any)
+		if !ok {
+			return nil
+		}
+		name, ok := m["name"].(string)
+		if !ok || name == "" || slices.Contains(names, name) {
+			return nil
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// formatPath writes a path in Gatekeeper's location syntax: spec.containers[name:app].image. A key
+// that is not a plain word is quoted, as a location has to quote it.
+func formatPath(path []pathSegment) string {
+	var b strings.Builder
+	for _, s := range path {
+		switch {
+		case s.Named != "":
+			b.WriteString("[name:" + s.Named + "]")
+		case s.Index >= 0:
+			b.WriteString("[" + strconv.Itoa(s.Index) + "]")
+		default:
+			if b.Len() > 0 {
+				b.WriteString(".")
+			}
+			if strings.ContainsAny(s.Key, `."/[]: `) {
+				b.WriteString(strconv.Quote(s.Key))
+			} else {
+				b.WriteString(s.Key)
+			}
+		}
+	}
+	return b.String()
+}
+
+// --- Attribution ----------------------------------------------------------------------------
+
+// One step of a mutator's spec.location: a field, or a list element selected by key and value,
+// where the value may be the * glob.
+type locationSegment struct {
+	Field      string
+	ListKey    string
+	ListValue  string
+	isSelector bool
+}
+
+// parseLocation reads a Gatekeeper location such as spec.containers[name:*].image or
+// metadata.annotations."example.com/owner". It is forgiving: a location Gatekeeper itself would
+// reject simply matches nothing useful.
+func parseLocation(location string) []locationSegment {
+	var out []locationSegment
+	var field strings.Builder
+	flush := func() {
+		if field.Len() > 0 {
+			out = append(out, locationSegment{Field: field.String()})
+			field.Reset()
+		}
+	}
+	for i := 0; i < len(location); i++ {
+		switch ch := location[i]; ch {
+		case '"':
+			end := strings.IndexByte(location[i+1:], '"')
+			if end < 0 {
+				field.WriteString(location[i+1:])
+				i = len(location)
+				continue
+			}
+			field.WriteString(location[i+1 : i+1+end])
+			i += end + 1
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(location[i:], ']')
+			if end < 0 {
+				return out
+			}
+			key, value, _ := strings.Cut(location[i+1:i+end], ":")
+			out = append(out, locationSegment{ListKey: key, ListValue: strings.Trim(value, `"`), isSelector: true})
+			i += end
+		default:
+			field.WriteByte(ch)
+		}
+	}
+	flush()
+	return out
+}
+
+// locationCovers reports whether a mutator at location could have made a change at path: the path
+// lies under the location (the mutator set a value, and this is part of it), or the location lies
+// under the path (the mutator added something, and this is the element it was added to). result is
+// the mutated object, to check a [key:value] selector on a list element picked by position.
+func locationCovers(location []locationSegment, path []pathSegment, result any) bool {
+	node := result
+	for i := 0; i < len(location) && i < len(path); i++ {
+		l, p := location[i], path[i]
+		if l.isSelector {
+			if p.Key != "" && p.Index < 0 && p.Named == "" {
+				return false
+			}
+			if l.ListValue != "*" {
+				value := p.Named
+				if l.ListKey != "name" || value == "" {
+					value = listElementField(node, p, l.ListKey)
+				}
+				if value != l.ListValue {
+					return false
+				}
+			}
+		} else if p.Key != l.Field || p.Named != "" || p.Index >= 0 {
+			return false
+		}
+		node = step(node, p)
+	}
+	return true
+}
+
+// listElementField reads a field of the list element a path segment picks.
+func listElementField(list any, p pathSegment, key string) string {
+	element, _ := step(list, p).(map[string]any)
+	v, _ := element[key].(string)
+	return v
+}
+
+// step follows one path segment down an object, or returns nil where the object has nothing.
+func step(node any, p pathSegment) any {
+	switch n := node.(type) {
+	case map[string]any:
+		return n[p.Key]
+	case []any:
+		if p.Index >= 0 && p.Index < len(n) {
+			return n[p.Index]
+		}
+		for _, e := range n {
+			if m, ok := e.(map[string]any); ok && p.Named != "" && m["name"] == p.Named {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// attributeChanges puts a mutator on each change whose path its location covers. With Gatekeeper's
+// annotation, only the mutators it names are candidates; without it, every mutator in the cluster.
+func attributeChanges(changes []pathChange, mutators []map[string]any, applied []mutatorRef, annotated bool, result map[string]any) []mutationChange {
+	type candidate struct {
+		ref      mutatorRef
+		location []locationSegment
+	}
+	var candidates []candidate
+	for _, m := range mutators {
+		u := unstructured.Unstructured{Object: m}
+		ref := mutatorRef{Kind: u.GetKind(), Name: u.GetName()}
+		if annotated && !slices.Contains(applied, ref) {
+			continue
+		}
+		location, _, _ := unstructured.NestedString(m, "spec", "location")
+		if location == "" {
+			continue
+		}
+		candidates = append(candidates, candidate{ref: ref, location: parseLocation(location)})
+	}
+
+	out := make([]mutationChange, 0, len(changes))
+	for _, c := range changes {
+		mc := mutationChange{
+			Path:   formatPath(c.Path),
+			Change: c.Change,
+			Before: previewValue(c.Before),
+			After:  previewValue(c.After),
+		}
+		if len(c.Path) == 3 && c.Path[0].Key == "metadata" && c.Path[1].Key == "annotations" &&
+			slices.Contains(gatekeeperMutationAnnotations, c.Path[2].Key) {
+			mc.System = true
+			out = append(out, mc)
+			continue
+		}
+		for _, cand := range candidates {
+			if locationCovers(cand.location, c.Path, result) {
+				mc.Mutators = append(mc.Mutators, cand.ref)
+			}
+		}
+		out = append(out, mc)
+	}
+	return out
+}
+
+// previewValue is a changed value as the changes table shows it: a scalar as itself, anything
+// bigger as compact JSON.
+func previewValue(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case map[string]any, []any:
+		return string(toJSON(t))
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// --- Line diff ------------------------------------------------------------------------------
+
+// diffText is a unified diff of two texts, every line kept, prefixed "+ ", "- " or "  " so the diff
+// highlighter colours it. The edits are gotextdiff's Myers diff, one per run of changed lines.
+func diffText(before, after string) string {
+	a := strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(after, "\n"), "\n")
+	var out strings.Builder
+	if len(a) > previewDiffMaxLines || len(b) > previewDiffMaxLines {
+		for _, l := range a {
+			out.WriteString("- " + l + "\n")
+		}
+		for _, l := range b {
+			out.WriteString("+ " + l + "\n")
+		}
+		return out.String()
+	}
+
+	edits := myers.ComputeEdits(span.URIFromPath("before"), strings.Join(a, "\n")+"\n", strings.Join(b, "\n")+"\n")
+	i := 0
+	for _, e := range edits {
+		// The spans count lines from 1; a deletion spans the lines it removes, an insertion is empty.
+		from, to := e.Span.Start().Line()-1, e.Span.End().Line()-1
+		for ; i < from; i++ {
+			out.WriteString("  " + a[i] + "\n")
+		}
+		for ; i < to; i++ {
+			out.WriteString("- " + a[i] + "\n")
+		}
+		if e.NewText != "" {
+			for _, l := range strings.Split(strings.TrimSuffix(e.NewText, "\n"), "\n") {
+				out.WriteString("+ " + l + "\n")
+			}
+		}
+	}
+	for ; i < len(a); i++ {
+		out.WriteString("  " + a[i] + "\n")
+	}
+	return out.String()
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+)
+
+func TestDiffTextKeepsEveryLine(t *testing.T) {
+	got := diffText("a\nb\nc\n", "a\nB\nc\nd\n")
+	want := "  a\n- b\n+ B\n  c\n+ d\n"
+	if got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+	if got := diffText("same\n", "same\n"); got != "  same\n" {
+		t.Errorf("diff of equal texts = %q", got)
+	}
+	if got := diffText("x\na\n", "a\ny\n"); got != "- x\n  a\n+ y\n" {
+		t.Errorf("diff at both ends = %q", got)
+	}
+
+	// Past the limit the diff is one side removed and the other added, whatever they share.
+	long := strings.Repeat("line\n", previewDiffMaxLines+1)
+	if got := diffText(long, long); strings.Count(got, "- line\n") != previewDiffMaxLines+1 || strings.Contains(got, "  line") {
+		t.Error("a text past the limit was diffed line by line")
+	}
+}
+
+// A list whose elements carry a name is compared by name, and the path reads like a mutator's
+// location, so the two can be matched.
+func TestChangedPathsComparesNamedListsByName(t *testing.T) {
+	before := map[string]any{
+		"metadata": map[string]any{"name": "web"},
+		"spec": map[string]any{"containers": []any{
+			map[string]any{"name": "app", "image": "nginx"},
+		}},
+	}
+	after := map[string]any{
+		"metadata": map[string]any{"name": "web", "labels": map[string]any{"example.com/owner": "team-a"}},
+		"spec": map[string]any{"containers": []any{
+			map[string]any{"name": "sidecar", "image": "envoy"},
+			map[string]any{"name": "app", "image": "nginx", "imagePullPolicy": "Always"},
+		}},
+	}
+
+	var got []string
+	for _, c := range changedPaths(before, after) {
+		got = append(got, c.Change+" "+formatPath(c.Path))
+	}
+	want := []string{
+		`added metadata.labels`,
+		`added spec.containers[name:app].imagePullPolicy`,
+		`added spec.containers[name:sidecar]`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("changes = %q, want %q", got, want)
+	}
+	if got := formatPath([]pathSegment{{Key: "metadata", Index: -1}, {Key: "annotations", Index: -1}, {Key: "example.com/owner", Index: -1}}); got != `metadata.annotations."example.com/owner"` {
+		t.Errorf("formatPath = %s, want the key quoted", got)
+	}
+}
+
+func TestLocationCovers(t *testing.T) {
+	result := map[string]any{"spec": map[string]any{"containers": []any{
+		map[string]any{"name": "app", "imagePullPolicy": "Always"},
+	}}}
+	path := []pathSegment{{Key: "spec", Index: -1}, {Key: "containers", Index: -1}, {Named: "app", Index: -1}, {Key: "imagePullPolicy", Index: -1}}
+
+	for location, want := range map[string]bool{
+		"spec.containers[name:*].imagePullPolicy":   true,
+		"spec.containers[name:app].imagePullPolicy": true,
+		"spec.containers[name:db].imagePullPolicy":  false,
+		"spec.containers[name:app]":                 true, // the mutator set the whole element
+		"spec.containers[name:app].image":           false,
+		"spec.initContainers[name:*].image":         false,
+		`metadata.labels."example.com/owner"`:       false,
+	} {
+		if got := locationCovers(parseLocation(location), path, result); got != want {
+			t.Errorf("%s covers the path = %t, want %t", location, got, want)
+		}
+	}
+
+	if got := parseLocation(`metadata.annotations."example.com/owner"`); len(got) != 3 || got[2].Field != "example.com/owner" {
+		t.Errorf("quoted segment parsed as %+v", got)
+	}
+}
+
+func TestParseMutationsAnnotation(t *testing.T) {
+	got := parseMutationsAnnotation("Assign//always-pull:1, AssignMetadata//owner:2, garbage")
+	want := []mutatorRef{{"Assign", "always-pull"}, {"AssignMetadata", "owner"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("refs = %+v, want %+v", got, want)
+	}
+}
+
+// With Gatekeeper's annotation only the mutators that ran are candidates; without it every
+// mutator whose location covers the path is, and Gatekeeper's own annotations are its own.
+func TestAttributeChanges(t *testing.T) {
+	mutator := func(kind, name, location string) map[string]any {
+		return map[string]any{"kind": kind, "metadata": map[string]any{"name": name}, "spec": map[string]any{"location": location}}
+	}
+	mutators := []map[string]any{
+		mutator("Assign", "always-pull", "spec.containers[name:*].imagePullPolicy"),
+		mutator("Assign", "pull-if-missing", "spec.containers[name:*].imagePullPolicy"),
+		mutator("AssignMetadata", "owner", "metadata.labels.owner"),
+	}
+	changes := []pathChange{
+		{Path: []pathSegment{{Key: "spec", Index: -1}, {Key: "containers", Index: -1}, {Named: "app", Index: -1}, {Key: "imagePullPolicy", Index: -1}}, After: "Always", Change: "added"},
+		{Path: []pathSegment{{Key: "metadata", Index: -1}, {Key: "annotations", Index: -1}, {Key: mutationsAnnotation, Index: -1}}, After: "Assign//always-pull:1", Change: "added"},
+	}
+
+	unannotated := attributeChanges(changes, mutators, nil, false, nil)
+	if got := unannotated[0].Mutators; len(got) != 2 {
+		t.Errorf("without the annotation the change went to %+v, want both pull policy mutators", got)
+	}
+	if !unannotated[1].System || unannotated[1].Mutators != nil {
+		t.Errorf("the mutations annotation = %+v, want it attributed to Gatekeeper", unannotated[1])
+	}
+
+	annotated := attributeChanges(changes, mutators, []mutatorRef{{"Assign", "always-pull"}}, true, nil)
+	if got := annotated[0].Mutators; len(got) != 1 || got[0].Name != "always-pull" {
+		t.Errorf("with the annotation the change went to %+v, want always-pull alone", got)
+	}
+}
+
+func TestPreviewObjectNeedsAnObject(t *testing.T) {
+	for name, form := range map[string]mutationPreviewForm{
+		"empty manifest":   {Source: "manifest"},
+		"broken manifest":  {Source: "manifest", Manifest: "kind: [Pod"},
+		"no kind":          {Source: "manifest", Manifest: "apiVersion: v1\nmetadata:\n  name: x\n"},
+		"no name":          {Source: "manifest", Manifest: "apiVersion: v1\nkind: Pod\n"},
+		"live without one": {Source: "live", APIVersion: "v1", Kind: "Pod"},
+	} {
+		if _, err := previewObject(form); !errPreviewInput(err) {
+			t.Errorf("%s: err = %v, want an input error", name, err)
+		}
+	}
+}
+
+// previewAPI serves the core Pods and two mutators, and answers a Pod create with what the
+// mutating webhooks would have returned.
+func previewAPI(t *testing.T) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.serveGroup("mutations.gatekeeper.sh", "v1",
+		servedResource{Name: "assign", Kind: "Assign", Items: []string{
+			`{"kind":"Assign","metadata":{"name":"always-pull"},"spec":{"location":"spec.containers[name:*].imagePullPolicy"}}`,
+		}},
+		servedResource{Name: "assignmetadata", Kind: "AssignMetadata", Items: []string{
+			`{"kind":"AssignMetadata","metadata":{"name":"owner"},"spec":{"location":"metadata.labels.owner"}}`,
+		}},
+	)
+	api.serveGroup("", "v1",
+		servedResource{Name: "pods", Kind: "Pod", Namespaced: true},
+		servedResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+		servedResource{Name: "secrets", Kind: "Secret", Namespaced: true})
+	api.respondAt("/api/v1/namespaces/default/pods", `{"apiVersion":"v1","kind":"Pod",`+
+		`"metadata":{"name":"web","namespace":"default","uid":"u-1","creationTimestamp":"2026-03-01T10:00:00Z",`+
+		`"annotations":{"gatekeeper.sh/mutations":"Assign//always-pull:1"}},`+
+		`"spec":{"containers":[{"name":"app","image":"nginx","imagePullPolicy":"Always"}]},"status":{"phase":"Pending"}}`)
+	return api
+}
+
+const previewManifest = "apiVersion: v1\nkind: Pod\nmetadata:\n  name: web\nspec:\n  containers:\n  - name: app\n    image: nginx\n"
+
+func TestPreviewMutationDryRunsTheManifest(t *testing.T) {
+	api := previewAPI(t)
+	_, clients := discoveryTestClients(t, api)
+
+	result, err := previewMutation(context.Background(), clients, mutationPreviewForm{Source: "manifest", Manifest: previewManifest})
+	if err != nil {
+		t.Fatalf("the preview failed: %v", err)
+	}
+	if result.Operation != "create" || !result.Annotated {
+		t.Errorf("result = %+v, want an annotated dry-run create", result)
+	}
+	// The uid, the timestamp and the status are the API server's, not a mutator's.
+	if len(result.Changes) != 2 {
+		t.Fatalf("changes = %+v, want the annotation and the pull policy", result.Changes)
+	}
+	pull := result.Changes[1]
+	if pull.Path != "spec.containers[name:app].imagePullPolicy" || pull.After != "Always" ||
+		len(pull.Mutators) != 1 || pull.Mutators[0].Name != "always-pull" {
+		t.Errorf("pull policy change = %+v", pull)
+	}
+	if !strings.Contains(result.Diff, "+     imagePullPolicy: Always\n") || strings.Contains(result.Diff, "uid") {
+		t.Errorf("diff =\n%s", result.Diff)
+	}
+}
+
+// The preview shows the object to anyone who can open it, so it reads no Secret and hides the
+// values under data.
+func TestPreviewMutationHidesSecrets(t *testing.T) {
+	api := previewAPI(t)
+	api.respondAt("/api/v1/namespaces/default/configmaps", `{"apiVersion":"v1","kind":"ConfigMap",`+
+		`"metadata":{"name":"settings","namespace":"default","labels":{"owner":"me"}},"data":{"password":"hunter2"}}`)
+	_, clients := discoveryTestClients(t, api)
+
+	_, err := previewMutation(context.Background(), clients, mutationPreviewForm{Source: "live", APIVersion: "v1", Kind: "Secret", Namespace: "default", Name: "token"})
+	var input previewInputError
+	if !errors.As(err, &input) {
+		t.Errorf("previewing a Secret = %v, want it refused", err)
+	}
+	for _, path := range api.requested() {
+		if strings.Contains(path, "/secrets") {
+			t.Errorf("the preview read %s", path)
+		}
+	}
+
+	manifest := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  password: hunter2\n"
+	result, err := previewMutation(context.Background(), clients, mutationPreviewForm{Source: "manifest", Manifest: manifest})
+	if err != nil {
+		t.Fatalf("the preview failed: %v", err)
+	}
+	if strings.Contains(result.Diff, "hunter2") || !strings.Contains(result.Diff, "+     owner: me") {
+		t.Errorf("diff =\n%s", result.Diff)
+	}
+}
+
+// The preview sends writes, even if dry ones, so it is off unless asked for and its form carries a
+// CSRF token the POST has to return.
+func TestMutationPreviewRoutes(t *testing.T) {
+	api := previewAPI(t)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+	e := echo.New()
+	registerViews(e, s)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if out := get("/mutation-preview").Body.String(); !strings.Contains(out, "Mutation preview is disabled") {
+		t.Error("the disabled preview rendered a form")
+	}
+	if strings.Contains(get("/mutations").Body.String(), "/mutation-preview") {
+		t.Error("the Mutations view linked to a disabled preview")
+	}
+
+	viper.Set("mutation_preview", true)
+	if !strings.Contains(get("/mutations/fake").Body.String(), `href="/mutation-preview/fake"`) {
+		t.Error("the Mutations view did not link to the preview")
+	}
+	page := get("/mutation-preview/fake")
+	token := regexp.MustCompile(`name="_csrf" value="([^"]+)"`).FindStringSubmatch(page.Body.String())
+	if token == nil {
+		t.Fatal("the form carries no CSRF token")
+	}
+
+	post := func(form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mutation-preview/fake", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	form := url.Values{"source": {"manifest"}, "manifest": {previewManifest}}
+	if rec := post(form, nil); rec.Code == http.StatusOK {
+		t.Error("a POST without the CSRF token ran the preview")
+	}
+
+	form.Set("_csrf", token[1])
+	rec := post(form, page.Result().Cookies())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{"spec.containers[name:app].imagePullPolicy", `href="/mutations/fake#always-pull"`, "dry run"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("preview output missing %q", want)
+		}
+	}
+}
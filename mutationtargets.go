@@ -0,0 +1,355 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The targeting on the Mutations view: the kinds and namespaces each mutator's applyTo and match
+// select in this cluster, the applyTo kinds the cluster does not serve, and the mutators that set
+// the same location on the same objects.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// The most namespaces a card lists by name. Past it the card gives the count, as a mutator for
+// every namespace but one is better read as that.
+const targetNamespacesShown = 24
+
+// The processes a Config exclusion has to name to keep the mutation webhook out of a namespace.
+var mutationProcesses = []string{"*", "mutation-webhook"}
+
+// One kind a mutator applies to.
+type targetKind struct {
+	APIVersion string // group/version from applyTo, or the group alone from match.kinds
+	Kind       string
+	Unserved   bool // applyTo names it and the cluster does not serve it
+	namespaced *bool
+}
+
+// Another mutator that sets the same location on some of the same objects.
+type mutatorOverlap struct {
+	Ref      mutatorRef
+	Location string
+}
+
+// mutatorTarget is what one mutator applies to, as the card renders it.
+type mutatorTarget struct {
+	Location string
+	Invalid  string // the match or applyTo did not have Gatekeeper's shape
+
+	Kinds   []targetKind
+	AnyKind bool
+	NoKind  bool // applyTo names kinds and match.kinds excludes every one of them
+
+	Namespaced        bool // objects in a namespace can match
+	ClusterScoped     bool // objects without a namespace can match
+	Namespaces        []string
+	NamespaceCount    int
+	MoreNamespaces    int // in scope past the ones listed
+	AllNamespaces     bool
+	ExcludedByConfig  []string // namespaces the match selects that the Config keeps the webhook out of
+	NamespacesUnknown bool     // GPM could not list the namespaces
+
+	LabelSelector string
+	Name          string
+
+	Overlaps []mutatorOverlap
+
+	kinds      []matchKinds // what overlap detection intersects: group/kind pairs, * for any
+	namespaces []string
+	location   []locationSegment
+}
+
+// Unserved reports whether any applyTo kind is one the cluster does not serve.
+func (t mutatorTarget) Unserved() bool {
+	return slices.ContainsFunc(t.Kinds, func(k targetKind) bool { return k.Unserved })
+}
+
+// Warnings counts what the card flags, for the summary at the top of the view.
+func (t mutatorTarget) Warnings() int {
+	n := len(t.Overlaps)
+	if t.Unserved() || t.NoKind || t.Invalid != "" {
+		n++
+	}
+	return n
+}
+
+// targetKey is how the view finds a mutator's targeting: names are only unique within a kind.
+func targetKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// mutatorTargets evaluates every mutator against the cluster. Reading the namespaces or the
+// Config failing is logged and degrades the answer rather than failing the view.
+func mutatorTargets(ctx context.Context, clients *kubeClients, mutators []map[string]any) map[string]mutatorTarget {
+	namespaces, err := listNamespaces(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR mutations: listing namespaces failed, the targeting leaves them out", "error", err)
+	}
+	excluded := configExclusions(ctx, clients, mutationProcesses)
+	served := servedKinds{client: clients.discovery, lists: map[string]*metav1.APIResourceList{}}
+
+	out := make(map[string]mutatorTarget, len(mutators))
+	var keys []string
+	for _, m := range mutators {
+		u := unstructured.Unstructured{Object: m}
+		t := evaluateMutator(m, namespaces, err != nil, excluded, &served)
+		key := targetKey(u.GetKind(), u.GetName())
+		out[key] = t
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+	for i, a := range keys {
+		for _, b := range keys[i+1:] {
+			ta, tb := out[a], out[b]
+			if !targetsOverlap(ta, tb) {
+				continue
+			}
+			kindA, nameA, _ := strings.Cut(a, "/")
+			kindB, nameB, _ := strings.Cut(b, "/")
+			ta.Overlaps = append(ta.Overlaps, mutatorOverlap{Ref: mutatorRef{Kind: kindB, Name: nameB}, Location: tb.Location})
+			tb.Overlaps = append(tb.Overlaps, mutatorOverlap{Ref: mutatorRef{Kind: kindA, Name: nameA}, Location: ta.Location})
+			out[a], out[b] = ta, tb
+		}
+	}
+	return out
+}
+
+// evaluateMutator works out one mutator's targets. AssignMetadata has no applyTo: it applies to
+// whatever match.kinds selects.
+func evaluateMutator(obj map[string]any, namespaces []clusterNamespace, namespacesUnknown bool, excluded []string, served *servedKinds) mutatorTarget {
+	t := mutatorTarget{NamespacesUnknown: namespacesUnknown}
+	t.Location, _, _ = unstructured.NestedString(obj, "spec", "location")
+	t.location = parseLocation(t.Location)
+
+	match, err := parseMatch(obj, "spec", "match")
+	if err != nil {
+		t.Invalid = "spec.match: " + err.Error()
+	}
+	var spec struct {
+		ApplyTo []struct {
+			Groups   []string `json:"groups"`
+			Versions []string `json:"versions"`
+			Kinds    []string `json:"kinds"`
+		} `json:"applyTo"`
+	}
+	if raw, ok, _ := unstructured.NestedMap(obj, "spec"); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec); err != nil && t.Invalid == "" {
+			t.Invalid = "spec.applyTo: " + err.Error()
+		}
+	}
+
+	if len(spec.ApplyTo) == 0 {
+		t.AnyKind = match.anyKind()
+		if t.AnyKind {
+			t.kinds = []matchKinds{{APIGroups: []string{"*"}, Kinds: []string{"*"}}}
+		} else {
+			// An empty list in a match.kinds entry means any, as it does to Gatekeeper.
+			for _, k := range match.Kinds {
+				if len(k.APIGroups) == 0 {
+					k.APIGroups = []string{"*"}
+				}
+				if len(k.Kinds) == 0 {
+					k.Kinds = []string{"*"}
+				}
+				t.kinds = append(t.kinds, k)
+				for _, g := range k.APIGroups {
+					for _, kind := range k.Kinds {
+						t.Kinds = append(t.Kinds, targetKind{APIVersion: g, Kind: kind})
+					}
+				}
+			}
+		}
+	} else {
+		for _, a := range spec.ApplyTo {
+			for _, g := range a.Groups {
+				for _, kind := range a.Kinds {
+					if !match.matchesKind(g, kind) {
+						continue
+					}
+					t.kinds = append(t.kinds, matchKinds{APIGroups: []string{g}, Kinds: []string{kind}})
+					for _, v := range a.Versions {
+						gv := schema.GroupVersion{Group: g, Version: v}.String()
+						k := targetKind{APIVersion: gv, Kind: kind}
+						k.namespaced, k.Unserved = served.lookup(gv, kind)
+						t.Kinds = append(t.Kinds, k)
+					}
+				}
+			}
+		}
+		t.NoKind = len(t.kinds) == 0
+	}
+
+	// Without applyTo, or with a kind discovery could not place, either scope may hold a match.
+	t.Namespaced, t.ClusterScoped = len(spec.ApplyTo) == 0, len(spec.ApplyTo) == 0
+	for _, k := range t.Kinds {
+		if k.namespaced == nil {
+			t.Namespaced, t.ClusterScoped = true, true
+		} else if *k.namespaced {
+			t.Namespaced = true
+		} else {
+			t.ClusterScoped = true
+		}
+	}
+	t.Namespaced = t.Namespaced && match.Scope != "Cluster"
+	t.ClusterScoped = t.ClusterScoped && match.matchesClusterScoped()
+
+	if t.Namespaced && !namespacesUnknown {
+		for _, ns := range namespaces {
+			if !match.matchesNamespace(ns) {
+				continue
+			}
+			if slices.ContainsFunc(excluded, func(p string) bool { return globMatch(p, ns.Name) }) {
+				t.ExcludedByConfig = append(t.ExcludedByConfig, ns.Name)
+				continue
+			}
+			t.namespaces = append(t.namespaces, ns.Name)
+		}
+		t.NamespaceCount = len(t.namespaces)
+		t.AllNamespaces = t.NamespaceCount > 0 && t.NamespaceCount+len(t.ExcludedByConfig) == len(namespaces)
+		t.Namespaces = t.namespaces[:min(len(t.namespaces), targetNamespacesShown)]
+		t.MoreNamespaces = t.NamespaceCount - len(t.Namespaces)
+	}
+
+	if match.LabelSelector != nil {
+		if selector, err := metav1.LabelSelectorAsSelector(match.LabelSelector); err == nil {
+			t.LabelSelector = selector.String()
+		}
+	}
+	t.Name = match.Name
+	return t
+}
+
+// targetsOverlap reports whether two mutators can set the same field of the same object. Mutators
+// that only append to a list, or that set the same field to the same value, overlap too: the
+// order still decides what the object ends up with.
+func targetsOverlap(a, b mutatorTarget) bool {
+	if a.Location == "" || !locationsOverlap(a.location, b.location) {
+		return false
+	}
+	if !kindsOverlap(a.kinds, b.kinds) {
+		return false
+	}
+	if a.ClusterScoped && b.ClusterScoped {
+		return true
+	}
+	if !a.Namespaced || !b.Namespaced {
+		return false
+	}
+	if a.NamespacesUnknown {
+		return true
+	}
+	return slices.ContainsFunc(a.namespaces, func(ns string) bool { return slices.Contains(b.namespaces, ns) })
+}
+
+// locationsOverlap reports whether two locations can name the same field: the same path, where a
+// [name:*] element also stands for any element the other names.
+func locationsOverlap(a, b []locationSegment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if x.isSelector != y.isSelector {
+			return false
+		}
+		if !x.isSelector && x.Field != y.Field {
+			return false
+		}
+		if x.isSelector && (x.ListKey != y.ListKey || x.ListValue != y.ListValue && x.ListValue != "*" && y.ListValue != "*") {
+			return false
+		}
+	}
+	return true
+}
+
+// kindsOverlap reports whether two sets of group/kind pairs share one, * matching anything.
+func kindsOverlap(a, b []matchKinds) bool {
+	wild := func(x, y string) bool { return x == "*" || y == "*" || x == y }
+	for _, x := range a {
+		for _, y := range b {
+			for _, gx := range x.APIGroups {
+				for _, gy := range y.APIGroups {
+					if !wild(gx, gy) {
+						continue
+					}
+					for _, kx := range x.Kinds {
+						if slices.ContainsFunc(y.Kinds, func(ky string) bool { return wild(kx, ky) }) {
+							return true
+						}
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// configExclusions reads the namespaces Gatekeeper's Config keeps one of the processes out of, the
+// mutation webhook's for the Mutations view and the audit's for the compliance score.
+func configExclusions(ctx context.Context, clients *kubeClients, processes []string) []string {
+	configs, err := getCustomResources(ctx, *clients.dynamic, "config.gatekeeper.sh", "v1alpha1", "configs")
+	if err != nil {
+		slog.Warn("reading the Gatekeeper Config failed, its exclusions are left out", "error", err)
+		return nil
+	}
+	var out []string
+	for _, c := range configs.Items {
+		entries, _, _ := unstructured.NestedSlice(c.Object, "spec", "match")
+		for _, e := range entries {
+			entry, _ := e.(map[string]any)
+			named, _, _ := unstructured.NestedStringSlice(entry, "processes")
+			if !slices.ContainsFunc(named, func(p string) bool { return slices.Contains(processes, p) }) {
+				continue
+			}
+			namespaces, _, _ := unstructured.NestedStringSlice(entry, "excludedNamespaces")
+			out = append(out, namespaces...)
+		}
+	}
+	return out
+}
+
+// servedKinds remembers the resources of each group version discovery was asked about, so a view
+// of many mutators asks once per group version.
+type servedKinds struct {
+	client discovery.DiscoveryInterface
+	lists  map[string]*metav1.APIResourceList // nil for a group version the cluster does not serve
+}
+
+// lookup says whether the cluster serves a kind, and whether it is namespaced. A discovery error
+// other than "not served" leaves both unknown rather than flag a kind that may well be there.
+func (s *servedKinds) lookup(groupVersion, kind string) (namespaced *bool, unserved bool) {
+	list, ok := s.lists[groupVersion]
+	if !ok {
+		var err error
+		list, err = s.client.ServerResourcesForGroupVersion(groupVersion)
+		if err != nil && !apierrors.IsNotFound(err) {
+			slog.Warn("SSR mutations: discovering a group version failed", "groupVersion", groupVersion, "error", err)
+			return nil, false
+		}
+		if err != nil {
+			list = nil
+		}
+		s.lists[groupVersion] = list
+	}
+	if list == nil {
+		return nil, true
+	}
+	for _, r := range list.APIResources {
+		if r.Kind == kind && !strings.Contains(r.Name, "/") {
+			return &r.Namespaced, false
+		}
+	}
+	return nil, true
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// targetsAPI serves Pods and Namespaces, three namespaces, a Config that keeps the mutation webhook
+// out of kube-system, and the given mutators.
+func targetsAPI(t *testing.T, assigns ...string) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.serveGroup("mutations.gatekeeper.sh", "v1",
+		servedResource{Name: "assign", Kind: "Assign", Items: assigns},
+		servedResource{Name: "assignmetadata", Kind: "AssignMetadata", Items: []string{
+			`{"kind":"AssignMetadata","metadata":{"name":"owner"},"spec":{"location":"metadata.labels.owner"}}`,
+		}},
+	)
+	api.serveGroup("config.gatekeeper.sh", "v1alpha1", servedResource{Name: "configs", Kind: "Config", Namespaced: true, Items: []string{
+		`{"kind":"Config","metadata":{"name":"config"},"spec":{"match":[{"excludedNamespaces":["kube-*"],"processes":["*"]}]}}`,
+	}})
+	api.serveGroup("", "v1",
+		servedResource{Name: "pods", Kind: "Pod", Namespaced: true},
+		servedResource{Name: "namespaces", Kind: "Namespace", Items: []string{
+			`{"metadata":{"name":"apps","labels":{"team":"a"}}}`, `{"metadata":{"name":"ci"}}`, `{"metadata":{"name":"kube-system"}}`,
+		}})
+	// apps/v1 is served, without Deployments: a cluster that lacks the kind applyTo names.
+	api.serveGroup("apps", "v1", servedResource{Name: "replicasets", Kind: "ReplicaSet", Namespaced: true})
+	return api
+}
+
+const (
+	alwaysPull = `{"kind":"Assign","metadata":{"name":"always-pull"},"spec":{` +
+		`"applyTo":[{"groups":[""],"versions":["v1"],"kinds":["Pod"]}],` +
+		`"location":"spec.containers[name:*].imagePullPolicy"}}`
+	teamPull = `{"kind":"Assign","metadata":{"name":"team-pull"},"spec":{` +
+		`"applyTo":[{"groups":[""],"versions":["v1"],"kinds":["Pod"]}],` +
+		`"match":{"namespaceSelector":{"matchLabels":{"team":"a"}}},` +
+		`"location":"spec.containers[name:app].imagePullPolicy"}}`
+	ciOnly = `{"kind":"Assign","metadata":{"name":"ci-pull"},"spec":{` +
+		`"applyTo":[{"groups":[""],"versions":["v1"],"kinds":["Pod"]}],` +
+		`"match":{"namespaces":["ci"]},` +
+		`"location":"spec.containers[name:app].imagePullPolicy"}}`
+	deployments = `{"kind":"Assign","metadata":{"name":"replicas"},"spec":{` +
+		`"applyTo":[{"groups":["apps"],"versions":["v1"],"kinds":["Deployment"]}],` +
+		`"match":{"kinds":[{"apiGroups":["batch"],"kinds":["Job"]}]},` +
+		`"location":"spec.replicas"}}`
+)
+
+func TestMutatorTargets(t *testing.T) {
+	api := targetsAPI(t, alwaysPull, teamPull, ciOnly, deployments)
+	_, clients := discoveryTestClients(t, api)
+	items, err := listGroup(context.Background(), clients, "mutations.gatekeeper.sh")
+	if err != nil {
+		t.Fatalf("listing the mutators failed: %v", err)
+	}
+
+	targets := mutatorTargets(context.Background(), clients, items)
+
+	always := targets["Assign/always-pull"]
+	if !always.AllNamespaces || always.NamespaceCount != 2 || strings.Join(always.ExcludedByConfig, ",") != "kube-system" {
+		t.Errorf("always-pull namespaces = %+v, want apps and ci with kube-system left out by the Config", always)
+	}
+	if always.ClusterScoped || len(always.Kinds) != 1 || always.Kinds[0].Unserved {
+		t.Errorf("always-pull kinds = %+v, want the served, namespaced v1 Pod", always.Kinds)
+	}
+	// [name:*] covers [name:app], and the selector and the namespace list each share a namespace
+	// with always-pull but none with each other.
+	if got := overlapNames(always); got != "ci-pull,team-pull" {
+		t.Errorf("always-pull overlaps %q, want ci-pull and team-pull", got)
+	}
+	if got := overlapNames(targets["Assign/team-pull"]); got != "always-pull" {
+		t.Errorf("team-pull overlaps %q, want always-pull alone", got)
+	}
+
+	if r := targets["Assign/replicas"]; !r.NoKind || r.Warnings() != 1 {
+		t.Errorf("replicas = %+v, want it flagged for selecting no kind", r)
+	}
+	owner := targets["AssignMetadata/owner"]
+	if !owner.AnyKind || !owner.ClusterScoped || owner.Overlaps != nil {
+		t.Errorf("owner = %+v, want every kind, cluster-scoped objects included, and no overlap", owner)
+	}
+}
+
+func TestMutatorTargetsFlagsUnservedKinds(t *testing.T) {
+	api := targetsAPI(t, strings.Replace(deployments, `"match":{"kinds":[{"apiGroups":["batch"],"kinds":["Job"]}]},`, "", 1))
+	_, clients := discoveryTestClients(t, api)
+	items, _ := listGroup(context.Background(), clients, "mutations.gatekeeper.sh")
+
+	r := mutatorTargets(context.Background(), clients, items)["Assign/replicas"]
+	if !r.Unserved() || r.Kinds[0].APIVersion != "apps/v1" {
+		t.Errorf("replicas kinds = %+v, want apps/v1 Deployment flagged as not served", r.Kinds)
+	}
+}
+
+func overlapNames(t mutatorTarget) string {
+	var names []string
+	for _, o := range t.Overlaps {
+		names = append(names, o.Ref.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestMutationsViewRendersTheTargets(t *testing.T) {
+	api := targetsAPI(t, alwaysPull, ciOnly)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getMutations(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/mutations", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"2 targeting issues need a look", "<code>v1 Pod</code>", "Every namespace (2)",
+		"Left out by the Gatekeeper Config: kube-system", `<a href="#ci-pull">ci-pull</a>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("mutations output missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The namespace page. A namespace in the Resources view is a heading; this page is what to hand
+// to the team that owns it: who they are, from the namespace's own labels, what is broken inside
+// it, which Constraints apply and which exemptions it has, and what the webhook turned down there
+// lately. It prints, so it can be attached to a ticket as it is.
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The annotation kubectl apply keeps its copy of the object in. It is the whole namespace again,
+// so the page leaves it out.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// ssrOwnerField is one of the namespace's owner or contact fields.
+type ssrOwnerField struct {
+	Key   string
+	Value string
+}
+
+// namespaceURL is the namespace page of a namespace, under a context.
+func namespaceURL(kubeContext, name string) string {
+	return viewURL(kubeContext, "/namespace", "") + "?" + url.Values{"name": {name}}.Encode()
+}
+
+// namespaceOwners reads the owner fields of a namespace, in the order the setting lists the keys.
+// A key is looked up in the labels first and then in the annotations, where free text such as an
+// email address has to go.
+func namespaceOwners(keys []string, labels, annotations map[string]string) []ssrOwnerField {
+	var out []ssrOwnerField
+	for _, k := range keys {
+		if v, ok := labels[k]; ok {
+			out = append(out, ssrOwnerField{Key: k, Value: v})
+		} else if v, ok := annotations[k]; ok {
+			out = append(out, ssrOwnerField{Key: k, Value: v})
+		}
+	}
+	return out
+}
+
+// namespaceConstraints are the Constraints whose match applies to the objects of a namespace. One
+// that excludes it is not among them: it is on the page as an exemption.
+func namespaceConstraints(constraints []map[string]any, ns clusterNamespace) []ssrConstraint {
+	var out []ssrConstraint
+	for _, o := range constraints {
+		m, err := parseMatch(o, "spec", "match")
+		if err != nil || !m.matchesNamespace(ns) {
+			continue
+		}
+		out = append(out, ssrConstraintModel(o))
+	}
+	return out
+}
+
+// inNamespace is the constraints with only the violations in a namespace.
+func inNamespace(constraints []ssrConstraint, name string) []ssrConstraint {
+	out := make([]ssrConstraint, 0, len(constraints))
+	for _, c := range constraints {
+		kept := c
+		kept.Violations = nil
+		for _, v := range c.Violations {
+			if v.Namespace == name {
+				kept.Violations = append(kept.Violations, v)
+			}
+		}
+		out = append(out, kept)
+	}
+	return out
+}
+
+// getNamespace renders the namespace page for the name in the query.
+func (s *server) getNamespace(c echo.Context) error {
+	name := c.QueryParam("name")
+	// The context switcher lands on the other cluster's Resources view, as from the object page.
+	layout := s.ssrLayoutData(c, "resources", "/resources", "Namespace "+name)
+
+	data := map[string]any{
+		"Layout":            layout,
+		"Name":              name,
+		"ResourcesURL":      contextPath(c, "/resources"),
+		"ConstraintsURL":    contextPath(c, "/constraints"),
+		"ConfigurationsURL": contextPath(c, "/configurations"),
+	}
+
+	if name == "" {
+		setViewError(data, "The page needs a namespace name. Open one from the Resources view.",
+			errors.New("the name query parameter is missing"))
+		return s.ssr.render(c, "namespace", data)
+	}
+
+	clients, err := s.clientsFor(c)
+	if err != nil {
+		slog.Error("SSR namespace: resolving context failed", "error", err)
+		setViewError(data, "GPM could not switch to the requested Kubernetes context. Make sure the kubeconfig defines it correctly.", err)
+		return s.ssr.render(c, "namespace", data)
+	}
+
+	ctx := c.Request().Context()
+	nsObj, err := clients.dynamic.Resource(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		slog.Error("SSR namespace: getting the namespace failed", "namespace", name, "error", err)
+		message := "GPM could not get the namespace from the Kubernetes API. Make sure the API is reachable and GPM can get namespaces."
+		if apierrors.IsNotFound(err) {
+			message = "The cluster has no namespace named " + name + "."
+		}
+		setViewError(data, message, err)
+		return s.ssr.render(c, "namespace", data)
+	}
+	ns := clusterNamespace{Name: name, Labels: nsObj.GetLabels()}
+	annotations := nsObj.GetAnnotations()
+	delete(annotations, lastAppliedAnnotation)
+	data["Labels"] = ns.Labels
+	data["Annotations"] = annotations
+	data["Owners"] = namespaceOwners(settingList("namespace_owner_keys"), ns.Labels, annotations)
+
+	// Without the Constraints the page still says who owns the namespace and what the Config
+	// exempts it from, so a failure costs the rest and a warning says so.
+	raw, err := listConstraints(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR namespace: reading constraints failed, violations and Constraints are left out", "error", err)
+		data["ConstraintsMissing"] = true
+	}
+	sortConstraints(raw)
+	models := make([]ssrConstraint, 0, len(raw))
+	audited, limited := false, false
+	for _, o := range raw {
+		m := ssrConstraintModel(o)
+		audited = audited || m.ViolationsKnown
+		limited = limited || m.AuditLimited
+		models = append(models, m)
+	}
+	models = inNamespace(models, name)
+
+	// Grouped by owning workload, as the Resources view does by default.
+	var refs []objectRef
+	for _, m := range models {
+		for _, v := range m.Violations {
+			refs = append(refs, objectRef{Group: v.Group, Kind: v.Kind, Namespace: v.Namespace, Name: v.Name})
+		}
+	}
+	owners, err := resolveOwners(ctx, clients, refs)
+	if err != nil {
+		slog.Warn("SSR namespace: resolving owners failed, those objects keep their own rows", "error", err)
+		data["OwnersIncomplete"] = true
+	}
+	if buckets := resourceModel(models, owners); len(buckets) > 0 {
+		bucket := buckets[0]
+		for i := range bucket.Resources {
+			r := &bucket.Resources[i]
+			r.URL = objectURL(c.Param("context"), objectRef{Group: r.Group, Kind: r.Kind, Namespace: name, Name: r.Name})
+		}
+		data["Violations"] = bucket
+	}
+	data["Audited"] = audited
+	data["AuditLimited"] = limited
+	data["Constraints"] = namespaceConstraints(raw, ns)
+
+	// The exemptions, as the Exemptions view merges them, for this one namespace. A Config that
+	// cannot be read costs its part only.
+	var configs []map[string]any
+	configList, err := getCustomResources(ctx, *clients.dynamic, "config.gatekeeper.sh", "v1alpha1", "configs")
+	if err != nil {
+		slog.Warn("SSR namespace: getting config resources failed, their exemptions are left out", "error", err)
+		data["ConfigsMissing"] = true
+	} else {
+		for i := range configList.Items {
+			configs = append(configs, configList.Items[i].Object)
+		}
+	}
+	if exempt, _ := exemptionMap([]clusterNamespace{ns}, configs, raw); len(exempt) > 0 {
+		data["Exemptions"] = exempt[0].Exemptions
+	}
+
+	// The webhook's recent denials in the namespace, as the Events view reads them. The audit's
+	// events say "deny" too, about objects already in the cluster; those are the violations above.
+	sources := slices.DeleteFunc(settingList("events_source"), func(s string) bool { return s == "gatekeeper-audit" })
+	query := eventQuery{
+		Sources:           sources,
+		Action:            "deny",
+		ResourceNamespace: name,
+		Now:               time.Now(),
+		PageSize:          eventPageSizes[len(eventPageSizes)-1],
+	}
+	page, err := getKubernetesEvents(ctx, *clients.dynamic, eventsResource(clients.discovery), settingList("events_namespace"), query)
+	if err != nil {
+		slog.Warn("SSR namespace: reading events failed, they are left out", "error", err)
+		data["EventsMissing"] = true
+	}
+	data["Denials"] = page.Events
+	data["EventsURL"] = contextPath(c, "/events") + "?" + url.Values{"action": {"deny"}, "resource_namespace": {name}}.Encode()
+
+	return s.ssr.render(c, "namespace", data)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestNamespaceOwners(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod"}
+	annotations := map[string]string{"contact": "payments@example.com", "team": "ignored, the label wins"}
+	got := namespaceOwners([]string{"owner", "team", "contact"}, labels, annotations)
+	if len(got) != 2 || got[0] != (ssrOwnerField{"team", "payments"}) || got[1] != (ssrOwnerField{"contact", "payments@example.com"}) {
+		t.Errorf("owners = %+v", got)
+	}
+}
+
+func TestNamespaceConstraints(t *testing.T) {
+	constraint := func(name string, match map[string]any) map[string]any {
+		return map[string]any{"kind": "K8sRequiredLabels", "metadata": map[string]any{"name": name}, "spec": map[string]any{"match": match}}
+	}
+	ns := clusterNamespace{Name: "payments", Labels: map[string]string{"env": "prod"}}
+	got := namespaceConstraints([]map[string]any{
+		constraint("everywhere", nil),
+		constraint("prod-only", map[string]any{"namespaceSelector": map[string]any{"matchLabels": map[string]any{"env": "prod"}}}),
+		constraint("dev-only", map[string]any{"namespaceSelector": map[string]any{"matchLabels": map[string]any{"env": "dev"}}}),
+		constraint("excludes-it", map[string]any{"excludedNamespaces": []any{"pay*"}}),
+		constraint("cluster-scoped", map[string]any{"scope": "Cluster"}),
+	}, ns)
+	var names []string
+	for _, c := range got {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "everywhere,prod-only" {
+		t.Errorf("constraints that apply = %v", names)
+	}
+}
+
+// The page to hand to a team: its owner from the labels, its violations, the Constraints that
+// apply, the Config exemption and the webhook's denial, and nothing from another namespace.
+func TestNamespacePage(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.respondAt("/api/v1/namespaces/payments", `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"payments",`+
+		`"labels":{"team":"payments","env":"prod"},`+
+		`"annotations":{"contact":"payments@example.com","kubectl.kubernetes.io/last-applied-configuration":"{}"}}}`)
+	api.serveConstraints("K8sRequiredLabels",
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"must-have-owner"},"spec":{"enforcementAction":"deny"},`+
+			`"status":{"totalViolations":2,"violations":[`+
+			`{"enforcementAction":"deny","group":"","version":"v1","kind":"ConfigMap","namespace":"payments","name":"settings","message":"missing owner"},`+
+			`{"enforcementAction":"deny","group":"","version":"v1","kind":"ConfigMap","namespace":"shop","name":"elsewhere","message":"not this one"}]}}`,
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"dev-labels"},"spec":{"match":{"namespaceSelector":{"matchLabels":{"env":"dev"}}}}}`)
+	api.respondAt("/apis/config.gatekeeper.sh/v1alpha1/configs", `{"apiVersion":"config.gatekeeper.sh/v1alpha1","kind":"ConfigList",`+
+		`"metadata":{},"items":[{"kind":"Config","metadata":{"name":"config","namespace":"gatekeeper-system"},`+
+		`"spec":{"match":[{"excludedNamespaces":["pay*"],"processes":["audit"]}]}}]}`)
+	api.respondAt("/api/v1/events", `{"apiVersion":"v1","kind":"EventList","metadata":{},"items":[`+
+		`{"metadata":{"name":"ev-1","annotations":{"constraint_action":"deny","constraint_kind":"K8sRequiredLabels",`+
+		`"constraint_name":"must-have-owner","resource_kind":"Deployment","resource_namespace":"payments","resource_name":"api",`+
+		`"request_username":"alice"}},"reason":"FailedAdmission","message":"Admission webhook denied the request",`+
+		`"lastTimestamp":"2026-03-01T10:00:00Z","source":{"component":"gatekeeper-webhook"}}]}`)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/namespace?name=payments", nil)
+	if err := s.getNamespace(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"<h1>Namespace payments</h1>",
+		"<dt>team</dt>",
+		"payments@example.com",
+		`<a href="/object?kind=ConfigMap&amp;name=settings&amp;namespace=payments">settings</a>`,
+		"missing owner",
+		`<td><a href="/constraints#` + constraintAnchor("K8sRequiredLabels", "must-have-owner") + `">must-have-owner</a></td>`,
+		"Exempt from Audit",
+		"Admission webhook denied the request",
+		"by alice",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("namespace page missing %q", want)
+		}
+	}
+	for _, unwanted := range []string{"not this one", "dev-labels", "last-applied-configuration"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("namespace page has %q", unwanted)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The object page. The Resources view says Deployment/foo breaks three policies; this page shows
+// the Deployment itself: its live YAML, every violation the audit reported against it, the recent
+// admission events about it and the Constraints whose match selects it today. Only an object the
+// audit reported is read, so the page cannot be pointed at any object in the cluster.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// objectRef names an object the way a violation does. The version is left out, as in the
+// Resources view: the page reads the object at the version its group prefers.
+type objectRef struct {
+	Group, Kind, Namespace, Name string
+}
+
+// Title is the kind and the namespaced name, as kubectl prints them.
+func (r objectRef) Title() string {
+	if r.Namespace != "" {
+		return r.Kind + " " + r.Namespace + "/" + r.Name
+	}
+	return r.Kind + " " + r.Name
+}
+
+// objectURL is the object page of an object, under a context.
+func objectURL(kubeContext string, r objectRef) string {
+	q := url.Values{"kind": {r.Kind}, "name": {r.Name}}
+	if r.Group != "" {
+		q.Set("group", r.Group)
+	}
+	if r.Namespace != "" {
+		q.Set("namespace", r.Namespace)
+	}
+	return viewURL(kubeContext, "/object", "") + "?" + q.Encode()
+}
+
+// objectResource finds the resource that serves a kind of a group, at the version the group
+// prefers, and whether it is namespaced.
+func objectResource(client discovery.DiscoveryInterface, group, kind string) (schema.GroupVersionResource, bool, error) {
+	version, resources, err := groupResources(client, group)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	for _, r := range resources {
+		if r.Kind == kind {
+			return schema.GroupVersionResource{Group: group, Version: version, Resource: r.Name}, r.Namespaced, nil
+		}
+	}
+	return schema.GroupVersionResource{}, false, apierrors.NewNotFound(schema.GroupResource{Group: group, Resource: kind}, "")
+}
+
+// objectViolations are the violations the Constraints report against an object, and whether one
+// of them hit the audit limit, so the list can be short.
+func objectViolations(constraints []ssrConstraint, r objectRef) (out []ssrResourceViolation, limited bool) {
+	for _, c := range constraints {
+		if c.AuditLimited {
+			limited = true
+		}
+		for _, v := range c.Violations {
+			if v.Group == r.Group && v.Kind == r.Kind && v.Namespace == r.Namespace && v.Name == r.Name {
+				out = append(out, ssrResourceViolation{
+					Constraint: c.Name, Kind: c.Kind, Mode: enforcementMode(v.EnforcementAction), Message: v.Message,
+				})
+			}
+		}
+	}
+	return out, limited
+}
+
+// getObject renders the object page for the group, kind, namespace and name in the query.
+func (s *server) getObject(c echo.Context) error {
+	ref := objectRef{
+		Group:     c.QueryParam("group"),
+		Kind:      c.QueryParam("kind"),
+		Namespace: c.QueryParam("namespace"),
+		Name:      c.QueryParam("name"),
+	}
+	// The context switcher lands on the other cluster's Resources view: this object is one
+	// cluster's.
+	layout := s.ssrLayoutData(c, "resources", "/resources", ref.Title())
+
+	data := map[string]any{
+		"Layout":         layout,
+		"Object":         ref,
+		"ResourcesURL":   contextPath(c, "/resources"),
+		"ConstraintsURL": contextPath(c, "/constraints"),
+	}
+
+	if ref.Kind == "" || ref.Name == "" {
+		setViewError(data, "The page needs an object's kind and name. Open one from the Resources view.",
+			errors.New("the kind or name query parameter is missing"))
+		return s.ssr.render(c, "object", data)
+	}
+
+	clients, err := s.clientsFor(c)
+	if err != nil {
+		slog.Error("SSR object: resolving context failed", "error", err)
+		setViewError(data, "GPM could not switch to the requested Kubernetes context. Make sure the kubeconfig defines it correctly.", err)
+		return s.ssr.render(c, "object", data)
+	}
+
+	ctx := c.Request().Context()
+	gvr, namespaced, err := objectResource(clients.discovery, ref.Group, ref.Kind)
+	if err != nil {
+		slog.Error("SSR object: resolving the resource failed", "group", ref.Group, "kind", ref.Kind, "error", err)
+		message := "GPM could not find the resource that serves this kind in the Kubernetes API."
+		if apierrors.IsNotFound(err) {
+			message = fmt.Sprintf("The cluster does not serve the %s kind%s.", ref.Kind, groupSuffix(ref.Group))
+		}
+		setViewError(data, message, err)
+		return s.ssr.render(c, "object", data)
+	}
+
+	// Without the Constraints the object is still worth showing, so a failure costs the
+	// violations and the matches, and a warning says so.
+	raw, err := listConstraints(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR object: reading constraints failed, violations and matches are left out", "error", err)
+		data["ConstraintsMissing"] = true
+	}
+	sortConstraints(raw)
+	models := make([]ssrConstraint, 0, len(raw))
+	for _, o := range raw {
+		models = append(models, ssrConstraintModel(o))
+	}
+	violations, limited := objectViolations(models, ref)
+	data["Violations"], data["AuditLimited"] = violations, limited
+
+	// The page is a link anyone with the URL can open, so it reads only what the audit already put
+	// on show: an object a violation names. A Secret is never read, whatever the audit says of it.
+	var live *unstructured.Unstructured
+	switch {
+	case ref.Group == "" && ref.Kind == "Secret":
+		data["Secret"] = true
+	case len(violations) == 0:
+		data["NotAudited"] = err == nil
+	default:
+		if live, err = getLiveObject(ctx, data, clients, gvr, namespaced, ref); err != nil {
+			slog.Error("SSR object: getting the object failed", "resource", gvr.String(), "name", ref.Name, "error", err)
+			setViewError(data, "GPM could not get the object from the Kubernetes API. Make sure the API is reachable and GPM can get this kind.", err)
+			return s.ssr.render(c, "object", data)
+		}
+	}
+
+	// The namespace's labels, for the namespaceSelectors. Without them the selectors are left
+	// out, and a warning says the list may be long.
+	var ns *clusterNamespace
+	if live != nil && namespaced && ref.Namespace != "" {
+		nsObj, err := clients.dynamic.Resource(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}).Get(ctx, ref.Namespace, metav1.GetOptions{})
+		if err != nil {
+			slog.Warn("SSR object: getting the namespace failed, namespaceSelectors are left out", "namespace", ref.Namespace, "error", err)
+			data["SelectorsUnknown"] = true
+		} else {
+			ns = &clusterNamespace{Name: nsObj.GetName(), Labels: nsObj.GetLabels()}
+		}
+	}
+	var matching []ssrConstraint
+	for i, o := range raw {
+		if live != nil && constraintMatches(o, live.Object, ns) {
+			matching = append(matching, models[i])
+		}
+	}
+	data["Matching"] = matching
+
+	// The most recent page of events, as the Events view reads it, narrowed to this object.
+	query := eventQuery{
+		Sources:           settingList("events_source"),
+		ResourceNamespace: ref.Namespace,
+		ResourceKind:      ref.Kind,
+		ResourceName:      ref.Name,
+		Now:               time.Now(),
+		PageSize:          eventPageSizes[len(eventPageSizes)-1],
+	}
+	page, err := getKubernetesEvents(ctx, *clients.dynamic, eventsResource(clients.discovery), settingList("events_namespace"), query)
+	if err != nil {
+		slog.Warn("SSR object: reading events failed, they are left out", "error", err)
+		data["EventsMissing"] = true
+	}
+	data["Events"] = page.Events
+	eventsFilter := url.Values{"resource_kind": {ref.Kind}}
+	if ref.Namespace != "" {
+		eventsFilter.Set("resource_namespace", ref.Namespace)
+	}
+	data["EventsURL"] = contextPath(c, "/events") + "?" + eventsFilter.Encode()
+
+	return s.ssr.render(c, "object", data)
+}
+
+// getLiveObject gets the object for the page. An object that is gone, or that GPM may not get, is
+// nil with no error, and data says which.
+func getLiveObject(ctx context.Context, data map[string]any, clients *kubeClients, gvr schema.GroupVersionResource, namespaced bool, ref objectRef) (*unstructured.Unstructured, error) {
+	resource := clients.dynamic.Resource(gvr)
+	var live *unstructured.Unstructured
+	var err error
+	if namespaced {
+		live, err = resource.Namespace(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	} else {
+		live, err = resource.Get(ctx, ref.Name, metav1.GetOptions{})
+	}
+	switch {
+	case apierrors.IsNotFound(err):
+		// Deleted since the audit: its violations are still listed until the next one.
+		data["Gone"] = true
+	case apierrors.IsForbidden(err):
+		// GPM's ClusterRole reads Gatekeeper's objects, not every kind they judge. What the audit
+		// and the events say about the object does not need it.
+		slog.Warn("SSR object: not allowed to get the object", "resource", gvr.String(), "name", ref.Name, "error", err)
+		data["Forbidden"] = true
+	case err != nil:
+		return nil, err
+	default:
+		data["Live"] = redactObject(live.Object)
+		return live, nil
+	}
+	return nil, nil
+}
+
+// redactObject is the object as the page shows it. kubectl hides the managedFields too: they are
+// the bulk of the YAML and none of the answer. The values under data and stringData are hidden
+// whatever the kind, since they are where a kind keeps what a Secret would.
+func redactObject(obj map[string]any) map[string]any {
+	out := runtime.DeepCopyJSON(obj)
+	unstructured.RemoveNestedField(out, "metadata", "managedFields")
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := out[field].(map[string]any)
+		if !ok {
+			continue
+		}
+		for k := range values {
+			values[k] = "(redacted)"
+		}
+	}
+	return out
+}
+
+// groupSuffix names an API group in a sentence; the core group has no name to give.
+func groupSuffix(group string) string {
+	if group == "" {
+		return ""
+	}
+	return " in the " + group + " API group"
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestObjectViolationsKeepsTheObjectsOwn(t *testing.T) {
+	constraints := []ssrConstraint{
+		{Kind: "K8sRequiredLabels", Name: "owner", Violations: []ssrConstraintViolation{
+			{EnforcementAction: "deny", Group: "apps", Kind: "Deployment", Namespace: "default", Name: "web", Message: "no owner"},
+			{EnforcementAction: "deny", Group: "apps", Kind: "Deployment", Namespace: "default", Name: "api", Message: "no owner"},
+			// Same name and namespace, another group: not the same object.
+			{EnforcementAction: "deny", Group: "example.com", Kind: "Deployment", Namespace: "default", Name: "web", Message: "no owner"},
+		}},
+		{Kind: "K8sReplicaLimits", Name: "replicas", AuditLimited: true, Violations: []ssrConstraintViolation{
+			{EnforcementAction: "dryrun", Group: "apps", Kind: "Deployment", Namespace: "default", Name: "web", Message: "too many"},
+		}},
+	}
+	got, limited := objectViolations(constraints, objectRef{Group: "apps", Kind: "Deployment", Namespace: "default", Name: "web"})
+	if len(got) != 2 || got[0].Constraint != "owner" || got[1].Constraint != "replicas" || got[1].Mode != "dryrun" || !limited {
+		t.Errorf("violations = %+v, limited %t", got, limited)
+	}
+}
+
+func TestObjectURL(t *testing.T) {
+	got := objectURL("prod", objectRef{Kind: "Namespace", Name: "team-a"})
+	if got != "/object/prod?kind=Namespace&name=team-a" {
+		t.Errorf("cluster-scoped core object URL = %q", got)
+	}
+	got = objectURL("", objectRef{Group: "apps", Kind: "Deployment", Namespace: "default", Name: "web"})
+	if got != "/object?group=apps&kind=Deployment&name=web&namespace=default" {
+		t.Errorf("namespaced object URL = %q", got)
+	}
+}
+
+// The page for a Deployment: the audit's violations against it, the Constraints that select it
+// (the namespaceSelector read against its namespace's labels), its events and its YAML without
+// the managedFields.
+func TestObjectPage(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.serveGroup("apps", "v1", servedResource{Name: "deployments", Kind: "Deployment", Namespaced: true})
+	api.respondAt("/apis/apps/v1/namespaces/default/deployments/web", `{"apiVersion":"apps/v1","kind":"Deployment",`+
+		`"metadata":{"name":"web","namespace":"default","labels":{"app":"web"},"managedFields":[{"manager":"kubectl"}]},`+
+		`"spec":{"replicas":12}}`)
+	api.respondAt("/api/v1/namespaces/default", `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"default","labels":{"env":"prod"}}}`)
+	api.serveConstraints("K8sReplicaLimits",
+		`{"kind":"K8sReplicaLimits","metadata":{"name":"prod-replicas"},"spec":{"enforcementAction":"deny","match":{`+
+			`"kinds":[{"apiGroups":["apps"],"kinds":["Deployment"]}],"namespaceSelector":{"matchLabels":{"env":"prod"}}}},`+
+			`"status":{"totalViolations":1,"violations":[{"enforcementAction":"deny","group":"apps","version":"v1",`+
+			`"kind":"Deployment","namespace":"default","name":"web","message":"12 replicas is more than 10"}]}}`,
+		`{"kind":"K8sReplicaLimits","metadata":{"name":"dev-replicas"},"spec":{"match":{`+
+			`"kinds":[{"apiGroups":["apps"],"kinds":["Deployment"]}],"namespaceSelector":{"matchLabels":{"env":"dev"}}}}}`)
+	api.respondAt("/api/v1/events", `{"apiVersion":"v1","kind":"EventList","metadata":{},"items":[`+
+		`{"metadata":{"name":"ev-web","annotations":{"constraint_action":"deny","constraint_kind":"K8sReplicaLimits",`+
+		`"constraint_name":"prod-replicas","resource_kind":"Deployment","resource_namespace":"default","resource_name":"web"}},`+
+		`"reason":"FailedAdmission","message":"Admission webhook denied the scale","lastTimestamp":"2026-03-01T10:00:00Z",`+
+		`"source":{"component":"gatekeeper-webhook"}},`+
+		`{"metadata":{"name":"ev-api","annotations":{"constraint_action":"deny","constraint_name":"prod-replicas",`+
+		`"resource_kind":"Deployment","resource_namespace":"default","resource_name":"api"}},`+
+		`"reason":"FailedAdmission","message":"Another deployment","lastTimestamp":"2026-03-01T10:01:00Z",`+
+		`"source":{"component":"gatekeeper-webhook"}}]}`)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	q := url.Values{"group": {"apps"}, "kind": {"Deployment"}, "namespace": {"default"}, "name": {"web"}}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/object?"+q.Encode(), nil)
+	if err := s.getObject(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	anchor := constraintAnchor("K8sReplicaLimits", "prod-replicas")
+	for _, want := range []string{
+		"<h1>Deployment default/web</h1>",
+		"12 replicas is more than 10",
+		`<td><a href="/constraints#` + anchor + `">prod-replicas</a></td>`,
+		"Admission webhook denied the scale",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("object page missing %q", want)
+		}
+	}
+	if strings.Contains(out, "dev-replicas") {
+		t.Error("dev-replicas selects env=dev namespaces, not this one")
+	}
+	if strings.Contains(out, "Another deployment") {
+		t.Error("an event about another Deployment is listed")
+	}
+	if plain := stripHTMLTags(out); strings.Contains(plain, "managedFields") || !strings.Contains(plain, "replicas: 12") {
+		t.Error("the YAML should be the object's, without its managedFields")
+	}
+}
+
+// webViolation is a Constraint whose audit reported the Deployment default/web.
+const webViolation = `{"kind":"K8sReplicaLimits","metadata":{"name":"prod-replicas"},"spec":{"enforcementAction":"deny"},` +
+	`"status":{"totalViolations":1,"violations":[{"enforcementAction":"deny","group":"apps","version":"v1",` +
+	`"kind":"Deployment","namespace":"default","name":"web","message":"12 replicas is more than 10"}]}}`
+
+// getObjectPage renders the object page for a query.
+func getObjectPage(t *testing.T, s *server, query string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/object?"+query, nil)
+	if err := s.getObject(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	return rec.Body.String()
+}
+
+// An object deleted since the audit still has its violations to show.
+func TestObjectPageForADeletedObject(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.serveGroup("apps", "v1", servedResource{Name: "deployments", Kind: "Deployment", Namespaced: true})
+	api.serveConstraints("K8sReplicaLimits", webViolation)
+	api.failAt("/apis/apps/v1/namespaces/default/deployments/web", http.StatusNotFound)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/object?group=apps&kind=Deployment&namespace=default&name=web", nil)
+	if err := s.getObject(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	if !strings.Contains(out, "The object is not in the cluster any more.") || strings.Contains(out, "Matching Constraints") {
+		t.Error("a deleted object should say so and list no matches")
+	}
+}
+
+// GPM's ClusterRole does not read every kind. The audit's and the events' word on the object still
+// show.
+func TestObjectPageForAKindGPMCannotRead(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.serveGroup("apps", "v1", servedResource{Name: "deployments", Kind: "Deployment", Namespaced: true})
+	api.serveConstraints("K8sReplicaLimits", webViolation)
+	api.failAt("/apis/apps/v1/namespaces/default/deployments/web", http.StatusForbidden)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/object?group=apps&kind=Deployment&namespace=default&name=web", nil)
+	if err := s.getObject(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	if !strings.Contains(out, "GPM is not allowed to get Deployment objects") || strings.Contains(out, "alert-error") ||
+		!strings.Contains(out, "<h2>Violations</h2>") {
+		t.Error("a kind GPM cannot read should cost only the YAML and the matches")
+	}
+}
+
+// The page's URL names the object, so it must not read one the audit did not report, nor a Secret
+// it did.
+func TestObjectPageReadsOnlyWhatTheAuditReported(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.serveGroup("apps", "v1", servedResource{Name: "deployments", Kind: "Deployment", Namespaced: true})
+	api.serveGroup("", "v1", servedResource{Name: "secrets", Kind: "Secret", Namespaced: true})
+	api.serveConstraints("K8sReplicaLimits", webViolation,
+		`{"kind":"K8sReplicaLimits","metadata":{"name":"secrets"},"status":{"violations":[{"enforcementAction":"deny",`+
+			`"version":"v1","kind":"Secret","namespace":"default","name":"token","message":"no owner"}]}}`)
+	api.respondAt("/apis/apps/v1/namespaces/default/deployments/api", `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"api"}}`)
+	api.respondAt("/api/v1/namespaces/default/secrets/token", `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"token"},"data":{"token":"c2VjcmV0"}}`)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	out := getObjectPage(t, s, "group=apps&kind=Deployment&namespace=default&name=api")
+	if !strings.Contains(out, "The last audit reported nothing against this object, so GPM does not read it") {
+		t.Error("an object the audit did not report should say why its YAML is left out")
+	}
+	out = getObjectPage(t, s, "kind=Secret&namespace=default&name=token")
+	if !strings.Contains(out, "GPM does not read Secrets") || !strings.Contains(out, "no owner") || strings.Contains(out, "c2VjcmV0") {
+		t.Error("a Secret should show its violations and nothing of its data")
+	}
+	for _, path := range api.requested() {
+		if strings.HasSuffix(path, "/deployments/api") || strings.HasSuffix(path, "/secrets/token") {
+			t.Errorf("the page read %s", path)
+		}
+	}
+}
+
+func TestRedactObject(t *testing.T) {
+	obj := map[string]any{
+		"metadata":   map[string]any{"name": "settings", "managedFields": []any{map[string]any{"manager": "kubectl"}}},
+		"data":       map[string]any{"password": "hunter2"},
+		"stringData": map[string]any{"token": "abc"},
+		"spec":       map[string]any{"replicas": int64(2)},
+	}
+	got := redactObject(obj)
+	if _, ok := got["metadata"].(map[string]any)["managedFields"]; ok {
+		t.Error("the managedFields should be left out")
+	}
+	if got["data"].(map[string]any)["password"] != "(redacted)" || got["stringData"].(map[string]any)["token"] != "(redacted)" {
+		t.Errorf("data and stringData = %v, %v", got["data"], got["stringData"])
+	}
+	if obj["data"].(map[string]any)["password"] != "hunter2" {
+		t.Error("the object itself should be left as it is")
+	}
+}
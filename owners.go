@@ -0,0 +1,214 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Owner resolution for the Resources view. The audit reports the Pods and the ReplicaSets of a bad
+// Deployment as well as the Deployment, so one mistake is dozens of rows. Following the controller
+// ownerReferences up from those kinds finds the workload someone actually wrote, and the view
+// groups the violations under it.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ownedKinds are the kinds GPM reads to find their controller. Anything else is taken to be the
+// top of its chain, so GPM only needs to read these: a StatefulSet's Pod stops at the
+// StatefulSet, a Deployment's at the Deployment, a CronJob's at the CronJob.
+var ownedKinds = map[schema.GroupKind]bool{
+	{Kind: "Pod"}:                       true,
+	{Group: "apps", Kind: "ReplicaSet"}: true,
+	{Group: "batch", Kind: "Job"}:       true,
+}
+
+// How many ownerReferences a chain follows at most. Pod, ReplicaSet, Deployment is two.
+const ownerDepth = 4
+
+func owned(r objectRef) bool {
+	return ownedKinds[schema.GroupKind{Group: r.Group, Kind: r.Kind}]
+}
+
+// The owned kinds GPM lists a namespace of at once rather than reads one by one. The audit reports
+// a few Pods of a namespace that may hold thousands, so those are read on their own; the
+// ReplicaSets and Jobs above them are read whenever a Pod is, and a namespace has few enough to list.
+var listedOwnerKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "ReplicaSet"}: true,
+	{Group: "batch", Kind: "Job"}:       true,
+}
+
+// ownerList names one kind in one namespace, as resolveOwners lists them.
+type ownerList struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+// listOwnerRefs lists the metadata of a kind in a namespace, a chunk at a time, and keeps each
+// object's ownerReferences by its name.
+func listOwnerRefs(ctx context.Context, clients *kubeClients, l ownerList) (map[string][]metav1.OwnerReference, error) {
+	out := map[string][]metav1.OwnerReference{}
+	opts := metav1.ListOptions{Limit: metadataListChunk}
+	for {
+		list, err := clients.metadata.Resource(l.gvr).Namespace(l.namespace).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			out[item.Name] = item.OwnerReferences
+		}
+		if list.Continue == "" {
+			return out, nil
+		}
+		opts.Continue = list.Continue
+	}
+}
+
+// controllerOf is the object an object's controller ownerReference names, or nil.
+func controllerOf(refs []metav1.OwnerReference, namespace string) *objectRef {
+	for _, ref := range refs {
+		if ref.Controller == nil || !*ref.Controller {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			continue
+		}
+		return &objectRef{Group: gv.Group, Kind: ref.Kind, Namespace: namespace, Name: ref.Name}
+	}
+	return nil
+}
+
+// resolveOwners maps each object of an owned kind to its top-level controller. Objects with no
+// controller are left out of the map, and so is an object deleted since the audit. Only metadata is
+// read: a Pod on its own, the ReplicaSets and Jobs a namespace at a time, each namespace once. An
+// object GPM cannot read stops its chain where it is, and the error says which.
+func resolveOwners(ctx context.Context, clients *kubeClients, refs []objectRef) (map[objectRef]objectRef, error) {
+	parent := map[objectRef]objectRef{}
+	seen := map[objectRef]bool{}
+	var level []objectRef
+	for _, r := range refs {
+		if owned(r) && !seen[r] {
+			seen[r] = true
+			level = append(level, r)
+		}
+	}
+
+	// Discovery once per kind, not once per object.
+	resources := map[schema.GroupKind]schema.GroupVersionResource{}
+	// The namespaces listed so far, nil where the list failed.
+	listed := map[ownerList]map[string][]metav1.OwnerReference{}
+	var errs []error
+	for depth := 0; depth < ownerDepth && len(level) > 0; depth++ {
+		for _, r := range level {
+			gk := schema.GroupKind{Group: r.Group, Kind: r.Kind}
+			if _, ok := resources[gk]; ok {
+				continue
+			}
+			gvr, _, err := objectResource(clients.discovery, r.Group, r.Kind)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("resolving %s: %w", gk, err))
+			}
+			resources[gk] = gvr
+		}
+
+		var lists []ownerList
+		for _, r := range level {
+			gvr := resources[schema.GroupKind{Group: r.Group, Kind: r.Kind}]
+			l := ownerList{gvr, r.Namespace}
+			if _, done := listed[l]; gvr.Resource == "" || !listedOwnerKinds[schema.GroupKind{Group: r.Group, Kind: r.Kind}] || done || slices.Contains(lists, l) {
+				continue
+			}
+			lists = append(lists, l)
+		}
+
+		ownerRefs := make([][]metav1.OwnerReference, len(level))
+		listResults := make([]map[string][]metav1.OwnerReference, len(lists))
+		levelErrs := make([]error, len(level)+len(lists))
+		sem := make(chan struct{}, listConstraintsConcurrency)
+		var wg sync.WaitGroup
+		for i, l := range lists {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				byName, err := listOwnerRefs(ctx, clients, l)
+				if err != nil {
+					levelErrs[len(level)+i] = fmt.Errorf("listing %s in %s: %w", l.gvr.Resource, l.namespace, err)
+					return
+				}
+				listResults[i] = byName
+			}()
+		}
+		for i, r := range level {
+			gk := schema.GroupKind{Group: r.Group, Kind: r.Kind}
+			gvr := resources[gk]
+			if gvr.Resource == "" || listedOwnerKinds[gk] {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				obj, err := clients.metadata.Resource(gvr).Namespace(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					return
+				}
+				if err != nil {
+					levelErrs[i] = fmt.Errorf("getting %s %s/%s: %w", r.Kind, r.Namespace, r.Name, err)
+					return
+				}
+				ownerRefs[i] = obj.OwnerReferences
+			}()
+		}
+		wg.Wait()
+		errs = append(errs, levelErrs...)
+		for i, l := range lists {
+			listed[l] = listResults[i]
+		}
+
+		var next []objectRef
+		for i, r := range level {
+			gk := schema.GroupKind{Group: r.Group, Kind: r.Kind}
+			if listedOwnerKinds[gk] {
+				ownerRefs[i] = listed[ownerList{resources[gk], r.Namespace}][r.Name]
+			}
+			c := controllerOf(ownerRefs[i], r.Namespace)
+			if c == nil {
+				continue
+			}
+			parent[r] = *c
+			if owned(*c) && !seen[*c] {
+				seen[*c] = true
+				next = append(next, *c)
+			}
+		}
+		level = next
+	}
+
+	top := map[objectRef]objectRef{}
+	for _, r := range refs {
+		cur := r
+		for range ownerDepth {
+			p, ok := parent[cur]
+			if !ok {
+				break
+			}
+			cur = p
+		}
+		if cur != r {
+			top[r] = cur
+		}
+	}
+	return top, errors.Join(errs...)
+}
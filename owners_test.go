@@ -0,0 +1,136 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// ownedJSON is an object with a controller ownerReference, or none when ownerKind is empty.
+func ownedJSON(apiVersion, kind, name, ownerAPIVersion, ownerKind, owner string) string {
+	refs := ""
+	if ownerKind != "" {
+		refs = fmt.Sprintf(`,"ownerReferences":[{"apiVersion":%q,"kind":%q,"name":%q,"uid":"u","controller":true}]`,
+			ownerAPIVersion, ownerKind, owner)
+	}
+	return fmt.Sprintf(`{"apiVersion":%q,"kind":%q,"metadata":{"name":%q,"namespace":"shop"%s}}`, apiVersion, kind, name, refs)
+}
+
+// A stand-in API with two Deployments' ReplicaSets, two Pods of one, a bare Pod, and a Pod deleted
+// since the audit.
+func ownersTestAPI(t *testing.T) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.serveGroup("apps", "v1",
+		servedResource{Name: "deployments", Kind: "Deployment", Namespaced: true},
+		servedResource{Name: "replicasets", Kind: "ReplicaSet", Namespaced: true})
+	api.serveGroup("", "v1", servedResource{Name: "pods", Kind: "Pod", Namespaced: true})
+	api.respondAt("/apis/apps/v1/namespaces/shop/replicasets", `{"apiVersion":"apps/v1","kind":"ReplicaSetList","metadata":{},"items":[`+
+		ownedJSON("apps/v1", "ReplicaSet", "web-5d8f", "apps/v1", "Deployment", "web")+","+
+		ownedJSON("apps/v1", "ReplicaSet", "api-7c4b", "apps/v1", "Deployment", "api")+`]}`)
+	for _, pod := range []string{"web-5d8f-a", "web-5d8f-b"} {
+		api.respondAt("/api/v1/namespaces/shop/pods/"+pod, ownedJSON("v1", "Pod", pod, "apps/v1", "ReplicaSet", "web-5d8f"))
+	}
+	api.respondAt("/api/v1/namespaces/shop/pods/debug", ownedJSON("v1", "Pod", "debug", "", "", ""))
+	api.failAt("/api/v1/namespaces/shop/pods/gone", http.StatusNotFound)
+	return api
+}
+
+func TestResolveOwnersFollowsTheControllerChain(t *testing.T) {
+	api := ownersTestAPI(t)
+	_, clients := discoveryTestClients(t, api)
+
+	pod := func(name string) objectRef { return objectRef{Kind: "Pod", Namespace: "shop", Name: name} }
+	deployment := objectRef{Group: "apps", Kind: "Deployment", Namespace: "shop", Name: "web"}
+	refs := []objectRef{pod("web-5d8f-a"), pod("web-5d8f-b"), pod("debug"), pod("gone"), deployment,
+		{Group: "apps", Kind: "ReplicaSet", Namespace: "shop", Name: "web-5d8f"},
+		{Group: "apps", Kind: "ReplicaSet", Namespace: "shop", Name: "api-7c4b"}}
+
+	owners, err := resolveOwners(context.Background(), clients, refs)
+	if err != nil {
+		t.Fatalf("resolving owners failed: %v", err)
+	}
+	if len(owners) != 4 || owners[pod("web-5d8f-a")] != deployment || owners[pod("web-5d8f-b")] != deployment ||
+		owners[refs[5]] != deployment || owners[refs[6]].Name != "api" {
+		t.Errorf("owners = %v, want the two Pods and the ReplicaSets under their Deployments", owners)
+	}
+	// The namespace's ReplicaSets are listed once for both Pods and both ReplicaSets, and the
+	// Deployment is not read at all.
+	reads := 0
+	for _, p := range api.requested() {
+		if strings.Contains(p, "/replicasets") {
+			reads++
+		}
+		if strings.HasSuffix(p, "/deployments/web") {
+			t.Error("the Deployment is the top of its chain and needs no read")
+		}
+	}
+	if reads != 1 {
+		t.Errorf("the ReplicaSets were read %d times, want one list", reads)
+	}
+}
+
+func TestResolveOwnersReportsWhatItCannotRead(t *testing.T) {
+	api := ownersTestAPI(t)
+	api.failAt("/apis/apps/v1/namespaces/shop/replicasets", http.StatusForbidden)
+	_, clients := discoveryTestClients(t, api)
+
+	a := objectRef{Kind: "Pod", Namespace: "shop", Name: "web-5d8f-a"}
+	owners, err := resolveOwners(context.Background(), clients, []objectRef{a})
+	if err == nil || !strings.Contains(err.Error(), "listing replicasets in shop") {
+		t.Errorf("error = %v, want the ReplicaSets' namespace named", err)
+	}
+	// The chain stops at what is known: the ReplicaSet.
+	if got := owners[a]; got.Kind != "ReplicaSet" || got.Name != "web-5d8f" {
+		t.Errorf("owner = %+v, want the ReplicaSet", got)
+	}
+}
+
+func TestResourceModelGroupsChildrenUnderTheirOwner(t *testing.T) {
+	deployment := objectRef{Group: "apps", Kind: "Deployment", Namespace: "shop", Name: "web"}
+	owners := map[objectRef]objectRef{
+		{Kind: "Pod", Namespace: "shop", Name: "web-5d8f-a"}: deployment,
+		{Kind: "Pod", Namespace: "shop", Name: "web-5d8f-b"}: deployment,
+	}
+	constraints := []ssrConstraint{
+		{Name: "no-root", Kind: "K8sPSPRunAsNonRoot", Violations: []ssrConstraintViolation{
+			viol("", "Pod", "shop", "web-5d8f-a", "deny"),
+			viol("", "Pod", "shop", "web-5d8f-b", "deny"),
+			viol("", "Pod", "shop", "debug", "deny"),
+		}},
+		{Name: "probes", Kind: "K8sLivenessProbe", Violations: []ssrConstraintViolation{
+			viol("apps", "Deployment", "shop", "web", "warn"),
+		}},
+	}
+
+	rows := resourceModel(constraints, owners)[0].Resources
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want the Deployment and the bare Pod", len(rows))
+	}
+	web := rows[0]
+	if web.Kind != "Deployment" || web.Name != "web" || web.Children != 2 || web.Deny != 2 || web.Warn != 1 {
+		t.Errorf("owner row = %+v", web)
+	}
+	if web.Violations[0].Object != "Pod web-5d8f-a" || web.Violations[2].Object != "" {
+		t.Errorf("violations = %+v, want the children named and the owner's own left bare", web.Violations)
+	}
+	if debug := rows[1]; debug.Name != "debug" || debug.Children != 0 {
+		t.Errorf("bare Pod row = %+v", debug)
+	}
+
+	page := renderSSR(t, "resources", map[string]any{
+		"Layout": minimalLayout(), "Audited": true, "ByOwner": true, "ObjectsURL": "/resources?view=objects",
+		"Namespaces": resourceModel(constraints, owners),
+	})
+	for _, want := range []string{"2 affected children", "Pod web-5d8f-a: ", `<a href="/resources?view=objects">Show each object</a>`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("resources output missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Constraint parameters against the template's schema. The API server only enforces a template's
+// openAPIV3Schema when the CRD Gatekeeper generates is structural, and legacy templates' are not: a
+// Constraint with a misspelled or mistyped parameter is accepted, and its Rego reads an empty value.
+// GPM checks the parameters itself, for the parts of OpenAPI v3 the templates use: types, required
+// fields, enums and fields the schema does not have.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// templateSchemas reads each template's parameter schema, keyed by the Constraint kind it creates. A
+// template without one is left out: its Constraints take any parameters.
+func templateSchemas(ctx context.Context, clients *kubeClients) (map[string]map[string]any, error) {
+	cts, err := getCustomResources(ctx, *clients.dynamic, "templates.gatekeeper.sh", "v1", "constrainttemplates")
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]map[string]any{}
+	for i := range cts.Items {
+		o := cts.Items[i].Object
+		kind, _, _ := unstructured.NestedString(o, "spec", "crd", "spec", "names", "kind")
+		if schema, found, _ := unstructured.NestedMap(o, "spec", "crd", "spec", "validation", "openAPIV3Schema"); found && kind != "" {
+			out[kind] = schema
+		}
+	}
+	return out, nil
+}
+
+// parameterIssues checks a Constraint's spec.parameters against its template's schema.
+func parameterIssues(constraint, schema map[string]any) []string {
+	if schema == nil {
+		return nil
+	}
+	params, found, _ := unstructured.NestedFieldNoCopy(constraint, "spec", "parameters")
+	if !found {
+		// No parameters is an empty object, which fails only a schema that requires something.
+		params = map[string]any{}
+	}
+	return schemaIssues("parameters", params, schema)
+}
+
+// schemaIssues validates a value against a schema, and every value under it against the schema
+// for it. Each problem is one sentence that starts with the path of the value.
+func schemaIssues(path string, value any, schema map[string]any) []string {
+	if value == nil {
+		if nullable, _, _ := unstructured.NestedBool(schema, "nullable"); nullable {
+			return nil
+		}
+	}
+
+	typ, _, _ := unstructured.NestedString(schema, "type")
+	properties, _, _ := unstructured.NestedMap(schema, "properties")
+	// A schema with properties and no type is an object schema all the same; legacy templates write
+	// them that way.
+	if typ == "" && properties != nil {
+		typ = "object"
+	}
+	if typ != "" && !hasSchemaType(value, typ) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, article(typ), article(jsonType(value)))}
+	}
+
+	var issues []string
+	if enum, found, _ := unstructured.NestedSlice(schema, "enum"); found &&
+		!slices.ContainsFunc(enum, func(e any) bool { return fmt.Sprint(e) == fmt.Sprint(value) }) {
+		allowed := make([]string, 0, len(enum))
+		for _, e := range enum {
+			allowed = append(allowed, fmt.Sprintf("%q", fmt.Sprint(e)))
+		}
+		issues = append(issues, fmt.Sprintf("%s: %q is not one of %s", path, fmt.Sprint(value), strings.Join(allowed, ", ")))
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		required, _, _ := unstructured.NestedStringSlice(schema, "required")
+		for _, r := range required {
+			if _, ok := v[r]; !ok {
+				issues = append(issues, fmt.Sprintf("%s.%s is required", path, r))
+			}
+		}
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		allowsAny := schema["additionalProperties"] == true
+		if preserve, _, _ := unstructured.NestedBool(schema, "x-kubernetes-preserve-unknown-fields"); preserve {
+			allowsAny = true
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if sub, ok := properties[k].(map[string]any); ok {
+				issues = append(issues, schemaIssues(path+"."+k, v[k], sub)...)
+				continue
+			}
+			switch {
+			case additional != nil:
+				issues = append(issues, schemaIssues(path+"."+k, v[k], additional)...)
+			// A schema that lists no properties says nothing about which fields there are.
+			case properties != nil && !allowsAny:
+				issues = append(issues, fmt.Sprintf("%s.%s is not in the template's schema", path, k))
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				issues = append(issues, schemaIssues(fmt.Sprintf("%s[%d]", path, i), item, items)...)
+			}
+		}
+	}
+	return issues
+}
+
+// hasSchemaType reports whether a decoded JSON value is of an OpenAPI type. A type GPM does not know
+// accepts anything, rather than flag a Constraint for GPM's own gap.
+func hasSchemaType(value any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		switch n := value.(type) {
+		case int64, int32, int:
+			return true
+		case float64:
+			return n == math.Trunc(n)
+		}
+		return false
+	case "number":
+		switch value.(type) {
+		case int64, int32, int, float64:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// jsonType names the type of a decoded JSON value, for the messages.
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case int64, int32, int:
+		return "integer"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// article puts "a" or "an" before a type name.
+func article(typ string) string {
+	if strings.ContainsRune("aeiou", rune(typ[0])) {
+		return "an " + typ
+	}
+	return "a " + typ
+}
+
+// constraintParameterIssues checks the parameters of every Constraint, keyed by constraintAnchor.
+// Failing to read the templates costs the check, not the view.
+func constraintParameterIssues(ctx context.Context, clients *kubeClients, constraints []map[string]any) map[string][]string {
+	schemas, err := templateSchemas(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR constraints: reading constraint templates failed, parameters are not checked", "error", err)
+		return nil
+	}
+	out := map[string][]string{}
+	for _, c := range constraints {
+		u := unstructured.Unstructured{Object: c}
+		if issues := parameterIssues(c, schemas[u.GetKind()]); len(issues) > 0 {
+			out[constraintAnchor(u.GetKind(), u.GetName())] = issues
+		}
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+const requiredLabelsSchema = `
+properties:
+  message: {type: string}
+  mode: {type: string, enum: [audit, enforce]}
+  labels:
+    type: array
+    items:
+      type: object
+      required: [key]
+      properties:
+        key: {type: string}
+        allowedRegex: {type: string}
+  limits:
+    type: object
+    additionalProperties: {type: integer}
+`
+
+func TestParameterIssues(t *testing.T) {
+	schema := yamlObject(t, requiredLabelsSchema)
+	for _, c := range []struct {
+		name, constraint string
+		want             []string
+	}{
+		{"valid", `spec: {parameters: {mode: audit, labels: [{key: owner}], limits: {cpu: 2}}}`, nil},
+		{"no parameters", `spec: {}`, nil},
+		{"wrong type", `spec: {parameters: {labels: owner}}`, []string{"parameters.labels: expected an array, got a string"}},
+		{"missing required", `spec: {parameters: {labels: [{allowedRegex: "^a"}]}}`, []string{"parameters.labels[0].key is required"}},
+		{"enum", `spec: {parameters: {mode: strict}}`, []string{`parameters.mode: "strict" is not one of "audit", "enforce"`}},
+		{"unknown field", `spec: {parameters: {lables: [{key: owner}]}}`, []string{"parameters.lables is not in the template's schema"}},
+		{"additional properties", `spec: {parameters: {limits: {cpu: 1.5}}}`, []string{"parameters.limits.cpu: expected an integer, got a number"}},
+	} {
+		got := parameterIssues(yamlObject(t, c.constraint), schema)
+		if strings.Join(got, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("%s: issues = %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	// A schema that requires something fails a Constraint without parameters.
+	required := yamlObject(t, "type: object\nrequired: [labels]\nproperties: {labels: {type: array}}\n")
+	if got := parameterIssues(yamlObject(t, "spec: {}"), required); len(got) != 1 || got[0] != "parameters.labels is required" {
+		t.Errorf("issues without parameters = %q", got)
+	}
+	// A schema that preserves unknown fields takes them.
+	preserving := yamlObject(t, "x-kubernetes-preserve-unknown-fields: true\nproperties: {labels: {type: array}}\n")
+	if got := parameterIssues(yamlObject(t, "spec: {parameters: {extra: 1}}"), preserving); got != nil {
+		t.Errorf("issues with a preserving schema = %q", got)
+	}
+}
+
+func TestConstraintsFlagMisconfiguredParameters(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.respondAt("/apis/templates.gatekeeper.sh/v1/constrainttemplates", `{"apiVersion":"templates.gatekeeper.sh/v1",`+
+		`"kind":"ConstraintTemplateList","metadata":{},"items":[{"kind":"ConstraintTemplate","metadata":{"name":"k8srequiredlabels"},`+
+		`"spec":{"crd":{"spec":{"names":{"kind":"K8sRequiredLabels"},"validation":{"openAPIV3Schema":`+
+		`{"properties":{"labels":{"type":"array","items":{"type":"string"}}}}}}}}}]}`)
+	api.serveConstraints("K8sRequiredLabels",
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"owner"},"spec":{"parameters":{"labels":["owner"]}}}`,
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"team"},"spec":{"parameters":{"label":"team"}}}`)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getConstraints(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/constraints", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`One Constraint has parameters that do not match`,
+		`<a href="#` + constraintAnchor("K8sRequiredLabels", "team") + `">team</a>`,
+		"<code>parameters.label is not in the template&#39;s schema</code>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("constraints output missing %q", want)
+		}
+	}
+	if strings.Count(out, "do not match the template's schema:") != 1 {
+		t.Error("want the misconfigured Constraint's card alone to carry the alert")
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The Providers view. A template that calls external_data depends on a Provider object naming the
+// service to ask, and a template whose Provider is missing fails every request it judges. The view
+// shows each Provider, the templates that call it, and the calls no Provider answers.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The API group of the Providers, read at whatever version the cluster prefers.
+const externalDataGroup = "externaldata.gatekeeper.sh"
+
+var (
+	// An external_data call, up to the end of its argument object.
+	externalDataCall = regexp.MustCompile(`external_data\s*\(\s*\{[^}]*\}`)
+	// The provider a call names, when it names it with a string literal.
+	externalDataProvider = regexp.MustCompile(`"provider"\s*:\s*"([^"]+)"`)
+)
+
+// regoProviders finds the Providers a Rego source calls. A call that names its provider through a
+// variable cannot be resolved by reading the source, and is reported as dynamic instead.
+func regoProviders(rego string) (names []string, dynamic bool) {
+	for _, call := range externalDataCall.FindAllString(rego, -1) {
+		m := externalDataProvider.FindStringSubmatch(call)
+		if m == nil {
+			dynamic = true
+			continue
+		}
+		if !slices.Contains(names, m[1]) {
+			names = append(names, m[1])
+		}
+	}
+	// A call whose argument is not an object literal, external_data(request), is dynamic too.
+	if strings.Count(rego, "external_data(") > len(externalDataCall.FindAllString(rego, -1)) {
+		dynamic = true
+	}
+	return names, dynamic
+}
+
+// templateProviders is every Provider a template's Rego and libs call.
+func templateProviders(t ssrConstraintTemplate) (names []string, dynamic bool) {
+	for _, source := range t.RegoSources() {
+		found, d := regoProviders(source)
+		dynamic = dynamic || d
+		for _, n := range found {
+			if !slices.Contains(names, n) {
+				names = append(names, n)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, dynamic
+}
+
+// A Provider a template calls, and whether the cluster has it.
+type ssrProviderRef struct {
+	Name    string
+	Missing bool
+}
+
+// ssrProvider is the flat shape a Provider card renders.
+type ssrProvider struct {
+	Name      string
+	URL       string
+	Insecure  bool // a plain http URL, which Gatekeeper refuses unless it runs with insecure providers allowed
+	Timeout   int64
+	CABundle  bool
+	Created   string
+	Templates []string // the Kinds of the templates that call it
+	Raw       map[string]any
+}
+
+// A Provider the templates call and the cluster does not have.
+type ssrMissingProvider struct {
+	Name      string
+	Templates []string
+}
+
+func ssrProviderModel(o map[string]any) ssrProvider {
+	m := ssrProvider{Raw: o}
+	m.Name, _, _ = unstructured.NestedString(o, "metadata", "name")
+	m.Created, _, _ = unstructured.NestedString(o, "metadata", "creationTimestamp")
+	m.URL, _, _ = unstructured.NestedString(o, "spec", "url")
+	m.Insecure = strings.HasPrefix(strings.ToLower(m.URL), "http://")
+	m.Timeout, _, _ = unstructured.NestedInt64(o, "spec", "timeout")
+	bundle, _, _ := unstructured.NestedString(o, "spec", "caBundle")
+	m.CABundle = bundle != ""
+	return m
+}
+
+// listProviders reads the Providers. ok is false when GPM could not tell which exist, so nothing
+// should be marked missing; a cluster that does not serve the group has none, and that is an answer.
+func listProviders(ctx context.Context, clients *kubeClients) (providers []map[string]any, ok bool) {
+	items, err := listGroup(ctx, clients, externalDataGroup)
+	if err != nil {
+		slog.Warn("SSR providers: listing providers failed", "error", err)
+		return items, false
+	}
+	return items, true
+}
+
+// getProviders renders the Providers view.
+func (s *server) getProviders(c echo.Context) error {
+	layout := s.ssrLayoutData(c, "providers", "/providers", "Providers")
+
+	data := map[string]any{"Layout": layout}
+
+	clients, err := s.clientsFor(c)
+	if err != nil {
+		slog.Error("SSR providers: resolving context failed", "error", err)
+		setViewError(data, "GPM could not switch to the requested Kubernetes context. Make sure the kubeconfig defines it correctly.", err)
+		return s.ssr.render(c, "providers", data)
+	}
+
+	ctx := c.Request().Context()
+	items, err := listGroup(ctx, clients, externalDataGroup)
+	if err != nil && len(items) == 0 {
+		slog.Error("SSR providers: getting providers failed", "error", err)
+		setViewError(data, "GPM could not get the external data Providers from the Kubernetes API. Make sure the API is reachable and GPM can read the externaldata.gatekeeper.sh group.", err)
+		return s.ssr.render(c, "providers", data)
+	}
+	complete := err == nil
+	if err != nil {
+		slog.Error("SSR providers: getting some providers failed", "error", err)
+	}
+
+	providers := make([]ssrProvider, 0, len(items))
+	index := map[string]int{}
+	for _, o := range items {
+		providers = append(providers, ssrProviderModel(o))
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	for i, p := range providers {
+		index[p.Name] = i
+	}
+
+	// The templates are only read for the cross-links, so failing to read them costs the links.
+	missing := map[string][]string{}
+	var dynamic []string
+	cts, err := getCustomResources(ctx, *clients.dynamic, "templates.gatekeeper.sh", "v1", "constrainttemplates")
+	if err != nil {
+		slog.Warn("SSR providers: reading constraint templates failed, the cards leave them out", "error", err)
+	} else {
+		for i := range cts.Items {
+			t := ssrConstraintTemplateModel(cts.Items[i].Object, nil)
+			names, d := templateProviders(t)
+			if d {
+				dynamic = append(dynamic, t.Kind)
+			}
+			for _, n := range names {
+				if j, ok := index[n]; ok {
+					providers[j].Templates = append(providers[j].Templates, t.Kind)
+				} else if complete {
+					missing[n] = append(missing[n], t.Kind)
+				}
+			}
+		}
+	}
+	var missingList []ssrMissingProvider
+	for n, kinds := range missing {
+		sort.Strings(kinds)
+		missingList = append(missingList, ssrMissingProvider{Name: n, Templates: kinds})
+	}
+	sort.Slice(missingList, func(i, j int) bool { return missingList[i].Name < missingList[j].Name })
+	for i := range providers {
+		sort.Strings(providers[i].Templates)
+	}
+	sort.Strings(dynamic)
+
+	data["Providers"] = providers
+	data["Missing"] = missingList
+	data["Dynamic"] = dynamic
+	data["ExpectedPods"] = maxPodCount(items)
+	data["TemplatesURL"] = contextPath(c, "/constrainttemplates")
+	return s.ssr.render(c, "providers", data)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRegoProviders(t *testing.T) {
+	for _, c := range []struct {
+		rego    string
+		names   string
+		dynamic bool
+	}{
+		{`response := external_data({"provider": "cosign", "keys": images})`, "cosign", false},
+		{`r := external_data({"keys": keys, "provider": "trivy"})` + "\n" + `s := external_data({"provider": "cosign", "keys": k})`, "trivy,cosign", false},
+		{`r := external_data({"provider": provider_name, "keys": keys})`, "", true},
+		{`r := external_data(request)`, "", true},
+		{`violation[{"msg": msg}] { msg := "no external data here" }`, "", false},
+	} {
+		names, dynamic := regoProviders(c.rego)
+		if strings.Join(names, ",") != c.names || dynamic != c.dynamic {
+			t.Errorf("regoProviders(%q) = %v, %t; want %q, %t", c.rego, names, dynamic, c.names, c.dynamic)
+		}
+	}
+}
+
+// constraintTemplateJSON is a template whose Rego is the given source.
+func constraintTemplateJSON(t *testing.T, kind, rego string) string {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{
+		"kind":     "ConstraintTemplate",
+		"metadata": map[string]any{"name": strings.ToLower(kind)},
+		"spec": map[string]any{
+			"crd":     map[string]any{"spec": map[string]any{"names": map[string]any{"kind": kind}}},
+			"targets": []any{map[string]any{"target": "admission.k8s.gatekeeper.sh", "rego": rego}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// providersAPI serves one Provider, cosign, and three templates: one calls cosign, one a provider
+// the cluster lacks, and one names its provider through a variable.
+func providersAPI(t *testing.T) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.serveGroup("externaldata.gatekeeper.sh", "v1beta1", servedResource{Name: "providers", Kind: "Provider", Items: []string{
+		`{"kind":"Provider","metadata":{"name":"cosign"},"spec":{"url":"http://cosign.gatekeeper-system:8090/validate","timeout":5}}`,
+	}})
+	api.respondAt("/apis/templates.gatekeeper.sh/v1/constrainttemplates", `{"apiVersion":"templates.gatekeeper.sh/v1",`+
+		`"kind":"ConstraintTemplateList","metadata":{},"items":[`+strings.Join([]string{
+		constraintTemplateJSON(t, "K8sSignedImages", `r := external_data({"provider": "cosign", "keys": images})`),
+		constraintTemplateJSON(t, "K8sScannedImages", `r := external_data({"provider": "trivy", "keys": images})`),
+		constraintTemplateJSON(t, "K8sAnyProvider", `r := external_data({"provider": input.parameters.provider, "keys": k})`),
+	}, ",")+`]}`)
+	return api
+}
+
+func TestProvidersViewLinksTheTemplates(t *testing.T) {
+	s, _ := discoveryTestClients(t, providersAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getProviders(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/providers", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`id="cosign"`, "<code>http://cosign.gatekeeper-system:8090/validate</code>", ">http</span>", "5s",
+		`<span class="tag tag-warn">absent</span>`, `<a href="/constrainttemplates#K8sSignedImages">K8sSignedImages</a>`,
+		"No Provider named <code>trivy</code> exists", `<a href="/constrainttemplates#K8sAnyProvider">K8sAnyProvider</a>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("providers output missing %q", want)
+		}
+	}
+}
+
+func TestConstraintTemplatesMarkMissingProviders(t *testing.T) {
+	s, _ := discoveryTestClients(t, providersAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getConstraintTemplates(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/constrainttemplates", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	if got := strings.Count(out, ">missing</span>"); got != 1 {
+		t.Errorf("%d providers marked missing, want trivy alone", got)
+	}
+	if !strings.Contains(out, `<a href="/providers#trivy">trivy <span class="badge badge-danger"`) ||
+		!strings.Contains(out, `<a href="/providers#cosign">cosign</a>`) {
+		t.Error("the template cards do not link their providers")
+	}
+	if !strings.Contains(out, "names its provider through a variable") {
+		t.Error("the dynamic call is not explained")
+	}
+}
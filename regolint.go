@@ -0,0 +1,364 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Rego lint. Gatekeeper compiles a template's Rego when the template is created, and reports what
+// does not compile on the template's status; everything that compiles is taken as written. This
+// file reads the Rego of each template for the mistakes that compile: a module with no violation
+// rule, rules nothing uses, built-ins OPA has deprecated, and input.review.object read without
+// minding the requests that have none. The modules are read with OPA's parser, so what does not
+// parse is reported with the parser's own errors, at the lines it names.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/open-policy-agent/opa/v1/ast"
+)
+
+// The severities of a finding, worst first.
+var regoSeverities = []string{"error", "warning", "info"}
+
+// regoFinding is one thing the lint found in a module. Line is 1-based; 0 is the module as a whole.
+type regoFinding struct {
+	Line     int
+	Severity string
+	Message  string
+}
+
+// regoModule is one Rego source of a template, the rules or a lib, with what the lint found in it.
+type regoModule struct {
+	ID       string // the prefix of its line anchors, unique on the page: <kind>-rego-<n>
+	Source   string
+	Lib      bool
+	Findings []regoFinding
+}
+
+// The built-ins OPA has deprecated, and what to write instead.
+var deprecatedBuiltins = map[string]string{
+	"any":              "use a comprehension or some ... in",
+	"all":              "use every",
+	"re_match":         "use regex.match",
+	"set_diff":         "use the - operator",
+	"net.cidr_overlap": "use net.cidr_contains",
+	"cast_array":       "use a comprehension",
+	"cast_set":         "use a set comprehension",
+	"cast_string":      "check the type with is_string",
+	"cast_boolean":     "check the type with is_boolean",
+	"cast_null":        "check the type with is_null",
+	"cast_object":      "check the type with is_object",
+}
+
+// The refs that check the request before reading the object: the operation, and the old object.
+var (
+	reviewObjectRef    = ast.MustParseRef("input.review.object")
+	reviewOperationRef = ast.MustParseRef("input.review.operation")
+	reviewOldObjectRef = ast.MustParseRef("input.review.oldObject")
+)
+
+// RegoVersion is the Rego version Gatekeeper reads the code in: v1 when its source says so, and
+// otherwise v0, the syntax before OPA 1.0, which a template keeps unless it says otherwise.
+func (c ssrTemplateCode) RegoVersion() ast.RegoVersion {
+	if c.Version == "v1" {
+		return ast.RegoV1
+	}
+	return ast.RegoV0
+}
+
+// parseRego parses a module the way Gatekeeper reads a template's Rego, in the code's version.
+func parseRego(name, src string, version ast.RegoVersion) (*ast.Module, error) {
+	return ast.ParseModuleWithOpts(name, src, ast.ParserOptions{RegoVersion: version})
+}
+
+// parseFindings turns the parser's errors into findings, one per error at the line it names.
+func parseFindings(err error) []regoFinding {
+	var errs ast.Errors
+	if !errors.As(err, &errs) {
+		return []regoFinding{{Severity: "error", Message: err.Error()}}
+	}
+	out := make([]regoFinding, 0, len(errs))
+	for _, e := range errs {
+		f := regoFinding{Severity: "error", Message: e.Message}
+		if e.Location != nil {
+			f.Line = e.Location.Row
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// ruleName is the name a rule is referred to by: the first part of its head.
+func ruleName(r *ast.Rule) string {
+	return r.Head.Ref()[0].Value.String()
+}
+
+// usedNames collects every name the modules refer to: the first part of each ref, and each part
+// after it, since a rule of a lib is read as data.lib.<pkg>.<name>. The package declarations are
+// left out, as they name no rule.
+func usedNames(modules []*ast.Module) map[string]bool {
+	used := map[string]bool{}
+	add := func(ref ast.Ref) bool {
+		for i, t := range ref {
+			switch v := t.Value.(type) {
+			case ast.Var:
+				if i == 0 {
+					used[string(v)] = true
+				}
+			case ast.String:
+				used[string(v)] = true
+			}
+		}
+		return false
+	}
+	for _, m := range modules {
+		if m == nil {
+			continue
+		}
+		for _, imp := range m.Imports {
+			ast.WalkRefs(imp, func(ref ast.Ref) bool { return add(ref) })
+		}
+		for _, r := range m.Rules {
+			ast.WalkRefs(r, func(ref ast.Ref) bool { return add(ref) })
+		}
+	}
+	return used
+}
+
+// guardsReview reports whether a module checks the request before reading the object: it reads the
+// operation or the old object, or has an expression that is the object alone, which is undefined
+// when there is none.
+func guardsReview(m *ast.Module) bool {
+	guarded := false
+	ast.WalkExprs(m, func(e *ast.Expr) bool {
+		if t, ok := e.Terms.(*ast.Term); ok {
+			if ref, ok := t.Value.(ast.Ref); ok && ref.Equal(reviewObjectRef) {
+				guarded = true
+			}
+		}
+		return guarded
+	})
+	ast.WalkRefs(m, func(ref ast.Ref) bool {
+		if ref.HasPrefix(reviewOperationRef) || ref.HasPrefix(reviewOldObjectRef) {
+			guarded = true
+		}
+		return guarded
+	})
+	return guarded
+}
+
+// firstObjectRead is the line where a module first reads input.review.object, or 0.
+func firstObjectRead(m *ast.Module) int {
+	line := 0
+	ast.WalkRefs(m, func(ref ast.Ref) bool {
+		if ref.HasPrefix(reviewObjectRef) && len(ref) > 0 && ref[0].Location != nil {
+			if row := ref[0].Location.Row; line == 0 || row < line {
+				line = row
+			}
+		}
+		return false
+	})
+	return line
+}
+
+// lintRegoModules lints the Rego modules of one code entry's template: the rules module, which must
+// have a violation rule, and its libs. A module that does not parse has its parse errors and nothing
+// else. Rules are unused when no module of the template refers to them. The object guard is looked
+// for across the modules too, since a lib may check the operation for the rules.
+func lintRegoModules(modules []*regoModule, version ast.RegoVersion, used map[string]bool, guarded bool) {
+	for _, m := range modules {
+		parsed, err := parseRego("template.rego", m.Source, version)
+		if err != nil {
+			m.Findings = append(m.Findings, parseFindings(err)...)
+			continue
+		}
+
+		if !m.Lib && !slices.ContainsFunc(parsed.Rules, func(r *ast.Rule) bool { return ruleName(r) == "violation" }) {
+			m.Findings = append(m.Findings, regoFinding{Severity: "error",
+				Message: "the module has no violation rule, so Gatekeeper finds no violations in it"})
+		}
+		seen := map[string]bool{}
+		for _, r := range parsed.Rules {
+			name := ruleName(r)
+			if seen[name] || name == "violation" {
+				continue
+			}
+			seen[name] = true
+			if !used[name] {
+				m.Findings = append(m.Findings, regoFinding{Line: r.Location.Row, Severity: "info",
+					Message: fmt.Sprintf("the rule %s is not used", name)})
+			}
+		}
+
+		// A call is an expression of its own, or a term inside one, as in x := re_match(...).
+		deprecated := func(op ast.Ref, loc *ast.Location) {
+			if advice, ok := deprecatedBuiltins[op.String()]; ok && loc != nil {
+				m.Findings = append(m.Findings, regoFinding{Line: loc.Row, Severity: "warning",
+					Message: fmt.Sprintf("%s is deprecated: %s", op, advice)})
+			}
+		}
+		ast.NewGenericVisitor(func(x any) bool {
+			switch v := x.(type) {
+			case *ast.Expr:
+				if v.IsCall() {
+					deprecated(v.Operator(), v.Location)
+				}
+			case *ast.Term:
+				if call, ok := v.Value.(ast.Call); ok {
+					if op, ok := call[0].Value.(ast.Ref); ok {
+						deprecated(op, v.Location)
+					}
+				}
+			}
+			return false
+		}).Walk(parsed)
+		if line := firstObjectRead(parsed); !guarded && line > 0 {
+			m.Findings = append(m.Findings, regoFinding{Line: line, Severity: "info",
+				Message: "input.review.object is read without a check on the request: a DELETE, if the webhook is sent them, has no object"})
+		}
+		sort.SliceStable(m.Findings, func(a, b int) bool {
+			if m.Findings[a].Line != m.Findings[b].Line {
+				return m.Findings[a].Line < m.Findings[b].Line
+			}
+			return m.Findings[a].Message < m.Findings[b].Message
+		})
+	}
+}
+
+// lintTemplate lints every Rego module of a template and hangs them on the code entries they come
+// from, each with an anchor prefix made from the template's kind.
+func lintTemplate(t *ssrConstraintTemplate) {
+	var parsed []*ast.Module
+	for _, target := range t.Targets {
+		for _, code := range target.Code {
+			if code.Engine != "Rego" {
+				continue
+			}
+			for _, source := range append([]string{code.Rego}, code.Libs...) {
+				// A module that does not parse has its errors reported where it is linted.
+				if m, err := parseRego("template.rego", source, code.RegoVersion()); source != "" && err == nil {
+					parsed = append(parsed, m)
+				}
+			}
+		}
+	}
+	used := usedNames(parsed)
+	guarded := slices.ContainsFunc(parsed, guardsReview)
+
+	n := 0
+	for ti := range t.Targets {
+		for ci := range t.Targets[ti].Code {
+			code := &t.Targets[ti].Code[ci]
+			if code.Engine != "Rego" {
+				continue
+			}
+			code.Modules = nil
+			if code.Rego != "" {
+				code.Modules = append(code.Modules, regoModule{Source: code.Rego})
+			}
+			for _, lib := range code.Libs {
+				code.Modules = append(code.Modules, regoModule{Source: lib, Lib: true})
+			}
+			ptrs := make([]*regoModule, len(code.Modules))
+			for i := range code.Modules {
+				n++
+				code.Modules[i].ID = fmt.Sprintf("%s-rego-%d", t.Kind, n)
+				ptrs[i] = &code.Modules[i]
+			}
+			lintRegoModules(ptrs, code.RegoVersion(), used, guarded)
+		}
+	}
+}
+
+// Findings counts a template's findings by severity, in regoSeverities order.
+func (t ssrConstraintTemplate) Findings() []int {
+	counts := make([]int, len(regoSeverities))
+	for _, target := range t.Targets {
+		for _, code := range target.Code {
+			for _, m := range code.Modules {
+				for _, f := range m.Findings {
+					counts[slices.Index(regoSeverities, f.Severity)]++
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// ssrLintSummary is one row of the Constraint Templates view's Rego analysis table.
+type ssrLintSummary struct {
+	Kind     string
+	Severity string // the worst severity of its findings
+	Counts   string // "2 errors, 1 warning"
+}
+
+// lintSummaries lists the templates with findings, the worst first, and by kind within a severity.
+func lintSummaries(templates []ssrConstraintTemplate) []ssrLintSummary {
+	type row struct {
+		ssrLintSummary
+		rank int
+	}
+	var rows []row
+	for _, t := range templates {
+		counts := t.Findings()
+		r := row{ssrLintSummary: ssrLintSummary{Kind: t.Kind}, rank: -1}
+		var parts []string
+		for i, c := range counts {
+			if c == 0 {
+				continue
+			}
+			if r.rank < 0 {
+				r.rank, r.Severity = i, regoSeverities[i]
+			}
+			parts = append(parts, plural(c, regoSeverities[i], regoSeverities[i]+"s"))
+		}
+		if r.rank < 0 {
+			continue
+		}
+		r.Counts = strings.Join(parts, ", ")
+		rows = append(rows, r)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].rank != rows[j].rank {
+			return rows[i].rank < rows[j].rank
+		}
+		return rows[i].Kind < rows[j].Kind
+	})
+	out := make([]ssrLintSummary, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, r.ssrLintSummary)
+	}
+	return out
+}
+
+// annotatedRego highlights a module as highlight does, with the lines that have a finding marked
+// and every line number an anchor, <id>-L<n>, for the findings listed under it to link to. It is
+// as safe as highlight for the same reason: chroma escapes the source.
+func annotatedRego(m regoModule) template.HTML {
+	var ranges [][2]int
+	for _, f := range m.Findings {
+		if f.Line > 0 {
+			ranges = append(ranges, [2]int{f.Line, f.Line})
+		}
+	}
+	formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithLineNumbers(true),
+		chromahtml.WithLinkableLineNumbers(true, m.ID+"-L"), chromahtml.HighlightLines(ranges))
+	iterator, err := chroma.Coalesce(lexers.Get("rego")).Tokenise(nil, m.Source)
+	if err != nil {
+		return template.HTML(template.HTMLEscapeString(m.Source))
+	}
+	var buf bytes.Buffer
+	if err := formatter.Format(&buf, styles.Fallback, iterator); err != nil {
+		return template.HTML(template.HTMLEscapeString(m.Source))
+	}
+	return template.HTML(buf.String())
+}
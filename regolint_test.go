@@ -0,0 +1,181 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The gatekeeper-library's required labels template, which the lint should take as written.
+const requiredLabelsRego = `package k8srequiredlabels
+
+get_message(parameters, _default) := _default {
+  not parameters.message
+}
+
+get_message(parameters, _) := parameters.message
+
+violation[{"msg": msg, "details": {"missing_labels": missing}}] {
+  provided := {label | input.review.object.metadata.labels[label]}
+  required := {label | label := input.parameters.labels[_].key}
+  missing := required - provided
+  count(missing) > 0
+  def_msg := sprintf("you must provide labels: %v", [missing])
+  msg := get_message(input.parameters, def_msg)
+}
+
+violation[{"msg": msg}] {
+  value := input.review.object.metadata.labels[key]
+  expected := input.parameters.labels[_]
+  expected.key == key
+  # do not match if allowedRegex is not defined, or is an empty string
+  expected.allowedRegex != ""
+  not re_match(expected.allowedRegex, value)
+  msg := sprintf("Label <%v: %v> does not satisfy allowed regex: %v", [key, value, expected.allowedRegex])
+}
+`
+
+// findings lints a template and flattens its findings, "<line> <severity>: <message>".
+func findings(t ssrConstraintTemplate) []string {
+	lintTemplate(&t)
+	var out []string
+	for _, target := range t.Targets {
+		for _, code := range target.Code {
+			for _, m := range code.Modules {
+				for _, f := range m.Findings {
+					out = append(out, fmt.Sprintf("%d %s: %s", f.Line, f.Severity, f.Message))
+				}
+			}
+		}
+	}
+	return out
+}
+
+func TestLintRegoLibraryTemplate(t *testing.T) {
+	got := findings(regoTemplate("K8sRequiredLabels", requiredLabelsRego))
+	want := []string{
+		"10 info: input.review.object is read without a check on the request: a DELETE, if the webhook is sent them, has no object",
+		"24 warning: re_match is deprecated: use regex.match",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLintRegoFindings(t *testing.T) {
+	for _, c := range []struct {
+		name, rego string
+		libs       []string
+		want       []string
+	}{{
+		name: "no violation rule",
+		rego: "package x\n\ndeny[msg] {\n  msg := \"no\"\n}\n",
+		want: []string{
+			"0 error: the module has no violation rule, so Gatekeeper finds no violations in it",
+			"3 info: the rule deny is not used",
+		},
+	}, {
+		name: "unbalanced brackets",
+		rego: "package x\n\nviolation[{\"msg\": msg}] {\n  msg := concat(\",\", [\"a\", \"b\"]\n}\n",
+		want: []string{`5 error: unexpected } token: expected "," or ")"`},
+	}, {
+		name: "brackets in strings and comments",
+		rego: "package x\n\n# a stray ) in a comment\nviolation[{\"msg\": msg}] {\n  msg := \"a { and a \\\" quote\"\n  input.review.operation != \"DELETE\"\n}\n",
+	}, {
+		name: "missing package",
+		rego: "violation[{\"msg\": \"x\"}] { true }\n",
+		want: []string{"1 error: package expected"},
+	}, {
+		name: "lib rules used from the rules module",
+		rego: "package x\n\nimport data.lib.helpers\n\nviolation[{\"msg\": msg}] {\n  helpers.missing(input.parameters)\n  msg := \"x\"\n}\n",
+		libs: []string{"package lib.helpers\n\nmissing(p) {\n  not p.labels\n}\n\nunused_helper := true\n"},
+		want: []string{"7 info: the rule unused_helper is not used"},
+	}, {
+		name: "a guard in a lib covers the rules module",
+		rego: "package x\n\nviolation[{\"msg\": \"x\"}] {\n  data.lib.ops.not_delete\n  input.review.object.spec\n}\n",
+		libs: []string{"package lib.ops\n\nnot_delete {\n  input.review.operation != \"DELETE\"\n}\n"},
+	}, {
+		name: "deprecated calls, not rules named like them",
+		rego: "package x\n\nviolation[{\"msg\": \"x\"}] {\n  any([true])\n  input.parameters.all\n  net.cidr_overlap(\"10.0.0.0/8\", \"10.1.0.0/16\")\n  diff := set_diff({1}, {2})\n  not input.review.object\n}\n",
+		want: []string{
+			"4 warning: any is deprecated: use a comprehension or some ... in",
+			"6 warning: net.cidr_overlap is deprecated: use net.cidr_contains",
+			"7 warning: set_diff is deprecated: use the - operator",
+		},
+	}} {
+		got := findings(regoTemplate("K8sTest", c.rego, c.libs...))
+		if strings.Join(got, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("%s: findings =\n%s\nwant\n%s", c.name, strings.Join(got, "\n"), strings.Join(c.want, "\n"))
+		}
+	}
+}
+
+func TestLintSummariesWorstFirst(t *testing.T) {
+	templates := []ssrConstraintTemplate{
+		regoTemplate("K8sClean", "package x\n\nviolation[{\"msg\": \"x\"}] {\n  input.review.operation == \"CREATE\"\n}\n"),
+		regoTemplate("K8sInfo", "package x\n\nviolation[{\"msg\": \"x\"}] {\n  true\n}\n\nunused := 1\n"),
+		regoTemplate("K8sBroken", "package x\n\nviolation[{\"msg\": \"x\"}] {\n"),
+		regoTemplate("K8sAlsoBroken", "package x\n"),
+		regoTemplate("K8sRequiredLabels", requiredLabelsRego),
+	}
+	for i := range templates {
+		lintTemplate(&templates[i])
+	}
+	var got []string
+	for _, s := range lintSummaries(templates) {
+		got = append(got, s.Kind+" "+s.Severity+" "+s.Counts)
+	}
+	want := []string{
+		"K8sAlsoBroken error 1 error",
+		"K8sBroken error 1 error",
+		"K8sRequiredLabels warning 1 warning, 1 info",
+		"K8sInfo info 1 info",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("summaries =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAnnotatedRegoMarksTheLines(t *testing.T) {
+	ct := regoTemplate("K8sRequiredLabels", requiredLabelsRego)
+	lintTemplate(&ct)
+	m := ct.Targets[0].Code[0].Modules[0]
+	if m.ID != "K8sRequiredLabels-rego-1" {
+		t.Fatalf("module id = %q", m.ID)
+	}
+	out := string(annotatedRego(m))
+	for _, want := range []string{`id="K8sRequiredLabels-rego-1-L24"`, `class="line hl"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("annotated source missing %q", want)
+		}
+	}
+	if strings.Count(out, `class="line hl"`) != 2 {
+		t.Errorf("want the two lines with findings marked, got %d", strings.Count(out, `class="line hl"`))
+	}
+}
+
+func TestConstraintTemplatesRenderLint(t *testing.T) {
+	templates := []ssrConstraintTemplate{regoTemplate("K8sRequiredLabels", requiredLabelsRego)}
+	lintTemplate(&templates[0])
+	data := map[string]any{"Layout": minimalLayout(), "Templates": templates, "Lint": lintSummaries(templates)}
+	var buf bytes.Buffer
+	if err := newSSRRenderer().pages["constrainttemplates"].ExecuteTemplate(&buf, "layout", data); err != nil {
+		t.Fatalf("constrainttemplates render failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`id="rego-analysis"`,
+		`<td><a href="#K8sRequiredLabels">K8sRequiredLabels</a></td>`,
+		`<a href="#K8sRequiredLabels-rego-1-L24">line 24</a> · warning: re_match is deprecated: use regex.match`,
+		`<details class="field" open>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("constrainttemplates output missing %q", want)
+		}
+	}
+}
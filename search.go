@@ -0,0 +1,348 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The Search view. "Every policy that mentions hostPath" spans four views: the templates' Rego,
+// the Constraints' parameters, the violations and the events. The view reads them all for the
+// selected context, or for every context, and lists what matches grouped by type, each linking to
+// its card in the view that has it.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The groups of the results, in the order the view lists them.
+var searchGroups = []string{"Constraint Templates", "Constraints", "Violations", "Mutators", "Events"}
+
+// How many results of a group the view lists; the count says how many more there are.
+const searchGroupLimit = 50
+
+// ssrSearchResult is one thing that matched.
+type ssrSearchResult struct {
+	Title   string
+	Detail  string // the kind, or for a violation the Constraint that reports it
+	Context string // the kubeconfig context, when the search covers them all
+	URL     string
+	Field   string // where the query matched: "Rego", "parameters", "message"
+	Snippet string
+}
+
+// ssrSearchGroup is one group of the results.
+type ssrSearchGroup struct {
+	Name    string
+	Total   int
+	Results []ssrSearchResult
+}
+
+// searchField is one field of a searchDoc, by the name the result shows.
+type searchField struct {
+	name string
+	text string
+}
+
+// searchDoc is one thing the search reads: its fields, and the result it makes.
+type searchDoc struct {
+	group  string
+	result ssrSearchResult
+	fields []searchField
+}
+
+// match reports whether every term of the query is in some field of the doc. The result names the
+// first field that has the first term, with the text around it.
+func (d searchDoc) match(terms []string) (ssrSearchResult, bool) {
+	lower := make([]string, len(d.fields))
+	for i, f := range d.fields {
+		lower[i] = strings.ToLower(f.text)
+	}
+	for _, t := range terms {
+		found := false
+		for _, l := range lower {
+			if strings.Contains(l, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ssrSearchResult{}, false
+		}
+	}
+	r := d.result
+	for i, l := range lower {
+		if !strings.Contains(l, terms[0]) {
+			continue
+		}
+		if at, n := lowerIndex(d.fields[i].text, terms[0]); at >= 0 {
+			r.Field = d.fields[i].name
+			r.Snippet = searchSnippet(d.fields[i].text, at, n)
+			break
+		}
+	}
+	return r, true
+}
+
+// lowerIndex finds a lower-cased term in text, and returns where the match starts in text and how
+// many bytes of text it covers, or -1. Lowering a rune can change its length, so an offset into
+// strings.ToLower(text) is not an offset into text: the match is made rune by rune on text itself,
+// lowering each rune as strings.ToLower does.
+func lowerIndex(text, term string) (at, n int) {
+	for at = range text {
+		n = 0
+		matched := true
+		for _, want := range term {
+			if at+n >= len(text) {
+				matched = false
+				break
+			}
+			r, size := utf8.DecodeRuneInString(text[at+n:])
+			if unicode.ToLower(r) != want {
+				matched = false
+				break
+			}
+			n += size
+		}
+		if matched {
+			return at, n
+		}
+	}
+	return -1, 0
+}
+
+// searchSnippet is the text around a match, on one line, cut at about 60 characters each side.
+func searchSnippet(text string, at, n int) string {
+	const around = 60
+	start, end := max(0, at-around), min(len(text), at+n+around)
+	// Cut on a rune boundary.
+	for start > 0 && start < len(text) && text[start]&0xC0 == 0x80 {
+		start--
+	}
+	for end < len(text) && text[end]&0xC0 == 0x80 {
+		end++
+	}
+	s := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
+
+// searchTerms splits a query into the lower-cased words every result must contain.
+func searchTerms(q string) []string {
+	return strings.Fields(strings.ToLower(q))
+}
+
+// viewURL is a view's path under a context, with an anchor when one is given.
+func viewURL(kubeContext, path, anchor string) string {
+	if kubeContext != "" {
+		path += "/" + url.PathEscape(kubeContext)
+	}
+	if anchor != "" {
+		path += "#" + anchor
+	}
+	return browserPath(path)
+}
+
+// searchDocs reads what the search covers in one context. A source that cannot be read costs its
+// results, and comes back in skipped; the others are still searched.
+func searchDocs(ctx context.Context, clients *kubeClients, kubeContext string) (docs []searchDoc, skipped []string) {
+	cts, err := getCustomResources(ctx, *clients.dynamic, "templates.gatekeeper.sh", "v1", "constrainttemplates")
+	if err != nil {
+		slog.Warn("SSR search: reading constraint templates failed", "context", kubeContext, "error", err)
+		skipped = append(skipped, "Constraint Templates")
+	} else {
+		for _, ct := range cts.Items {
+			// Read as the search needs them rather than through the view's model, which lints the Rego.
+			name := ct.GetName()
+			kind, _, _ := unstructured.NestedString(ct.Object, "spec", "crd", "spec", "names", "kind")
+			targets, _, _ := unstructured.NestedSlice(ct.Object, "spec", "targets")
+			var rego []string
+			var sources []searchField
+			for _, target := range targets {
+				tm, ok := target.(map[string]any)
+				if !ok {
+					continue
+				}
+				for _, code := range templateCode(tm) {
+					if code.Rego != "" {
+						rego = append(rego, code.Rego)
+					}
+					rego = append(rego, code.Libs...)
+					if code.Source != nil {
+						sources = append(sources, searchField{code.Engine, toYAML(code.Source)})
+					}
+				}
+			}
+			d := searchDoc{group: "Constraint Templates",
+				result: ssrSearchResult{Title: kind, Detail: "ConstraintTemplate " + name, URL: viewURL(kubeContext, "/constrainttemplates", kind)},
+				fields: []searchField{{"name", kind + " " + name}, {"description", ct.GetAnnotations()["description"]}, {"Rego", strings.Join(rego, "\n")}}}
+			d.fields = append(d.fields, sources...)
+			docs = append(docs, d)
+		}
+	}
+
+	constraints, err := listConstraints(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR search: reading constraints failed", "context", kubeContext, "error", err)
+		skipped = append(skipped, "Constraints")
+	}
+	sortConstraints(constraints)
+	for _, o := range constraints {
+		m := ssrConstraintModel(o)
+		link := viewURL(kubeContext, "/constraints", constraintAnchor(m.Kind, m.Name))
+		d := searchDoc{group: "Constraints",
+			result: ssrSearchResult{Title: m.Name, Detail: m.Kind, URL: link},
+			fields: []searchField{{"name", m.Kind + " " + m.Name}, {"description", m.Description}}}
+		if m.Parameters != nil {
+			d.fields = append(d.fields, searchField{"parameters", toYAML(m.Parameters)})
+		}
+		if m.Match != nil {
+			d.fields = append(d.fields, searchField{"match", toYAML(m.Match)})
+		}
+		docs = append(docs, d)
+		for _, v := range m.Violations {
+			resource := v.Kind + " " + strings.TrimPrefix(v.Namespace+"/"+v.Name, "/")
+			docs = append(docs, searchDoc{group: "Violations",
+				result: ssrSearchResult{Title: resource, Detail: m.Kind + " " + m.Name, URL: link},
+				fields: []searchField{{"message", v.Message}, {"resource", resource}}})
+		}
+	}
+
+	mutators, err := listGroup(ctx, clients, "mutations.gatekeeper.sh")
+	if err != nil {
+		slog.Warn("SSR search: reading mutators failed", "context", kubeContext, "error", err)
+		skipped = append(skipped, "Mutators")
+	}
+	for _, o := range mutators {
+		u := unstructured.Unstructured{Object: o}
+		spec, _, _ := unstructured.NestedMap(o, "spec")
+		docs = append(docs, searchDoc{group: "Mutators",
+			result: ssrSearchResult{Title: u.GetName(), Detail: u.GetKind(), URL: viewURL(kubeContext, "/mutations", u.GetName())},
+			fields: []searchField{{"name", u.GetKind() + " " + u.GetName()}, {"spec", toYAML(spec)}}})
+	}
+
+	// The most recent page of events, as the Events view reads it, unfiltered.
+	query := eventQuery{Sources: settingList("events_source"), Now: time.Now(), PageSize: eventPageSizes[len(eventPageSizes)-1]}
+	page, err := getKubernetesEvents(ctx, *clients.dynamic, eventsResource(clients.discovery), settingList("events_namespace"), query)
+	if err != nil {
+		slog.Warn("SSR search: reading events failed", "context", kubeContext, "error", err)
+		skipped = append(skipped, "Events")
+	}
+	for _, e := range page.Events {
+		link := viewURL(kubeContext, "/events", "")
+		if e.ConstraintName != "" {
+			link += "?" + url.Values{"constraint": {e.ConstraintName}}.Encode()
+		}
+		resource := e.ResourceKind + " " + strings.TrimPrefix(e.ResourceNamespace+"/"+e.ResourceName, "/")
+		docs = append(docs, searchDoc{group: "Events",
+			result: ssrSearchResult{Title: e.Reason, Detail: strings.TrimSpace(resource + " · " + e.LastTimestamp), URL: link},
+			fields: []searchField{{"message", e.Message}, {"resource", resource}, {"constraint", e.ConstraintKind + " " + e.ConstraintName}}})
+	}
+	return docs, skipped
+}
+
+// searchResults runs the query over the docs and groups what matches, in searchGroups order. A
+// group with no results is left out.
+func searchResults(docs []searchDoc, terms []string) []ssrSearchGroup {
+	byGroup := map[string]*ssrSearchGroup{}
+	for _, d := range docs {
+		r, ok := d.match(terms)
+		if !ok {
+			continue
+		}
+		g := byGroup[d.group]
+		if g == nil {
+			g = &ssrSearchGroup{Name: d.group}
+			byGroup[d.group] = g
+		}
+		g.Total++
+		if len(g.Results) < searchGroupLimit {
+			g.Results = append(g.Results, r)
+		}
+	}
+	var out []ssrSearchGroup
+	for _, name := range searchGroups {
+		if g := byGroup[name]; g != nil {
+			out = append(out, *g)
+		}
+	}
+	return out
+}
+
+// getSearch renders the Search view. Without a query it is only the form.
+func (s *server) getSearch(c echo.Context) error {
+	layout := s.ssrLayoutData(c, "search", "/search", "Search")
+
+	q := strings.TrimSpace(c.QueryParam("q"))
+	all := c.QueryParam("all") == "1"
+	data := map[string]any{"Layout": layout, "Query": q, "All": all}
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return s.ssr.render(c, "search", data)
+	}
+
+	if !all {
+		clients, err := s.clientsFor(c)
+		if err != nil {
+			slog.Error("SSR search: resolving context failed", "error", err)
+			setViewError(data, "GPM could not switch to the requested Kubernetes context. Make sure the kubeconfig defines it correctly.", err)
+			return s.ssr.render(c, "search", data)
+		}
+		docs, skipped := searchDocs(c.Request().Context(), clients, c.Param("context"))
+		data["Groups"] = searchResults(docs, terms)
+		data["Skipped"] = skipped
+		return s.ssr.render(c, "search", data)
+	}
+
+	// Every context, concurrently, each under the dashboard's timeout.
+	names, _ := s.fleetContexts()
+	docs := make([][]searchDoc, len(names))
+	skipped := make([][]string, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients, err := s.k8s.forContext(name)
+			if err != nil {
+				slog.Warn("SSR search: resolving cluster failed", "cluster", name, "error", err)
+				skipped[i] = []string{clusterLabel(name) + ": the context does not resolve"}
+				return
+			}
+			ctx, cancel := context.WithTimeout(c.Request().Context(), clusterFetchTimeout)
+			defer cancel()
+			found, missed := searchDocs(ctx, clients, name)
+			for j := range found {
+				found[j].result.Context = clusterLabel(name)
+			}
+			docs[i] = found
+			for _, m := range missed {
+				skipped[i] = append(skipped[i], fmt.Sprintf("%s: %s", clusterLabel(name), m))
+			}
+		}()
+	}
+	wg.Wait()
+
+	var merged []searchDoc
+	var missed []string
+	for i := range names {
+		merged = append(merged, docs[i]...)
+		missed = append(missed, skipped[i]...)
+	}
+	data["Groups"] = searchResults(merged, terms)
+	data["Skipped"] = missed
+	return s.ssr.render(c, "search", data)
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSearchDocMatch(t *testing.T) {
+	d := searchDoc{
+		result: ssrSearchResult{Title: "K8sPSPHostFilesystem"},
+		fields: []searchField{
+			{"name", "K8sPSPHostFilesystem k8spsphostfilesystem"},
+			{"Rego", "package k8spsphostfilesystem\n\nviolation[{\"msg\": msg}] {\n  volume := input_hostpath_volumes[_]\n  not input_hostpath_allowed(volume)\n}\n"},
+		},
+	}
+	r, ok := d.match(searchTerms("HostPath allowed"))
+	if !ok {
+		t.Fatal("every word is in the doc, want a match")
+	}
+	if r.Field != "Rego" || !strings.Contains(r.Snippet, "input_hostpath_volumes[_]") || strings.Contains(r.Snippet, "\n") {
+		t.Errorf("result = %+v, want the Rego line on one line", r)
+	}
+	if _, ok := d.match(searchTerms("hostpath seccomp")); ok {
+		t.Error("a word the doc does not have, want no match")
+	}
+}
+
+func TestSearchSnippetCutsLongText(t *testing.T) {
+	text := strings.Repeat("a ", 100) + "needle" + strings.Repeat(" b", 100)
+	got := searchSnippet(text, strings.Index(text, "needle"), len("needle"))
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "needle") || len(got) > 140 {
+		t.Errorf("snippet = %q", got)
+	}
+	if got := searchSnippet("needle", 0, 6); got != "needle" {
+		t.Errorf("short snippet = %q", got)
+	}
+}
+
+// Lowering some runes changes their length in bytes, so the snippet must come from where the match
+// is in the text itself.
+func TestSearchSnippetAfterRunesThatLowerToAnotherLength(t *testing.T) {
+	for _, c := range []struct{ text, want string }{
+		// Ⱥ is two bytes, and three lowered.
+		{strings.Repeat("Ⱥ", 100) + "hostPath", "hostPath"},
+		// İ is two bytes, and one lowered.
+		{strings.Repeat("İ", 100) + " hostPath", "İ hostPath"},
+	} {
+		d := searchDoc{fields: []searchField{{"message", c.text}}}
+		r, ok := d.match(searchTerms("hostpath"))
+		if !ok || !strings.HasPrefix(r.Snippet, "…") || !strings.HasSuffix(r.Snippet, c.want) || !utf8.ValidString(r.Snippet) {
+			t.Errorf("snippet = %q, %v; want it to end with %q", r.Snippet, ok, c.want)
+		}
+	}
+	if at, n := lowerIndex("xİx", "i"); at != 1 || n != 2 {
+		t.Errorf("lowerIndex = %d, %d; want the two bytes of İ", at, n)
+	}
+}
+
+func TestSearchResultsGroupAndCap(t *testing.T) {
+	var docs []searchDoc
+	for range searchGroupLimit + 5 {
+		docs = append(docs, searchDoc{group: "Violations", fields: []searchField{{"message", "hostPath volumes are not allowed"}}})
+	}
+	docs = append(docs,
+		searchDoc{group: "Events", fields: []searchField{{"message", "hostPath denied"}}},
+		searchDoc{group: "Constraint Templates", fields: []searchField{{"Rego", "hostPath"}}},
+		searchDoc{group: "Mutators", fields: []searchField{{"spec", "nothing here"}}},
+	)
+	groups := searchResults(docs, searchTerms("hostpath"))
+	if len(groups) != 3 || groups[0].Name != "Constraint Templates" || groups[1].Name != "Violations" || groups[2].Name != "Events" {
+		t.Fatalf("groups = %+v", groups)
+	}
+	if groups[1].Total != searchGroupLimit+5 || len(groups[1].Results) != searchGroupLimit {
+		t.Errorf("violations total %d, listed %d", groups[1].Total, len(groups[1].Results))
+	}
+}
+
+func TestSearchViewLinksToTheCards(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.respondAt("/apis/templates.gatekeeper.sh/v1/constrainttemplates", `{"apiVersion":"templates.gatekeeper.sh/v1",`+
+		`"kind":"ConstraintTemplateList","metadata":{},"items":[`+constraintTemplateJSON(t, "K8sPSPHostFilesystem",
+		"package k8spsphostfilesystem\nviolation[{\"msg\": \"no hostPath\"}] { input.review.object.spec.volumes[_].hostPath }")+`]}`)
+	api.serveConstraints("K8sPSPHostFilesystem",
+		`{"kind":"K8sPSPHostFilesystem","metadata":{"name":"no-host"},`+
+			`"spec":{"parameters":{"allowedHostPaths":[{"pathPrefix":"/tmp"}]}},"status":{"totalViolations":1,"violations":[`+
+			`{"enforcementAction":"deny","kind":"Pod","namespace":"default","name":"logger","message":"HostPath volume /var/log is not allowed"}]}}`)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/search?q=hostpath", nil)
+	if err := s.getSearch(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`<a href="/constrainttemplates#K8sPSPHostFilesystem">K8sPSPHostFilesystem</a>`,
+		`<a href="/constraints#` + constraintAnchor("K8sPSPHostFilesystem", "no-host") + `">no-host</a>`,
+		`<a href="/constraints#` + constraintAnchor("K8sPSPHostFilesystem", "no-host") + `">Pod default/logger</a>`,
+		"HostPath volume /var/log is not allowed",
+		`<h2>Violations</h2>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("search output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "<h2>Mutators</h2>") || strings.Contains(out, "could not read everything") {
+		t.Error("no mutators match and every source is readable")
+	}
+}
@@ -20,11 +20,13 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 	"log/slog"
 
 	"github.com/alecthomas/chroma/v2"
@@ -32,6 +34,7 @@ import (
 	"github.com/alecthomas/chroma/v2/lexers"
 	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/spf13/viper"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
@@ -39,6 +42,7 @@ import (
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/yaml"
 )
@@ -55,11 +59,21 @@ var ssrStaticFS embed.FS
 var ssrPages = map[string]string{
 	"home":                "templates/ssr/home.html.gotpl",
 	"configurations":      "templates/ssr/configurations.html.gotpl",
+	"exemptions":          "templates/ssr/exemptions.html.gotpl",
 	"mutations":           "templates/ssr/mutations.html.gotpl",
+	"mutationpreview":     "templates/ssr/mutationpreview.html.gotpl",
+	"expansion":           "templates/ssr/expansion.html.gotpl",
+	"providers":           "templates/ssr/providers.html.gotpl",
 	"constrainttemplates": "templates/ssr/constrainttemplates.html.gotpl",
 	"constraints":         "templates/ssr/constraints.html.gotpl",
 	"resources":           "templates/ssr/resources.html.gotpl",
+	"object":              "templates/ssr/object.html.gotpl",
+	"namespace":           "templates/ssr/namespace.html.gotpl",
+	"teams":               "templates/ssr/teams.html.gotpl",
+	"drift":               "templates/ssr/drift.html.gotpl",
+	"bundle":              "templates/ssr/bundle.html.gotpl",
 	"events":              "templates/ssr/events.html.gotpl",
+	"search":              "templates/ssr/search.html.gotpl",
 	"error":               "templates/ssr/error.html.gotpl",
 	"notfound":            "templates/ssr/notfound.html.gotpl",
 	"loggedout":           "templates/ssr/loggedout.html.gotpl",
@@ -85,6 +99,10 @@ func newSSRRenderer() *ssrRenderer {
 		"podSummary":  podSummary,
 		// constraintAnchor keeps the card id, the sidebar link and every cross-link in step.
 		"constraintAnchor": constraintAnchor,
+		// targetKey finds a mutator's targeting on the Mutations view.
+		"targetKey": targetKey,
+		// annotatedRego is highlight for a linted Rego module; see regolint.go.
+		"annotatedRego": annotatedRego,
 	}
 	layout := template.Must(
 		template.New("layout").Funcs(funcs).ParseFS(ssrTemplateFS, "templates/ssr/layout.html.gotpl"),
@@ -346,9 +364,24 @@ var ssrNavRoutes = []struct {
 	{"constrainttemplates", "Templates", "/constrainttemplates"},
 	{"constraints", "Constraints", "/constraints"},
 	{"resources", "Resources", "/resources"},
+	{"teams", "Teams", "/teams"},
 	{"mutations", "Mutations", "/mutations"},
+	{"expansion", "Expansion", "/expansion"},
+	{"providers", "Providers", "/providers"},
 	{"events", "Events", "/events"},
 	{"configurations", "Configurations", "/configurations"},
+	{"exemptions", "Exemptions", "/exemptions"},
+	{"search", "Search", "/search"},
+	{"drift", "Drift", "/drift"},
+	{"bundle", "Bundle", "/bundle"},
+}
+
+// contextPath is a view's path under the context the request names, for a link that keeps it.
+func contextPath(c echo.Context, path string) string {
+	if name := c.Param("context"); name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return browserPath(path)
 }
 
 // Builds the data every SSR page shares: nav with the active item highlighted, the context switcher
@@ -400,7 +433,8 @@ func (s *server) ssrLayoutData(c echo.Context, active, switchBase, title string)
 // --- handlers -------------------------------------------------------------------------------
 
 // getConfigurations renders the Configurations view. It reads the very same Gatekeeper Config
-// objects as the JSON handler getConfigs, then hands them to the template instead of to c.JSON.
+// objects as the JSON handler getConfigs, then hands them to the template instead of to c.JSON,
+// together with the SyncSets and what the pods report about the kinds the two replicate.
 func (s *server) getConfigurations(c echo.Context) error {
 	layout := s.ssrLayoutData(c, "configurations", "/configurations", "Configurations")
 
@@ -413,7 +447,8 @@ func (s *server) getConfigurations(c echo.Context) error {
 		return s.ssr.render(c, "configurations", data)
 	}
 
-	configResources, err := getCustomResources(c.Request().Context(), *clients.dynamic,
+	ctx := c.Request().Context()
+	configResources, err := getCustomResources(ctx, *clients.dynamic,
 		"config.gatekeeper.sh", "v1alpha1", "configs")
 	if err != nil {
 		slog.Error("SSR configurations: getting config resources failed", "error", err)
@@ -425,17 +460,45 @@ func (s *server) getConfigurations(c echo.Context) error {
 	for i := range configResources.Items {
 		items = append(items, configResources.Items[i].Object)
 	}
+
+	// The SyncSets add to what the Config syncs. Without all of them the referential data check
+	// would flag kinds a missing SyncSet may well sync, so it is left out.
+	syncSets, err := listGroup(ctx, clients, syncSetGroup)
+	syncSetsComplete := err == nil
+	if err != nil {
+		slog.Error("SSR configurations: getting syncsets failed", "error", err)
+	}
+	sort.Slice(syncSets, func(i, j int) bool {
+		return (&unstructured.Unstructured{Object: syncSets[i]}).GetName() < (&unstructured.Unstructured{Object: syncSets[j]}).GetName()
+	})
+
+	served := servedKinds{client: clients.discovery, lists: map[string]*metav1.APIResourceList{}}
+	models := make([]ssrSyncSet, 0, len(syncSets))
+	for _, o := range syncSets {
+		models = append(models, ssrSyncSetModel(o, &served))
+	}
+	expected := maxPodCount(append(slices.Clone(items), syncSets...))
+
 	data["Configs"] = items
+	data["SyncSets"] = models
+	data["Synced"] = syncedKinds(items, syncSets, expected, &served)
+	data["ExpectedPods"] = expected
+	if syncSetsComplete {
+		data["Inventory"] = templateInventoryAnalysis(ctx, clients, syncEntriesOf(items, syncSets))
+	}
+	data["TemplatesURL"] = contextPath(c, "/constrainttemplates")
 	return s.ssr.render(c, "configurations", data)
 }
 
-// getMutations renders the Mutations view. It reads the very same Gatekeeper mutator objects as
-// the JSON handler getMutations (assign, assignmetadata, modifyset, assignimage under
-// mutations.gatekeeper.sh/v1), then hands them to the template instead of to c.JSON.
+// getMutations renders the Mutations view: every mutator object of every kind the
+// mutations.gatekeeper.sh group serves, at the version the cluster prefers.
 func (s *server) getMutations(c echo.Context) error {
 	layout := s.ssrLayoutData(c, "mutations", "/mutations", "Mutations")
 
 	data := map[string]any{"Layout": layout}
+	if viper.GetBool("mutation_preview") {
+		data["PreviewURL"] = contextPath(c, "/mutation-preview")
+	}
 
 	clients, err := s.clientsFor(c)
 	if err != nil {
@@ -444,66 +507,133 @@ func (s *server) getMutations(c echo.Context) error {
 		return s.ssr.render(c, "mutations", data)
 	}
 
-	// Mutators are well-known; the same list the JSON handler uses. A missing kind just means no
-	// such mutations are defined, so we log and continue rather than failing the whole page.
-	mutators := []string{"assign", "assignmetadata", "modifyset", "assignimage"}
-	items := make([]map[string]any, 0)
-	for _, mutator := range mutators {
-		mutations, err := getCustomResources(c.Request().Context(), *clients.dynamic,
-			"mutations.gatekeeper.sh", "v1", mutator)
-		if err != nil {
-			slog.Error("SSR mutations: getting mutator resources failed", "mutator", mutator, "error", err)
-			continue
-		}
-		for i := range mutations.Items {
-			items = append(items, mutations.Items[i].Object)
-		}
+	// The mutator kinds are discovered rather than listed by hand, so a new kind, or a cluster that
+	// only serves an older version of the group, shows up without a GPM release. One kind failing to
+	// list is logged and the others still render.
+	items, err := listGroup(c.Request().Context(), clients, "mutations.gatekeeper.sh")
+	if err != nil && len(items) == 0 {
+		slog.Error("SSR mutations: getting mutator resources failed", "error", err)
+		setViewError(data, "GPM could not get the mutators from the Kubernetes API. Make sure the API is reachable and GPM can read the mutations.gatekeeper.sh group.", err)
+		return s.ssr.render(c, "mutations", data)
+	}
+	if err != nil {
+		slog.Error("SSR mutations: getting some mutator resources failed", "error", err)
 	}
 	data["Mutations"] = items
 	data["ExpectedPods"] = maxPodCount(items)
+	targets := mutatorTargets(c.Request().Context(), clients, items)
+	warnings := 0
+	for _, t := range targets {
+		warnings += t.Warnings()
+	}
+	data["Targets"] = targets
+	data["TargetWarnings"] = warnings
 	return s.ssr.render(c, "mutations", data)
 }
 
 // --- Constraint Templates view ---------------------------------------------------------------
 
-// ssrConstraintTemplate is the flat shape the template renders. The rego lookup (rego, or the
-// first Rego entry under code[]) and the related-constraints join are awkward in a gotpl, so the
-// handler resolves them here.
+// ssrConstraintTemplate is the flat shape the template renders. The code lookup (inline rego, then
+// every engine under code[], for every target) and the related-constraints join are awkward in a
+// gotpl, so the handler resolves them here.
 type ssrConstraintTemplate struct {
 	Name          string
 	Kind          string
 	Created       string
 	Description   string
-	Target        string
-	Rego          string
-	Libs          []string
+	Targets       []ssrTemplateTarget
 	Schema        map[string]any // openAPIV3Schema.properties; nil when the template takes no parameters
 	Constraints   []string       // names of the Constraints that use this template
 	StatusCreated bool           // status.created: Gatekeeper compiled the template into a CRD
 	Raw           map[string]any // the whole object, for the "Full YAML" details
+
+	// The external data Providers the Rego calls, and whether a call names its provider through a
+	// variable GPM cannot resolve.
+	Providers        []ssrProviderRef
+	DynamicProviders bool
+	// The kinds the Rego reads from data.inventory, and whether a lookup names its kind in a way GPM
+	// cannot resolve.
+	Inventory        []ssrInventoryRef
+	DynamicInventory bool
+
+	// The ValidatingAdmissionPolicy Gatekeeper generated from the template's CEL; nil for a Rego-only
+	// template, or one Gatekeeper generates no policy for.
+	Policy *ssrAdmissionPolicy
+
+	// The gator suite cases run against the template; nil when no suite tests it.
+	Tests *ssrTemplateTests
+}
+
+// One target of a template, such as admission.k8s.gatekeeper.sh, with its code in every engine.
+type ssrTemplateTarget struct {
+	Target string
+	Code   []ssrTemplateCode
+}
+
+// One code entry of a target: the source for one engine.
+type ssrTemplateCode struct {
+	Engine  string // "Rego", "K8sNativeValidation", or whatever else the template names
+	Rego    string
+	Libs    []string
+	Version string         // the Rego version the source declares; empty is v0
+	Source  map[string]any // any engine but Rego: the validations and variables of K8sNativeValidation
+
+	// The Rego, then each lib, with what the lint found in them; see regolint.go.
+	Modules []regoModule
 }
 
-// extractRego returns a target's inline rego, falling back to the first Rego engine entry under
-// code[] (the newer ConstraintTemplate shape), mirroring the React view.
-func extractRego(target map[string]any) string {
-	if rego, found, _ := unstructured.NestedString(target, "rego"); found && rego != "" {
-		return rego
+// RegoSources is every Rego module of the template, the rules and the libs of every target, for
+// the checks that read the Rego.
+func (t ssrConstraintTemplate) RegoSources() []string {
+	var out []string
+	for _, target := range t.Targets {
+		for _, code := range target.Code {
+			if code.Rego != "" {
+				out = append(out, code.Rego)
+			}
+			out = append(out, code.Libs...)
+		}
 	}
-	code, found, _ := unstructured.NestedSlice(target, "code")
-	if !found {
-		return ""
+	return out
+}
+
+// HasSource reports whether any target carries code in any engine.
+func (t ssrConstraintTemplate) HasSource() bool {
+	return slices.ContainsFunc(t.Targets, func(target ssrTemplateTarget) bool { return len(target.Code) > 0 })
+}
+
+// templateCode returns a target's code in every engine: the inline rego and libs first, the older
+// ConstraintTemplate shape, then each code[] entry in the order the template lists them.
+func templateCode(target map[string]any) []ssrTemplateCode {
+	var out []ssrTemplateCode
+	if rego, _, _ := unstructured.NestedString(target, "rego"); rego != "" {
+		libs, _, _ := unstructured.NestedStringSlice(target, "libs")
+		out = append(out, ssrTemplateCode{Engine: "Rego", Rego: rego, Libs: libs})
 	}
+	code, _, _ := unstructured.NestedSlice(target, "code")
 	for _, c := range code {
 		cm, ok := c.(map[string]any)
 		if !ok {
 			continue
 		}
-		if engine, _, _ := unstructured.NestedString(cm, "engine"); engine == "Rego" {
-			rego, _, _ := unstructured.NestedString(cm, "source", "rego")
-			return rego
+		entry := ssrTemplateCode{}
+		entry.Engine, _, _ = unstructured.NestedString(cm, "engine")
+		if entry.Engine == "Rego" {
+			entry.Rego, _, _ = unstructured.NestedString(cm, "source", "rego")
+			entry.Libs, _, _ = unstructured.NestedStringSlice(cm, "source", "libs")
+			entry.Version, _, _ = unstructured.NestedString(cm, "source", "version")
+			// Gatekeeper may copy the inline rego into code[]; the same module twice says nothing new,
+			// except the version, which only code[] can declare.
+			if i := slices.IndexFunc(out, func(o ssrTemplateCode) bool { return o.Engine == "Rego" && o.Rego == entry.Rego }); i >= 0 {
+				out[i].Version = entry.Version
+				continue
+			}
+		} else {
+			entry.Source, _, _ = unstructured.NestedMap(cm, "source")
 		}
+		out = append(out, entry)
 	}
-	return ""
+	return out
 }
 
 func ssrConstraintTemplateModel(ct map[string]any, related []unstructured.Unstructured) ssrConstraintTemplate {
@@ -514,13 +644,15 @@ func ssrConstraintTemplateModel(ct map[string]any, related []unstructured.Unstru
 	m.StatusCreated, _, _ = unstructured.NestedBool(ct, "status", "created")
 	m.Description, _, _ = unstructured.NestedString(ct, "metadata", "annotations", "description")
 
-	// React only ever reads the first target.
-	if targets, found, _ := unstructured.NestedSlice(ct, "spec", "targets"); found && len(targets) > 0 {
-		if t0, ok := targets[0].(map[string]any); ok {
-			m.Target, _, _ = unstructured.NestedString(t0, "target")
-			m.Rego = extractRego(t0)
-			m.Libs, _, _ = unstructured.NestedStringSlice(t0, "libs")
+	targets, _, _ := unstructured.NestedSlice(ct, "spec", "targets")
+	for _, t := range targets {
+		tm, ok := t.(map[string]any)
+		if !ok {
+			continue
 		}
+		target := ssrTemplateTarget{Code: templateCode(tm)}
+		target.Target, _, _ = unstructured.NestedString(tm, "target")
+		m.Targets = append(m.Targets, target)
 	}
 
 	if props, found, _ := unstructured.NestedMap(ct, "spec", "crd", "spec", "validation", "openAPIV3Schema", "properties"); found {
@@ -530,6 +662,7 @@ func ssrConstraintTemplateModel(ct map[string]any, related []unstructured.Unstru
 	for i := range related {
 		m.Constraints = append(m.Constraints, related[i].GetName())
 	}
+	lintTemplate(&m)
 	return m
 }
 
@@ -555,6 +688,28 @@ func (s *server) getConstraintTemplates(c echo.Context) error {
 		return s.ssr.render(c, "constrainttemplates", data)
 	}
 
+	// Which Providers exist, to mark the templates that call one that does not. Not knowing marks
+	// nothing.
+	providerItems, providersKnown := listProviders(ctx, clients)
+	providers := make([]string, 0, len(providerItems))
+	for _, p := range providerItems {
+		providers = append(providers, (&unstructured.Unstructured{Object: p}).GetName())
+	}
+
+	// What Gatekeeper syncs, to mark the templates that read a kind it does not. Not knowing marks
+	// nothing.
+	syncEntries, err := listSyncEntries(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR constraint templates: reading the sync entries failed", "error", err)
+	}
+	syncKnown := err == nil
+
+	// The policies Gatekeeper generated from CEL templates. Failing to read them costs their status.
+	policies, err := listAdmissionPolicies(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR constraint templates: reading admission policies failed", "error", err)
+	}
+
 	templates := make([]ssrConstraintTemplate, 0, len(cts.Items))
 	objects := make([]map[string]any, 0, len(cts.Items))
 	for i := range cts.Items {
@@ -567,9 +722,26 @@ func (s *server) getConstraintTemplates(c echo.Context) error {
 			slog.Debug("SSR constraint templates: getting related constraints failed", "constraintTemplate", name, "error", err)
 			constraints = &unstructured.UnstructuredList{}
 		}
-		templates = append(templates, ssrConstraintTemplateModel(cts.Items[i].Object, constraints.Items))
+		t := ssrConstraintTemplateModel(cts.Items[i].Object, constraints.Items)
+		names, dynamic := templateProviders(t)
+		for _, n := range names {
+			t.Providers = append(t.Providers, ssrProviderRef{Name: n, Missing: providersKnown && !slices.Contains(providers, n)})
+		}
+		t.DynamicProviders = dynamic
+		needs, dynamic := templateInventory(t)
+		t.Inventory = inventoryRefs(needs, syncEntries, syncKnown)
+		t.DynamicInventory = dynamic
+		t.Policy = policies.forTemplate(t.Kind)
+		templates = append(templates, t)
+	}
+	// The gator suites, when GPM has a directory of them, run against this context's templates.
+	if dir := viper.GetString("gator_suites"); dir != "" {
+		data["SuiteProblems"] = s.gator.runSuites(ctx, c.Param("context"), dir, templates)
 	}
 	data["Templates"] = templates
+	data["Lint"] = lintSummaries(templates)
+	data["ProvidersURL"] = contextPath(c, "/providers")
+	data["ConfigurationsURL"] = contextPath(c, "/configurations")
 	data["ExpectedPods"] = maxPodCount(objects)
 	return s.ssr.render(c, "constrainttemplates", data)
 }
@@ -629,6 +801,14 @@ type ssrConstraint struct {
 	Pods           []ssrConstraintPod
 	// Enforcement points reporting anything but "active", collapsed across the pods that report them.
 	EnforcementIssues []ssrEnforcementIssue
+	// The ExpansionTemplates whose generated kind this Constraint matches, so it also judges the
+	// workloads they expand.
+	Expansions []ssrExpansionRef
+	// The ValidatingAdmissionPolicyBinding Gatekeeper generated for this Constraint; nil when it
+	// generated none.
+	Binding *ssrAdmissionBinding
+	// Where spec.parameters does not match the template's schema, one sentence per problem.
+	ParameterIssues []string
 
 	Raw map[string]any
 }
@@ -727,8 +907,9 @@ func podSummary(obj any, expected int) ssrPodSummary {
 	return summary
 }
 
-// podErrors reads the compile errors a Constraint Template's pods report. The other kinds do not use
-// this field, and read as an empty list.
+// podErrors reads the errors a pod reports: a Constraint Template's compile errors, or the kinds a
+// Config's or SyncSet's pod could not sync. The other kinds do not use this field, and read as an
+// empty list.
 func podErrors(pod map[string]any) []string {
 	raw, _, _ := unstructured.NestedSlice(pod, "errors")
 	var out []string
@@ -828,6 +1009,7 @@ type ssrResourceViolation struct {
 	Kind       string // the constraint's kind, i.e. the template
 	Mode       string // deny | warn | dryrun
 	Message    string
+	Object     string // the child the audit named, when the row is its owner's
 }
 
 // One object that breaks at least one policy. Identity is group, kind, namespace and name, so two
@@ -837,6 +1019,8 @@ type ssrResource struct {
 	Group, Kind, Name  string
 	Deny, DryRun, Warn int
 	Violations         []ssrResourceViolation
+	Children           int    // the owned objects grouped into the row, in the owner view
+	URL                string // the object page, set by getResources
 }
 
 func (r ssrResource) Total() int { return r.Deny + r.DryRun + r.Warn }
@@ -848,6 +1032,8 @@ type ssrResourceNamespace struct {
 	Anchor             string
 	Deny, DryRun, Warn int
 	Resources          []ssrResource
+	URL                string           // the namespace page, set by getResources
+	Compliance         *complianceTally // the score, when GPM_COMPLIANCE_SCORE is on
 }
 
 func (n ssrResourceNamespace) Total() int { return n.Deny + n.DryRun + n.Warn }
@@ -896,11 +1082,16 @@ func (n ssrResourceNamespace) Summary() string {
 // Constraints view's "worst first": within a namespace by blocking violations then by total, and the
 // namespaces the same way, with the cluster-scoped bucket last because it is a different kind of
 // thing rather than a worse one. Ties break on name so the page is stable between audits.
-func resourceModel(constraints []ssrConstraint) []ssrResourceNamespace {
+//
+// owners maps an object to its top-level controller (see resolveOwners). An owned object's
+// violations land on its owner's row, which counts the children; a nil map keeps one row per
+// object.
+func resourceModel(constraints []ssrConstraint, owners map[objectRef]objectRef) []ssrResourceNamespace {
 	type key struct{ ns, group, kind, name string }
 
 	byNS := map[string]*ssrResourceNamespace{}
 	byRes := map[key]*ssrResource{}
+	children := map[key]map[objectRef]bool{}
 
 	for _, c := range constraints {
 		for _, v := range c.Violations {
@@ -916,15 +1107,27 @@ func resourceModel(constraints []ssrConstraint) []ssrResourceNamespace {
 				ns = &ssrResourceNamespace{Name: v.Namespace, Anchor: anchor}
 				byNS[v.Namespace] = ns
 			}
-			k := key{v.Namespace, v.Group, v.Kind, v.Name}
+			ref := objectRef{Group: v.Group, Kind: v.Kind, Namespace: v.Namespace, Name: v.Name}
+			owner, isOwned := owners[ref]
+			if !isOwned {
+				owner = ref
+			}
+			k := key{owner.Namespace, owner.Group, owner.Kind, owner.Name}
 			res, ok := byRes[k]
 			if !ok {
-				res = &ssrResource{Group: v.Group, Kind: v.Kind, Name: v.Name}
+				res = &ssrResource{Group: owner.Group, Kind: owner.Kind, Name: owner.Name}
 				byRes[k] = res
 			}
-			res.Violations = append(res.Violations, ssrResourceViolation{
-				Constraint: c.Name, Kind: c.Kind, Mode: mode, Message: v.Message,
-			})
+			violation := ssrResourceViolation{Constraint: c.Name, Kind: c.Kind, Mode: mode, Message: v.Message}
+			if isOwned {
+				violation.Object = ref.Kind + " " + ref.Name
+				if children[k] == nil {
+					children[k] = map[objectRef]bool{}
+				}
+				children[k][ref] = true
+				res.Children = len(children[k])
+			}
+			res.Violations = append(res.Violations, violation)
 			switch mode {
 			case "deny":
 				res.Deny++
@@ -1142,6 +1345,20 @@ func (s *server) getResources(c echo.Context) error {
 		return s.ssr.render(c, "resources", data)
 	}
 
+	// ?team= keeps the violations in one team's namespaces, before the pivot counts them. The
+	// score is still read from every Constraint's, as it is per namespace anyway.
+	all := raw
+	team, err := teamScope(c, clients)
+	if err != nil {
+		slog.Error("SSR resources: listing namespaces for the team filter failed", "error", err)
+		setViewError(data, "GPM could not list the namespaces from the Kubernetes API to find the team's. Make sure GPM can list namespaces.", err)
+		return s.ssr.render(c, "resources", data)
+	}
+	if team != nil {
+		raw, team.Limited = teamConstraints(raw, team.Namespaces)
+		data["Team"] = team
+	}
+
 	models := make([]ssrConstraint, 0, len(raw))
 	audited, limited := false, false
 	for _, o := range raw {
@@ -1155,7 +1372,61 @@ func (s *server) getResources(c echo.Context) error {
 		models = append(models, m)
 	}
 
-	data["Namespaces"] = resourceModel(models)
+	// The owner view is the default: it is the one that says which workload to fix. GPM reads the
+	// Pods, ReplicaSets and Jobs for it; an object it cannot read keeps its own row.
+	var owners map[objectRef]objectRef
+	byOwner := c.QueryParam("view") != "objects"
+	if byOwner {
+		var refs []objectRef
+		for _, m := range models {
+			for _, v := range m.Violations {
+				refs = append(refs, objectRef{Group: v.Group, Kind: v.Kind, Namespace: v.Namespace, Name: v.Name})
+			}
+		}
+		owners, err = resolveOwners(c.Request().Context(), clients, refs)
+		if err != nil {
+			slog.Warn("SSR resources: resolving owners failed, those objects keep their own rows", "error", err)
+			data["OwnersIncomplete"] = true
+		}
+	}
+	data["ByOwner"] = byOwner
+	// The toggle keeps the team filter.
+	ownerQuery, objectsQuery := url.Values{}, url.Values{"view": {"objects"}}
+	if team != nil {
+		ownerQuery.Set("team", team.Name)
+		objectsQuery.Set("team", team.Name)
+	}
+	data["OwnerURL"] = strings.TrimSuffix(contextPath(c, "/resources")+"?"+ownerQuery.Encode(), "?")
+	data["ObjectsURL"] = contextPath(c, "/resources") + "?" + objectsQuery.Encode()
+
+	namespaces := resourceModel(models, owners)
+	for i := range namespaces {
+		if namespaces[i].Name != "" {
+			namespaces[i].URL = namespaceURL(c.Param("context"), namespaces[i].Name)
+		}
+		for j := range namespaces[i].Resources {
+			r := &namespaces[i].Resources[j]
+			r.URL = objectURL(c.Param("context"), objectRef{Group: r.Group, Kind: r.Kind, Namespace: namespaces[i].Name, Name: r.Name})
+		}
+	}
+	if complianceEnabled() {
+		score, err := s.complianceFor(c.Request().Context(), c.Param("context"), clients, all)
+		if err != nil {
+			slog.Warn("SSR resources: scoring compliance failed for some Constraints, the score leaves them out", "error", err)
+		}
+		if score != nil {
+			for i := range namespaces {
+				t := score.Namespaces[namespaces[i].Name]
+				namespaces[i].Compliance = &t
+			}
+			data["Compliance"] = score
+			data["Score"] = score.Cluster
+			if team != nil {
+				data["Score"] = score.forNamespaces(team.Namespaces)
+			}
+		}
+	}
+	data["Namespaces"] = namespaces
 	// Two different empty states: nothing broken, or nothing audited yet. Saying "no violations"
 	// before the first audit would be a lie.
 	data["Audited"] = audited
@@ -1186,6 +1457,20 @@ func (s *server) getConstraints(c echo.Context) error {
 		return s.ssr.render(c, "constraints", data)
 	}
 
+	// ?team= narrows the view, and the report with it, to one team's namespaces. The cards keep
+	// every Constraint: one the team does not break is worth seeing too.
+	all := raw
+	team, err := teamScope(c, clients)
+	if err != nil {
+		slog.Error("SSR constraints: listing namespaces for the team filter failed", "error", err)
+		setViewError(data, "GPM could not list the namespaces from the Kubernetes API to find the team's. Make sure GPM can list namespaces.", err)
+		return s.ssr.render(c, "constraints", data)
+	}
+	if team != nil {
+		raw, team.Limited = teamConstraints(raw, team.Namespaces)
+		data["Team"] = team
+	}
+
 	sortConstraints(raw)
 
 	// The context named in the path, or the kubeconfig default. It names the cluster the report
@@ -1198,26 +1483,65 @@ func (s *server) getConstraints(c echo.Context) error {
 	// The printable HTML report shares this data path. When ?report is present, render it instead
 	// of the interactive view.
 	if c.QueryParam("report") != "" {
-		return c.Render(http.StatusOK, "report", map[string]any{
+		report := map[string]any{
 			"constraints":   raw,
 			"apiServerHost": clients.rest.Host,
 			"context":       selected,
 			"timestamp":     time.Now().Format(time.ANSIC),
-		})
+		}
+		if team != nil {
+			report["team"] = team
+		}
+		if complianceEnabled() {
+			score, err := s.complianceFor(ctx, selected, clients, all)
+			if err != nil {
+				slog.Warn("SSR constraints: scoring compliance failed for some Constraints, the report's score leaves them out", "error", err)
+			}
+			if score != nil {
+				report["compliance"] = score.Cluster
+				if team != nil {
+					report["compliance"] = score.forNamespaces(team.Namespaces)
+				}
+				report["unscored"] = score.Unscored
+				report["complianceLimited"] = score.Limited
+			}
+		}
+		return c.Render(http.StatusOK, "report", report)
 	}
 
+	expansions := expansionRefs(c, clients, raw)
+	policies, err := listAdmissionPolicies(ctx, clients)
+	if err != nil {
+		slog.Warn("SSR constraints: reading admission policies failed, the cards leave the bindings out", "error", err)
+	}
+	bindings := policies.forConstraints()
+	paramIssues := constraintParameterIssues(ctx, clients, raw)
 	models := make([]ssrConstraint, 0, len(raw))
+	var misconfigured []ssrConstraintRef
 	for _, o := range raw {
-		models = append(models, ssrConstraintModel(o))
+		m := ssrConstraintModel(o)
+		m.Expansions = expansions[constraintAnchor(m.Kind, m.Name)]
+		m.Binding = bindings[constraintAnchor(m.Kind, m.Name)]
+		m.ParameterIssues = paramIssues[constraintAnchor(m.Kind, m.Name)]
+		if len(m.ParameterIssues) > 0 {
+			misconfigured = append(misconfigured, ssrConstraintRef{Kind: m.Kind, Name: m.Name})
+		}
+		models = append(models, m)
 	}
 	data["Constraints"] = models
+	data["Misconfigured"] = misconfigured
+	data["ExpansionURL"] = contextPath(c, "/expansion")
 	data["ExpectedPods"] = maxPodCount(raw)
 
-	// The printable report is this same view with ?report set.
+	// The printable report is this same view with ?report set, for the same team.
+	report := url.Values{"report": {"html"}}
+	if team != nil {
+		report.Set("team", team.Name)
+	}
 	if selected != "" {
-		data["ReportURL"] = browserPath("/constraints/" + url.PathEscape(selected) + "?report=html")
+		data["ReportURL"] = browserPath("/constraints/" + url.PathEscape(selected) + "?" + report.Encode())
 	} else {
-		data["ReportURL"] = browserPath("/constraints?report=html")
+		data["ReportURL"] = browserPath("/constraints?" + report.Encode())
 	}
 	return s.ssr.render(c, "constraints", data)
 }
@@ -1253,6 +1577,27 @@ type ssrEvent struct {
 
 	SourceComponent string
 	SourceHost      string
+
+	// Mode is Action collapsed the way enforcementMode collapses it, and empty when Gatekeeper
+	// recorded no action. Time is the last sighting, or the first when that is all there is; First
+	// is the first sighting when the Event has one.
+	Mode  string
+	Time  time.Time
+	First time.Time
+	// Origin is "log" for a verdict the deny-log collector read from a Gatekeeper pod, and empty
+	// for an Event object.
+	Origin string
+	// The analytics histogram bar this event falls in, or -1; set by eventAnalytics.
+	Bucket int
+}
+
+// Namespace is the one the event is about: the resource Gatekeeper judged, and only failing that
+// the object the Event is attached to.
+func (e ssrEvent) Namespace() string {
+	if e.ResourceNamespace != "" {
+		return e.ResourceNamespace
+	}
+	return e.ObjNamespace
 }
 
 // formatTimestamp turns an RFC3339 Kubernetes timestamp into a readable 24-hour UTC string,
@@ -1268,6 +1613,9 @@ func formatTimestamp(v string) string {
 	return t.UTC().Format("2006-01-02 15:04:05 UTC")
 }
 
+// ssrEventModel reads one Event in either shape of the API. The Gatekeeper annotations, the name
+// and the reason are the same in both; the rest moved in events.k8s.io/v1, where the old fields
+// survive with a deprecated prefix.
 func ssrEventModel(e map[string]any) ssrEvent {
 	ann := func(k string) string {
 		v, _, _ := unstructured.NestedString(e, "metadata", "annotations", k)
@@ -1277,17 +1625,29 @@ func ssrEventModel(e map[string]any) ssrEvent {
 	m := ssrEvent{}
 	m.Name, _, _ = unstructured.NestedString(e, "metadata", "name")
 	m.Reason, _, _ = unstructured.NestedString(e, "reason")
-	m.Message, _, _ = unstructured.NestedString(e, "message")
-	if count, found, _ := unstructured.NestedInt64(e, "count"); found {
-		m.Count = strconv.FormatInt(count, 10)
-	}
 
-	first, _, _ := unstructured.NestedString(e, "firstTimestamp")
-	last, _, _ := unstructured.NestedString(e, "lastTimestamp")
+	var first, last string
+	if apiVersion, _, _ := unstructured.NestedString(e, "apiVersion"); strings.HasPrefix(apiVersion, eventsV1Resource.Group+"/") {
+		first, last = eventsV1Fields(&m, e)
+	} else {
+		first, last = coreEventFields(&m, e)
+	}
 	m.FirstTimestamp = formatTimestamp(first)
 	m.LastTimestamp = formatTimestamp(last)
+	for _, v := range []string{last, first} {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			m.Time = t
+			break
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, first); err == nil {
+		m.First = t
+	}
 
 	m.Action = ann("constraint_action")
+	if m.Action != "" {
+		m.Mode = enforcementMode(m.Action)
+	}
 	m.ConstraintKind = ann("constraint_kind")
 	m.ConstraintName = ann("constraint_name")
 	m.EventType = ann("event_type")
@@ -1298,19 +1658,191 @@ func ssrEventModel(e map[string]any) ssrEvent {
 	m.ResourceKind = ann("resource_kind")
 	m.ResourceName = ann("resource_name")
 	m.ResourceNamespace = ann("resource_namespace")
+	return m
+}
 
+// coreEventFields reads the core v1 fields and returns the first and last timestamps.
+func coreEventFields(m *ssrEvent, e map[string]any) (first, last string) {
+	m.Message, _, _ = unstructured.NestedString(e, "message")
+	if count, found, _ := unstructured.NestedInt64(e, "count"); found {
+		m.Count = strconv.FormatInt(count, 10)
+	}
 	m.ObjKind, _, _ = unstructured.NestedString(e, "involvedObject", "kind")
 	m.ObjName, _, _ = unstructured.NestedString(e, "involvedObject", "name")
 	m.ObjNamespace, _, _ = unstructured.NestedString(e, "involvedObject", "namespace")
-
 	m.SourceComponent, _, _ = unstructured.NestedString(e, "source", "component")
 	m.SourceHost, _, _ = unstructured.NestedString(e, "source", "host")
-	return m
+
+	first, _, _ = unstructured.NestedString(e, "firstTimestamp")
+	last, _, _ = unstructured.NestedString(e, "lastTimestamp")
+	return first, last
+}
+
+// eventsV1Fields reads the events.k8s.io/v1 fields and returns the first and last timestamps. A
+// repeated event keeps its count and last sighting in series; an event written through the core
+// API keeps them in the deprecated fields instead, and only has eventTime when its writer set it.
+func eventsV1Fields(m *ssrEvent, e map[string]any) (first, last string) {
+	m.Message, _, _ = unstructured.NestedString(e, "note")
+	count, found, _ := unstructured.NestedInt64(e, "series", "count")
+	if !found {
+		count, found, _ = unstructured.NestedInt64(e, "deprecatedCount")
+	}
+	if found {
+		m.Count = strconv.FormatInt(count, 10)
+	}
+	m.ObjKind, _, _ = unstructured.NestedString(e, "regarding", "kind")
+	m.ObjName, _, _ = unstructured.NestedString(e, "regarding", "name")
+	m.ObjNamespace, _, _ = unstructured.NestedString(e, "regarding", "namespace")
+
+	// Gatekeeper still records through the core API, so its source component lands in
+	// deprecatedSource; reportingController is what a newer writer fills in instead.
+	m.SourceComponent, _, _ = unstructured.NestedString(e, "deprecatedSource", "component")
+	if m.SourceComponent == "" {
+		m.SourceComponent, _, _ = unstructured.NestedString(e, "reportingController")
+	}
+	m.SourceHost, _, _ = unstructured.NestedString(e, "deprecatedSource", "host")
+	if m.SourceHost == "" {
+		m.SourceHost, _, _ = unstructured.NestedString(e, "reportingInstance")
+	}
+
+	eventTime, _, _ := unstructured.NestedString(e, "eventTime")
+	first, _, _ = unstructured.NestedString(e, "deprecatedFirstTimestamp")
+	if first == "" {
+		first = eventTime
+	}
+	last, _, _ = unstructured.NestedString(e, "series", "lastObservedTime")
+	if last == "" {
+		last, _, _ = unstructured.NestedString(e, "deprecatedLastTimestamp")
+	}
+	if last == "" {
+		last = eventTime
+	}
+	return first, last
 }
 
-// getEvents renders the Events view. It reads the same events as the JSON handler getEvents:
-// core v1 Events filtered to the configured source (GPM_EVENTS_SOURCE), in the configured or
-// requested namespace. Like getEvents, this is an alpha feature; see its note in handlers.go.
+// The time windows the Events view offers. Kubernetes keeps events for an hour by default, so the
+// longer ones only help on a cluster whose event TTL was raised.
+var eventWindows = []string{"15m", "1h", "6h", "24h", "7d"}
+
+// parseEventWindow reads a time window as a Go duration, plus days, which the standard parser has no
+// unit for. Anything else means no window.
+func parseEventWindow(s string) time.Duration {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0
+		}
+		return time.Duration(n) * 24 * time.Hour
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// ssrEventFilters is what the filter bar above the table renders: the values in force, the choices
+// each select offers, and the links to move between pages.
+type ssrEventFilters struct {
+	Sources           []string // the configured sources; the select cannot offer anything wider
+	Source            string
+	Constraint        string
+	Action            string
+	User              string
+	ResourceNamespace string
+	ResourceKind      string
+	Since             string
+	Sort              string
+	PageSize          int
+	PageSizes         []int
+	Windows           []string
+	Actions           []string
+	Namespace         string // the ?namespace= the page was opened with, carried through the form
+	Team              string // the ?team= the page was opened with, carried through the form too
+	LogsFrom          int    // how many deny-log verdicts the pages before this one showed
+
+	Active   bool   // any filter narrows the listing
+	Paged    bool   // this is not the first page
+	NextURL  string // relative, so it keeps whatever base path and context the page was served at
+	FirstURL string
+	ResetURL string // the unfiltered first page
+}
+
+// eventQueryFrom reads the filter bar's parameters. GPM_EVENTS_SOURCE is the outer bound: a source
+// parameter narrows it to one of the configured components and cannot add another.
+func eventQueryFrom(c echo.Context, configured []string) (eventQuery, ssrEventFilters) {
+	f := ssrEventFilters{
+		Sources:           configured,
+		Source:            c.QueryParam("source"),
+		Constraint:        strings.TrimSpace(c.QueryParam("constraint")),
+		Action:            c.QueryParam("action"),
+		User:              strings.TrimSpace(c.QueryParam("user")),
+		ResourceNamespace: strings.TrimSpace(c.QueryParam("resource_namespace")),
+		ResourceKind:      strings.TrimSpace(c.QueryParam("resource_kind")),
+		Since:             c.QueryParam("since"),
+		Sort:              c.QueryParam("sort"),
+		PageSize:          defaultEventPageSize,
+		PageSizes:         eventPageSizes,
+		Windows:           eventWindows,
+		Actions:           []string{"deny", "warn", "dryrun"},
+		Namespace:         c.QueryParam("namespace"),
+		Team:              strings.TrimSpace(c.QueryParam("team")),
+	}
+	if n, err := strconv.Atoi(c.QueryParam("limit")); err == nil && n > 0 {
+		f.PageSize = min(n, eventPageSizes[len(eventPageSizes)-1])
+	}
+	if n, err := strconv.Atoi(c.QueryParam("logs")); err == nil && n > 0 {
+		f.LogsFrom = n
+	}
+	if f.Action != "" {
+		f.Action = enforcementMode(f.Action)
+	}
+	if f.Sort != "oldest" {
+		f.Sort = ""
+	}
+
+	q := eventQuery{
+		Sources:           configured,
+		Constraint:        f.Constraint,
+		Action:            f.Action,
+		User:              f.User,
+		ResourceNamespace: f.ResourceNamespace,
+		ResourceKind:      f.ResourceKind,
+		Since:             parseEventWindow(f.Since),
+		Now:               time.Now(),
+		PageSize:          f.PageSize,
+		Continue:          c.QueryParam("continue"),
+		Sort:              f.Sort,
+	}
+	if f.Source != "" {
+		q.Sources = nil
+		if slices.Contains(configured, f.Source) {
+			q.Sources = []string{f.Source}
+		}
+	}
+	if q.Since == 0 {
+		f.Since = ""
+	}
+	f.Active = f.Source != "" || f.Constraint != "" || f.Action != "" || f.User != "" ||
+		f.ResourceNamespace != "" || f.ResourceKind != "" || f.Since != "" || f.Team != ""
+	f.Paged = q.Continue != "" || f.LogsFrom > 0
+
+	first := c.Request().URL.Query()
+	first.Del("continue")
+	first.Del("logs")
+	f.FirstURL = "?" + first.Encode()
+	reset := url.Values{}
+	if f.Namespace != "" {
+		reset.Set("namespace", f.Namespace)
+	}
+	f.ResetURL = "?" + reset.Encode()
+	return q, f
+}
+
+// getEvents renders the Events view: Events filtered to the configured source (GPM_EVENTS_SOURCE),
+// in the configured or requested namespaces, narrowed by the filter bar and read one page at a time.
+// They are read from events.k8s.io/v1 where the cluster serves it, from core v1 otherwise. Emitting
+// events is a Gatekeeper alpha feature.
 func (s *server) getEvents(c echo.Context) error {
 	layout := s.ssrLayoutData(c, "events", "/events", "Events")
 
@@ -1326,30 +1858,80 @@ func (s *server) getEvents(c echo.Context) error {
 	// GPM_EVENTS_SOURCE is a comma-separated list of event source components. Gatekeeper tags
 	// admission events with gatekeeper-webhook and audit events with gatekeeper-audit; the default
 	// shows both.
-	var sources []string
-	for _, src := range strings.Split(viper.GetString("events_source"), ",") {
-		if src = strings.TrimSpace(src); src != "" {
-			sources = append(sources, src)
-		}
+	sources := settingList("events_source")
+	// GPM_EVENTS_NAMESPACE is a comma-separated list too, and wins over the query parameter: the
+	// parameter can pick one of the configured namespaces, never one outside them.
+	namespaces := settingList("events_namespace")
+	if requested := c.QueryParam("namespace"); requested != "" && (len(namespaces) == 0 || slices.Contains(namespaces, requested)) {
+		namespaces = []string{requested}
+	}
+
+	query, filters := eventQueryFrom(c, sources)
+	data["Filters"] = &filters
+
+	// ?team= keeps the events about objects in the team's namespaces. The events are still read
+	// where they are configured to be; an event can sit in another namespace than its object.
+	team, err := teamScope(c, clients)
+	if err != nil {
+		slog.Error("SSR events: listing namespaces for the team filter failed", "error", err)
+		setViewError(data, "GPM could not list the namespaces from the Kubernetes API to find the team's. Make sure GPM can list namespaces.", err)
+		return s.ssr.render(c, "events", data)
 	}
-	// GPM_EVENTS_NAMESPACE wins over the query parameter.
-	namespace := viper.GetString("events_namespace")
-	if namespace == "" {
-		namespace = c.QueryParam("namespace")
+	if team != nil {
+		query.ResourceNamespaces = team.Namespaces
+		data["Team"] = team
 	}
 
-	events, err := getKubernetesEvents(c.Request().Context(), *clients.dynamic, namespace, sources)
+	resource := eventsResource(clients.discovery)
+	page, err := getKubernetesEvents(c.Request().Context(), *clients.dynamic, resource, namespaces, query)
+	if errors.Is(err, errExpiredEventsPage) {
+		setViewError(data, "This page of events is no longer available. Go back to the first page and page through again.", err)
+		return s.ssr.render(c, "events", data)
+	}
 	if err != nil {
-		slog.Error("SSR events: getting events failed", "namespace", namespace, "sources", sources, "error", err)
+		slog.Error("SSR events: getting events failed", "namespaces", namespaces, "api", resource.GroupVersion().String(), "sources", sources, "error", err)
 		setViewError(data, "GPM could not get the events from the Kubernetes API. Make sure the API is reachable.", err)
 		return s.ssr.render(c, "events", data)
 	}
 
-	models := make([]ssrEvent, 0, len(*events))
-	for i := range *events {
-		models = append(models, ssrEventModel((*events)[i].Object))
+	// The deny-log verdicts are paged beside the Events: each page takes the next page size of them,
+	// whatever share of the page the Events fill, and the page links carry how many the pages before
+	// took. They go through the same filters, and are scoped to the namespaces the Events are read
+	// from, so a log cannot show a namespace the deployment is not configured for. The collector
+	// adds new verdicts at the newest end, so a later page can repeat one that a newer verdict
+	// pushed along, as an Event can.
+	moreLogs := false
+	var logCount int
+	if s.denyLogs != nil {
+		logs := query
+		if len(namespaces) > 0 {
+			logs.ResourceNamespaces = namespaces
+			if query.ResourceNamespaces != nil {
+				logs.ResourceNamespaces = slices.DeleteFunc(slices.Clone(namespaces), func(ns string) bool {
+					return !slices.Contains(query.ResourceNamespaces, ns)
+				})
+			}
+		}
+		var verdicts []ssrEvent
+		verdicts, moreLogs = s.denyLogs.events(c.Param("context"), logs, filters.LogsFrom, filters.PageSize)
+		logCount = len(verdicts)
+		page.Events = mergeDenyLogs(page.Events, verdicts)
+		sortEvents(page.Events, query.Sort)
+		data["DenyLogs"] = true
+	}
+	if page.Continue != "" || moreLogs {
+		next := c.Request().URL.Query()
+		next.Set("continue", page.Continue)
+		if page.Continue == "" {
+			next.Set("continue", doneEventCursor(namespaces))
+		}
+		if s.denyLogs != nil {
+			next.Set("logs", strconv.Itoa(filters.LogsFrom+logCount))
+		}
+		filters.NextURL = "?" + next.Encode()
 	}
-	data["Events"] = models
+	data["Analytics"] = eventAnalytics(page.Events)
+	data["Events"] = page.Events
 	return s.ssr.render(c, "events", data)
 }
 
@@ -1381,6 +1963,9 @@ type dashboardCluster struct {
 	ConstraintsURL  string `json:"url"`    // link to this cluster's constraints view
 	Status          string `json:"status"` // Violations | Compliant | Unreachable (sortable label)
 	State           string `json:"state"`  // bad | ok | warn (drives the status dot color)
+	// The compliance score, null when it is off or the cluster could not be scored.
+	Score        *int   `json:"score"`
+	ScoreSummary string `json:"scoreSummary,omitempty"`
 	// The raw fetch error is deliberately not carried here: it can name internal API-server hosts,
 	// IPs and cert details, and the dashboard is reachable without a session under Anonymous auth.
 	// It is logged server-side in fetchClusterConstraints; the table only shows "Unreachable".
@@ -1413,7 +1998,8 @@ type dashboardData struct {
 	ReachableClusters int
 	TotalConstraints  int
 	TotalViolations   int
-	GeneratedUnixMs   int64 // when this data was fetched, for the "updated Ns ago" hint (may be cached)
+	GeneratedUnixMs   int64            // when this data was fetched, for the "updated Ns ago" hint (may be cached)
+	Compliance        *complianceTally // the fleet's score, over the clusters scored; nil when none was
 }
 
 // donutSegment is one slice of a donut chart: its share of the ring (as SVG stroke geometry over a
@@ -1466,6 +2052,7 @@ type clusterConstraints struct {
 	reachable   bool
 	err         error
 	constraints []ssrConstraint
+	compliance  *complianceTally // nil unless GPM_COMPLIANCE_SCORE is on and the cluster was scored
 }
 
 // dashboardCache holds the last-built dashboard for a short TTL. The dashboard is fleet-wide (the
@@ -1480,6 +2067,10 @@ type dashboardCache struct {
 
 const dashboardCacheTTL = 10 * time.Second
 
+// How long one cluster's reads may take on a view that fans out to the fleet, so one unreachable
+// cluster cannot hang the page.
+const clusterFetchTimeout = 10 * time.Second
+
 // buildDashboard returns the cached dashboard when it is still fresh, otherwise rebuilds it. The lock
 // is held across the rebuild on purpose: concurrent loads then coalesce onto one fan-out instead of
 // each launching its own. Data can be up to dashboardCacheTTL stale, which is fine — Gatekeeper's
@@ -1508,17 +2099,7 @@ func (s *server) buildDashboard(ctx context.Context) dashboardData {
 // own timeout so one unreachable cluster cannot hang the page, then aggregates them. An unreachable
 // cluster becomes an error row rather than failing the whole view.
 func (s *server) computeDashboard(ctx context.Context) dashboardData {
-	contexts, current := s.k8s.contexts()
-
-	names := make([]string, 0, len(contexts))
-	for n := range contexts {
-		names = append(names, n)
-	}
-	sort.Strings(names)
-	if len(names) == 0 {
-		// In-cluster or a kubeconfig with no named contexts: the single default cluster.
-		names = []string{defaultKubeContext}
-	}
+	names, current := s.fleetContexts()
 
 	// ponytail: one goroutine per cluster, unbounded. A kubeconfig holds a handful of clusters, not
 	// hundreds; add a worker pool only if that stops being true.
@@ -1536,7 +2117,7 @@ func (s *server) computeDashboard(ctx context.Context) dashboardData {
 	return aggregateDashboard(results)
 }
 
-// fetchClusterConstraints resolves one context and lists its Constraints under a 10s timeout.
+// fetchClusterConstraints resolves one context and lists its Constraints under clusterFetchTimeout.
 func (s *server) fetchClusterConstraints(ctx context.Context, name string, selected bool) clusterConstraints {
 	res := clusterConstraints{context: name, selected: selected}
 
@@ -1547,7 +2128,7 @@ func (s *server) fetchClusterConstraints(ctx context.Context, name string, selec
 		return res
 	}
 
-	cctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	cctx, cancel := context.WithTimeout(ctx, clusterFetchTimeout)
 	defer cancel()
 
 	raw, err := listConstraints(cctx, clients)
@@ -1562,6 +2143,15 @@ func (s *server) fetchClusterConstraints(ctx context.Context, name string, selec
 	for _, o := range raw {
 		res.constraints = append(res.constraints, ssrConstraintModel(o))
 	}
+	if complianceEnabled() {
+		score, err := s.complianceFor(cctx, name, clients, raw)
+		if err != nil {
+			slog.Warn("dashboard: scoring compliance failed for some Constraints", "cluster", name, "error", err)
+		}
+		if score != nil {
+			res.compliance = &score.Cluster
+		}
+	}
 	return res
 }
 
@@ -1596,6 +2186,14 @@ func aggregateDashboard(results []clusterConstraints) dashboardData {
 
 		d.ReachableClusters++
 		cluster.ConstraintCount = len(r.constraints)
+		if r.compliance != nil {
+			score := r.compliance.Score()
+			cluster.Score, cluster.ScoreSummary = &score, r.compliance.Summary()
+			if d.Compliance == nil {
+				d.Compliance = &complianceTally{}
+			}
+			d.Compliance.add(*r.compliance)
+		}
 		for _, c := range r.constraints {
 			d.TotalConstraints++
 			switch c.EnforcementMode {
@@ -1671,6 +2269,18 @@ func clusterLabel(context string) string {
 	return context
 }
 
+// clusterAnchor is the fragment a cluster's card answers to. A context's name may hold anything a
+// kubeconfig allows, an EKS ARN's colons and slashes or spaces, so every rune but a letter, a digit,
+// '-', '_' and '.' reads as '-'.
+func clusterAnchor(context string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, clusterLabel(context))
+}
+
 // constraintAnchor is the fragment a Constraint card answers to. The name alone is not unique: two
 // Constraints of different Kinds may share one, and that produced two cards with the same id, so one
 // was unreachable, its sidebar entry could never be marked, and a shared link (issue #1324) landed on
@@ -1789,8 +2399,23 @@ func registerViews(e *echo.Echo, s *server) {
 	e.GET("/configurations", s.getConfigurations)
 	e.GET("/configurations/:context", s.getConfigurations)
 
+	e.GET("/exemptions", s.getExemptions)
+	e.GET("/exemptions/:context", s.getExemptions)
+
 	e.GET("/mutations", s.getMutations)
 	e.GET("/mutations/:context", s.getMutations)
+	// The preview has a path of its own: under /mutations, it would shadow a context named preview.
+	csrf := csrfProtection()
+	e.GET("/mutation-preview", s.getMutationPreview, csrf)
+	e.GET("/mutation-preview/:context", s.getMutationPreview, csrf)
+	e.POST("/mutation-preview", s.postMutationPreview, csrf)
+	e.POST("/mutation-preview/:context", s.postMutationPreview, csrf)
+
+	e.GET("/expansion", s.getExpansion)
+	e.GET("/expansion/:context", s.getExpansion)
+
+	e.GET("/providers", s.getProviders)
+	e.GET("/providers/:context", s.getProviders)
 
 	e.GET("/constrainttemplates", s.getConstraintTemplates)
 	e.GET("/constrainttemplates/:context", s.getConstraintTemplates)
@@ -1798,11 +2423,33 @@ func registerViews(e *echo.Echo, s *server) {
 	e.GET("/constraints", s.getConstraints)
 	e.GET("/constraints/:context", s.getConstraints)
 
+	// Like the preview, the object and namespace pages have paths of their own, so they shadow no
+	// context.
+	e.GET("/object", s.getObject)
+	e.GET("/object/:context", s.getObject)
+	e.GET("/namespace", s.getNamespace)
+	e.GET("/namespace/:context", s.getNamespace)
 	e.GET("/resources", s.getResources)
 	e.GET("/resources/:context", s.getResources)
 
+	e.GET("/teams", s.getTeams)
+	e.GET("/teams/:context", s.getTeams)
+
 	e.GET("/events", s.getEvents)
 	e.GET("/events/:context", s.getEvents)
+
+	e.GET("/search", s.getSearch)
+	e.GET("/search/:context", s.getSearch)
+
+	e.GET("/drift", s.getDrift)
+	e.GET("/drift/:context", s.getDrift)
+
+	// The upload is bounded before the CSRF check reads the form, which would otherwise buffer it whole.
+	bodyLimit := middleware.BodyLimit(fmt.Sprintf("%dM", bundleMaxBytes>>20))
+	e.GET("/bundle", s.getBundle, csrf)
+	e.GET("/bundle/:context", s.getBundle, csrf)
+	e.POST("/bundle", s.postBundle, bodyLimit, csrf)
+	e.POST("/bundle/:context", s.postBundle, bodyLimit, csrf)
 }
 
 // renderLoggedOut renders the "you are signed out" page. It is what the local logout path lands
@@ -30,13 +30,17 @@ func TestSSRNewViewsRenderWithoutError(t *testing.T) {
 
 	ct := ssrConstraintTemplate{
 		Name: "k8srequiredlabels", Kind: "K8sRequiredLabels", Created: "2026-01-01T00:00:00Z",
-		Description: "Requires labels", Target: "admission.k8s.gatekeeper.sh",
-		Rego: "package x", Libs: []string{"lib1"},
+		Description: "Requires labels",
+		Targets: []ssrTemplateTarget{{Target: "admission.k8s.gatekeeper.sh", Code: []ssrTemplateCode{
+			{Engine: "Rego", Rego: "package x", Libs: []string{"lib1"}},
+		}}},
 		Schema:        map[string]any{"labels": map[string]any{"type": "array"}},
 		Constraints:   []string{"must-have-owner"},
 		StatusCreated: true,
 		Raw:           map[string]any{"kind": "ConstraintTemplate"},
 	}
+	// The model builder lints the Rego into the modules the card renders; a literal has to ask.
+	lintTemplate(&ct)
 	ctData := map[string]any{"Layout": minimalLayout(), "Templates": []ssrConstraintTemplate{ct}}
 
 	var buf bytes.Buffer
@@ -252,16 +256,63 @@ func stripHTMLTags(s string) string {
 	return html.UnescapeString(htmlTagRE.ReplaceAllString(s, ""))
 }
 
-func TestExtractRegoFallsBackToCode(t *testing.T) {
-	if got := extractRego(map[string]any{"rego": "inline"}); got != "inline" {
-		t.Errorf("inline rego = %q, want %q", got, "inline")
+func TestTemplateCodeReadsEveryEngine(t *testing.T) {
+	if got := templateCode(map[string]any{"rego": "inline"}); len(got) != 1 || got[0].Rego != "inline" {
+		t.Errorf("inline rego = %+v, want %q", got, "inline")
 	}
-	target := map[string]any{"code": []any{
-		map[string]any{"engine": "K8sNativeValidation", "source": map[string]any{}},
-		map[string]any{"engine": "Rego", "source": map[string]any{"rego": "fromcode"}},
+	target := map[string]any{"rego": "inline", "code": []any{
+		map[string]any{"engine": "K8sNativeValidation", "source": map[string]any{"validations": []any{}}},
+		map[string]any{"engine": "Rego", "source": map[string]any{"rego": "fromcode", "libs": []any{"lib"}}},
+		map[string]any{"engine": "Rego", "source": map[string]any{"rego": "inline"}},
 	}}
-	if got := extractRego(target); got != "fromcode" {
-		t.Errorf("code rego = %q, want %q", got, "fromcode")
+	var got []string
+	for _, c := range templateCode(target) {
+		got = append(got, c.Engine+":"+c.Rego+strings.Join(c.Libs, ""))
+	}
+	if want := "Rego:inline K8sNativeValidation: Rego:fromcodelib"; strings.Join(got, " ") != want {
+		t.Errorf("code = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+// A template carrying Rego and CEL, in two targets, shows every block with its engine and target.
+func TestConstraintTemplatesRenderEveryTarget(t *testing.T) {
+	ct := ssrConstraintTemplateModel(map[string]any{
+		"metadata": map[string]any{"name": "k8sallowedrepos"},
+		"spec": map[string]any{
+			"crd": map[string]any{"spec": map[string]any{"names": map[string]any{"kind": "K8sAllowedRepos"}}},
+			"targets": []any{
+				map[string]any{"target": "admission.k8s.gatekeeper.sh", "code": []any{
+					map[string]any{"engine": "K8sNativeValidation", "source": map[string]any{"validations": []any{
+						map[string]any{"expression": "object.spec.containers.all(c, c.image.startsWith('registry.example.com/'))"},
+					}}},
+					map[string]any{"engine": "Rego", "source": map[string]any{"rego": "package allowedrepos", "libs": []any{"package lib.repos"}}},
+				}},
+				map[string]any{"target": "audit.example.com", "rego": "package audit"},
+			},
+		},
+	}, nil)
+	if got := len(ct.RegoSources()); got != 3 {
+		t.Errorf("%d Rego sources, want the rules and lib of the first target and the second target's", got)
+	}
+
+	var buf bytes.Buffer
+	data := map[string]any{"Layout": minimalLayout(), "Templates": []ssrConstraintTemplate{ct}}
+	if err := newSSRRenderer().pages["constrainttemplates"].ExecuteTemplate(&buf, "layout", data); err != nil {
+		t.Fatalf("constrainttemplates render failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"CEL definition · K8sNativeValidation · target admission.k8s.gatekeeper.sh",
+		"Rego definition · target admission.k8s.gatekeeper.sh",
+		"Libs definition · target admission.k8s.gatekeeper.sh",
+		"Rego definition · target audit.example.com",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("constrainttemplates output missing %q", want)
+		}
+	}
+	if stripped := stripHTMLTags(out); !strings.Contains(stripped, "package audit") || !strings.Contains(stripped, "registry.example.com/") {
+		t.Error("a target's source is missing")
 	}
 }
 
@@ -892,7 +943,7 @@ func TestResourceModelPivotsViolationsOntoObjects(t *testing.T) {
 		}},
 	}
 
-	got := resourceModel(constraints)
+	got := resourceModel(constraints, nil)
 
 	if len(got) != 3 {
 		t.Fatalf("expected three namespaces (apps-prod, team-payments, cluster-scoped), got %d", len(got))
@@ -934,7 +985,7 @@ func TestResourceModelKeepsSameNamedKindsFromDifferentGroupsApart(t *testing.T)
 		viol("other.example.com", "Widget", "shop", "thing", "warn"),
 	}}}
 
-	rows := resourceModel(constraints)[0].Resources
+	rows := resourceModel(constraints, nil)[0].Resources
 	if len(rows) != 2 {
 		t.Fatalf("same Kind and name from different groups must stay apart; got %d row(s)", len(rows))
 	}
@@ -965,7 +1016,7 @@ func TestResourcesViewRendersRowsAndCounts(t *testing.T) {
 			{Name: "liveness-probe", Kind: "K8sLivenessProbe", Violations: []ssrConstraintViolation{
 				viol("apps", "Deployment", "apps-prod", "checkout-api", "deny"),
 			}},
-		}),
+		}, nil),
 	})
 
 	for _, want := range []string{
@@ -1000,3 +1051,17 @@ func TestResourcesViewDistinguishesEmptyFromUnaudited(t *testing.T) {
 		t.Error("an audited cluster with no violations should say so")
 	}
 }
+
+// A view with a static segment under another view's path shadowed the contexts of that name: with
+// the preview at /mutations/preview, a context called preview never reached the Mutations view.
+func TestViewsLeaveEveryContextNameFree(t *testing.T) {
+	e := echo.New()
+	registerViews(e, &server{})
+	for _, path := range []string{"/mutations/preview", "/resources/object", "/resources/namespace"} {
+		c := e.NewContext(nil, nil)
+		e.Router().Find(http.MethodGet, path, c)
+		if want := path[strings.LastIndex(path, "/")+1:]; c.Param("context") != want {
+			t.Errorf("%s routes to %s with context %q, want %q", path, c.Path(), c.Param("context"), want)
+		}
+	}
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// The HTML violations report renderer.
+// The renderer of the standalone HTML reports: the violations report and the policy bundle report.
 package main
 
 import (
@@ -13,17 +13,17 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-//go:embed templates/constraints-report.html.gotpl
+//go:embed templates/constraints-report.html.gotpl templates/bundle-report.html.gotpl
 var reportTemplateFS embed.FS
 
 type Template struct {
 	templates *template.Template
 }
 
-// Builds the renderer for the HTML report. html/template, never text/template: the report
-// interpolates cluster-controlled data (constraint and resource names, namespaces, violation
-// messages), and only html/template escapes it per HTML context. The template is embedded, so the
-// renderer does not depend on the working directory.
+// Builds the renderer for the HTML reports. html/template, never text/template: the reports
+// interpolate cluster-controlled data (constraint and resource names, namespaces, violation
+// messages, and an uploaded bundle's files), and only html/template escapes it per HTML context.
+// The templates are embedded, so the renderer does not depend on the working directory.
 func newRenderer() *Template {
 	return &Template{templates: template.Must(
 		template.New("constraints-report.html.gotpl").
@@ -36,7 +36,7 @@ func newRenderer() *Template {
 				"violationsOf":    reportViolations,
 				"enforcementOf":   reportEnforcement,
 			}).
-			ParseFS(reportTemplateFS, "templates/constraints-report.html.gotpl"))}
+			ParseFS(reportTemplateFS, "templates/constraints-report.html.gotpl", "templates/bundle-report.html.gotpl"))}
 }
 
 func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
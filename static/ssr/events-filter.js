@@ -0,0 +1,43 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Filters the Events view from its analytics panel. Every bar and list entry is a button carrying
+// data-dim (the row attribute it filters on) and data-value; a click narrows the table to the rows
+// whose data-<dim> matches. One value per dimension, and the dimensions combine, so "this user" and
+// "this hour" together mean both. The rows are server-rendered, so this only hides them.
+function eventsFilter() {
+  return {
+    filters: [],
+    shown: 0,
+    total: 0,
+    toggle(el) {
+      const { dim, value, label } = el.dataset;
+      const on = this.filters.some((f) => f.dim === dim && f.value === value);
+      this.filters = this.filters.filter((f) => f.dim !== dim);
+      if (!on) this.filters.push({ dim, value, label: label || value });
+      this.apply();
+    },
+    isOn(el) {
+      return this.filters.some((f) => f.dim === el.dataset.dim && f.value === el.dataset.value);
+    },
+    remove(dim) {
+      this.filters = this.filters.filter((f) => f.dim !== dim);
+      this.apply();
+    },
+    clear() {
+      this.filters = [];
+      this.apply();
+    },
+    apply() {
+      this.total = 0;
+      this.shown = 0;
+      for (const row of this.$root.querySelectorAll(".event-row")) {
+        const match = this.filters.every((f) => row.dataset[f.dim] === f.value);
+        row.hidden = !match;
+        this.total++;
+        if (match) this.shown++;
+      }
+    },
+  };
+}
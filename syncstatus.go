@@ -0,0 +1,153 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Sync status. The Config and the SyncSets name the kinds Gatekeeper replicates into OPA's cache, and
+// each Gatekeeper pod reports on them in status.byPod. A kind a pod could not watch shows up there
+// as an error, and nowhere else, so the Configurations view folds those reports per kind.
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ssrSyncSet is the flat shape a SyncSet card renders.
+type ssrSyncSet struct {
+	Name        string
+	Description string
+	Created     string
+	GVKs        []targetKind
+	Raw         map[string]any
+}
+
+func ssrSyncSetModel(o map[string]any, served *servedKinds) ssrSyncSet {
+	m := ssrSyncSet{Raw: o}
+	m.Name, _, _ = unstructured.NestedString(o, "metadata", "name")
+	m.Created, _, _ = unstructured.NestedString(o, "metadata", "creationTimestamp")
+	m.Description = annotation(o, "description")
+	for _, e := range syncGVKs(o, "", "spec", "gvks") {
+		k := targetKind{APIVersion: e.APIVersion, Kind: e.Kind}
+		if served != nil {
+			k.namespaced, k.Unserved = served.lookup(e.APIVersion, e.Kind)
+		}
+		m.GVKs = append(m.GVKs, k)
+	}
+	return m
+}
+
+// An error a pod reports about a synced kind.
+type ssrSyncError struct {
+	Pod     string
+	Message string
+}
+
+// ssrSyncedKind is one row of the Configurations view's replicated kinds table: a kind, what asks
+// for it, and whether every pod is watching it.
+type ssrSyncedKind struct {
+	targetKind
+	Sources []string
+	Errors  []ssrSyncError
+	Line    string
+	State   string // "" reads as muted, "warn" and "error" colour the line, as podSummary's do
+}
+
+// mentionsKind reports whether a pod's error is about a kind. Gatekeeper names the kind in the form
+// schema.GroupVersionKind prints, or in the API server's "no matches for kind" message.
+// The printed form has nothing after the kind, so an occurrence followed by more of a name, Kind=Pod
+// in Kind=PodTemplate, is another kind.
+func mentionsKind(message string, k targetKind) bool {
+	gvk := schema.FromAPIVersionAndKind(k.APIVersion, k.Kind).String()
+	for rest := message; ; {
+		i := strings.Index(rest, gvk)
+		if i < 0 {
+			break
+		}
+		rest = rest[i+len(gvk):]
+		if rest == "" || !unicode.IsLetter(rune(rest[0])) && !unicode.IsDigit(rune(rest[0])) {
+			return true
+		}
+	}
+	return strings.Contains(message, fmt.Sprintf("%q", k.Kind)) && strings.Contains(message, fmt.Sprintf("%q", k.APIVersion))
+}
+
+// syncedKinds folds the sync entries of the Configs and the SyncSets per kind, with the reports of
+// the pods on the objects that ask for it. expected is the pod count maxPodCount finds across them.
+func syncedKinds(configs, syncSets []map[string]any, expected int, served *servedKinds) []ssrSyncedKind {
+	objects := map[string]map[string]any{}
+	for _, c := range configs {
+		objects[syncSource("Config", c)] = c
+	}
+	for _, s := range syncSets {
+		objects[syncSource("SyncSet", s)] = s
+	}
+
+	var rows []ssrSyncedKind
+	index := map[targetKind]int{}
+	for _, e := range syncEntriesOf(configs, syncSets) {
+		k := targetKind{APIVersion: e.APIVersion, Kind: e.Kind}
+		i, ok := index[k]
+		if !ok {
+			i = len(rows)
+			index[k] = i
+			rows = append(rows, ssrSyncedKind{targetKind: k})
+		}
+		if !slices.Contains(rows[i].Sources, e.Source) {
+			rows[i].Sources = append(rows[i].Sources, e.Source)
+		}
+	}
+
+	for i := range rows {
+		r := &rows[i]
+		if served != nil {
+			r.namespaced, r.Unserved = served.lookup(r.APIVersion, r.Kind)
+		}
+		synced := map[string]bool{}
+		failing := map[string]bool{}
+		for _, source := range r.Sources {
+			for _, pod := range podSummary(objects[source], 0).Pods {
+				for _, msg := range pod.Errors {
+					if mentionsKind(msg, r.targetKind) {
+						r.Errors = append(r.Errors, ssrSyncError{Pod: pod.ID, Message: msg})
+						failing[pod.ID] = true
+					}
+				}
+				if !pod.Behind {
+					synced[pod.ID] = true
+				}
+			}
+		}
+		for id := range failing {
+			delete(synced, id)
+		}
+		sort.Strings(r.Sources)
+
+		switch {
+		case r.Unserved:
+			r.Line, r.State = "not served by the cluster, so Gatekeeper cannot watch it", "error"
+		case len(failing) > 0:
+			r.Line, r.State = plural(len(failing), "pod reports", "pods report")+" an error", "error"
+		case expected == 0:
+			// Gatekeeper releases before the sync status report nothing, which says nothing.
+		case len(synced) == 0:
+			r.Line, r.State = "no pod has reported on it yet", "warn"
+		case len(synced) < expected:
+			r.Line, r.State = fmt.Sprintf("synced on %d of %d pods", len(synced), expected), "warn"
+		default:
+			r.Line = "synced on " + plural(len(synced), "pod", "pods")
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Kind != rows[j].Kind {
+			return rows[i].Kind < rows[j].Kind
+		}
+		return rows[i].APIVersion < rows[j].APIVersion
+	})
+	return rows
+}
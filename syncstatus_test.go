@@ -0,0 +1,111 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMentionsKind(t *testing.T) {
+	pod := targetKind{APIVersion: "v1", Kind: "Pod"}
+	for msg, want := range map[string]bool{
+		"failed to watch /v1, Kind=Pod: forbidden":                          true,
+		`no matches for kind "Pod" in version "v1"`:                         true,
+		"failed to watch /v1, Kind=PodTemplate: forbidden":                  false,
+		`no matches for kind "Deployment" in version "apps/v1"`:             false,
+		"failed to watch networking.k8s.io/v1, Kind=Ingress: the server...": false,
+	} {
+		if got := mentionsKind(msg, pod); got != want {
+			t.Errorf("mentionsKind(%q) = %t, want %t", msg, got, want)
+		}
+	}
+}
+
+// The kinds of a Config and a SyncSet are folded together, and a pod reporting an error about a
+// kind is not counted as syncing it.
+func TestSyncedKinds(t *testing.T) {
+	config := yamlObject(t, `
+kind: Config
+metadata: {name: config, namespace: gatekeeper-system, generation: 2}
+spec:
+  sync:
+    syncOnly:
+    - {group: "", version: v1, kind: Namespace}
+    - {group: "", version: v1, kind: Pod}
+status:
+  byPod:
+  - {id: gatekeeper-audit, observedGeneration: 2}
+  - id: gatekeeper-controller-manager-0
+    observedGeneration: 2
+    errors:
+    - {type: UpsertCacheError, message: "failed to watch /v1, Kind=Pod: forbidden"}
+`)
+	syncSet := yamlObject(t, `
+kind: SyncSet
+metadata: {name: namespaces}
+spec:
+  gvks:
+  - {group: "", version: v1, kind: Namespace}
+`)
+
+	rows := syncedKinds([]map[string]any{config}, []map[string]any{syncSet}, 2, nil)
+	if len(rows) != 2 {
+		t.Fatalf("rows = %+v, want Namespace and Pod", rows)
+	}
+	ns, pod := rows[0], rows[1]
+	if ns.Kind != "Namespace" || strings.Join(ns.Sources, ",") != "Config gatekeeper-system/config,SyncSet namespaces" {
+		t.Errorf("Namespace row = %+v, want both sources", ns)
+	}
+	if ns.Line != "synced on 2 pods" || ns.State != "" {
+		t.Errorf("Namespace status = %q %q", ns.Line, ns.State)
+	}
+	if pod.Line != "1 pod reports an error" || pod.State != "error" || len(pod.Errors) != 1 || pod.Errors[0].Pod != "gatekeeper-controller-manager-0" {
+		t.Errorf("Pod row = %+v, want the controller's error", pod)
+	}
+
+	// A Gatekeeper that reports no sync status says nothing either way.
+	if rows := syncedKinds(nil, []map[string]any{syncSet}, 0, nil); rows[0].Line != "" {
+		t.Errorf("with no reports the status = %q, want none", rows[0].Line)
+	}
+}
+
+func TestConfigurationsListSyncSets(t *testing.T) {
+	api := newRecordingAPI(t)
+	api.serveGroup("config.gatekeeper.sh", "v1alpha1", servedResource{Name: "configs", Kind: "Config", Namespaced: true, Items: []string{
+		`{"kind":"Config","metadata":{"name":"config","namespace":"gatekeeper-system","generation":1},` +
+			`"spec":{"sync":{"syncOnly":[{"group":"","version":"v1","kind":"Service"}]}},` +
+			`"status":{"byPod":[{"id":"gatekeeper-audit","observedGeneration":1}]}}`,
+	}})
+	api.serveGroup("syncset.gatekeeper.sh", "v1alpha1", servedResource{Name: "syncsets", Kind: "SyncSet", Items: []string{
+		`{"kind":"SyncSet","metadata":{"name":"ingresses","generation":1},` +
+			`"spec":{"gvks":[{"group":"example.com","version":"v1","kind":"Widget"}]},` +
+			`"status":{"byPod":[{"id":"gatekeeper-audit","observedGeneration":1}]}}`,
+	}})
+	api.respondAt("/apis/example.com/v1", `{"kind":"APIResourceList","apiVersion":"v1","groupVersion":"example.com/v1","resources":[]}`)
+	s, _ := discoveryTestClients(t, api)
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getConfigurations(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/configurations", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`id="sync-status"`, "<code>v1 Service</code>", `<span>synced on 1 pod</span>`,
+		`<a href="#syncset-ingresses">ingresses</a>`, `id="syncset-ingresses"`,
+		`<code>example.com/v1 Widget</code> <span class="badge badge-danger"`,
+		`<span class="foot-error">not served by the cluster, so Gatekeeper cannot watch it</span>`,
+		"in sync on 1 pod",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("configurations output missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teams. A namespace label (GPM_TEAM_LABEL, team by default) names the team that owns it, and
+// GPM pivots the audit onto those teams: the Teams view counts each team's violations, and ?team=
+// narrows the Constraints, Resources and Events views, and the printable report, to one team's
+// namespaces. The counting is resourceModel's, bucket by bucket; nothing new is read from the audit.
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ssrTeam is one team and its namespaces. The embedded bucket carries the team's tally, the sum of
+// its namespaces', so the card head and the severity bar render as the Resources view's do.
+type ssrTeam struct {
+	ssrResourceNamespace
+	Namespaces []ssrResourceNamespace // the team's namespaces with their own tallies, worst first
+
+	ResourcesURL   string
+	ConstraintsURL string
+	EventsURL      string
+	ReportURL      string
+}
+
+// Title is what the table shows. The namespaces without the label are a team of their own.
+func (t ssrTeam) Title() string {
+	if t.Name == "" {
+		return "No team"
+	}
+	return t.Name
+}
+
+// ssrTeamScope is a view narrowed with ?team= to one team's namespaces.
+type ssrTeamScope struct {
+	Name       string
+	Namespaces []string
+	Limited    bool   // a Constraint's audit was cut short, so its total is still the cluster's
+	AllURL     string // the same view, every team
+	TeamsURL   string
+}
+
+// teamLabel is the namespace label that names a namespace's team.
+func teamLabel() string {
+	return viper.GetString("team_label")
+}
+
+// teamNamespaces is the names of the namespaces whose label names the team. A team no namespace
+// names has none, which is an empty list rather than nil: the filters read nil as "no filter".
+func teamNamespaces(namespaces []clusterNamespace, label, team string) []string {
+	out := []string{}
+	for _, ns := range namespaces {
+		if ns.Labels[label] == team {
+			out = append(out, ns.Name)
+		}
+	}
+	return out
+}
+
+// teamScope reads ?team= and finds the team's namespaces. Without the parameter the view is not
+// narrowed, and the scope is nil.
+func teamScope(c echo.Context, clients *kubeClients) (*ssrTeamScope, error) {
+	name := strings.TrimSpace(c.QueryParam("team"))
+	if name == "" {
+		return nil, nil
+	}
+	namespaces, err := listNamespaces(c.Request().Context(), clients)
+	if err != nil {
+		return nil, err
+	}
+	all := c.Request().URL.Query()
+	all.Del("team")
+	return &ssrTeamScope{
+		Name:       name,
+		Namespaces: teamNamespaces(namespaces, teamLabel(), name),
+		AllURL:     "?" + all.Encode(),
+		TeamsURL:   contextPath(c, "/teams"),
+	}, nil
+}
+
+// teamConstraints is the constraints with only the violations in the namespaces listed. They are
+// copies down to the status, so the report template, which reads the objects as they are, and
+// sortConstraints see the team's numbers. A Constraint whose audit reported fewer violations than it
+// counted keeps the cluster's total: how many of the rest are the team's is not known. limited says
+// whether any did.
+func teamConstraints(constraints []map[string]any, namespaces []string) (out []map[string]any, limited bool) {
+	in := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		in[ns] = true
+	}
+	out = make([]map[string]any, 0, len(constraints))
+	for _, o := range constraints {
+		status, ok := o["status"].(map[string]any)
+		if !ok {
+			out = append(out, o)
+			continue
+		}
+		violations, _ := status["violations"].([]any)
+		kept := make([]any, 0, len(violations))
+		for _, v := range violations {
+			if vm, ok := v.(map[string]any); ok {
+				if ns, _ := vm["namespace"].(string); in[ns] {
+					kept = append(kept, v)
+				}
+			}
+		}
+		status = maps.Clone(status)
+		status["violations"] = kept
+		if total, found, _ := unstructured.NestedInt64(o, "status", "totalViolations"); found {
+			if total > int64(len(violations)) {
+				limited = true
+			} else {
+				status["totalViolations"] = int64(len(kept))
+			}
+		}
+		copied := maps.Clone(o)
+		copied["status"] = status
+		out = append(out, copied)
+	}
+	return out, limited
+}
+
+// worstFirst orders tallies as resourceModel orders namespaces: blocking violations, then the
+// total, then the name.
+func worstFirst(a, b ssrResourceNamespace) int {
+	if a.Deny != b.Deny {
+		return cmp.Compare(b.Deny, a.Deny)
+	}
+	if a.Total() != b.Total() {
+		return cmp.Compare(b.Total(), a.Total())
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// teamModel adds resourceModel's namespace buckets up by team. Every namespace of the cluster is
+// listed, a clean one too, so a team sees all of its namespaces; a namespace the audit names but the
+// cluster no longer has goes under no team. Cluster-scoped violations belong to no namespace and to
+// no team, and are left out. The teams come worst first, with no team last.
+func teamModel(buckets []ssrResourceNamespace, namespaces []clusterNamespace, label string) []ssrTeam {
+	byName := map[string]ssrResourceNamespace{}
+	for _, b := range buckets {
+		if b.Name != "" {
+			byName[b.Name] = b
+		}
+	}
+
+	teams := map[string]*ssrTeam{}
+	add := func(team string, b ssrResourceNamespace) {
+		t, ok := teams[team]
+		if !ok {
+			t = &ssrTeam{ssrResourceNamespace: ssrResourceNamespace{Name: team, Anchor: "team-" + team}}
+			teams[team] = t
+		}
+		b.Resources = nil
+		t.Namespaces = append(t.Namespaces, b)
+		t.Deny += b.Deny
+		t.DryRun += b.DryRun
+		t.Warn += b.Warn
+	}
+	for _, ns := range namespaces {
+		b, ok := byName[ns.Name]
+		if !ok {
+			b = ssrResourceNamespace{Name: ns.Name, Anchor: "ns-" + ns.Name}
+		}
+		delete(byName, ns.Name)
+		add(ns.Labels[label], b)
+	}
+	for _, b := range byName {
+		add("", b)
+	}
+
+	out := make([]ssrTeam, 0, len(teams))
+	for _, t := range teams {
+		slices.SortFunc(t.Namespaces, worstFirst)
+		out = append(out, *t)
+	}
+	slices.SortFunc(out, func(a, b ssrTeam) int {
+		if (a.Name == "") != (b.Name == "") {
+			if a.Name == "" {
+				return 1
+			}
+			return -1
+		}
+		return worstFirst(a.ssrResourceNamespace, b.ssrResourceNamespace)
+	})
+	return out
+}
+
+// getTeams renders the Teams view: every team's violations by mode, added up from its namespaces,
+// with links to the other views narrowed to the team.
+func (s *server) getTeams(c echo.Context) error {
+	layout := s.ssrLayoutData(c, "teams", "/teams", "Teams")
+
+	label := teamLabel()
+	data := map[string]any{"Layout": layout, "Label": label}
+
+	clients, err := s.clientsFor(c)
+	if err != nil {
+		slog.Error("SSR teams: resolving context failed", "error", err)
+		setViewError(data, "GPM could not switch to the requested Kubernetes context. Make sure the kubeconfig defines it correctly.", err)
+		return s.ssr.render(c, "teams", data)
+	}
+
+	ctx := c.Request().Context()
+	namespaces, err := listNamespaces(ctx, clients)
+	if err != nil {
+		slog.Error("SSR teams: listing namespaces failed", "error", err)
+		setViewError(data, "GPM could not list the namespaces from the Kubernetes API, and their labels are what names the teams. Make sure GPM can list namespaces.", err)
+		return s.ssr.render(c, "teams", data)
+	}
+	raw, err := listConstraints(ctx, clients)
+	if err != nil {
+		slog.Error("SSR teams: reading constraints failed", "error", err)
+		setViewError(data, "GPM could not read the Constraints from the Kubernetes API. Make sure Gatekeeper is installed in the cluster.", err)
+		return s.ssr.render(c, "teams", data)
+	}
+
+	models := make([]ssrConstraint, 0, len(raw))
+	audited, limited := false, false
+	for _, o := range raw {
+		m := ssrConstraintModel(o)
+		audited = audited || m.ViolationsKnown
+		limited = limited || m.AuditLimited
+		models = append(models, m)
+	}
+
+	// Grouping by owner moves violations between rows, never between namespaces, so the tallies
+	// need no owners.
+	teams := teamModel(resourceModel(models, nil), namespaces, label)
+	var score *compliance
+	if complianceEnabled() {
+		score, err = s.complianceFor(ctx, c.Param("context"), clients, raw)
+		if err != nil {
+			slog.Warn("SSR teams: scoring compliance failed for some Constraints, the score leaves them out", "error", err)
+		}
+		data["Compliance"] = score
+	}
+	labelled := false
+	for i := range teams {
+		t := &teams[i]
+		names := make([]string, 0, len(t.Namespaces))
+		for j := range t.Namespaces {
+			ns := &t.Namespaces[j]
+			ns.URL = namespaceURL(c.Param("context"), ns.Name)
+			names = append(names, ns.Name)
+			if score != nil {
+				tally := score.Namespaces[ns.Name]
+				ns.Compliance = &tally
+			}
+		}
+		if score != nil {
+			tally := score.forNamespaces(names)
+			t.Compliance = &tally
+		}
+		if t.Name == "" {
+			continue
+		}
+		labelled = true
+		q := url.Values{"team": {t.Name}}.Encode()
+		t.ResourcesURL = contextPath(c, "/resources") + "?" + q
+		t.ConstraintsURL = contextPath(c, "/constraints") + "?" + q
+		t.EventsURL = contextPath(c, "/events") + "?" + q
+		t.ReportURL = contextPath(c, "/constraints") + "?" + url.Values{"report": {"html"}, "team": {t.Name}}.Encode()
+	}
+	data["Teams"] = teams
+	data["Labelled"] = labelled
+	data["Audited"] = audited
+	data["AuditLimited"] = limited
+
+	return s.ssr.render(c, "teams", data)
+}
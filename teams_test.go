@@ -0,0 +1,189 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// teamsTestAPI stands in for a cluster with two teams' namespaces, one namespace without the label,
+// and one Constraint with a violation in each and one on a cluster-scoped object.
+func teamsTestAPI(t *testing.T) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.respondAt("/api/v1/namespaces", `{"apiVersion":"v1","kind":"NamespaceList","metadata":{},"items":[`+
+		`{"metadata":{"name":"payments","labels":{"team":"payments"}}},`+
+		`{"metadata":{"name":"payments-jobs","labels":{"team":"payments"}}},`+
+		`{"metadata":{"name":"shop","labels":{"team":"storefront"}}},`+
+		`{"metadata":{"name":"sandbox"}}]}`)
+	api.serveConstraints("K8sRequiredLabels",
+		`{"kind":"K8sRequiredLabels","metadata":{"name":"must-have-owner"},"spec":{"enforcementAction":"deny"},`+
+			`"status":{"totalViolations":4,"violations":[`+
+			`{"enforcementAction":"deny","kind":"ConfigMap","namespace":"payments","name":"settings","message":"payments config"},`+
+			`{"enforcementAction":"deny","kind":"ConfigMap","namespace":"shop","name":"catalogue","message":"shop config"},`+
+			`{"enforcementAction":"deny","kind":"ConfigMap","namespace":"sandbox","name":"scratch","message":"sandbox config"},`+
+			`{"enforcementAction":"deny","kind":"Namespace","name":"sandbox","message":"cluster-scoped"}]}}`)
+	return api
+}
+
+func TestTeamConstraints(t *testing.T) {
+	violation := func(ns string) any { return map[string]any{"namespace": ns, "name": "x"} }
+	raw := []map[string]any{
+		{"metadata": map[string]any{"name": "complete"}, "status": map[string]any{
+			"totalViolations": int64(3), "violations": []any{violation("payments"), violation("shop"), violation("")}}},
+		{"metadata": map[string]any{"name": "capped"}, "status": map[string]any{
+			"totalViolations": int64(50), "violations": []any{violation("payments"), violation("shop")}}},
+		{"metadata": map[string]any{"name": "unaudited"}},
+	}
+
+	got, limited := teamConstraints(raw, []string{"payments"})
+	if !limited {
+		t.Error("the capped Constraint should mark the result limited")
+	}
+	for i, want := range []int64{1, 50} {
+		total, _, _ := unstructured.NestedInt64(got[i], "status", "totalViolations")
+		kept, _, _ := unstructured.NestedSlice(got[i], "status", "violations")
+		if total != want || len(kept) != 1 {
+			t.Errorf("%v: total %d and %d violations, want %d and 1", got[i]["metadata"], total, len(kept), want)
+		}
+	}
+	if _, found := got[2]["status"]; found {
+		t.Error("a Constraint without a status should be left as it is")
+	}
+	// The listed objects are copies: the cluster-wide ones are not touched.
+	if vs, _, _ := unstructured.NestedSlice(raw[0], "status", "violations"); len(vs) != 3 {
+		t.Errorf("the input was changed: %d violations left", len(vs))
+	}
+}
+
+func TestTeamModel(t *testing.T) {
+	buckets := resourceModel([]ssrConstraint{{Name: "c", Violations: []ssrConstraintViolation{
+		viol("", "ConfigMap", "payments", "a", "deny"),
+		viol("", "ConfigMap", "payments-jobs", "b", "warn"),
+		viol("", "ConfigMap", "shop", "c", "deny"),
+		viol("", "ConfigMap", "shop", "d", "deny"),
+		viol("", "ConfigMap", "deleted", "e", "dryrun"),
+		viol("", "Namespace", "", "shop", "deny"),
+	}}}, nil)
+	namespaces := []clusterNamespace{
+		{Name: "payments", Labels: map[string]string{"team": "payments"}},
+		{Name: "payments-jobs", Labels: map[string]string{"team": "payments"}},
+		{Name: "payments-idle", Labels: map[string]string{"team": "payments"}},
+		{Name: "shop", Labels: map[string]string{"team": "storefront"}},
+	}
+
+	teams := teamModel(buckets, namespaces, "team")
+	if len(teams) != 3 {
+		t.Fatalf("got %d teams, want storefront, payments and no team", len(teams))
+	}
+	if s := teams[0]; s.Name != "storefront" || s.Deny != 2 || s.Total() != 2 {
+		t.Errorf("first team = %+v, want storefront with the most denials", s.ssrResourceNamespace)
+	}
+	p := teams[1]
+	if p.Name != "payments" || p.Deny != 1 || p.Warn != 1 || len(p.Namespaces) != 3 {
+		t.Errorf("payments = %+v with %d namespaces", p.ssrResourceNamespace, len(p.Namespaces))
+	}
+	if p.Namespaces[2].Name != "payments-idle" || p.Namespaces[2].Total() != 0 {
+		t.Errorf("the clean namespace should be listed last, got %+v", p.Namespaces[2])
+	}
+	if none := teams[2]; none.Title() != "No team" || none.DryRun != 1 || none.Namespaces[0].Name != "deleted" {
+		t.Errorf("no team = %+v, want the namespace the cluster no longer has", none.ssrResourceNamespace)
+	}
+}
+
+func TestEventQueryResourceNamespaces(t *testing.T) {
+	e := ssrEvent{SourceComponent: "gatekeeper-webhook", ResourceNamespace: "payments"}
+	for _, tc := range []struct {
+		namespaces []string
+		want       bool
+	}{
+		{nil, true},
+		{[]string{"shop", "payments"}, true},
+		{[]string{"shop"}, false},
+		{[]string{}, false},
+	} {
+		q := eventQuery{Sources: []string{"gatekeeper-webhook"}, ResourceNamespaces: tc.namespaces}
+		if got := q.matches(e); got != tc.want {
+			t.Errorf("namespaces %v: matches = %v, want %v", tc.namespaces, got, tc.want)
+		}
+	}
+}
+
+func TestTeamsView(t *testing.T) {
+	s, _ := discoveryTestClients(t, teamsTestAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getTeams(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/teams", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		`<tr id="team-payments">`,
+		`<a href="/resources?team=payments">Resources</a>`,
+		`<a href="/constraints?report=html&amp;team=payments" download>Report</a>`,
+		`<a href="/events?team=storefront">Events</a>`,
+		`<a href="/namespace?name=payments-jobs" title="no violations">payments-jobs</a>`,
+		"No team",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("teams view missing %q", want)
+		}
+	}
+	// The cluster-scoped violation belongs to no team, so no team counts only the sandbox's.
+	none := out[strings.Index(out, "No team"):]
+	if !strings.Contains(none, `<span class="n n-deny">1</span>`) {
+		t.Errorf("no team's row should count one denial:\n%s", none)
+	}
+}
+
+func TestResourcesTeamFilter(t *testing.T) {
+	s, _ := discoveryTestClients(t, teamsTestAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/resources?team=payments&view=objects", nil)
+	if err := s.getResources(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := stripHTMLTags(rec.Body.String())
+	for _, want := range []string{"Team payments: the violations in payments, payments-jobs only.", "payments config"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("filtered view missing %q", want)
+		}
+	}
+	for _, unwanted := range []string{"shop config", "sandbox config", "cluster-scoped"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("filtered view has %q", unwanted)
+		}
+	}
+	if !strings.Contains(rec.Body.String(), `href="/resources?team=payments"`) {
+		t.Error("the owner toggle should keep the team")
+	}
+}
+
+func TestConstraintsTeamFilterKeepsTheTeamInTheReport(t *testing.T) {
+	s, _ := discoveryTestClients(t, teamsTestAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/constraints?team=storefront", nil)
+	if err := s.getConstraints(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	if !strings.Contains(out, `?report=html&amp;team=storefront"`) {
+		t.Error("the report link should carry the team")
+	}
+	if !strings.Contains(out, "shop config") || strings.Contains(out, "payments config") {
+		t.Error("the cards should list the team's violations only")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// ValidatingAdmissionPolicy generation. For a template with K8sNativeValidation (CEL) code Gatekeeper
+// can hand enforcement to the API server: it writes a ValidatingAdmissionPolicy per template, whose
+// paramKind is the template's Constraint kind, and a binding per Constraint, whose paramRef names the
+// Constraint. The API server then type-checks the CEL and reports on the policy, not on the
+// Constraint, so those objects are read here and shown beside the ones they come from.
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The API group of the admission policies. Clusters before Kubernetes 1.30 serve them at v1beta1
+// only, next to a v1 that has the webhook configurations.
+const admissionGroup = "admissionregistration.k8s.io"
+
+var admissionPolicyVersions = []string{"v1", "v1beta1"}
+
+// ssrAdmissionPolicy is what a template card shows of the policy generated from it.
+type ssrAdmissionPolicy struct {
+	Name       string
+	Warnings   []string // the API server's type-checking warnings, "<field>: <warning>"
+	Conditions []string // the conditions that are not True, "<type>: <message>"
+}
+
+// ssrAdmissionBinding is what a Constraint card shows of the binding generated for it.
+type ssrAdmissionBinding struct {
+	Name              string
+	ValidationActions []string
+	Policy            ssrAdmissionPolicy
+	Raw               map[string]any
+}
+
+// admissionPolicyModel reads a policy's status.
+func admissionPolicyModel(o map[string]any) ssrAdmissionPolicy {
+	p := ssrAdmissionPolicy{Name: (&unstructured.Unstructured{Object: o}).GetName()}
+	warnings, _, _ := unstructured.NestedSlice(o, "status", "typeChecking", "expressionWarnings")
+	for _, w := range warnings {
+		wm, _ := w.(map[string]any)
+		field, _, _ := unstructured.NestedString(wm, "fieldRef")
+		warning, _, _ := unstructured.NestedString(wm, "warning")
+		p.Warnings = append(p.Warnings, strings.TrimPrefix(field+": "+warning, ": "))
+	}
+	conditions, _, _ := unstructured.NestedSlice(o, "status", "conditions")
+	for _, c := range conditions {
+		cm, _ := c.(map[string]any)
+		if status, _, _ := unstructured.NestedString(cm, "status"); status == "True" {
+			continue
+		}
+		kind, _, _ := unstructured.NestedString(cm, "type")
+		message, _, _ := unstructured.NestedString(cm, "message")
+		p.Conditions = append(p.Conditions, strings.TrimSuffix(kind+": "+message, ": "))
+	}
+	return p
+}
+
+// admissionPolicies is what the cluster has of the policies and bindings.
+type admissionPolicies struct {
+	policies []map[string]any
+	bindings []map[string]any
+}
+
+// listAdmissionPolicies reads the policies and their bindings at the first version that serves them.
+// A cluster that serves neither has none, and that is an answer.
+func listAdmissionPolicies(ctx context.Context, clients *kubeClients) (admissionPolicies, error) {
+	var out admissionPolicies
+	for _, version := range admissionPolicyVersions {
+		served, err := clients.discovery.ServerResourcesForGroupVersion(admissionGroup + "/" + version)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return out, fmt.Errorf("discovering %s/%s: %w", admissionGroup, version, err)
+		}
+		if !slices.ContainsFunc(served.APIResources, func(r metav1.APIResource) bool { return r.Name == "validatingadmissionpolicies" }) {
+			continue
+		}
+		policies, err := getCustomResources(ctx, *clients.dynamic, admissionGroup, version, "validatingadmissionpolicies")
+		if err != nil {
+			return out, fmt.Errorf("listing validatingadmissionpolicies: %w", err)
+		}
+		bindings, err := getCustomResources(ctx, *clients.dynamic, admissionGroup, version, "validatingadmissionpolicybindings")
+		if err != nil {
+			return out, fmt.Errorf("listing validatingadmissionpolicybindings: %w", err)
+		}
+		for i := range policies.Items {
+			out.policies = append(out.policies, policies.Items[i].Object)
+		}
+		for i := range bindings.Items {
+			out.bindings = append(out.bindings, bindings.Items[i].Object)
+		}
+		return out, nil
+	}
+	return out, nil
+}
+
+// paramKind is the Constraint kind a policy of Gatekeeper's takes as its parameters, and empty for
+// any other policy: one whose parameters are not in the constraints group only shares the kind's
+// name.
+func paramKind(policy map[string]any) string {
+	apiVersion, _, _ := unstructured.NestedString(policy, "spec", "paramKind", "apiVersion")
+	if !strings.HasPrefix(apiVersion, "constraints.gatekeeper.sh/") {
+		return ""
+	}
+	kind, _, _ := unstructured.NestedString(policy, "spec", "paramKind", "kind")
+	return kind
+}
+
+// forTemplate is the policy generated from the template whose Constraints are of kind.
+func (a admissionPolicies) forTemplate(kind string) *ssrAdmissionPolicy {
+	for _, p := range a.policies {
+		if kind != "" && paramKind(p) == kind {
+			m := admissionPolicyModel(p)
+			return &m
+		}
+	}
+	return nil
+}
+
+// forConstraints finds the binding generated for each Constraint: the one whose paramRef names it
+// and whose policy takes its kind. The map is keyed by constraintAnchor. A binding whose policy is
+// gone cannot say which kind it binds, and is left out.
+func (a admissionPolicies) forConstraints() map[string]*ssrAdmissionBinding {
+	policies := map[string]map[string]any{}
+	for _, p := range a.policies {
+		policies[(&unstructured.Unstructured{Object: p}).GetName()] = p
+	}
+	out := map[string]*ssrAdmissionBinding{}
+	for _, b := range a.bindings {
+		param, _, _ := unstructured.NestedString(b, "spec", "paramRef", "name")
+		policyName, _, _ := unstructured.NestedString(b, "spec", "policyName")
+		policy, found := policies[policyName]
+		if param == "" || !found || paramKind(policy) == "" {
+			continue
+		}
+		m := &ssrAdmissionBinding{Name: (&unstructured.Unstructured{Object: b}).GetName(), Raw: b}
+		m.ValidationActions, _, _ = unstructured.NestedStringSlice(b, "spec", "validationActions")
+		m.Policy = admissionPolicyModel(policy)
+		out[constraintAnchor(paramKind(policy), param)] = m
+	}
+	return out
+}
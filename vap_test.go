@@ -0,0 +1,104 @@
+// Copyright (c) 2017-present SIGHUP s.r.l All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// vapAPI serves a CEL template, one Constraint of its kind, and the policy and binding Gatekeeper
+// generated for them, at v1beta1 as a cluster before Kubernetes 1.30 does.
+func vapAPI(t *testing.T) *recordingAPI {
+	t.Helper()
+	api := newRecordingAPI(t)
+	api.respondAt("/apis/templates.gatekeeper.sh/v1/constrainttemplates", `{"apiVersion":"templates.gatekeeper.sh/v1",`+
+		`"kind":"ConstraintTemplateList","metadata":{},"items":[{"kind":"ConstraintTemplate","metadata":{"name":"k8srequiredlabels"},`+
+		`"spec":{"crd":{"spec":{"names":{"kind":"K8sRequiredLabels"}}},"targets":[{"target":"admission.k8s.gatekeeper.sh","code":[`+
+		`{"engine":"K8sNativeValidation","source":{"validations":[{"expression":"has(object.metadata.labels.owner)","message":"owner is required"}]}}]}]}}]}`)
+	api.serveConstraints("K8sRequiredLabels", `{"kind":"K8sRequiredLabels","metadata":{"name":"owner"},"spec":{}}`)
+	api.respondAt("/apis/admissionregistration.k8s.io/v1", `{"kind":"APIResourceList","apiVersion":"v1",`+
+		`"groupVersion":"admissionregistration.k8s.io/v1","resources":[`+
+		`{"name":"validatingwebhookconfigurations","namespaced":false,"kind":"ValidatingWebhookConfiguration","verbs":["list"]}]}`)
+	api.respondAt("/apis/admissionregistration.k8s.io/v1beta1", `{"kind":"APIResourceList","apiVersion":"v1",`+
+		`"groupVersion":"admissionregistration.k8s.io/v1beta1","resources":[`+
+		`{"name":"validatingadmissionpolicies","namespaced":false,"kind":"ValidatingAdmissionPolicy","verbs":["list"]},`+
+		`{"name":"validatingadmissionpolicybindings","namespaced":false,"kind":"ValidatingAdmissionPolicyBinding","verbs":["list"]}]}`)
+	api.respondAt("/apis/admissionregistration.k8s.io/v1beta1/validatingadmissionpolicies", `{"apiVersion":"admissionregistration.k8s.io/v1beta1",`+
+		`"kind":"ValidatingAdmissionPolicyList","metadata":{},"items":[{"metadata":{"name":"gatekeeper-k8srequiredlabels"},`+
+		`"spec":{"paramKind":{"apiVersion":"constraints.gatekeeper.sh/v1beta1","kind":"K8sRequiredLabels"}},`+
+		`"status":{"typeChecking":{"expressionWarnings":[{"fieldRef":"spec.validations[0].expression","warning":"no such key: owner"}]}}}]}`)
+	api.respondAt("/apis/admissionregistration.k8s.io/v1beta1/validatingadmissionpolicybindings", `{"apiVersion":"admissionregistration.k8s.io/v1beta1",`+
+		`"kind":"ValidatingAdmissionPolicyBindingList","metadata":{},"items":[{"metadata":{"name":"gatekeeper-owner"},`+
+		`"spec":{"policyName":"gatekeeper-k8srequiredlabels","paramRef":{"name":"owner"},"validationActions":["Deny"]}}]}`)
+	return api
+}
+
+func TestConstraintsLinkTheirBinding(t *testing.T) {
+	s, _ := discoveryTestClients(t, vapAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getConstraints(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/constraints", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"Generated ValidatingAdmissionPolicyBinding", "<code>gatekeeper-owner</code>",
+		"<code>gatekeeper-k8srequiredlabels</code>", "<dd>Deny</dd>",
+		`<p class="foot-warn">spec.validations[0].expression: no such key: owner</p>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("constraints output missing %q", want)
+		}
+	}
+}
+
+func TestConstraintTemplatesShowTheCEL(t *testing.T) {
+	s, _ := discoveryTestClients(t, vapAPI(t))
+	s.ssr = newSSRRenderer()
+
+	rec := httptest.NewRecorder()
+	if err := s.getConstraintTemplates(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/constrainttemplates", nil), rec)); err != nil {
+		t.Fatalf("the handler returned an error: %v", err)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{"CEL definition · K8sNativeValidation", "has(object.metadata.labels.owner)", "Generated ValidatingAdmissionPolicy"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("constraint templates output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "defines no Rego or CEL source") {
+		t.Error("a CEL-only template reads as having no source")
+	}
+}
+
+// A binding is matched by its paramRef and its policy's paramKind, not by Gatekeeper's naming. A
+// policy whose parameters are not Constraints is not Gatekeeper's, whatever its kind is called.
+func TestAdmissionBindingsForConstraints(t *testing.T) {
+	a := admissionPolicies{
+		policies: []map[string]any{
+			yamlObject(t, "metadata: {name: p}\nspec: {paramKind: {apiVersion: constraints.gatekeeper.sh/v1beta1, kind: K8sRequiredLabels}}\n"),
+			yamlObject(t, "metadata: {name: other}\nspec: {paramKind: {apiVersion: example.com/v1, kind: K8sRequiredLabels}}\n"),
+		},
+		bindings: []map[string]any{
+			yamlObject(t, "metadata: {name: b}\nspec: {policyName: p, paramRef: {name: owner}, validationActions: [Warn, Audit]}\n"),
+			yamlObject(t, "metadata: {name: orphan}\nspec: {policyName: gone, paramRef: {name: owner}}\n"),
+			yamlObject(t, "metadata: {name: unrelated}\nspec: {policyName: other, paramRef: {name: team}}\n"),
+		},
+	}
+	got := a.forConstraints()
+	b := got[constraintAnchor("K8sRequiredLabels", "owner")]
+	if len(got) != 1 || b == nil || b.Name != "b" || strings.Join(b.ValidationActions, ",") != "Warn,Audit" {
+		t.Errorf("bindings = %+v, want b alone", got)
+	}
+	if p := (admissionPolicies{policies: a.policies[1:]}).forTemplate("K8sRequiredLabels"); p != nil {
+		t.Errorf("a policy of another group was attached to the template: %+v", p)
+	}
+}